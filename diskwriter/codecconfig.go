@@ -0,0 +1,547 @@
+package diskwriter
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+
+	"github.com/pion/rtp"
+
+	gcodecs "github.com/jech/galene/codecs"
+)
+
+// This file builds the Matroska CodecPrivate data for H.264 (avcC, ISO
+// 14496-15) and AV1 (av1C, as defined by the AV1 Codec ISO Media File
+// Format Binding), and extracts the frame dimensions from the parameter
+// sets, since they are not available in the RTP payload descriptor.
+
+var errNoConfig = errors.New("no codec configuration in keyframe")
+
+// keyframeConfig returns the dimensions and the codec private data of
+// a keyframe.  Packet is the first packet of the keyframe, and data is
+// the depacketised keyframe.
+func keyframeConfig(codec string, packet *rtp.Packet, data []byte) (uint32, uint32, []byte, error) {
+	if strings.EqualFold(codec, "video/h264") {
+		return h264Config(data)
+	} else if strings.EqualFold(codec, "video/av1") {
+		return av1Config(data)
+	}
+	w, h := gcodecs.KeyframeDimensions(codec, packet)
+	return w, h, nil, nil
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+var errTruncated = errors.New("truncated bitstream")
+
+func (r *bitReader) bits(n int) (uint32, error) {
+	var v uint32
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.data)*8 {
+			return 0, errTruncated
+		}
+		bit := (r.data[r.pos/8] >> (7 - r.pos%8)) & 1
+		v = (v << 1) | uint32(bit)
+		r.pos++
+	}
+	return v, nil
+}
+
+func (r *bitReader) bit() (bool, error) {
+	v, err := r.bits(1)
+	return v != 0, err
+}
+
+// ue reads an unsigned Exp-Golomb code.
+func (r *bitReader) ue() (uint32, error) {
+	zeros := 0
+	for {
+		b, err := r.bit()
+		if err != nil {
+			return 0, err
+		}
+		if b {
+			break
+		}
+		zeros++
+		if zeros > 31 {
+			return 0, errors.New("bad Exp-Golomb code")
+		}
+	}
+	v, err := r.bits(zeros)
+	if err != nil {
+		return 0, err
+	}
+	return (1 << zeros) - 1 + v, nil
+}
+
+// se reads a signed Exp-Golomb code.
+func (r *bitReader) se() (int32, error) {
+	v, err := r.ue()
+	if err != nil {
+		return 0, err
+	}
+	if v&1 != 0 {
+		return int32((v + 1) / 2), nil
+	}
+	return -int32(v / 2), nil
+}
+
+// unescapeRBSP removes the emulation prevention bytes from a NAL unit.
+func unescapeRBSP(nalu []byte) []byte {
+	out := make([]byte, 0, len(nalu))
+	zeros := 0
+	for _, b := range nalu {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// h264Config parses a keyframe in AVC format (length-prefixed NAL units),
+// and returns its dimensions and an avcC record.
+func h264Config(data []byte) (uint32, uint32, []byte, error) {
+	var sps, pps []byte
+	for len(data) >= 4 {
+		length := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		if uint32(len(data)) < length {
+			return 0, 0, nil, errTruncated
+		}
+		nalu := data[:length]
+		data = data[length:]
+		if len(nalu) < 1 {
+			continue
+		}
+		switch nalu[0] & 0x1F {
+		case 7:
+			if sps == nil {
+				sps = nalu
+			}
+		case 8:
+			if pps == nil {
+				pps = nalu
+			}
+		}
+	}
+
+	if sps == nil || pps == nil || len(sps) < 4 {
+		return 0, 0, nil, errNoConfig
+	}
+
+	width, height, err := h264Dimensions(sps)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	avcc := []byte{
+		1, sps[1], sps[2], sps[3],
+		0xFC | 3, // 4-byte lengths
+		0xE0 | 1, // one SPS
+	}
+	avcc = binary.BigEndian.AppendUint16(avcc, uint16(len(sps)))
+	avcc = append(avcc, sps...)
+	avcc = append(avcc, 1) // one PPS
+	avcc = binary.BigEndian.AppendUint16(avcc, uint16(len(pps)))
+	avcc = append(avcc, pps...)
+
+	return width, height, avcc, nil
+}
+
+// h264Dimensions parses an SPS, as described in Section 7.3.2.1.1 of
+// ITU-T H.264, and returns the cropped frame dimensions.
+func h264Dimensions(sps []byte) (uint32, uint32, error) {
+	r := &bitReader{data: unescapeRBSP(sps[1:])}
+
+	profile, err := r.bits(8)
+	if err != nil {
+		return 0, 0, err
+	}
+	// constraint flags and level
+	r.bits(16)
+	r.ue() // seq_parameter_set_id
+
+	chromaFormat := uint32(1)
+	separateColourPlane := false
+	switch profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat, err = r.ue()
+		if err != nil {
+			return 0, 0, err
+		}
+		if chromaFormat == 3 {
+			separateColourPlane, _ = r.bit()
+		}
+		r.ue()  // bit_depth_luma_minus8
+		r.ue()  // bit_depth_chroma_minus8
+		r.bit() // qpprime_y_zero_transform_bypass_flag
+		scaling, err := r.bit()
+		if err != nil {
+			return 0, 0, err
+		}
+		if scaling {
+			n := 8
+			if chromaFormat == 3 {
+				n = 12
+			}
+			for i := 0; i < n; i++ {
+				present, err := r.bit()
+				if err != nil {
+					return 0, 0, err
+				}
+				if !present {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				last, next := int32(8), int32(8)
+				for j := 0; j < size; j++ {
+					if next != 0 {
+						delta, err := r.se()
+						if err != nil {
+							return 0, 0, err
+						}
+						next = (last + delta + 256) % 256
+					}
+					if next != 0 {
+						last = next
+					}
+				}
+			}
+		}
+	}
+
+	r.ue() // log2_max_frame_num_minus4
+	pocType, err := r.ue()
+	if err != nil {
+		return 0, 0, err
+	}
+	if pocType == 0 {
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	} else if pocType == 1 {
+		r.bit() // delta_pic_order_always_zero_flag
+		r.se()  // offset_for_non_ref_pic
+		r.se()  // offset_for_top_to_bottom_field
+		n, err := r.ue()
+		if err != nil {
+			return 0, 0, err
+		}
+		for i := uint32(0); i < n; i++ {
+			_, err := r.se()
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	r.ue()  // max_num_ref_frames
+	r.bit() // gaps_in_frame_num_value_allowed_flag
+
+	widthMbs, err := r.ue()
+	if err != nil {
+		return 0, 0, err
+	}
+	heightMapUnits, err := r.ue()
+	if err != nil {
+		return 0, 0, err
+	}
+	frameMbsOnly, err := r.bit()
+	if err != nil {
+		return 0, 0, err
+	}
+	if !frameMbsOnly {
+		r.bit() // mb_adaptive_frame_field_flag
+	}
+	r.bit() // direct_8x8_inference_flag
+
+	fieldFactor := uint32(2)
+	if frameMbsOnly {
+		fieldFactor = 1
+	}
+	width := (widthMbs + 1) * 16
+	height := fieldFactor * (heightMapUnits + 1) * 16
+
+	cropping, err := r.bit()
+	if err != nil {
+		return 0, 0, err
+	}
+	if cropping {
+		var crop [4]uint32
+		for i := range crop {
+			crop[i], err = r.ue()
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+		cropX, cropY := uint32(1), fieldFactor
+		if !separateColourPlane && chromaFormat != 0 {
+			if chromaFormat == 1 || chromaFormat == 2 {
+				cropX = 2
+			}
+			if chromaFormat == 1 {
+				cropY = 2 * fieldFactor
+			}
+		}
+		w := cropX * (crop[0] + crop[1])
+		h := cropY * (crop[2] + crop[3])
+		if w >= width || h >= height {
+			return 0, 0, errors.New("bad cropping")
+		}
+		width -= w
+		height -= h
+	}
+
+	return width, height, nil
+}
+
+// AV1 OBU types
+const (
+	av1OBUSequenceHeader = 1
+)
+
+// av1Config parses a keyframe in low overhead bitstream format, and
+// returns its dimensions and an av1C record.
+func av1Config(data []byte) (uint32, uint32, []byte, error) {
+	for len(data) > 0 {
+		header := data[0]
+		obuType := (header >> 3) & 0xF
+		length := 1
+		if header&0x04 != 0 {
+			// extension header
+			length++
+		}
+		if header&0x02 == 0 || len(data) < length {
+			return 0, 0, nil, errors.New("bad OBU")
+		}
+		size, n, err := readLeb128(data[length:])
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if uint64(len(data)-length-n) < size {
+			return 0, 0, nil, errTruncated
+		}
+		end := length + n + int(size)
+		if obuType != av1OBUSequenceHeader {
+			data = data[end:]
+			continue
+		}
+
+		payload := data[length+n : end]
+		seq, err := parseAV1SequenceHeader(payload)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+
+		av1c := []byte{
+			0x81, // marker and version
+			seq.profile<<5 | seq.level,
+			seq.tier<<7 | seq.highBitdepth<<6 | seq.twelveBit<<5 |
+				seq.monochrome<<4 | seq.subsamplingX<<3 |
+				seq.subsamplingY<<2 | seq.chromaSamplePosition,
+			0,
+		}
+		av1c = append(av1c, data[:end]...)
+		return seq.width, seq.height, av1c, nil
+	}
+	return 0, 0, nil, errNoConfig
+}
+
+func readLeb128(data []byte) (uint64, int, error) {
+	var v uint64
+	for i := 0; i < 8; i++ {
+		if i >= len(data) {
+			return 0, 0, errTruncated
+		}
+		v |= uint64(data[i]&0x7F) << (7 * i)
+		if data[i]&0x80 == 0 {
+			return v, i + 1, nil
+		}
+	}
+	return 0, 0, errors.New("bad leb128")
+}
+
+type av1SequenceHeader struct {
+	profile, level, tier                byte
+	highBitdepth, twelveBit, monochrome byte
+	subsamplingX, subsamplingY          byte
+	chromaSamplePosition                byte
+	width, height                       uint32
+}
+
+// parseAV1SequenceHeader parses a sequence header OBU, as described in
+// Section 5.5 of the AV1 specification.
+func parseAV1SequenceHeader(data []byte) (av1SequenceHeader, error) {
+	var seq av1SequenceHeader
+	r := &bitReader{data: data}
+	var err error
+	f := func(n int) uint32 {
+		if err != nil {
+			return 0
+		}
+		var v uint32
+		v, err = r.bits(n)
+		return v
+	}
+
+	seq.profile = byte(f(3))
+	f(1) // still_picture
+	reduced := f(1) != 0
+	if reduced {
+		seq.level = byte(f(5))
+	} else {
+		decoderModelInfo := false
+		bufferDelayLength := 0
+		if f(1) != 0 { // timing_info_present_flag
+			f(32)          // num_units_in_display_tick
+			f(32)          // time_scale
+			if f(1) != 0 { // equal_picture_interval
+				// num_ticks_per_picture_minus_1, uvlc
+				zeros := 0
+				for err == nil && f(1) == 0 {
+					zeros++
+				}
+				if zeros < 32 {
+					f(zeros)
+				}
+			}
+			decoderModelInfo = f(1) != 0
+			if decoderModelInfo {
+				bufferDelayLength = int(f(5)) + 1
+				f(32) // num_units_in_decoding_tick
+				f(5)  // buffer_removal_time_length_minus_1
+				f(5)  // frame_presentation_time_length_minus_1
+			}
+		}
+		initialDisplayDelay := f(1) != 0
+		count := int(f(5)) + 1
+		for i := 0; i < count; i++ {
+			f(12) // operating_point_idc
+			level := byte(f(5))
+			var tier byte
+			if level > 7 {
+				tier = byte(f(1))
+			}
+			if i == 0 {
+				seq.level = level
+				seq.tier = tier
+			}
+			if decoderModelInfo {
+				if f(1) != 0 {
+					f(bufferDelayLength) // decoder_buffer_delay
+					f(bufferDelayLength) // encoder_buffer_delay
+					f(1)                 // low_delay_mode_flag
+				}
+			}
+			if initialDisplayDelay {
+				if f(1) != 0 {
+					f(4) // initial_display_delay_minus_1
+				}
+			}
+		}
+	}
+
+	widthBits := int(f(4)) + 1
+	heightBits := int(f(4)) + 1
+	seq.width = f(widthBits) + 1
+	seq.height = f(heightBits) + 1
+
+	if !reduced {
+		if f(1) != 0 { // frame_id_numbers_present_flag
+			f(4) // delta_frame_id_length_minus_2
+			f(3) // additional_frame_id_length_minus_1
+		}
+	}
+	f(1) // use_128x128_superblock
+	f(1) // enable_filter_intra
+	f(1) // enable_intra_edge_filter
+	if !reduced {
+		f(1) // enable_interintra_compound
+		f(1) // enable_masked_compound
+		f(1) // enable_warped_motion
+		f(1) // enable_dual_filter
+		orderHint := f(1) != 0
+		if orderHint {
+			f(1) // enable_jnt_comp
+			f(1) // enable_ref_frame_mvs
+		}
+		forceScreenContentTools := uint32(2)
+		if f(1) == 0 { // seq_choose_screen_content_tools
+			forceScreenContentTools = f(1)
+		}
+		if forceScreenContentTools > 0 {
+			if f(1) == 0 { // seq_choose_integer_mv
+				f(1) // seq_force_integer_mv
+			}
+		}
+		if orderHint {
+			f(3) // order_hint_bits_minus_1
+		}
+	}
+	f(1) // enable_superres
+	f(1) // enable_cdef
+	f(1) // enable_restoration
+
+	// color_config
+	seq.highBitdepth = byte(f(1))
+	bitDepth := 8
+	if seq.profile == 2 && seq.highBitdepth != 0 {
+		seq.twelveBit = byte(f(1))
+		if seq.twelveBit != 0 {
+			bitDepth = 12
+		} else {
+			bitDepth = 10
+		}
+	}
+	if seq.profile != 1 {
+		seq.monochrome = byte(f(1))
+	}
+	primaries, transfer, matrix := uint32(2), uint32(2), uint32(2)
+	if f(1) != 0 { // color_description_present_flag
+		primaries = f(8)
+		transfer = f(8)
+		matrix = f(8)
+	}
+	if seq.monochrome != 0 {
+		seq.subsamplingX = 1
+		seq.subsamplingY = 1
+	} else if primaries == 1 && transfer == 13 && matrix == 0 {
+		// sRGB
+	} else {
+		f(1) // color_range
+		switch seq.profile {
+		case 0:
+			seq.subsamplingX = 1
+			seq.subsamplingY = 1
+		case 1:
+		default:
+			if bitDepth == 12 {
+				seq.subsamplingX = byte(f(1))
+				if seq.subsamplingX != 0 {
+					seq.subsamplingY = byte(f(1))
+				}
+			} else {
+				seq.subsamplingX = 1
+			}
+		}
+		if seq.subsamplingX != 0 && seq.subsamplingY != 0 {
+			seq.chromaSamplePosition = byte(f(2))
+		}
+	}
+
+	if err != nil {
+		return av1SequenceHeader{}, err
+	}
+	return seq, nil
+}
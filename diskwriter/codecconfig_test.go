@@ -0,0 +1,155 @@
+package diskwriter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type bitWriter struct {
+	data []byte
+	n    int
+}
+
+func (w *bitWriter) bits(n int, v uint32) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		if (v>>i)&1 != 0 {
+			w.data[len(w.data)-1] |= 1 << (7 - w.n%8)
+		}
+		w.n++
+	}
+}
+
+func (w *bitWriter) ue(v uint32) {
+	v++
+	n := 0
+	for (v >> n) > 1 {
+		n++
+	}
+	w.bits(n, 0)
+	w.bits(n+1, v)
+}
+
+func TestBitReader(t *testing.T) {
+	w := &bitWriter{}
+	values := []uint32{0, 1, 2, 3, 7, 42, 1000}
+	for _, v := range values {
+		w.ue(v)
+	}
+	r := &bitReader{data: w.data}
+	for _, v := range values {
+		vv, err := r.ue()
+		if err != nil || vv != v {
+			t.Errorf("Expected %v, got %v (%v)", v, vv, err)
+		}
+	}
+}
+
+func TestUnescapeRBSP(t *testing.T) {
+	in := []byte{1, 0, 0, 3, 0, 0, 3, 1, 0, 3}
+	out := []byte{1, 0, 0, 0, 0, 1, 0, 3}
+	if !bytes.Equal(unescapeRBSP(in), out) {
+		t.Errorf("Expected %v, got %v", out, unescapeRBSP(in))
+	}
+}
+
+func h264SPS() []byte {
+	// 1920x1080, constrained baseline
+	w := &bitWriter{}
+	w.bits(8, 66) // profile_idc
+	w.bits(8, 0xC0)
+	w.bits(8, 40) // level_idc
+	w.ue(0)       // seq_parameter_set_id
+	w.ue(0)       // log2_max_frame_num_minus4
+	w.ue(2)       // pic_order_cnt_type
+	w.ue(1)       // max_num_ref_frames
+	w.bits(1, 0)  // gaps_in_frame_num_value_allowed_flag
+	w.ue(119)     // pic_width_in_mbs_minus1
+	w.ue(67)      // pic_height_in_map_units_minus1
+	w.bits(1, 1)  // frame_mbs_only_flag
+	w.bits(1, 1)  // direct_8x8_inference_flag
+	w.bits(1, 1)  // frame_cropping_flag
+	w.ue(0)
+	w.ue(0)
+	w.ue(0)
+	w.ue(4)
+	w.bits(1, 0) // vui_parameters_present_flag
+	w.bits(1, 1) // rbsp_stop_one_bit
+	return append([]byte{0x67}, w.data...)
+}
+
+func TestH264Config(t *testing.T) {
+	sps := h264SPS()
+	pps := []byte{0x68, 0xCE, 0x38, 0x80}
+	idr := []byte{0x65, 0x88, 0x84}
+
+	var data []byte
+	for _, nalu := range [][]byte{sps, pps, idr} {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(nalu)))
+		data = append(data, nalu...)
+	}
+
+	w, h, avcc, err := h264Config(data)
+	if err != nil {
+		t.Fatalf("h264Config: %v", err)
+	}
+	if w != 1920 || h != 1080 {
+		t.Errorf("Expected 1920x1080, got %vx%v", w, h)
+	}
+	if len(avcc) != 6+2+len(sps)+1+2+len(pps) ||
+		avcc[0] != 1 || avcc[1] != 66 || avcc[3] != 40 ||
+		avcc[4] != 0xFF || avcc[5] != 0xE1 {
+		t.Errorf("Bad avcC %v", avcc)
+	}
+
+	_, _, _, err = h264Config(data[4+len(sps):])
+	if err != errNoConfig {
+		t.Errorf("Expected errNoConfig, got %v", err)
+	}
+}
+
+func TestAV1Config(t *testing.T) {
+	// reduced still picture header, 640x360, profile 0, level 8
+	w := &bitWriter{}
+	w.bits(3, 0)    // seq_profile
+	w.bits(1, 1)    // still_picture
+	w.bits(1, 1)    // reduced_still_picture_header
+	w.bits(5, 8)    // seq_level_idx[0]
+	w.bits(4, 9)    // frame_width_bits_minus_1
+	w.bits(4, 8)    // frame_height_bits_minus_1
+	w.bits(10, 639) // max_frame_width_minus_1
+	w.bits(9, 359)  // max_frame_height_minus_1
+	w.bits(3, 0)    // 128x128, filter_intra, intra_edge_filter
+	w.bits(3, 0)    // superres, cdef, restoration
+	w.bits(1, 0)    // high_bitdepth
+	w.bits(1, 0)    // mono_chrome
+	w.bits(1, 0)    // color_description_present_flag
+	w.bits(1, 0)    // color_range
+	w.bits(2, 0)    // chroma_sample_position
+	w.bits(1, 0)    // separate_uv_delta_q
+	w.bits(1, 1)    // trailing bit
+
+	seq := append([]byte{1<<3 | 0x02, byte(len(w.data))}, w.data...)
+	frame := []byte{6<<3 | 0x02, 2, 0xAA, 0xBB}
+	data := append(append([]byte{}, seq...), frame...)
+
+	width, height, av1c, err := av1Config(data)
+	if err != nil {
+		t.Fatalf("av1Config: %v", err)
+	}
+	if width != 640 || height != 360 {
+		t.Errorf("Expected 640x360, got %vx%v", width, height)
+	}
+	expected := append([]byte{0x81, 8, 0x0C, 0}, seq...)
+	if !bytes.Equal(av1c, expected) {
+		t.Errorf("Expected %v, got %v", expected, av1c)
+	}
+
+	_, _, _, err = av1Config(frame)
+	if err != errNoConfig {
+		t.Errorf("Expected errNoConfig, got %v", err)
+	}
+}
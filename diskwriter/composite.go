@@ -0,0 +1,372 @@
+package diskwriter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/at-wat/ebml-go/mkvcore"
+	"github.com/at-wat/ebml-go/webm"
+)
+
+// A composite is a recording session that writes all the streams of
+// a group into a single Matroska file, with one track per stream.
+//
+// Matroska requires all tracks to be declared in the header, yet
+// participants may start publishing at any time.  We therefore spool
+// the blocks of each track to a temporary file, and build the final
+// file when the session is closed.  The final file is only created at
+// that point, so that a crash leaves the spool files rather than an
+// empty file.
+type composite struct {
+	root  *os.Root
+	group string
+	dir   string
+	start time.Time
+
+	// the name of the final file, which is recorded in the manifest
+	// before the file is created; protected by mu after the session
+	// starts, since it changes if the name is already taken
+	filename string
+
+	mu     sync.Mutex
+	tracks []*spool
+	closed bool
+	// whether the final file was successfully written
+	written bool
+}
+
+// A spool holds the blocks of a single track of a composite recording.
+type spool struct {
+	entry  webm.TrackEntry
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func newComposite(root *os.Root, group, id string) (*composite, error) {
+	dir := ".composite-" + id
+	err := root.Mkdir(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	c := &composite{
+		root:     root,
+		group:    group,
+		dir:      dir,
+		start:    start,
+		filename: diskFilename(start, "") + ".mkv",
+	}
+	setActive(group, c.filename, true)
+	return c, nil
+}
+
+// Filename returns the name of the final file.
+func (c *composite) Filename() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.filename
+}
+
+// addTrack adds a new track to a composite recording.
+func (c *composite) addTrack(entry webm.TrackEntry) (*spool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, os.ErrClosed
+	}
+
+	entry.TrackNumber = uint64(len(c.tracks) + 1)
+	entry.TrackUID = entry.TrackNumber
+	f, err := c.root.OpenFile(
+		c.spoolName(entry.TrackNumber),
+		os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600,
+	)
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{
+		entry:  entry,
+		file:   f,
+		writer: bufio.NewWriter(f),
+	}
+	c.tracks = append(c.tracks, s)
+	return s, nil
+}
+
+func (c *composite) spoolName(number uint64) string {
+	return path.Join(c.dir, fmt.Sprintf("%v.spool", number))
+}
+
+// A spooled block is stored as a 64-bit timestamp in milliseconds,
+// a flags byte and a 32-bit length, followed by the data.
+const spoolHeaderSize = 13
+
+func (s *spool) write(keyframe bool, timestamp int64, data []byte) error {
+	var h [spoolHeaderSize]byte
+	binary.BigEndian.PutUint64(h[0:8], uint64(timestamp))
+	if keyframe {
+		h[8] = 1
+	}
+	binary.BigEndian.PutUint32(h[9:13], uint32(len(data)))
+	_, err := s.writer.Write(h[:])
+	if err != nil {
+		return err
+	}
+	_, err = s.writer.Write(data)
+	return err
+}
+
+type spoolBlock struct {
+	keyframe  bool
+	timestamp int64
+	data      []byte
+}
+
+func readSpoolBlock(r io.Reader) (spoolBlock, error) {
+	var h [spoolHeaderSize]byte
+	_, err := io.ReadFull(r, h[:])
+	if err != nil {
+		return spoolBlock{}, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(h[9:13]))
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return spoolBlock{}, err
+	}
+	return spoolBlock{
+		keyframe:  h[8]&1 != 0,
+		timestamp: int64(binary.BigEndian.Uint64(h[0:8])),
+		data:      data,
+	}, nil
+}
+
+// compositeWriter implements mkvcore.BlockWriteCloser by writing to
+// a spool.  Timestamps are shifted by offset, the time in milliseconds
+// between the start of the session and the origin of the connection.
+// Called with the diskConn locked.
+type compositeWriter struct {
+	spool  *spool
+	offset int64
+}
+
+func (w *compositeWriter) Write(keyframe bool, timestamp int64, data []byte) (int, error) {
+	tm := timestamp + w.offset
+	if tm < 0 {
+		return len(data), nil
+	}
+	err := w.spool.write(keyframe, tm, data)
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (w *compositeWriter) Close() error {
+	return w.spool.writer.Flush()
+}
+
+// Close builds the final file from the spooled tracks, and removes the
+// spool files.
+func (c *composite) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	defer func() {
+		setActive(c.group, c.filename, false)
+		for _, s := range c.tracks {
+			s.file.Close()
+			c.root.Remove(c.spoolName(s.entry.TrackNumber))
+		}
+		c.tracks = nil
+		c.root.Remove(c.dir)
+	}()
+
+	if len(c.tracks) == 0 {
+		return nil
+	}
+
+	readers := make([]*bufio.Reader, len(c.tracks))
+	for i, s := range c.tracks {
+		err := s.writer.Flush()
+		if err != nil {
+			return err
+		}
+		_, err = s.file.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		readers[i] = bufio.NewReader(s.file)
+	}
+
+	desc := make([]mkvcore.TrackDescription, len(c.tracks))
+	for i, s := range c.tracks {
+		desc[i] = mkvcore.TrackDescription{
+			TrackNumber: s.entry.TrackNumber,
+			TrackEntry:  s.entry,
+		}
+	}
+
+	file, err := c.root.OpenFile(
+		c.filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600,
+	)
+	if errors.Is(err, os.ErrExist) {
+		// unlikely, but don't overwrite an unrelated file
+		file, err = openDiskFile(c.root, c.start, "", "mkv")
+		if err == nil {
+			setActive(c.group, c.filename, false)
+			c.filename = filepath.Base(file.Name())
+			setActive(c.group, c.filename, true)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	header := *webm.DefaultEBMLHeader
+	header.DocType = "matroska"
+
+	ws, err := mkvcore.NewSimpleBlockWriter(
+		file, desc,
+		mkvcore.WithEBMLHeader(&header),
+		mkvcore.WithSegmentInfo(webm.DefaultSegmentInfo),
+	)
+	if err != nil {
+		file.Close()
+		c.root.Remove(c.filename)
+		return err
+	}
+
+	// closing the last writer closes the file
+	defer func() {
+		for _, w := range ws {
+			w.Close()
+		}
+	}()
+
+	err = mergeSpools(readers, ws)
+	if err != nil {
+		return err
+	}
+	c.written = true
+	return nil
+}
+
+// mergeSpools writes the blocks read from rs to the corresponding
+// writers in ws in timestamp order.  Each spool is already sorted.
+func mergeSpools(rs []*bufio.Reader, ws []mkvcore.BlockWriteCloser) error {
+	heads := make([]*spoolBlock, len(rs))
+	next := func(i int) error {
+		b, err := readSpoolBlock(rs[i])
+		if err == io.EOF {
+			heads[i] = nil
+			return nil
+		} else if err != nil {
+			heads[i] = nil
+			return err
+		}
+		heads[i] = &b
+		return nil
+	}
+
+	var errs []error
+	for i := range rs {
+		err := next(i)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for {
+		first := -1
+		for i, h := range heads {
+			if h != nil && (first < 0 ||
+				h.timestamp < heads[first].timestamp) {
+				first = i
+			}
+		}
+		if first < 0 {
+			break
+		}
+		h := heads[first]
+		_, err := ws[first].Write(h.keyframe, h.timestamp, h.data)
+		if err != nil {
+			return err
+		}
+		err = next(first)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// initCompositeWriter is the equivalent of initWriter for composite
+// recordings.  Since Matroska allows the resolution to change within
+// a track, there is no need to reopen the writers once they exist.
+// Called locked.
+func (conn *diskConn) initCompositeWriter(width, height uint32, track *diskTrack, ts uint32) error {
+	for _, t := range conn.tracks {
+		if t.writer != nil {
+			return nil
+		}
+	}
+
+	if track != nil {
+		track.adjustOrigin(ts)
+	}
+
+	if conn.originLocal.Equal(time.Time{}) {
+		return errors.New("origin not set")
+	}
+
+	c := conn.client.composite
+	offset := conn.originLocal.Sub(c.start).Milliseconds()
+
+	for _, t := range conn.tracks {
+		if t.spool == nil {
+			entry, _, err := trackEntry(
+				t.remote.Codec(), 0, width, height, t.private,
+			)
+			if err != nil {
+				return err
+			}
+			if conn.username != "" {
+				entry.Name = conn.username + " " + entry.Name
+			}
+			t.spool, err = c.addTrack(entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	numbers := make([]uint64, 0, len(conn.tracks))
+	for _, t := range conn.tracks {
+		t.writer = &compositeWriter{
+			spool:  t.spool,
+			offset: offset,
+		}
+		numbers = append(numbers, t.spool.entry.TrackNumber)
+	}
+
+	conn.addToManifest(c.Filename(), numbers)
+	return nil
+}
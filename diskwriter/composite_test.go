@@ -0,0 +1,171 @@
+package diskwriter
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/at-wat/ebml-go/mkvcore"
+	"github.com/at-wat/ebml-go/webm"
+)
+
+type testBlock struct {
+	track     int
+	timestamp int64
+}
+
+type testBlockWriter struct {
+	track  int
+	blocks *[]testBlock
+}
+
+func (w testBlockWriter) Write(keyframe bool, timestamp int64, b []byte) (int, error) {
+	*w.blocks = append(*w.blocks, testBlock{w.track, timestamp})
+	return len(b), nil
+}
+
+func (w testBlockWriter) Close() error {
+	return nil
+}
+
+func TestMergeSpools(t *testing.T) {
+	timestamps := [][]int64{
+		{0, 20, 40, 60},
+		{10, 30, 35},
+		{},
+		{50},
+	}
+
+	var blocks []testBlock
+	rs := make([]*bufio.Reader, len(timestamps))
+	ws := make([]mkvcore.BlockWriteCloser, len(timestamps))
+	for i, tss := range timestamps {
+		var buf bytes.Buffer
+		s := &spool{writer: bufio.NewWriter(&buf)}
+		for _, ts := range tss {
+			err := s.write(ts == 0, ts, []byte{byte(i)})
+			if err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+		}
+		s.writer.Flush()
+		rs[i] = bufio.NewReader(&buf)
+		ws[i] = testBlockWriter{i, &blocks}
+	}
+
+	err := mergeSpools(rs, ws)
+	if err != nil {
+		t.Fatalf("mergeSpools: %v", err)
+	}
+
+	expected := []testBlock{
+		{0, 0}, {1, 10}, {0, 20}, {1, 30}, {1, 35},
+		{0, 40}, {3, 50}, {0, 60},
+	}
+	if len(blocks) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, blocks)
+	}
+	for i := range blocks {
+		if blocks[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, blocks)
+			break
+		}
+	}
+}
+
+func TestReadSpoolBlockTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	s := &spool{writer: bufio.NewWriter(&buf)}
+	s.write(true, 42, []byte("hello"))
+	s.writer.Flush()
+
+	b, err := readSpoolBlock(bytes.NewReader(buf.Bytes()))
+	if err != nil || !b.keyframe || b.timestamp != 42 ||
+		string(b.data) != "hello" {
+		t.Errorf("Got %v %v", b, err)
+	}
+
+	_, err = readSpoolBlock(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	if err == nil {
+		t.Errorf("Expected error")
+	}
+}
+
+func TestCompositeClose(t *testing.T) {
+	root, err := os.OpenRoot(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenRoot: %v", err)
+	}
+	defer root.Close()
+
+	names := func() []string {
+		d, err := root.Open(".")
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		defer d.Close()
+		n, err := d.Readdirnames(-1)
+		if err != nil {
+			t.Fatalf("Readdirnames: %v", err)
+		}
+		slices.Sort(n)
+		return n
+	}
+
+	c, err := newComposite(root, "test", "empty")
+	if err != nil {
+		t.Fatalf("newComposite: %v", err)
+	}
+	err = c.Close()
+	if err != nil || c.written {
+		t.Errorf("Close (empty): %v %v", err, c.written)
+	}
+	if n := names(); len(n) != 0 {
+		t.Errorf("Expected no files, got %v", n)
+	}
+
+	c, err = newComposite(root, "test", "test")
+	if err != nil {
+		t.Fatalf("newComposite: %v", err)
+	}
+	s, err := c.addTrack(webm.TrackEntry{
+		Name: "Audio", CodecID: "A_OPUS", TrackType: 2,
+	})
+	if err != nil {
+		t.Fatalf("addTrack: %v", err)
+	}
+	err = s.write(true, 0, []byte{1, 2, 3})
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if n := names(); !slices.Equal(n, []string{".composite-test"}) {
+		t.Errorf("Expected only the spool directory, got %v", n)
+	}
+
+	// occupy the announced name
+	name := c.Filename()
+	f, err := root.Create(name)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	f.Close()
+
+	err = c.Close()
+	if err != nil || !c.written {
+		t.Fatalf("Close: %v %v", err, c.written)
+	}
+	if c.Filename() == name {
+		t.Errorf("Existing file was overwritten")
+	}
+	expected := []string{name, c.Filename()}
+	slices.Sort(expected)
+	if n := names(); !slices.Equal(n, expected) {
+		t.Errorf("Unexpected files %v", n)
+	}
+	fi, err := root.Stat(c.Filename())
+	if err != nil || fi.Size() == 0 {
+		t.Errorf("Bad output file: %v", err)
+	}
+}
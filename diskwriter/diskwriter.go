@@ -18,6 +18,7 @@ import (
 	"github.com/at-wat/ebml-go/webm"
 	"github.com/pion/rtp"
 	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
 	"github.com/pion/webrtc/v4/pkg/media"
 
 	"github.com/jech/samplebuilder"
@@ -26,6 +27,7 @@ import (
 	"github.com/jech/galene/conn"
 	"github.com/jech/galene/group"
 	"github.com/jech/galene/rtptime"
+	"github.com/jech/galene/webhook"
 )
 
 const (
@@ -36,9 +38,12 @@ const (
 var Directory string
 
 type Client struct {
-	group *group.Group
-	id    string
-	root  *os.Root
+	group     *group.Group
+	id        string
+	root      *os.Root
+	manifest  *manifest
+	composite *composite
+	format    string
 
 	mu     sync.Mutex
 	down   map[string]*diskConn
@@ -63,7 +68,44 @@ func New(g *group.Group) (*Client, error) {
 		return nil, err
 	}
 
-	return &Client{group: g, id: newId(), root: root}, nil
+	client := &Client{group: g, id: newId(), root: root}
+
+	mode := g.Description().RecordingMode
+	switch mode {
+	case "":
+		mode = "separate"
+	case "separate", "composite":
+	default:
+		root.Close()
+		return nil, errors.New("unknown recording mode")
+	}
+
+	switch g.Description().RecordingFormat {
+	case "", "webm":
+	case "ogg":
+		client.format = "ogg"
+	default:
+		root.Close()
+		return nil, errors.New("unknown recording format")
+	}
+
+	client.manifest, err = newManifest(root, g.Name(), client.id, mode)
+	if err != nil {
+		root.Close()
+		return nil, err
+	}
+
+	if mode == "composite" {
+		client.composite, err = newComposite(root, g.Name(), client.id)
+		if err != nil {
+			setActive(g.Name(), client.manifest.filename, false)
+			root.Remove(client.manifest.filename)
+			root.Close()
+			return nil, err
+		}
+	}
+
+	return client, nil
 }
 
 func (client *Client) Group() *group.Group {
@@ -101,12 +143,57 @@ func (client *Client) RequestConns(target group.Client, g *group.Group, id strin
 func (client *Client) Close() error {
 	client.mu.Lock()
 	defer client.mu.Unlock()
+	if client.closed {
+		return nil
+	}
 	for _, down := range client.down {
 		down.Close()
 	}
 	client.down = nil
-	client.root.Close()
 	client.closed = true
+
+	event := webhook.Event{
+		Type:     "recording-stop",
+		Id:       client.id,
+		Filename: client.manifest.filename,
+	}
+
+	if client.composite == nil {
+		err := client.manifest.close()
+		client.root.Close()
+		client.group.SendWebhook(event)
+		return err
+	}
+
+	// Building the composite file may take a while
+	c, m, root, g :=
+		client.composite, client.manifest, client.root, client.group
+	go func() {
+		name := c.Filename()
+		err := c.Close()
+		if err != nil {
+			log.Printf("Disk writer: %v", err)
+			g.WallOps("Write to disk: " + err.Error())
+		} else if c.written {
+			g.SendWebhook(webhook.Event{
+				Type:     "recording-file",
+				Id:       event.Id,
+				Filename: c.Filename(),
+			})
+		}
+		if c.Filename() != name {
+			err = m.rename(name, c.Filename())
+			if err != nil {
+				log.Printf("Disk writer: manifest: %v", err)
+			}
+		}
+		err = m.close()
+		if err != nil {
+			log.Printf("Disk writer: %v", err)
+		}
+		root.Close()
+		g.SendWebhook(event)
+	}()
 	return nil
 }
 
@@ -121,6 +208,13 @@ func (client *Client) Addr() net.Addr {
 }
 
 func (client *Client) Joined(group, kind string) error {
+	if kind == "join" {
+		client.group.SendWebhook(webhook.Event{
+			Type:     "recording-start",
+			Id:       client.id,
+			Filename: client.manifest.filename,
+		})
+	}
 	return nil
 }
 
@@ -183,6 +277,8 @@ type diskConn struct {
 	lastWarning   time.Time
 	originLocal   time.Time
 	originRemote  uint64
+	manifestIndex int
+	duration      uint32
 }
 
 // called locked
@@ -202,17 +298,67 @@ func (conn *diskConn) open(extension string) error {
 		return errors.New("already open")
 	}
 
-	file, err := openDiskFile(conn.client.root, conn.username, extension)
+	file, err := openDiskFile(
+		conn.client.root, time.Now(), conn.username, extension,
+	)
 	if err != nil {
 		return err
 	}
 
 	conn.file = file
+	setActive(conn.client.group.Name(), filepath.Base(file.Name()), true)
 	return nil
 }
 
+// abort closes the current file after we failed to set up its writer.
+// called locked
+func (conn *diskConn) abort() {
+	conn.file.Close()
+	setActive(
+		conn.client.group.Name(), filepath.Base(conn.file.Name()), false,
+	)
+	conn.file = nil
+}
+
+// originTime returns the wall-clock time of the origin, using the
+// sender's clock if known.
 // called locked
-func (conn *diskConn) close() []*diskTrack {
+func (conn *diskConn) originTime() time.Time {
+	if conn.originRemote != 0 {
+		return rtptime.NTPToTime(conn.originRemote)
+	}
+	return conn.originLocal
+}
+
+// addToManifest records the fact that we started writing to filename.
+// called locked
+func (conn *diskConn) addToManifest(filename string, tracks []uint64) {
+	codecs := make([]string, 0, len(conn.tracks))
+	for _, t := range conn.tracks {
+		codecs = append(codecs, t.remote.Codec().MimeType)
+	}
+	id, _ := conn.remote.User()
+	index, err := conn.client.manifest.add(ManifestFile{
+		Filename: filename,
+		Tracks:   tracks,
+		Username: conn.username,
+		ClientId: id,
+		Label:    conn.remote.Label(),
+		Start:    conn.originTime(),
+		Codecs:   codecs,
+	})
+	if err != nil {
+		log.Printf("Disk writer: manifest: %v", err)
+	}
+	conn.manifestIndex = index
+	conn.duration = 0
+}
+
+// close closes the current file.  Split indicates the reason why the
+// file is being closed while the stream goes on, if any.
+// called locked
+func (conn *diskConn) close(split string) []*diskTrack {
+	start := conn.originTime()
 	conn.originLocal = time.Time{}
 	conn.originRemote = 0
 
@@ -226,6 +372,31 @@ func (conn *diskConn) close() []*diskTrack {
 		t.origin = none
 		tracks = append(tracks, t)
 	}
+
+	if conn.file != nil {
+		filename := filepath.Base(conn.file.Name())
+		setActive(conn.client.group.Name(), filename, false)
+		conn.client.group.SendWebhook(webhook.Event{
+			Type:     "recording-file",
+			Id:       conn.client.id,
+			Username: conn.username,
+			Filename: filename,
+		})
+	}
+
+	if conn.manifestIndex >= 0 {
+		end := start.Add(
+			time.Duration(conn.duration) * time.Millisecond,
+		)
+		err := conn.client.manifest.finish(
+			conn.manifestIndex, start, end, split,
+		)
+		if err != nil {
+			log.Printf("Disk writer: manifest: %v", err)
+		}
+		conn.manifestIndex = -1
+	}
+
 	conn.file = nil
 	return tracks
 }
@@ -234,7 +405,7 @@ func (conn *diskConn) Close() error {
 	conn.remote.DelLocal(conn)
 
 	conn.mu.Lock()
-	tracks := conn.close()
+	tracks := conn.close("")
 	conn.mu.Unlock()
 
 	for _, t := range tracks {
@@ -254,16 +425,23 @@ func sanitise(s string) string {
 	return replacer.Replace(s)
 }
 
-func openDiskFile(root *os.Root, username, extension string) (*os.File, error) {
+// diskFilename returns the name, without extension, of a file started
+// at time tm by the given user.
+func diskFilename(tm time.Time, username string) string {
 	filenameFormat := "2006-01-02T15:04:05.000"
 	if runtime.GOOS == "windows" {
 		filenameFormat = "2006-01-02T15-04-05-000"
 	}
 
-	filename := time.Now().Format(filenameFormat)
+	filename := tm.Format(filenameFormat)
 	if username != "" {
 		filename = filename + "-" + sanitise(username)
 	}
+	return filename
+}
+
+func openDiskFile(root *os.Root, tm time.Time, username, extension string) (*os.File, error) {
+	filename := diskFilename(tm, username)
 	for counter := 0; counter < 100; counter++ {
 		var fn string
 		if counter == 0 {
@@ -307,6 +485,8 @@ type diskTrack struct {
 	conn   *diskConn
 
 	writer    mkvcore.BlockWriteCloser
+	spool     *spool
+	private   []byte
 	builder   *samplebuilder.SampleBuilder
 	lastSeqno maybeUint32
 
@@ -333,7 +513,8 @@ func newDiskConn(client *Client, up conn.Up, remoteTracks []conn.UpTrack) (*disk
 			}
 		} else if strings.EqualFold(codec, "video/vp8") ||
 			strings.EqualFold(codec, "video/vp9") ||
-			strings.EqualFold(codec, "video/h264") {
+			strings.EqualFold(codec, "video/h264") ||
+			strings.EqualFold(codec, "video/av1") {
 			if video == nil || video.Label() == "l" {
 				video = remote
 			} else if remote.Label() != "l" {
@@ -358,10 +539,11 @@ func newDiskConn(client *Client, up conn.Up, remoteTracks []conn.UpTrack) (*disk
 
 	_, username := up.User()
 	conn := diskConn{
-		client:   client,
-		username: username,
-		tracks:   make([]*diskTrack, 0, len(tracks)),
-		remote:   up,
+		client:        client,
+		username:      username,
+		tracks:        make([]*diskTrack, 0, len(tracks)),
+		remote:        up,
+		manifestIndex: -1,
 	}
 
 	for _, remote := range tracks {
@@ -385,8 +567,15 @@ func newDiskConn(client *Client, up conn.Up, remoteTracks []conn.UpTrack) (*disk
 			)
 			conn.hasVideo = true
 		} else if strings.EqualFold(codec.MimeType, "video/h264") {
+			// Matroska wants length-prefixed NAL units
 			builder = samplebuilder.New(
-				videoMaxLate, &codecs.H264Packet{},
+				videoMaxLate, &codecs.H264Packet{IsAVC: true},
+				codec.ClockRate,
+			)
+			conn.hasVideo = true
+		} else if strings.EqualFold(codec.MimeType, "video/av1") {
+			builder = samplebuilder.New(
+				videoMaxLate, &codecs.AV1Depacketizer{},
 				codec.ClockRate,
 			)
 			conn.hasVideo = true
@@ -556,7 +745,7 @@ func (t *diskTrack) writeBuffered(force bool) error {
 			}
 			// we've gone around 2^31 timestamps, force
 			// creating a new file to avoid wraparound
-			t.conn.close()
+			t.conn.close("wraparound")
 		}
 
 		var keyframe bool
@@ -568,15 +757,24 @@ func (t *diskTrack) writeBuffered(force bool) error {
 			}
 
 			if keyframe {
-				w, h := gcodecs.KeyframeDimensions(
-					codec, t.savedKf,
+				w, h, private, err := keyframeConfig(
+					codec, t.savedKf, sample.Data,
 				)
-				err := t.conn.initWriter(w, h, t, ts)
 				if err != nil {
-					t.conn.warn(
-						"Write to disk " + err.Error(),
-					)
-					return err
+					// we cannot start a file without
+					// the codec configuration
+					keyframe = false
+					requestKeyframe(t)
+				} else {
+					t.private = private
+					err := t.conn.initWriter(w, h, t, ts)
+					if err != nil {
+						t.conn.warn(
+							"Write to disk " +
+								err.Error(),
+						)
+						return err
+					}
 				}
 			}
 		} else {
@@ -610,6 +808,9 @@ func (t *diskTrack) writeBuffered(force bool) error {
 		if err != nil {
 			return err
 		}
+		if tm > t.conn.duration {
+			t.conn.duration = tm
+		}
 	}
 }
 
@@ -719,68 +920,81 @@ func (t *diskTrack) adjustOrigin(ts uint32) {
 	}
 }
 
+// trackEntry returns the Matroska track entry for a track with the given
+// codec.  The boolean is false if the codec is not allowed in WebM.
+func trackEntry(codec webrtc.RTPCodecCapability, number uint64, width, height uint32, private []byte) (webm.TrackEntry, bool, error) {
+	if strings.EqualFold(codec.MimeType, "audio/opus") {
+		return webm.TrackEntry{
+			Name:        "Audio",
+			TrackNumber: number,
+			CodecID:     "A_OPUS",
+			TrackType:   2,
+			Audio: &webm.Audio{
+				SamplingFrequency: float64(codec.ClockRate),
+				Channels:          uint64(codec.Channels),
+			},
+		}, true, nil
+	}
+
+	var codecID string
+	isWebm := true
+	if strings.EqualFold(codec.MimeType, "video/vp8") {
+		codecID = "V_VP8"
+	} else if strings.EqualFold(codec.MimeType, "video/vp9") {
+		codecID = "V_VP9"
+	} else if strings.EqualFold(codec.MimeType, "video/h264") {
+		codecID = "V_MPEG4/ISO/AVC"
+		isWebm = false
+	} else if strings.EqualFold(codec.MimeType, "video/av1") {
+		codecID = "V_AV1"
+	} else {
+		return webm.TrackEntry{}, false, errors.New("unknown track type")
+	}
+	return webm.TrackEntry{
+		Name:         "Video",
+		TrackNumber:  number,
+		CodecID:      codecID,
+		CodecPrivate: private,
+		TrackType:    1,
+		Video: &webm.Video{
+			PixelWidth:  uint64(width),
+			PixelHeight: uint64(height),
+		},
+	}, isWebm, nil
+}
+
 // called locked
 func (conn *diskConn) initWriter(width, height uint32, track *diskTrack, ts uint32) error {
+	if conn.client.composite != nil {
+		return conn.initCompositeWriter(width, height, track, ts)
+	}
+
 	if conn.file != nil {
 		if width == conn.width && height == conn.height {
 			return nil
 		} else {
-			conn.close()
+			conn.close("resolution")
 		}
 	}
 
+	if conn.client.format == "ogg" && len(conn.tracks) == 1 &&
+		strings.EqualFold(
+			conn.tracks[0].remote.Codec().MimeType, "audio/opus",
+		) {
+		return conn.initOggWriter(track, ts)
+	}
+
 	isWebm := true
 	var desc []mkvcore.TrackDescription
 	for i, t := range conn.tracks {
-		var entry webm.TrackEntry
-		codec := t.remote.Codec()
-		if strings.EqualFold(codec.MimeType, "audio/opus") {
-			entry = webm.TrackEntry{
-				Name:        "Audio",
-				TrackNumber: uint64(i + 1),
-				CodecID:     "A_OPUS",
-				TrackType:   2,
-				Audio: &webm.Audio{
-					SamplingFrequency: float64(codec.ClockRate),
-					Channels:          uint64(codec.Channels),
-				},
-			}
-		} else if strings.EqualFold(codec.MimeType, "video/vp8") {
-			entry = webm.TrackEntry{
-				Name:        "Video",
-				TrackNumber: uint64(i + 1),
-				CodecID:     "V_VP8",
-				TrackType:   1,
-				Video: &webm.Video{
-					PixelWidth:  uint64(width),
-					PixelHeight: uint64(height),
-				},
-			}
-		} else if strings.EqualFold(codec.MimeType, "video/vp9") {
-			entry = webm.TrackEntry{
-				Name:        "Video",
-				TrackNumber: uint64(i + 1),
-				CodecID:     "V_VP9",
-				TrackType:   1,
-				Video: &webm.Video{
-					PixelWidth:  uint64(width),
-					PixelHeight: uint64(height),
-				},
-			}
-		} else if strings.EqualFold(codec.MimeType, "video/h264") {
-			entry = webm.TrackEntry{
-				Name:        "Video",
-				TrackNumber: uint64(i + 1),
-				CodecID:     "V_MPEG4/ISO/AVC",
-				TrackType:   1,
-				Video: &webm.Video{
-					PixelWidth:  uint64(width),
-					PixelHeight: uint64(height),
-				},
-			}
+		entry, compatible, err := trackEntry(
+			t.remote.Codec(), uint64(i+1), width, height, t.private,
+		)
+		if err != nil {
+			return err
+		}
+		if !compatible {
 			isWebm = false
-		} else {
-			return errors.New("unknown track type")
 		}
 		desc = append(desc,
 			mkvcore.TrackDescription{
@@ -814,8 +1028,7 @@ func (conn *diskConn) initWriter(width, height uint32, track *diskTrack, ts uint
 		mkvcore.WithSortRule(mkvcore.BlockSorterWriteOutdated),
 	)
 	if err != nil {
-		conn.file.Close()
-		conn.file = nil
+		conn.abort()
 		return err
 	}
 
@@ -826,14 +1039,12 @@ func (conn *diskConn) initWriter(width, height uint32, track *diskTrack, ts uint
 		mkvcore.WithBlockInterceptor(interceptor),
 	)
 	if err != nil {
-		conn.file.Close()
-		conn.file = nil
+		conn.abort()
 		return err
 	}
 
 	if len(ws) != len(conn.tracks) {
-		conn.file.Close()
-		conn.file = nil
+		conn.abort()
 		return errors.New("unexpected number of writers")
 	}
 
@@ -843,6 +1054,36 @@ func (conn *diskConn) initWriter(width, height uint32, track *diskTrack, ts uint
 	for i, t := range conn.tracks {
 		t.writer = ws[i]
 	}
+
+	conn.addToManifest(filepath.Base(conn.file.Name()), nil)
+	return nil
+}
+
+// initOggWriter is the equivalent of initWriter for audio-only
+// connections recorded in Ogg format.
+// called locked
+func (conn *diskConn) initOggWriter(track *diskTrack, ts uint32) error {
+	if track != nil {
+		track.adjustOrigin(ts)
+	}
+
+	err := conn.open("ogg")
+	if err != nil {
+		return err
+	}
+
+	t := conn.tracks[0]
+	w, err := newOggWriter(conn.file, t.remote.Codec().Channels)
+	if err != nil {
+		conn.abort()
+		return err
+	}
+
+	conn.width = 0
+	conn.height = 0
+	t.writer = w
+
+	conn.addToManifest(filepath.Base(conn.file.Name()), nil)
 	return nil
 }
 
@@ -0,0 +1,185 @@
+package diskwriter
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Manifest describes a recording session.  It is written to a file with
+// extension ".json" in the recordings directory of the group, and is
+// updated whenever a file is started or finished.
+type Manifest struct {
+	Group string          `json:"group"`
+	Id    string          `json:"id"`
+	Mode  string          `json:"mode"`
+	Start time.Time       `json:"start"`
+	End   *time.Time      `json:"end,omitempty"`
+	Files []*ManifestFile `json:"files"`
+}
+
+// ManifestFile describes a recorded stream.  In composite mode, all
+// streams are in the same file, and Tracks indicates the track numbers.
+type ManifestFile struct {
+	Filename string     `json:"filename"`
+	Tracks   []uint64   `json:"tracks,omitempty"`
+	Username string     `json:"username,omitempty"`
+	ClientId string     `json:"clientId,omitempty"`
+	Label    string     `json:"label,omitempty"`
+	Start    time.Time  `json:"start"`
+	End      *time.Time `json:"end,omitempty"`
+	Codecs   []string   `json:"codecs"`
+	// Why the file was finished before the end of the stream, either
+	// "resolution" or "wraparound".
+	Split string `json:"split,omitempty"`
+}
+
+type manifest struct {
+	root *os.Root
+
+	mu       sync.Mutex
+	filename string
+	manifest Manifest
+}
+
+func newManifest(root *os.Root, group, id, mode string) (*manifest, error) {
+	start := time.Now()
+	file, err := openDiskFile(root, start, "", "json")
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{
+		root:     root,
+		filename: filepath.Base(file.Name()),
+		manifest: Manifest{
+			Group: group,
+			Id:    id,
+			Mode:  mode,
+			Start: start,
+			Files: []*ManifestFile{},
+		},
+	}
+	err = m.writeFile(file)
+	if err != nil {
+		root.Remove(m.filename)
+		return nil, err
+	}
+	setActive(group, m.filename, true)
+	return m, nil
+}
+
+// called locked
+func (m *manifest) writeFile(file *os.File) error {
+	e := json.NewEncoder(file)
+	e.SetIndent("", "    ")
+	err := e.Encode(&m.manifest)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// called locked
+func (m *manifest) write() error {
+	file, err := m.root.OpenFile(m.filename, os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	return m.writeFile(file)
+}
+
+// add adds a new file to the manifest, and returns its index.
+func (m *manifest) add(f ManifestFile) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.manifest.Files = append(m.manifest.Files, &f)
+	return len(m.manifest.Files) - 1, m.write()
+}
+
+// finish records the fact that a file is complete.  The start time is
+// updated, since it may have been refined by sender reports.
+func (m *manifest) finish(index int, start, end time.Time, split string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if index < 0 || index >= len(m.manifest.Files) {
+		return nil
+	}
+	f := m.manifest.Files[index]
+	f.Start = start
+	f.End = &end
+	f.Split = split
+	return m.write()
+}
+
+// rename records the fact that a file was written under a different name
+// than the one that was announced.
+func (m *manifest) rename(from, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, f := range m.manifest.Files {
+		if f.Filename == from {
+			f.Filename = to
+		}
+	}
+	return m.write()
+}
+
+// close records the end of the recording session.
+func (m *manifest) close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.manifest.End = &now
+	defer setActive(m.manifest.Group, m.filename, false)
+	return m.write()
+}
+
+// GetManifests returns the manifests of all recording sessions of
+// a given group, sorted by start time.
+func GetManifests(group string) ([]Manifest, error) {
+	root, err := os.OpenRoot(filepath.Join(Directory, group))
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+
+	dir, err := root.Open(".")
+	if err != nil {
+		return nil, err
+	}
+	names, err := dir.Readdirnames(-1)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := make([]Manifest, 0)
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		f, err := root.Open(name)
+		if err != nil {
+			continue
+		}
+		var m Manifest
+		err = json.NewDecoder(f).Decode(&m)
+		f.Close()
+		if err != nil {
+			log.Printf("Manifest %v: %v", name, err)
+			continue
+		}
+		manifests = append(manifests, m)
+	}
+
+	slices.SortFunc(manifests, func(a, b Manifest) int {
+		return a.Start.Compare(b.Start)
+	})
+	return manifests, nil
+}
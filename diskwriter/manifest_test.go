@@ -0,0 +1,62 @@
+package diskwriter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestManifest(t *testing.T) {
+	Directory = t.TempDir()
+	err := os.Mkdir(filepath.Join(Directory, "test"), 0700)
+	if err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	root, err := os.OpenRoot(filepath.Join(Directory, "test"))
+	if err != nil {
+		t.Fatalf("OpenRoot: %v", err)
+	}
+	defer root.Close()
+
+	m, err := newManifest(root, "test", "id", "separate")
+	if err != nil {
+		t.Fatalf("newManifest: %v", err)
+	}
+
+	now := time.Now()
+	index, err := m.add(ManifestFile{
+		Filename: "file.webm",
+		Username: "john",
+		Start:    now,
+		Codecs:   []string{"audio/opus"},
+	})
+	if err != nil || index != 0 {
+		t.Fatalf("add: %v %v", index, err)
+	}
+
+	err = m.finish(index, now, now.Add(time.Second), "resolution")
+	if err != nil {
+		t.Fatalf("finish: %v", err)
+	}
+
+	err = m.close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	ms, err := GetManifests("test")
+	if err != nil {
+		t.Fatalf("GetManifests: %v", err)
+	}
+	if len(ms) != 1 || ms[0].Id != "id" || ms[0].End == nil ||
+		len(ms[0].Files) != 1 {
+		t.Fatalf("Unexpected manifests %v", ms)
+	}
+	f := ms[0].Files[0]
+	if f.Filename != "file.webm" || f.Username != "john" ||
+		f.Split != "resolution" || f.End == nil ||
+		f.End.Sub(f.Start) != time.Second {
+		t.Errorf("Unexpected file %v", f)
+	}
+}
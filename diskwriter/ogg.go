@@ -0,0 +1,284 @@
+package diskwriter
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Ogg/Opus (RFC 7845) writer, used for recording audio-only streams.
+
+// oggCRCTable is the table for the CRC used by Ogg, which uses the
+// polynomial 0x04c11db7, unreflected, with initial value 0.
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = (r << 1) ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+func oggCRC(crc uint32, data []byte) uint32 {
+	for _, b := range data {
+		crc = (crc << 8) ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+const (
+	oggBOS = 0x02
+	oggEOS = 0x04
+)
+
+const (
+	// Opus always uses a 48kHz clock for granule positions.
+	opusRate = 48000
+	// Since we start recording in the middle of a stream, we ask the
+	// decoder to discard the first 80ms, as recommended by RFC 7845.
+	opusPreSkip = 3840
+	// We flush a page whenever it holds at least this many samples.
+	oggPageDuration = opusRate
+	// The maximum number of segments in a page.
+	oggMaxSegments = 255
+)
+
+// oggWriter implements mkvcore.BlockWriteCloser.  It writes Opus packets
+// into an Ogg stream, computing granule positions from the timestamps.
+// Gaps in the timestamps are filled with empty Opus frames, which cause
+// the decoder to perform packet loss concealment.
+type oggWriter struct {
+	w      io.WriteCloser
+	serial uint32
+	seqno  uint32
+
+	// the granule position of the end of the last packet written
+	granule int64
+
+	// the current page
+	segments []byte
+	data     []byte
+	flags    byte
+	start    int64
+}
+
+func newOggWriter(w io.WriteCloser, channels uint16) (*oggWriter, error) {
+	var serial [4]byte
+	_, err := crand.Read(serial[:])
+	if err != nil {
+		return nil, err
+	}
+
+	writer := &oggWriter{
+		w:      w,
+		serial: binary.LittleEndian.Uint32(serial[:]),
+	}
+
+	if channels == 0 {
+		channels = 1
+	} else if channels > 2 {
+		channels = 2
+	}
+
+	head := make([]byte, 19)
+	copy(head, "OpusHead")
+	head[8] = 1
+	head[9] = byte(channels)
+	binary.LittleEndian.PutUint16(head[10:12], opusPreSkip)
+	binary.LittleEndian.PutUint32(head[12:16], opusRate)
+	// output gain and channel mapping family are zero
+
+	vendor := "Galene"
+	tags := make([]byte, 8+4+len(vendor)+4)
+	copy(tags, "OpusTags")
+	binary.LittleEndian.PutUint32(tags[8:12], uint32(len(vendor)))
+	copy(tags[12:], vendor)
+	// the number of comments is zero
+
+	// The headers must each be on a page of their own.
+	err = writer.writePacket(head, 0, oggBOS)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.writePacket(tags, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// writePacket writes a single packet on a page of its own.
+func (w *oggWriter) writePacket(data []byte, granule int64, flags byte) error {
+	err := w.add(data)
+	if err != nil {
+		return err
+	}
+	w.flags |= flags
+	return w.flush(granule)
+}
+
+// add adds a packet to the current page.
+func (w *oggWriter) add(data []byte) error {
+	n := len(data)/255 + 1
+	if n > oggMaxSegments {
+		return errors.New("packet too large")
+	}
+	if len(w.segments)+n > oggMaxSegments {
+		err := w.flush(w.granule)
+		if err != nil {
+			return err
+		}
+	}
+	for i := 0; i < n-1; i++ {
+		w.segments = append(w.segments, 255)
+	}
+	w.segments = append(w.segments, byte(len(data)%255))
+	w.data = append(w.data, data...)
+	return nil
+}
+
+// flush writes out the current page, if any.
+func (w *oggWriter) flush(granule int64) error {
+	if len(w.segments) == 0 {
+		if w.flags&oggEOS == 0 {
+			return nil
+		}
+	}
+
+	header := make([]byte, 27+len(w.segments))
+	copy(header, "OggS")
+	header[4] = 0
+	header[5] = w.flags
+	binary.LittleEndian.PutUint64(header[6:14], uint64(granule))
+	binary.LittleEndian.PutUint32(header[14:18], w.serial)
+	binary.LittleEndian.PutUint32(header[18:22], w.seqno)
+	header[26] = byte(len(w.segments))
+	copy(header[27:], w.segments)
+
+	crc := oggCRC(0, header)
+	crc = oggCRC(crc, w.data)
+	binary.LittleEndian.PutUint32(header[22:26], crc)
+
+	_, err := w.w.Write(header)
+	if err == nil {
+		_, err = w.w.Write(w.data)
+	}
+
+	w.seqno++
+	w.segments = w.segments[:0]
+	w.data = w.data[:0]
+	w.flags = 0
+	w.start = w.granule
+	return err
+}
+
+// opusSamples returns the number of samples at 48kHz in an Opus packet,
+// as described in RFC 6716 Section 3.
+func opusSamples(data []byte) (int64, error) {
+	if len(data) < 1 {
+		return 0, errors.New("empty Opus packet")
+	}
+	toc := data[0]
+	config := toc >> 3
+
+	var frame int64
+	switch {
+	case config < 12:
+		// SILK, 10, 20, 40 or 60ms
+		frame = []int64{480, 960, 1920, 2880}[config&3]
+	case config < 16:
+		// Hybrid, 10 or 20ms
+		frame = []int64{480, 960}[config&1]
+	default:
+		// CELT, 2.5, 5, 10 or 20ms
+		frame = []int64{120, 240, 480, 960}[config&3]
+	}
+
+	var count int64
+	switch toc & 3 {
+	case 0:
+		count = 1
+	case 1, 2:
+		count = 2
+	case 3:
+		if len(data) < 2 {
+			return 0, errors.New("truncated Opus packet")
+		}
+		count = int64(data[1] & 0x3F)
+	}
+
+	return frame * count, nil
+}
+
+// silence returns an Opus packet containing a single empty CELT frame
+// of the given duration in samples, which must be 120, 240, 480 or 960.
+func silence(samples int64) []byte {
+	var config byte
+	switch samples {
+	case 120:
+		config = 28
+	case 240:
+		config = 29
+	case 480:
+		config = 30
+	default:
+		config = 31
+	}
+	return []byte{config << 3}
+}
+
+// Write writes an Opus packet with a timestamp in milliseconds.
+func (w *oggWriter) Write(keyframe bool, timestamp int64, data []byte) (int, error) {
+	samples, err := opusSamples(data)
+	if err != nil {
+		return 0, err
+	}
+
+	// fill any gap with empty frames of at most 20ms; smaller gaps
+	// are due to rounding, and are ignored.
+	ts := timestamp * (opusRate / 1000)
+	for ts-w.granule >= 120 {
+		gap := ts - w.granule
+		s := int64(960)
+		for s > gap {
+			s /= 2
+		}
+		err := w.add(silence(s))
+		if err != nil {
+			return 0, err
+		}
+		w.granule += s
+	}
+
+	err = w.add(data)
+	if err != nil {
+		return 0, err
+	}
+	w.granule += samples
+
+	if w.granule-w.start >= oggPageDuration {
+		err = w.flush(w.granule)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *oggWriter) Close() error {
+	w.flags |= oggEOS
+	err := w.flush(w.granule)
+	err2 := w.w.Close()
+	if err == nil {
+		err = err2
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package diskwriter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+type closeBuffer struct {
+	bytes.Buffer
+}
+
+func (b *closeBuffer) Close() error {
+	return nil
+}
+
+func TestOggCRC(t *testing.T) {
+	crc := oggCRC(0, []byte("123456789"))
+	if crc != 0x89A1897F {
+		t.Errorf("Expected 0x89A1897F, got %#x", crc)
+	}
+}
+
+func TestOpusSamples(t *testing.T) {
+	tests := []struct {
+		data    []byte
+		samples int64
+	}{
+		{[]byte{31 << 3}, 960},
+		{[]byte{28 << 3}, 120},
+		{[]byte{1 << 3}, 960},
+		{[]byte{3 << 3}, 2880},
+		{[]byte{(31 << 3) | 1}, 1920},
+		{[]byte{(31 << 3) | 3, 3}, 2880},
+	}
+	for _, tt := range tests {
+		s, err := opusSamples(tt.data)
+		if err != nil || s != tt.samples {
+			t.Errorf("opusSamples(%v): got %v %v, expected %v",
+				tt.data, s, err, tt.samples)
+		}
+	}
+
+	_, err := opusSamples(nil)
+	if err == nil {
+		t.Errorf("Expected error")
+	}
+}
+
+type oggPage struct {
+	flags    byte
+	granule  int64
+	seqno    uint32
+	segments []byte
+}
+
+func parseOgg(t *testing.T, data []byte) []oggPage {
+	var pages []oggPage
+	for len(data) > 0 {
+		if len(data) < 27 || string(data[:4]) != "OggS" {
+			t.Fatalf("Bad page")
+		}
+		n := int(data[26])
+		size := 27 + n
+		for _, s := range data[27 : 27+n] {
+			size += int(s)
+		}
+		page := make([]byte, size)
+		copy(page, data[:size])
+		crc := binary.LittleEndian.Uint32(page[22:26])
+		binary.LittleEndian.PutUint32(page[22:26], 0)
+		if oggCRC(0, page) != crc {
+			t.Errorf("Bad CRC")
+		}
+		pages = append(pages, oggPage{
+			flags:    data[5],
+			granule:  int64(binary.LittleEndian.Uint64(data[6:14])),
+			seqno:    binary.LittleEndian.Uint32(data[18:22]),
+			segments: data[27 : 27+n],
+		})
+		data = data[size:]
+	}
+	return pages
+}
+
+func TestOggWriter(t *testing.T) {
+	var buf closeBuffer
+	w, err := newOggWriter(&buf, 2)
+	if err != nil {
+		t.Fatalf("newOggWriter: %v", err)
+	}
+
+	packet := append([]byte{31 << 3}, make([]byte, 300)...)
+	for i := 0; i < 10; i++ {
+		_, err := w.Write(true, int64(i*20), packet)
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	// a gap of 103ms
+	_, err = w.Write(true, 303, packet)
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	pages := parseOgg(t, buf.Bytes())
+	if len(pages) != 3 {
+		t.Fatalf("Expected 3 pages, got %v", len(pages))
+	}
+	if pages[0].flags != oggBOS || pages[2].flags != oggEOS {
+		t.Errorf("Bad flags")
+	}
+	for i, p := range pages {
+		if p.seqno != uint32(i) {
+			t.Errorf("Bad seqno")
+		}
+	}
+
+	// 11 packets, 5 empty 20ms frames and one empty 2.5ms frame;
+	// the remaining 0.5ms are ignored
+	expected := int64(11*960 + 5*960 + 120)
+	if pages[2].granule != expected {
+		t.Errorf("Expected granule %v, got %v",
+			expected, pages[2].granule)
+	}
+	if len(pages[2].segments) != 11*2+6 {
+		t.Errorf("Expected %v segments, got %v",
+			11*2+6, len(pages[2].segments))
+	}
+}
@@ -0,0 +1,144 @@
+package diskwriter
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+// active is the set of files that are currently being written, indexed
+// by group and filename.  They are never expired.
+var active struct {
+	mu    sync.Mutex
+	files map[string]map[string]bool
+}
+
+// setActive records whether the given file of the recordings directory
+// of group is currently being written.
+func setActive(group, filename string, value bool) {
+	active.mu.Lock()
+	defer active.mu.Unlock()
+	if value {
+		if active.files == nil {
+			active.files = make(map[string]map[string]bool)
+		}
+		if active.files[group] == nil {
+			active.files[group] = make(map[string]bool)
+		}
+		active.files[group][filename] = true
+	} else {
+		delete(active.files[group], filename)
+		if len(active.files[group]) == 0 {
+			delete(active.files, group)
+		}
+	}
+}
+
+func isActive(group, filename string) bool {
+	active.mu.Lock()
+	defer active.mu.Unlock()
+	return active.files[group][filename]
+}
+
+// Expire deletes recordings according to the retention policy of each
+// group.  It is called periodically.
+func Expire() {
+	if Directory == "" {
+		return
+	}
+	filepath.WalkDir(
+		Directory,
+		func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					log.Printf("Recordings %v: %v", path, err)
+				}
+				return nil
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			if path != Directory && strings.HasPrefix(d.Name(), ".") {
+				// composite spool files
+				return fs.SkipDir
+			}
+			name, err := filepath.Rel(Directory, path)
+			if err != nil || name == "." {
+				return nil
+			}
+			err = expireGroup(filepath.ToSlash(name), path)
+			if err != nil {
+				log.Printf("Expire recordings of %v: %v", name, err)
+			}
+			return nil
+		},
+	)
+}
+
+// expireGroup deletes the recordings of a single group.
+func expireGroup(name, dir string) error {
+	desc, err := group.GetDescription(name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	maxAge := time.Duration(desc.RecordingMaxAge) * time.Second
+	maxSize := desc.RecordingMaxSize
+	if maxAge <= 0 && maxSize <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var files []fs.FileInfo
+	var total int64
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, fi)
+		total += fi.Size()
+	}
+
+	slices.SortFunc(files, func(a, b fs.FileInfo) int {
+		return a.ModTime().Compare(b.ModTime())
+	})
+
+	now := time.Now()
+	for _, fi := range files {
+		if isActive(name, fi.Name()) {
+			// the modification time of a file that is being
+			// written is not meaningful
+			continue
+		}
+		age := now.Sub(fi.ModTime())
+		if (maxAge > 0 && age > maxAge) ||
+			(maxSize > 0 && total > maxSize) {
+			err := os.Remove(filepath.Join(dir, fi.Name()))
+			if err != nil {
+				log.Printf("Expire recording: %v", err)
+				continue
+			}
+			total -= fi.Size()
+		}
+	}
+	return nil
+}
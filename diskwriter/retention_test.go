@@ -0,0 +1,171 @@
+package diskwriter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+func TestExpire(t *testing.T) {
+	group.Directory = t.TempDir()
+	Directory = t.TempDir()
+
+	err := os.WriteFile(
+		filepath.Join(group.Directory, "test.json"),
+		[]byte(`{"recording-max-age": 7200, "recording-max-size": 250}`),
+		0600,
+	)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	dir := filepath.Join(Directory, "test")
+	err = os.Mkdir(dir, 0700)
+	if err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+
+	now := time.Now()
+	files := []struct {
+		name string
+		age  time.Duration
+	}{
+		{"a", 3 * time.Hour},
+		{"b", 90 * time.Minute},
+		{"c", time.Hour},
+		{"d", 30 * time.Minute},
+		{"e", time.Second},
+	}
+	for _, f := range files {
+		fn := filepath.Join(dir, f.name)
+		err := os.WriteFile(fn, make([]byte, 100), 0600)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		tm := now.Add(-f.age)
+		err = os.Chtimes(fn, tm, tm)
+		if err != nil {
+			t.Fatalf("Chtimes: %v", err)
+		}
+	}
+
+	Expire()
+
+	// "a" is too old, "b" and "c" exceed the size limit
+	for _, f := range files {
+		_, err := os.Stat(filepath.Join(dir, f.name))
+		exists := err == nil
+		expected := f.name == "d" || f.name == "e"
+		if exists != expected {
+			t.Errorf("%v: expected %v, got %v",
+				f.name, expected, exists)
+		}
+	}
+}
+
+func TestExpireActive(t *testing.T) {
+	group.Directory = t.TempDir()
+	Directory = t.TempDir()
+
+	for _, mode := range []string{"separate", "composite"} {
+		err := os.WriteFile(
+			filepath.Join(group.Directory, mode+".json"),
+			[]byte(`{"recording-mode": "`+mode+`",
+                                 "recording-max-age": 60}`),
+			0600,
+		)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	old := time.Now().Add(-time.Hour)
+	age := func(dir string) []string {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("ReadDir: %v", err)
+		}
+		var names []string
+		for _, e := range entries {
+			if !e.Type().IsRegular() {
+				continue
+			}
+			err := os.Chtimes(
+				filepath.Join(dir, e.Name()), old, old,
+			)
+			if err != nil {
+				t.Fatalf("Chtimes: %v", err)
+			}
+			names = append(names, e.Name())
+		}
+		return names
+	}
+
+	for _, mode := range []string{"separate", "composite"} {
+		g, err := group.Add(mode, nil)
+		if err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		client, err := New(g)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		dir := filepath.Join(Directory, mode)
+
+		var conn *diskConn
+		if mode == "separate" {
+			conn = &diskConn{client: client, manifestIndex: -1}
+			err = conn.open("webm")
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			defer conn.file.Close()
+		} else {
+			if !isActive(mode, client.composite.Filename()) {
+				t.Errorf("Composite file is not active")
+			}
+		}
+
+		names := age(dir)
+		if mode == "separate" && len(names) != 2 {
+			t.Errorf("Expected manifest and recording, got %v",
+				names)
+		}
+
+		Expire()
+
+		if n := age(dir); len(n) != len(names) {
+			t.Errorf("%v: files were expired during recording: "+
+				"%v -> %v", mode, names, n)
+		}
+
+		if conn != nil {
+			conn.mu.Lock()
+			conn.close("")
+			conn.mu.Unlock()
+		}
+		err = client.Close()
+		if err != nil {
+			t.Errorf("Close: %v", err)
+		}
+		if mode == "composite" {
+			// the composite file is built asynchronously
+			for range 100 {
+				if !isActive(mode, client.manifest.filename) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+
+		age(dir)
+		Expire()
+
+		if n := age(dir); len(n) != 0 {
+			t.Errorf("%v: files were not expired: %v", mode, n)
+		}
+	}
+}
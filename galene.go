@@ -18,14 +18,16 @@ import (
 	"github.com/jech/galene/group"
 	"github.com/jech/galene/ice"
 	"github.com/jech/galene/limit"
+	"github.com/jech/galene/rtmp"
 	"github.com/jech/galene/token"
 	"github.com/jech/galene/turnserver"
 	"github.com/jech/galene/webserver"
 )
 
 func main() {
-	var cpuprofile, memprofile, mutexprofile, httpAddr string
+	var cpuprofile, memprofile, mutexprofile, httpAddr, rtmpAddr string
 	var udpRange string
+	var persistentChat bool
 
 	flag.StringVar(&httpAddr, "http", ":8443", "web server `address`")
 	flag.StringVar(&webserver.StaticRoot, "static", "./static/",
@@ -51,6 +53,10 @@ func main() {
 		"require use of TURN relays for all media traffic")
 	flag.StringVar(&turnserver.Address, "turn", "auto",
 		"built-in TURN server `address` (\"\" to disable)")
+	flag.BoolVar(&persistentChat, "persistent-chat", false,
+		"store chat history on disk")
+	flag.StringVar(&rtmpAddr, "rtmp", "",
+		"RTMP ingest server `address` (\"\" to disable)")
 	flag.Parse()
 
 	if udpRange != "" {
@@ -130,6 +136,12 @@ func main() {
 		),
 	)
 
+	if persistentChat {
+		group.SetHistoryStore(group.NewFileHistoryStore(
+			filepath.Join(group.DataDirectory, "var", "chat"),
+		))
+	}
+
 	// make sure the list of public groups is updated early
 	go group.Update()
 
@@ -142,6 +154,14 @@ func main() {
 		log.Fatalf("Server: %v", err)
 	}
 
+	if rtmpAddr != "" {
+		err = rtmp.Serve(rtmpAddr)
+		if err != nil {
+			log.Fatalf("RTMP: %v", err)
+		}
+		defer rtmp.Shutdown()
+	}
+
 	terminate := make(chan os.Signal, 1)
 	signal.Notify(terminate, syscall.SIGINT, syscall.SIGTERM)
 
@@ -159,11 +179,13 @@ func main() {
 			go func() {
 				group.Update()
 				token.Expire()
+				diskwriter.Expire()
 			}()
 		case <-slowTicker.C:
 			go relayTest()
 		case <-terminate:
 			webserver.Shutdown()
+			group.FlushHistoryStore()
 			return
 		}
 	}
@@ -930,6 +930,44 @@ func parsePermissions(p string, expand bool) (any, error) {
 	return pp.Permissions(nil), nil
 }
 
+// parseGroupPermissions is like parsePermissions, but also accepts the
+// names of the roles defined in the given group, which are expanded by
+// the server.  Roles may also be defined in the server's configuration
+// file, which we cannot read, so we only warn about unknown roles.
+func parseGroupPermissions(groupname, p string, expand bool) (any, error) {
+	perms, err := parsePermissions(p, expand)
+	if err != nil && !errors.Is(err, group.ErrUnknownPermission) {
+		return nil, err
+	}
+	name, ok := perms.(string)
+	if err == nil && !ok {
+		return perms, nil
+	}
+	if err != nil {
+		name = strings.TrimSpace(p)
+	} else if _, err := group.NewPermissions(name); err == nil {
+		return perms, nil
+	}
+
+	u, err := url.JoinPath(serverURL, "/galene-api/v0/.groups/", groupname)
+	if err != nil {
+		return nil, err
+	}
+	var desc struct {
+		Roles map[string][]string `json:"roles"`
+	}
+	_, err = getJSON(u, &desc)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := desc.Roles[name]; !ok {
+		log.Printf("Warning: role %v is not defined in group %v, "+
+			"it must be defined in the server's configuration",
+			name, groupname)
+	}
+	return name, nil
+}
+
 func formatRawPermissions(permissions []string) string {
 	var perms []byte
 	for _, p := range permissions {
@@ -1083,10 +1121,12 @@ func createUserCmd(cmdname string, args []string) {
 	var perms any
 	if permissions.set {
 		var err error
-		perms, err = parsePermissions(permissions.value, false)
+		perms, err = parseGroupPermissions(
+			groupname, permissions.value, false,
+		)
 		if err != nil {
 			fmt.Fprintf(cmd.Output(),
-				"Could not parse \"-permissions\"\n",
+				"Could not parse \"-permissions\": %v\n", err,
 			)
 			os.Exit(1)
 		}
@@ -1144,10 +1184,12 @@ func updateUserCmd(cmdname string, args []string) {
 	var perms any
 	if permissions.set {
 		var err error
-		perms, err = parsePermissions(permissions.value, false)
+		perms, err = parseGroupPermissions(
+			groupname, permissions.value, false,
+		)
 		if err != nil {
 			fmt.Fprintf(cmd.Output(),
-				"Could not parse \"-permissions\"\n",
+				"Could not parse \"-permissions\": %v\n", err,
 			)
 			os.Exit(1)
 		}
@@ -1407,7 +1449,7 @@ func createTokenCmd(cmdname string, args []string) {
 		os.Exit(1)
 	}
 
-	perms, err := parsePermissions(permissions, true)
+	perms, err := parseGroupPermissions(groupname.value, permissions, true)
 	if err != nil {
 		log.Fatalf("Parse permissions: %v", err)
 	}
@@ -1455,24 +1497,26 @@ func updateTokenCmd(cmdname string, args []string) {
 		os.Exit(1)
 	}
 
+	if !groupname.set || token == "" {
+		fmt.Fprintf(cmd.Output(),
+			"Options \"-group\" and \"-token\" are required\n")
+		os.Exit(1)
+	}
+
 	var perms any
 	var err error
 	if permissions.set {
-		perms, err = parsePermissions(permissions.value, true)
+		perms, err = parseGroupPermissions(
+			groupname.value, permissions.value, true,
+		)
 		if err != nil {
 			fmt.Fprintf(cmd.Output(),
-				"Could not parse \"-permissions\"\n",
+				"Could not parse \"-permissions\": %v\n", err,
 			)
 			os.Exit(1)
 		}
 	}
 
-	if !groupname.set || token == "" {
-		fmt.Fprintf(cmd.Output(),
-			"Options \"-group\" and \"-token\" are required\n")
-		os.Exit(1)
-	}
-
 	u, err := url.JoinPath(
 		serverURL, "/galene-api/v0/.groups/", groupname.value,
 		".tokens", token,
@@ -2,6 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"testing"
 
@@ -141,3 +143,54 @@ func TestParsePermissions(t *testing.T) {
 		}
 	}
 }
+
+func TestParseGroupPermissions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/galene-api/v0/.groups/test" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("content-type", "application/json")
+			w.Write([]byte(`{"roles": {"moderator": ["op"]}}`))
+		},
+	))
+	defer server.Close()
+	serverURL = server.URL
+	defer func() {
+		serverURL = ""
+	}()
+
+	tests := []struct {
+		g, i string
+		e    bool
+		o    any // nil for error
+	}{
+		{"test", "moderator", true, "moderator"},
+		{"test", "moderator", false, "moderator"},
+		{"test", "present", true, []string{"present", "message"}},
+		{"test", "present", false, "present"},
+		{"test", `["message"]`, true, []string{"message"}},
+		// might be defined in the server's configuration
+		{"test", "unknown", true, "unknown"},
+		{"test", "", true, nil},
+		{"nosuchgroup", "moderator", true, nil},
+	}
+
+	for _, test := range tests {
+		o, err := parseGroupPermissions(test.g, test.i, test.e)
+		if (err != nil) != (test.o == nil) {
+			t.Errorf("parseGroupPermissions(%v, %v, %v): "+
+				"expected %v, got %v (%v)",
+				test.g, test.i, test.e, test.o, o, err,
+			)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(o, test.o) {
+			t.Errorf("parseGroupPermissions(%v, %v, %v): "+
+				"expected %v, got %v",
+				test.g, test.i, test.e, test.o, o,
+			)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package group
+
+import (
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// MaxBreakouts is the maximum number of breakout rooms of a single group.
+const MaxBreakouts = 64
+
+// Breakout rooms are subgroups of a group that have been opened by an
+// operator.  Unlike automatic subgroups, they exist even if the parent
+// group doesn't have the auto-subgroups option set.
+var breakouts struct {
+	mu    sync.Mutex
+	rooms map[string][]string
+}
+
+// BreakoutParent returns the name of the group that the breakout room
+// with the given name belongs to, or the empty string if name is not the
+// name of an open breakout room.
+func BreakoutParent(name string) string {
+	breakouts.mu.Lock()
+	defer breakouts.mu.Unlock()
+	parent, _ := path.Split(name)
+	if parent == "" {
+		return ""
+	}
+	parent = parent[:len(parent)-1]
+	if !slices.Contains(breakouts.rooms[parent], name) {
+		return ""
+	}
+	return parent
+}
+
+// Breakouts returns the names of the open breakout rooms of group g.
+func (g *Group) Breakouts() []string {
+	breakouts.mu.Lock()
+	defer breakouts.mu.Unlock()
+	return slices.Clone(breakouts.rooms[g.name])
+}
+
+// OpenBreakouts opens n breakout rooms for group g, and returns their
+// names.
+func OpenBreakouts(g *Group, n int) ([]string, error) {
+	if n < 1 || n > MaxBreakouts {
+		return nil, UserError("bad number of breakout rooms")
+	}
+	if BreakoutParent(g.name) != "" {
+		return nil, UserError("this is already a breakout room")
+	}
+
+	breakouts.mu.Lock()
+	defer breakouts.mu.Unlock()
+	if len(breakouts.rooms[g.name]) > 0 {
+		return nil, UserError("breakout rooms are already open")
+	}
+	rooms := make([]string, n)
+	for i := range rooms {
+		rooms[i] = g.name + "/breakout-" + strconv.Itoa(i+1)
+	}
+	if breakouts.rooms == nil {
+		breakouts.rooms = make(map[string][]string)
+	}
+	breakouts.rooms[g.name] = rooms
+	return slices.Clone(rooms), nil
+}
+
+// CloseBreakouts closes the breakout rooms of group g, and returns their
+// names.  Clients are not moved out of the breakout rooms, this is the
+// responsibility of the caller.
+func CloseBreakouts(g *Group) ([]string, error) {
+	breakouts.mu.Lock()
+	defer breakouts.mu.Unlock()
+	rooms := breakouts.rooms[g.name]
+	if len(rooms) == 0 {
+		return nil, UserError("no breakout rooms are open")
+	}
+	delete(breakouts.rooms, g.name)
+	return rooms, nil
+}
@@ -0,0 +1,86 @@
+package group
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestBreakouts(t *testing.T) {
+	groups.groups = nil
+	Directory = t.TempDir()
+	err := os.WriteFile(filepath.Join(Directory, "breakout.json"),
+		[]byte(`{"lobby": true}`), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	g, err := Add("breakout", nil)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	_, err = Add("breakout/breakout-1", nil)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Add (closed room): %v", err)
+	}
+
+	_, err = OpenBreakouts(g, 0)
+	if err == nil {
+		t.Errorf("OpenBreakouts(0) succeeded")
+	}
+
+	rooms, err := OpenBreakouts(g, 2)
+	if err != nil {
+		t.Fatalf("OpenBreakouts: %v", err)
+	}
+	expected := []string{"breakout/breakout-1", "breakout/breakout-2"}
+	if !slices.Equal(rooms, expected) ||
+		!slices.Equal(g.Breakouts(), expected) {
+		t.Errorf("Expected %v, got %v %v", expected, rooms, g.Breakouts())
+	}
+
+	_, err = OpenBreakouts(g, 2)
+	if err == nil {
+		t.Errorf("OpenBreakouts (twice) succeeded")
+	}
+
+	if p := BreakoutParent("breakout/breakout-2"); p != "breakout" {
+		t.Errorf("BreakoutParent: expected breakout, got %v", p)
+	}
+	if p := BreakoutParent("breakout/other"); p != "" {
+		t.Errorf("BreakoutParent: expected empty, got %v", p)
+	}
+	if p := BreakoutParent("breakout"); p != "" {
+		t.Errorf("BreakoutParent: expected empty, got %v", p)
+	}
+
+	sg, err := Add("breakout/breakout-1", nil)
+	if err != nil {
+		t.Fatalf("Add (room): %v", err)
+	}
+	desc := sg.Description()
+	if !desc.isSubgroup || desc.Lobby {
+		t.Errorf("Bad description for room: %v %v",
+			desc.isSubgroup, desc.Lobby)
+	}
+
+	_, err = OpenBreakouts(sg, 2)
+	if err == nil {
+		t.Errorf("OpenBreakouts (nested) succeeded")
+	}
+
+	closed, err := CloseBreakouts(g)
+	if err != nil || !slices.Equal(closed, expected) {
+		t.Errorf("CloseBreakouts: %v %v", closed, err)
+	}
+	if len(g.Breakouts()) != 0 {
+		t.Errorf("Breakouts: expected empty, got %v", g.Breakouts())
+	}
+	_, err = CloseBreakouts(g)
+	if err == nil {
+		t.Errorf("CloseBreakouts (twice) succeeded")
+	}
+}
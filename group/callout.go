@@ -0,0 +1,167 @@
+package group
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// An authentication callout delegates the verification of usernames and
+// passwords to an HTTP server.
+
+const (
+	// how long a successful result is cached
+	calloutCacheTime = 5 * time.Minute
+	// how long a failed authentication is cached
+	calloutNegativeCacheTime = 10 * time.Second
+	// the maximum number of cached results
+	calloutCacheSize = 4096
+)
+
+var calloutClient = http.Client{
+	Timeout: 5 * time.Second,
+}
+
+type calloutKey struct {
+	url, group, username string
+	password             [sha256.Size]byte
+}
+
+type calloutResult struct {
+	username    string
+	permissions Permissions
+	err         error
+	expires     time.Time
+}
+
+var calloutCache struct {
+	mu      sync.Mutex
+	entries map[calloutKey]calloutResult
+}
+
+func getCalloutCache(key calloutKey) (calloutResult, bool) {
+	calloutCache.mu.Lock()
+	defer calloutCache.mu.Unlock()
+	r, ok := calloutCache.entries[key]
+	if !ok || time.Now().After(r.expires) {
+		return calloutResult{}, false
+	}
+	return r, true
+}
+
+func setCalloutCache(key calloutKey, r calloutResult) {
+	calloutCache.mu.Lock()
+	defer calloutCache.mu.Unlock()
+
+	if calloutCache.entries == nil {
+		calloutCache.entries = make(map[calloutKey]calloutResult)
+	}
+
+	if len(calloutCache.entries) >= calloutCacheSize {
+		now := time.Now()
+		for k, v := range calloutCache.entries {
+			if now.After(v.expires) {
+				delete(calloutCache.entries, k)
+			}
+		}
+		if len(calloutCache.entries) >= calloutCacheSize {
+			clear(calloutCache.entries)
+		}
+	}
+	calloutCache.entries[key] = r
+}
+
+type calloutRequest struct {
+	Group    string `json:"group"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type calloutResponse struct {
+	Username    *string     `json:"username,omitempty"`
+	Permissions Permissions `json:"permissions"`
+}
+
+// authCallout verifies a username and password by querying the server at
+// url.  It returns the username, which may have been canonicalised by
+// the server, and the user's permissions.
+func authCallout(url, group string, creds ClientCredentials) (string, Permissions, error) {
+	if creds.Username == nil {
+		return "", Permissions{}, errors.New("username not provided")
+	}
+
+	key := calloutKey{
+		url:      url,
+		group:    group,
+		username: *creds.Username,
+		password: sha256.Sum256([]byte(creds.Password)),
+	}
+	if r, ok := getCalloutCache(key); ok {
+		return r.username, r.permissions, r.err
+	}
+
+	username, perms, err := doAuthCallout(url, group, creds)
+
+	var expires time.Duration
+	if err == nil {
+		expires = calloutCacheTime
+	} else if errors.Is(err, ErrBadPassword) {
+		expires = calloutNegativeCacheTime
+	}
+	if expires > 0 {
+		setCalloutCache(key, calloutResult{
+			username:    username,
+			permissions: perms,
+			err:         err,
+			expires:     time.Now().Add(expires),
+		})
+	}
+	return username, perms, err
+}
+
+func doAuthCallout(url, group string, creds ClientCredentials) (string, Permissions, error) {
+	body, err := json.Marshal(calloutRequest{
+		Group:    group,
+		Username: *creds.Username,
+		Password: creds.Password,
+	})
+	if err != nil {
+		return "", Permissions{}, err
+	}
+
+	resp, err := calloutClient.Post(
+		url, "application/json", bytes.NewReader(body),
+	)
+	if err != nil {
+		return "", Permissions{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden,
+		http.StatusNotFound:
+		return "", Permissions{}, ErrBadPassword
+	default:
+		return "", Permissions{}, fmt.Errorf(
+			"authentication server returned %v", resp.Status,
+		)
+	}
+
+	var r calloutResponse
+	err = json.NewDecoder(io.LimitReader(resp.Body, 65536)).Decode(&r)
+	if err != nil {
+		return "", Permissions{}, err
+	}
+	username := *creds.Username
+	if r.Username != nil {
+		username = *r.Username
+	}
+	return username, r.Permissions, nil
+}
@@ -0,0 +1,85 @@
+package group
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestAuthCallout(t *testing.T) {
+	count := 0
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			count++
+			var req calloutRequest
+			err := json.NewDecoder(r.Body).Decode(&req)
+			if err != nil || req.Group != "test" {
+				http.Error(w, "bad request",
+					http.StatusBadRequest)
+				return
+			}
+			if req.Password != "pw" {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if req.Username == "impostor" {
+				w.Write([]byte(`{"username": "local"}`))
+				return
+			}
+			w.Write([]byte(
+				`{"username": "Vimes", "permissions": "op"}`,
+			))
+		},
+	))
+	defer server.Close()
+
+	desc := &Description{
+		AuthCallout: server.URL,
+		Users: map[string]UserDescription{
+			"local": {Password: pw2},
+		},
+	}
+
+	vimes := "vimes"
+	for i := 0; i < 2; i++ {
+		username, perms, err := desc.GetPermission("test",
+			ClientCredentials{Username: &vimes, Password: "pw"},
+		)
+		if err != nil || username != "Vimes" ||
+			!slices.Contains(perms, "op") {
+			t.Errorf("GetPermission: %v %v %v", username, perms, err)
+		}
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 request, got %v", count)
+	}
+
+	_, _, err := desc.GetPermission("test",
+		ClientCredentials{Username: &vimes, Password: "bad"},
+	)
+	if !errors.Is(err, ErrBadPassword) {
+		t.Errorf("GetPermission (bad password): %v", err)
+	}
+
+	// users defined in the group are never checked remotely
+	local := "local"
+	_, _, err = desc.GetPermission("test",
+		ClientCredentials{Username: &local, Password: "bad"},
+	)
+	if !errors.Is(err, ErrBadPassword) || count != 2 {
+		t.Errorf("GetPermission (local): %v %v", err, count)
+	}
+
+	// the server may not return the name of a user defined in the group
+	impostor := "impostor"
+	_, _, err = desc.GetPermission("test",
+		ClientCredentials{Username: &impostor, Password: "pw"},
+	)
+	if !errors.Is(err, ErrDuplicateUsername) {
+		t.Errorf("GetPermission (impostor): %v", err)
+	}
+}
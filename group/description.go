@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
@@ -13,6 +14,7 @@ import (
 	"time"
 
 	"github.com/jech/galene/token"
+	"github.com/jech/galene/webhook"
 )
 
 var ErrTagMismatch = errors.New("tag mismatch")
@@ -33,8 +35,77 @@ var permissionsMap = map[string][]string{
 	"observe": {},
 	"caption": {"caption"},
 	"admin":   {"admin"},
+	"relay":   {"present", "relay"},
 }
 
+// lookupRole returns the permissions granted by a named role.  Built-in
+// roles take precedence over the roles defined in the group description,
+// which take precedence over the ones defined in the global configuration.
+func lookupRole(name string, desc *Description) ([]string, bool) {
+	if perms, ok := permissionsMap[name]; ok {
+		return perms, true
+	}
+	if desc != nil {
+		if perms, ok := desc.Roles[name]; ok {
+			return perms, true
+		}
+	}
+	conf, err := GetConfiguration()
+	if err != nil {
+		log.Printf("Read config file: %v", err)
+		return nil, false
+	}
+	perms, ok := conf.Roles[name]
+	return perms, ok
+}
+
+// checkRoles checks role definitions, and that users only refer to
+// built-in roles or to the roles being defined.  Users that may refer to
+// roles defined elsewhere are checked by checkPermissions.
+func checkRoles(roles map[string][]string, users map[string]UserDescription) error {
+	for name := range roles {
+		if name == "" {
+			return errors.New("empty role name")
+		}
+		if _, ok := permissionsMap[name]; ok {
+			return fmt.Errorf("role %v redefines a built-in role", name)
+		}
+	}
+	for username, u := range users {
+		name := u.Permissions.name
+		if name == "" {
+			continue
+		}
+		_, builtin := permissionsMap[name]
+		_, defined := roles[name]
+		if !builtin && !defined {
+			return fmt.Errorf("user %v: %w %v",
+				username, ErrUnknownPermission, name,
+			)
+		}
+	}
+	return nil
+}
+
+// checkPermissions checks that the roles granted to the users of a group
+// are either built-in or defined in the group or the global configuration.
+func (desc *Description) checkPermissions() error {
+	for _, u := range desc.Users {
+		err := u.Permissions.check(desc)
+		if err != nil {
+			return err
+		}
+	}
+	if desc.WildcardUser != nil {
+		err := desc.WildcardUser.Permissions.check(desc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewPermissions returns a built-in named permissions set.
 func NewPermissions(name string) (Permissions, error) {
 	_, ok := permissionsMap[name]
 	if !ok {
@@ -50,7 +121,15 @@ func (p Permissions) Permissions(desc *Description) []string {
 		return p.permissions
 	}
 
-	perms := permissionsMap[p.name]
+	perms, builtin := permissionsMap[p.name]
+	if !builtin {
+		// roles defined by the administrator are used as is
+		perms, ok := lookupRole(p.name, desc)
+		if !ok {
+			log.Printf("Unknown role %v", p.name)
+		}
+		return perms
+	}
 
 	op := false
 	present := false
@@ -85,6 +164,50 @@ func (p Permissions) Permissions(desc *Description) []string {
 	return perms
 }
 
+// check returns an error if p refers to a role that is unknown in the
+// context of the given description.
+func (p Permissions) check(desc *Description) error {
+	if p.name == "" {
+		return nil
+	}
+	_, ok := lookupRole(p.name, desc)
+	if !ok {
+		return fmt.Errorf("%w %v", ErrUnknownPermission, p.name)
+	}
+	return nil
+}
+
+// GetQuota returns the quota that applies to a given user.
+func (desc *Description) GetQuota(username string) Quota {
+	var q Quota
+	q.merge(desc.Quota)
+	if u, ok := desc.Users[username]; ok {
+		q.merge(u.Quota)
+	} else if desc.WildcardUser != nil {
+		q.merge(desc.WildcardUser.Quota)
+	}
+	return q
+}
+
+// ExpandPermissions returns the permissions granted by a named role in
+// the given group, or globally if group is empty.
+func ExpandPermissions(group, name string) ([]string, error) {
+	var desc *Description
+	if group != "" {
+		var err error
+		desc, err = GetDescription(group)
+		if err != nil {
+			return nil, err
+		}
+	}
+	p := Permissions{name: name}
+	err := p.check(desc)
+	if err != nil {
+		return nil, err
+	}
+	return p.Permissions(desc), nil
+}
+
 func (p Permissions) String() string {
 	if p.name != "" {
 		if p.permissions != nil {
@@ -111,8 +234,10 @@ func (p *Permissions) UnmarshalJSON(b []byte) error {
 	var s string
 	err = json.Unmarshal(b, &s)
 	if err == nil {
-		_, ok := permissionsMap[s]
-		if !ok {
+		// roles are checked when the description is read or
+		// written, since they may be defined in the global
+		// configuration
+		if s == "" {
 			return ErrUnknownPermission
 		}
 		*p = Permissions{
@@ -133,20 +258,86 @@ func (p Permissions) MarshalJSON() ([]byte, error) {
 type UserDescription struct {
 	Password    Password    `json:"password"`
 	Permissions Permissions `json:"permissions"`
+	Quota       *Quota      `json:"quota,omitempty"`
 }
 
 // Custom MarshalJSON in order to omit empty fields
 func (u UserDescription) MarshalJSON() ([]byte, error) {
-	uu := make(map[string]any, 2)
+	uu := make(map[string]any, 3)
 	if u.Password.Type != "" {
 		uu["password"] = &u.Password
 	}
 	if u.Permissions.name != "" || u.Permissions.permissions != nil {
 		uu["permissions"] = &u.Permissions
 	}
+	if u.Quota != nil {
+		uu["quota"] = u.Quota
+	}
 	return json.Marshal(uu)
 }
 
+// Quota limits the resources used by a single client.  A value of 0
+// means no limit.
+type Quota struct {
+	// The maximum number of streams that the client may publish.
+	MaxUpStreams int `json:"max-up-streams,omitempty"`
+
+	// The maximum total bitrate of the streams published by the
+	// client, in bits per second.
+	MaxUpBitrate uint64 `json:"max-up-bitrate,omitempty"`
+
+	// The maximum number of streams that the client may receive.
+	MaxDownStreams int `json:"max-down-streams,omitempty"`
+}
+
+// A CascadeLink describes a link to a group on another server.  Streams
+// published in either group are relayed to the other one.
+type CascadeLink struct {
+	// The URL of the remote server's websocket endpoint.
+	URL string `json:"url"`
+
+	// The name of the remote group.  Defaults to the name of the
+	// local group.
+	Group string `json:"group,omitempty"`
+
+	// The token used to join the remote group, which must grant the
+	// relay permission.
+	Token string `json:"token"`
+}
+
+// checkCascade checks the cascading links of a group.
+func checkCascade(links []CascadeLink) error {
+	for _, l := range links {
+		u, err := url.Parse(l.URL)
+		if err != nil {
+			return fmt.Errorf("cascade: %w", err)
+		}
+		if u.Scheme != "ws" && u.Scheme != "wss" {
+			return fmt.Errorf("cascade: bad scheme in URL %v", l.URL)
+		}
+		if l.Token == "" {
+			return errors.New("cascade: empty token")
+		}
+	}
+	return nil
+}
+
+// merge overrides the limits in q with the ones set in other.
+func (q *Quota) merge(other *Quota) {
+	if other == nil {
+		return
+	}
+	if other.MaxUpStreams != 0 {
+		q.MaxUpStreams = other.MaxUpStreams
+	}
+	if other.MaxUpBitrate != 0 {
+		q.MaxUpBitrate = other.MaxUpBitrate
+	}
+	if other.MaxDownStreams != 0 {
+		q.MaxDownStreams = other.MaxDownStreams
+	}
+}
+
 // Description represents a group description together with some metadata
 // about the JSON file it was deserialised from.
 type Description struct {
@@ -184,6 +375,10 @@ type Description struct {
 	// The maximum number of simultaneous clients.  Unlimited if 0.
 	MaxClients int `json:"max-clients,omitempty"`
 
+	// The limits that apply to each client, unless overridden in
+	// the user definition.
+	Quota *Quota `json:"quota,omitempty"`
+
 	// The time for which history entries are kept.
 	MaxHistoryAge int `json:"max-history-age,omitempty"`
 
@@ -196,18 +391,66 @@ type Description struct {
 	// Whether recording is allowed.
 	AllowRecording bool `json:"allow-recording,omitempty"`
 
+	// How recordings are written, either "separate" (one file per
+	// stream, the default) or "composite" (a single file per session).
+	RecordingMode string `json:"recording-mode,omitempty"`
+
+	// The format of recordings of audio-only streams, either "webm"
+	// (the default) or "ogg".
+	RecordingFormat string `json:"recording-format,omitempty"`
+
+	// Whether to start recording when a stream is published, and to
+	// stop recording when the group becomes empty.
+	AutoRecord bool `json:"auto-record,omitempty"`
+
+	// The time, in seconds, after which recordings are deleted.
+	// Unlimited if 0.
+	RecordingMaxAge int `json:"recording-max-age,omitempty"`
+
+	// The maximum total size, in bytes, of the recordings of the group.
+	// The oldest recordings are deleted first.  Unlimited if 0.
+	RecordingMaxSize int64 `json:"recording-max-size,omitempty"`
+
+	// Whether audio is mixed by the server rather than forwarded
+	// separately to every client.
+	AudioMixing bool `json:"audio-mixing,omitempty"`
+
+	// The number of most recent active speakers whose video is
+	// forwarded at full quality.  Unlimited if 0.
+	LastN int `json:"last-n,omitempty"`
+
+	// Links to groups on other servers.
+	Cascade []CascadeLink `json:"cascade,omitempty"`
+
 	// Whether creating tokens is allowed
 	UnrestrictedTokens bool `json:"unrestricted-tokens,omitempty"`
 
 	// Whether subgroups are created on the fly.
 	AutoSubgroups bool `json:"auto-subgroups,omitempty"`
 
+	// Whether non-operators wait in a lobby until they are admitted
+	// by an operator.
+	Lobby bool `json:"lobby,omitempty"`
+
+	// Whether only clients that may present, operators and system
+	// clients are announced to the other clients, while the remaining
+	// clients are only counted.  This is useful for very large groups.
+	ScalablePresence bool `json:"scalable-presence,omitempty"`
+
 	// Whether to lock the group when the last op logs out.
 	Autolock bool `json:"autolock,omitempty"`
 
 	// Whether to kick all users when the last op logs out.
 	Autokick bool `json:"autokick,omitempty"`
 
+	// Named permission sets, in addition to the ones defined in the
+	// global configuration.
+	Roles map[string][]string `json:"roles,omitempty"`
+
+	// Receivers of notifications about this group, in addition to
+	// the ones in the global configuration.
+	Webhooks []webhook.Hook `json:"webhooks,omitempty"`
+
 	// Users allowed to login
 	Users map[string]UserDescription `json:"users,omitempty"`
 
@@ -223,6 +466,10 @@ type Description struct {
 	// The URL of the authentication portal, if any.
 	AuthPortal string `json:"authPortal,omitempty"`
 
+	// The URL of a server used to verify passwords of users that are
+	// not defined in the group, if any.
+	AuthCallout string `json:"authCallout,omitempty"`
+
 	// Codec preferences.  If empty, a suitable default is chosen in
 	// the APIFromNames function.
 	Codecs []string `json:"codecs,omitempty"`
@@ -387,6 +634,19 @@ func UpdateDescription(name, etag string, desc *Description) error {
 		newdesc.AuthKeys = old.AuthKeys
 	}
 
+	err = checkRoles(newdesc.Roles, nil)
+	if err != nil {
+		return err
+	}
+	err = checkCascade(newdesc.Cascade)
+	if err != nil {
+		return err
+	}
+	err = newdesc.checkPermissions()
+	if err != nil {
+		return err
+	}
+
 	return rewriteDescriptionFile(filename, &newdesc)
 }
 
@@ -469,13 +729,31 @@ func readDescription(name string, allowSubgroups bool) (*Description, error) {
 		return nil, err
 	}
 
+	err = checkRoles(desc.Roles, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = desc.checkPermissions()
+	if err != nil {
+		return nil, err
+	}
+	err = checkCascade(desc.Cascade)
+	if err != nil {
+		return nil, err
+	}
+
 	if isSubgroup {
-		if !desc.AutoSubgroups {
+		breakout := BreakoutParent(name) != ""
+		if !desc.AutoSubgroups && !breakout {
 			return nil, os.ErrNotExist
 		}
 		desc.isSubgroup = true
 		desc.Public = false
 		desc.Description = ""
+		if breakout {
+			// participants have already been admitted
+			desc.Lobby = false
+		}
 	}
 
 	return &desc, nil
@@ -738,6 +1016,11 @@ func UpdateUser(group, username string, wildcard bool, etag string, user *UserDe
 		return ErrTagMismatch
 	}
 
+	err = user.Permissions.check(desc)
+	if err != nil {
+		return err
+	}
+
 	newuser := *user
 	newuser.Password = old.Password
 
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -319,3 +320,182 @@ func TestSubGroup(t *testing.T) {
 		t.Fatalf("UpdateDescription: got %v", err)
 	}
 }
+
+func TestRoles(t *testing.T) {
+	err := setupTest(t.TempDir(), t.TempDir(), true)
+	if err != nil {
+		t.Fatalf("setupTest: %v", err)
+	}
+	err = os.WriteFile(filepath.Join(DataDirectory, "config.json"),
+		[]byte(`{
+			"writableGroups": true,
+			"roles": {
+				"speaker": ["present", "message"],
+				"moderator": ["op", "message", "caption"]
+			}
+		}`), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	var d Description
+	err = json.Unmarshal([]byte(`{
+		"roles": {"moderator": ["op", "message"]},
+		"users": {
+			"jch": {"permissions": "speaker"},
+			"vimes": {"permissions": "moderator"}
+		}
+	}`), &d)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	perms := d.Users["jch"].Permissions.Permissions(&d)
+	if !reflect.DeepEqual(perms, []string{"present", "message"}) {
+		t.Errorf("speaker: got %v", perms)
+	}
+	// roles are used as is, and group roles override global ones
+	perms = d.Users["vimes"].Permissions.Permissions(&d)
+	if !reflect.DeepEqual(perms, []string{"op", "message"}) {
+		t.Errorf("moderator: got %v", perms)
+	}
+
+	err = UpdateDescription("test", "", &Description{Roles: d.Roles})
+	if err != nil {
+		t.Fatalf("UpdateDescription: %v", err)
+	}
+
+	err = UpdateUser("test", "jch", false, "", &UserDescription{
+		Permissions: Permissions{name: "speaker"},
+	})
+	if err != nil {
+		t.Errorf("UpdateUser: %v", err)
+	}
+
+	err = UpdateUser("test", "vimes", false, "", &UserDescription{
+		Permissions: Permissions{name: "unknown"},
+	})
+	if !errors.Is(err, ErrUnknownPermission) {
+		t.Errorf("UpdateUser: got %v, expected ErrUnknownPermission",
+			err)
+	}
+
+	perms, err = ExpandPermissions("test", "moderator")
+	if err != nil || !reflect.DeepEqual(perms, []string{"op", "message"}) {
+		t.Errorf("ExpandPermissions: got %v %v", perms, err)
+	}
+	perms, err = ExpandPermissions("", "moderator")
+	if err != nil ||
+		!reflect.DeepEqual(perms, []string{"op", "message", "caption"}) {
+		t.Errorf("ExpandPermissions: got %v %v", perms, err)
+	}
+	_, err = ExpandPermissions("", "unknown")
+	if !errors.Is(err, ErrUnknownPermission) {
+		t.Errorf("ExpandPermissions: got %v, expected ErrUnknownPermission",
+			err)
+	}
+
+	err = UpdateDescription("bad", "", &Description{
+		Roles: map[string][]string{"op": {"present"}},
+	})
+	if err == nil {
+		t.Errorf("UpdateDescription: redefined built-in role")
+	}
+
+	files := map[string]string{
+		"global":   `{"users": {"jch": {"permissions": "speaker"}}}`,
+		"typo":     `{"users": {"jch": {"permissions": "preesent"}}}`,
+		"wildcard": `{"wildcard-user": {"permissions": "preesent"}}`,
+	}
+	for name, f := range files {
+		err := os.WriteFile(
+			filepath.Join(Directory, name+".json"), []byte(f), 0o600,
+		)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	_, err = readDescription("global", false)
+	if err != nil {
+		t.Errorf("readDescription (global role): %v", err)
+	}
+	for _, name := range []string{"typo", "wildcard"} {
+		_, err = readDescription(name, false)
+		if !errors.Is(err, ErrUnknownPermission) {
+			t.Errorf("readDescription (%v): got %v, "+
+				"expected ErrUnknownPermission", name, err)
+		}
+	}
+
+	err = os.WriteFile(filepath.Join(DataDirectory, "config.json"),
+		[]byte(`{
+			"roles": {"speaker": ["present", "message"]},
+			"users": {"jch": {"permissions": "spaeker"}}
+		}`), 0o600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	_, err = GetConfiguration()
+	if !errors.Is(err, ErrUnknownPermission) {
+		t.Errorf("GetConfiguration: got %v, "+
+			"expected ErrUnknownPermission", err)
+	}
+}
+
+func TestGetQuota(t *testing.T) {
+	var d Description
+	err := json.Unmarshal([]byte(`{
+		"quota": {"max-up-streams": 1, "max-down-streams": 10},
+		"users": {
+			"jch": {"permissions": "op", "quota": {"max-up-streams": 3}}
+		},
+		"wildcard-user": {
+			"permissions": "present",
+			"quota": {"max-up-bitrate": 500000}
+		}
+	}`), &d)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	tests := []struct {
+		username string
+		quota    Quota
+	}{
+		{"jch", Quota{MaxUpStreams: 3, MaxDownStreams: 10}},
+		{"vimes", Quota{
+			MaxUpStreams: 1, MaxUpBitrate: 500000, MaxDownStreams: 10,
+		}},
+	}
+	for _, test := range tests {
+		q := d.GetQuota(test.username)
+		if q != test.quota {
+			t.Errorf("GetQuota(%v): expected %v, got %v",
+				test.username, test.quota, q)
+		}
+	}
+
+	b, err := json.Marshal(d.Users["jch"])
+	if err != nil || !strings.Contains(string(b), `"max-up-streams":3`) {
+		t.Errorf("Marshal: got %v %v", string(b), err)
+	}
+}
+
+func TestCheckCascade(t *testing.T) {
+	tests := []struct {
+		link CascadeLink
+		ok   bool
+	}{
+		{CascadeLink{URL: "wss://galene.org:8443/ws", Token: "x"}, true},
+		{CascadeLink{URL: "ws://localhost:8443/ws", Token: "x"}, true},
+		{CascadeLink{URL: "https://galene.org:8443/ws", Token: "x"}, false},
+		{CascadeLink{URL: "wss://galene.org:8443/ws"}, false},
+		{CascadeLink{URL: ":", Token: "x"}, false},
+	}
+	for _, tt := range tests {
+		err := checkCascade([]CascadeLink{tt.link})
+		if (err == nil) != tt.ok {
+			t.Errorf("checkCascade(%v): got %v", tt.link, err)
+		}
+	}
+}
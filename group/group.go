@@ -19,9 +19,11 @@ import (
 
 	"github.com/pion/ice/v4"
 	"github.com/pion/interceptor"
+	"github.com/pion/sdp/v3"
 	"github.com/pion/webrtc/v4"
 
 	"github.com/jech/galene/token"
+	"github.com/jech/galene/webhook"
 )
 
 var Directory, DataDirectory string
@@ -87,12 +89,12 @@ func (err ProtocolError) Error() string {
 }
 
 type ChatHistoryEntry struct {
-	Id     string
-	Source string
-	User   *string
-	Time   time.Time
-	Kind   string
-	Value  interface{}
+	Id     string      `json:"id,omitempty"`
+	Source string      `json:"source,omitempty"`
+	User   *string     `json:"username,omitempty"`
+	Time   time.Time   `json:"time"`
+	Kind   string      `json:"kind,omitempty"`
+	Value  interface{} `json:"value,omitempty"`
 }
 
 const (
@@ -108,15 +110,88 @@ type Group struct {
 	description *Description
 	locked      *string
 	clients     map[string]Client
-	history     []ChatHistoryEntry
+	// the clients waiting to be admitted, in order of arrival
+	lobby   []*lobbyClient
+	history []ChatHistoryEntry
+	// the number of entries in the history store
+	historyStored int
+	// the time of the oldest entry in the history store
+	historyOldest time.Time
+	// the history is loaded from the store when first accessed
+	historyOnce sync.Once
 	timestamp   time.Time
 	data        map[string]interface{}
+	// the id of the client currently speaking
+	activeSpeaker string
+	// the ids of the clients that have spoken, most recent first
+	speakers []string
+	// the clients that have raised their hand, in order
+	hands []Client
+	// the client that has been given the floor by an operator
+	floor *Turn
+	polls []*poll
+	// the timer that limits the rate of poll notifications
+	pollTimer *time.Timer
+	// the ids of the clients that have been announced to the other
+	// clients; with scalable presence, the others are only counted
+	announced map[string]bool
+	// the number of observers last sent to the clients
+	observersSent int
+	// with scalable presence, the announced clients as last sent to
+	// the observers, and the ids of the clients whose changes have not
+	// been sent to the observers yet
+	observersView   map[string]presenceEntry
+	presencePending map[string]bool
+	// the timer that limits the rate of updates of the above
+	presenceTimer *time.Timer
+	// whether automatic recording has already been triggered, or was
+	// inhibited by an operator, since the group last became empty
+	autoRecorded bool
 }
 
 func (g *Group) Name() string {
 	return g.name
 }
 
+// ActiveSpeaker returns the id of the client that is currently speaking,
+// or the empty string if there is none.
+func (g *Group) ActiveSpeaker() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.activeSpeaker
+}
+
+// SetActiveSpeaker sets the active speaker, and returns true if it has
+// changed.
+func (g *Group) SetActiveSpeaker(id string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.activeSpeaker == id {
+		return false
+	}
+	g.activeSpeaker = id
+
+	speakers := make([]string, 0, len(g.speakers)+1)
+	if id != "" {
+		speakers = append(speakers, id)
+	}
+	for _, s := range g.speakers {
+		if s != id && g.clients[s] != nil {
+			speakers = append(speakers, s)
+		}
+	}
+	g.speakers = speakers
+	return true
+}
+
+// RecentSpeakers returns the ids of the clients that have been active
+// speakers, the most recent first.
+func (g *Group) RecentSpeakers() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return slices.Clone(g.speakers)
+}
+
 func (g *Group) Locked() (bool, string) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
@@ -187,7 +262,7 @@ func (g *Group) mayExpire() bool {
 	if g.description.Public {
 		return false
 	}
-	if len(g.clients) > 0 {
+	if len(g.clients) > 0 || len(g.lobby) > 0 {
 		return false
 	}
 	return time.Since(g.timestamp) > maxHistoryAge(g.description)
@@ -409,6 +484,14 @@ func APIFromCodecs(codecs []webrtc.RTPCodecParameters) (*webrtc.API, error) {
 		return nil, err
 	}
 
+	err = m.RegisterHeaderExtension(
+		webrtc.RTPHeaderExtensionCapability{URI: sdp.AudioLevelURI},
+		webrtc.RTPCodecTypeAudio,
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	ir := interceptor.Registry{}
 
 	return webrtc.NewAPI(
@@ -418,9 +501,24 @@ func APIFromCodecs(codecs []webrtc.RTPCodecParameters) (*webrtc.API, error) {
 	), nil
 }
 
+// defaultCodecs are the codecs used by groups that don't specify any.
+var defaultCodecs = []string{"vp8", "opus"}
+
+// CodecEnabled returns true if the named codec is enabled in group g.
+func (g *Group) CodecEnabled(name string) bool {
+	g.mu.Lock()
+	codecs := g.description.Codecs
+	g.mu.Unlock()
+
+	if len(codecs) == 0 {
+		codecs = defaultCodecs
+	}
+	return slices.Contains(codecs, name)
+}
+
 func APIFromNames(names []string) (*webrtc.API, error) {
 	if len(names) == 0 {
-		names = []string{"vp8", "opus"}
+		names = defaultCodecs
 	}
 	var codecs []webrtc.RTPCodecParameters
 	for _, n := range names {
@@ -440,6 +538,10 @@ func Add(name string, desc *Description) (*Group, error) {
 	for _, c := range notify {
 		c.Joined(g.Name(), "change")
 	}
+	if len(notify) > 0 {
+		// the value of scalable-presence may have changed
+		g.updatePresence()
+	}
 	return g, err
 }
 
@@ -488,6 +590,7 @@ func add(name string, desc *Description) (*Group, []Client, error) {
 			name:        name,
 			description: desc,
 			clients:     make(map[string]Client),
+			announced:   make(map[string]bool),
 			timestamp:   time.Now(),
 		}
 		groups.groups[name] = g
@@ -594,7 +697,7 @@ func Delete(name string) bool {
 
 // Called with both groups.mu and g.mu taken.
 func deleteUnlocked(g *Group) bool {
-	if len(g.clients) != 0 {
+	if len(g.clients) != 0 || len(g.lobby) != 0 {
 		return false
 	}
 
@@ -608,19 +711,37 @@ func AddClient(group string, c Client, creds ClientCredentials) (*Group, error)
 		return nil, err
 	}
 
-	g.mu.Lock()
-	defer g.mu.Unlock()
-
-	clients := g.getClientsUnlocked(nil)
+	system := slices.Contains(c.Permissions(), "system")
 
-	if !slices.Contains(c.Permissions(), "system") {
-		username, perms, err := g.description.GetPermission(
+	var username string
+	var perms []string
+	if !system {
+		// this may query an authentication server, so don't hold
+		// the group lock
+		username, perms, err = g.Description().GetPermission(
 			g.name, creds,
 		)
 		if err != nil {
 			return nil, err
 		}
+	}
+
+	// the join event is sent after the group lock has been released,
+	// since sending it requires reading the configuration
+	var joined *webhook.Event
+	var desc *Description
+	defer func() {
+		if joined != nil {
+			sendWebhook(g.name, desc, *joined)
+		}
+	}()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	clients := g.getClientsUnlocked(nil)
 
+	if !system {
 		c.Init(username, perms)
 
 		if !slices.Contains(perms, "op") {
@@ -672,6 +793,15 @@ func AddClient(group string, c Client, creds ClientCredentials) (*Group, error)
 				return nil, UserError("too many users")
 			}
 		}
+
+		if g.description.Lobby &&
+			!slices.Contains(perms, "op") &&
+			!slices.Contains(perms, "relay") {
+			err := g.enterLobbyUnlocked(c)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 	id := c.Id()
 	if id == "" {
@@ -680,20 +810,48 @@ func AddClient(group string, c Client, creds ClientCredentials) (*Group, error)
 	if g.clients[id] != nil {
 		return nil, ProtocolError("duplicate client id")
 	}
+	if !system && creds.Token != "" {
+		// single-use tokens are only consumed once the client
+		// has actually joined, not when it enters the lobby
+		err := token.Redeem(creds.Token)
+		if err != nil {
+			return nil, &NotAuthorisedError{err: err}
+		}
+	}
 	g.clients[id] = c
 	g.timestamp = time.Now()
 
+	if !system {
+		joined = &webhook.Event{
+			Type:     "join",
+			Id:       id,
+			Username: c.Username(),
+		}
+		desc = g.description
+	}
+
 	c.Joined(g.Name(), "join")
 
 	u := c.Username()
 	p := c.Permissions()
 	s := c.Data()
+	announced := g.announcedUnlocked(p)
+	if announced {
+		g.announced[id] = true
+	} else {
+		g.schedulePresenceUnlocked()
+	}
 	c.PushClient(g.Name(), "add", c.Id(), u, p, s)
-	for _, cc := range clients {
-		pp := cc.Permissions()
-		uu := cc.Username()
-		c.PushClient(g.Name(), "add", cc.Id(), uu, pp, cc.Data())
-		cc.PushClient(g.Name(), "add", id, u, p, s)
+	g.pushAnnouncedUnlocked(c)
+	if announced {
+		for _, cc := range g.presenceRecipientsUnlocked(c, id) {
+			cc.PushClient(g.Name(), "add", id, u, p, s)
+		}
+	}
+	if g.description.ScalablePresence {
+		if w, ok := c.(observersAware); ok {
+			w.PushObservers(g.Name(), g.observersSent)
+		}
 	}
 
 	return g, nil
@@ -718,9 +876,18 @@ func autoLockKick(g *Group) {
 		for _, c := range clients {
 			c.Joined(g.Name(), "change")
 		}
+		// we may be holding the group lock
+		go sendWebhook(g.name, g.description,
+			webhook.Event{Type: "autolock"},
+		)
 	}
 
 	if g.description.Autokick {
+		if len(clients) > 0 {
+			go sendWebhook(g.name, g.description,
+				webhook.Event{Type: "autokick"},
+			)
+		}
 		// we cannot call kickall, since it requires the group to
 		// be unlocked.  And calling it asynchronously might
 		// spuriously kick out an operator.
@@ -748,16 +915,114 @@ func DelClient(c Client) {
 	}
 	delete(g.clients, c.Id())
 	g.timestamp = time.Now()
+	announced := g.announced[c.Id()]
+	if announced {
+		delete(g.announced, c.Id())
+	} else {
+		g.schedulePresenceUnlocked()
+	}
+	g.leaveHandsUnlocked(c)
 	clients := g.getClientsUnlocked(nil)
+	var recipients []Client
+	if announced {
+		recipients = g.presenceRecipientsUnlocked(c, c.Id())
+	}
 	g.mu.Unlock()
 
 	c.Joined(g.Name(), "leave")
-	for _, cc := range clients {
-		cc.PushClient(
-			g.Name(), "delete", c.Id(), c.Username(), nil, nil,
-		)
+	if announced {
+		for _, cc := range recipients {
+			cc.PushClient(
+				g.Name(), "delete", c.Id(), c.Username(),
+				nil, nil,
+			)
+		}
 	}
 	autoLockKick(g)
+	autoRecordStop(g, clients)
+
+	if !slices.Contains(c.Permissions(), "system") {
+		g.SendWebhook(webhook.Event{
+			Type:     "leave",
+			Id:       c.Id(),
+			Username: c.Username(),
+		})
+		empty := true
+		for _, cc := range clients {
+			if !slices.Contains(cc.Permissions(), "system") {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			g.mu.Lock()
+			g.autoRecorded = false
+			g.mu.Unlock()
+			g.SendWebhook(webhook.Event{Type: "empty"})
+		}
+	}
+}
+
+// SendWebhook sends an event to the receivers of notifications defined
+// in the global configuration and in the group's description.
+func (g *Group) SendWebhook(event webhook.Event) {
+	sendWebhook(g.name, g.Description(), event)
+}
+
+func sendWebhook(name string, desc *Description, event webhook.Event) {
+	conf, err := GetConfiguration()
+	if err != nil {
+		log.Printf("Read config file: %v", err)
+		return
+	}
+	hooks := slices.Concat(conf.Webhooks, desc.Webhooks)
+	if len(hooks) == 0 {
+		return
+	}
+	event.Group = name
+	webhook.Send(hooks, event)
+}
+
+// ClaimAutoRecord returns true if automatic recording should be started,
+// which happens when the first stream is published after the group
+// became empty, unless an operator has stopped recording since.
+func (g *Group) ClaimAutoRecord() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.autoRecorded {
+		return false
+	}
+	g.autoRecorded = true
+	return true
+}
+
+// InhibitAutoRecord prevents automatic recording from starting again
+// until the group becomes empty.
+func (g *Group) InhibitAutoRecord() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.autoRecorded = true
+}
+
+// autoRecordStop kicks out any system clients, such as disk writers,
+// audio mixers and relays, when the last user leaves a group with
+// automatic recording, audio mixing or cascading.
+func autoRecordStop(g *Group, clients []Client) {
+	desc := g.Description()
+	if !(desc.AutoRecord || desc.AudioMixing || len(desc.Cascade) > 0) ||
+		len(clients) == 0 {
+		return
+	}
+	for _, c := range clients {
+		if !slices.Contains(c.Permissions(), "system") {
+			return
+		}
+	}
+	go func(clients []Client) {
+		for _, c := range clients {
+			c.Kick("", nil, "the group is empty")
+		}
+	}(clients)
 }
 
 func (g *Group) GetClients(except Client) []Client {
@@ -838,18 +1103,55 @@ func (g *Group) WallOps(message string) {
 const maxChatHistory = 50
 
 func (g *Group) ClearChatHistory(id string, userId string) {
+	g.loadHistory()
 	g.mu.Lock()
 	defer g.mu.Unlock()
 	if id == "" && userId == "" {
 		g.history = nil
+	} else {
+		g.history = slices.DeleteFunc(g.history,
+			func(e ChatHistoryEntry) bool {
+				return e.Source == userId &&
+					(id == "" || e.Id == id)
+			},
+		)
+	}
+	g.storeHistory(nil)
+}
+
+// storeHistory schedules an update of the history store after entry was
+// added to the history, or after the history was modified if entry is nil.
+// Called locked.
+func (g *Group) storeHistory(entry *ChatHistoryEntry) {
+	if historyStore == nil || g.name == "" {
 		return
 	}
-	g.history = slices.DeleteFunc(g.history, func(e ChatHistoryEntry) bool {
-		return e.Source == userId && (id == "" || e.Id == id)
-	})
+
+	maxAge := maxHistoryAge(g.description)
+	w := historyWrite{store: historyStore, group: g.name}
+	if entry != nil && g.historyStored < 2*maxChatHistory &&
+		(g.historyStored == 0 || time.Since(g.historyOldest) <= maxAge) {
+		e := *entry
+		w.entry = &e
+		if g.historyStored == 0 {
+			g.historyOldest = e.Time
+		}
+		g.historyStored++
+	} else {
+		// rewrite the store, which drops old and obsolete entries
+		g.history = discardObsoleteHistory(g.history, maxAge)
+		w.history = slices.Clone(g.history)
+		g.historyStored = len(g.history)
+		g.historyOldest = time.Time{}
+		if len(g.history) > 0 {
+			g.historyOldest = g.history[0].Time
+		}
+	}
+	queueHistoryWrite(w)
 }
 
 func (g *Group) AddToChatHistory(id, source string, user *string, time time.Time, kind string, value interface{}) {
+	g.loadHistory()
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
@@ -857,9 +1159,9 @@ func (g *Group) AddToChatHistory(id, source string, user *string, time time.Time
 		copy(g.history, g.history[1:])
 		g.history = g.history[:len(g.history)-1]
 	}
-	g.history = append(g.history,
-		ChatHistoryEntry{Id: id, Source: source, User: user, Time: time, Kind: kind, Value: value},
-	)
+	entry := ChatHistoryEntry{Id: id, Source: source, User: user, Time: time, Kind: kind, Value: value}
+	g.history = append(g.history, entry)
+	g.storeHistory(&entry)
 }
 
 func discardObsoleteHistory(h []ChatHistoryEntry, duration time.Duration) []ChatHistoryEntry {
@@ -878,6 +1180,7 @@ func discardObsoleteHistory(h []ChatHistoryEntry, duration time.Duration) []Chat
 }
 
 func (g *Group) GetChatHistory() []ChatHistoryEntry {
+	g.loadHistory()
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
@@ -903,6 +1206,8 @@ type Configuration struct {
 	ProxyURL         string                     `json:"proxyURL,omitempty"`
 	WritableGroups   bool                       `json:"writableGroups,omitempty"`
 	Users            map[string]UserDescription `json:"users,omitempty"`
+	Webhooks         []webhook.Hook             `json:"webhooks,omitempty"`
+	Roles            map[string][]string        `json:"roles,omitempty"`
 
 	// obsolete fields
 	Admin []ClientPattern `json:"admin,omitempty"`
@@ -960,6 +1265,10 @@ func GetConfiguration() (*Configuration, error) {
 		log.Printf("%v: field \"admin\" is obsolete, ignored", filename)
 		conf.Admin = nil
 	}
+	err = checkRoles(conf.Roles, conf.Users)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", filename, err)
+	}
 	configuration.configuration = &conf
 	return configuration.configuration, nil
 }
@@ -1048,6 +1357,14 @@ func (desc *Description) GetPermission(groupname string, creds ClientCredentials
 	} else if creds.Username != nil {
 		username = *creds.Username
 		ps, err := desc.getPasswordPermission(creds)
+		if errors.Is(err, ErrNoSuchUsername) && desc.AuthCallout != "" {
+			username, ps, err =
+				authCallout(desc.AuthCallout, groupname, creds)
+			// the server may not hand out the name of a local user
+			if err == nil && desc.userExists(username) {
+				err = ErrDuplicateUsername
+			}
+		}
 		if err != nil {
 			return "", nil, err
 		}
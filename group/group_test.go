@@ -4,7 +4,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
 	"sort"
+	"sync"
 	"testing"
 	"time"
 
@@ -73,6 +77,52 @@ func TestGroup(t *testing.T) {
 	}
 }
 
+func TestClaimAutoRecord(t *testing.T) {
+	groups.groups = nil
+	Directory = t.TempDir()
+	err := os.WriteFile(filepath.Join(Directory, "record.json"),
+		[]byte(`{"wildcard-user": {"password": {"type": "wildcard"}}}`),
+		0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	username := "user"
+	c := &lobbyTestClient{id: "user"}
+	g, err := AddClient("record", c, ClientCredentials{Username: &username})
+	if err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	c.group = g
+
+	if !g.ClaimAutoRecord() {
+		t.Errorf("First claim failed")
+	}
+	if g.ClaimAutoRecord() {
+		t.Errorf("Second claim succeeded")
+	}
+
+	DelClient(c)
+	_, err = AddClient("record", c, ClientCredentials{Username: &username})
+	if err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	if !g.ClaimAutoRecord() {
+		t.Errorf("Claim failed after the group became empty")
+	}
+
+	DelClient(c)
+	_, err = AddClient("record", c, ClientCredentials{Username: &username})
+	if err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	g.InhibitAutoRecord()
+	if g.ClaimAutoRecord() {
+		t.Errorf("Claim succeeded after inhibition")
+	}
+	DelClient(c)
+}
+
 func TestChatHistory(t *testing.T) {
 	g := Group{
 		description: &Description{},
@@ -121,6 +171,59 @@ func TestChatHistory(t *testing.T) {
 	}
 }
 
+func TestPersistentChatHistory(t *testing.T) {
+	SetHistoryStore(NewFileHistoryStore(t.TempDir()))
+	defer SetHistoryStore(nil)
+
+	groups.groups = nil
+	g, err := Add("group/subgroup", &Description{})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	user := "user"
+	for i := 0; i < 3*maxChatHistory; i++ {
+		g.AddToChatHistory(
+			fmt.Sprintf("id-%v", i), "source", &user, time.Now(),
+			"", fmt.Sprintf("%v", i),
+		)
+	}
+	g.ClearChatHistory(fmt.Sprintf("id-%v", 3*maxChatHistory-1), "source")
+	if g.historyStored > 2*maxChatHistory {
+		t.Errorf("Store not compacted: %v", g.historyStored)
+	}
+
+	// simulate a restart
+	groups.groups = nil
+	g, err = Add("group/subgroup", &Description{})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	h := g.GetChatHistory()
+	if len(h) != maxChatHistory-1 {
+		t.Fatalf("Expected %v, got %v", maxChatHistory-1, len(h))
+	}
+	for i, e := range h {
+		j := i + 2*maxChatHistory
+		if e.Id != fmt.Sprintf("id-%v", j) ||
+			e.User == nil || *e.User != user ||
+			e.Value.(string) != fmt.Sprintf("%v", j) {
+			t.Errorf("Expected %v, got %v", j, e)
+		}
+	}
+
+	g.ClearChatHistory("", "")
+	groups.groups = nil
+	g, err = Add("group/subgroup", &Description{})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if lh := len(g.GetChatHistory()); lh != 0 {
+		t.Errorf("Expected 0, got %v", lh)
+	}
+}
+
 func permissionsEqual(a, b []string) bool {
 	// nil case
 	if len(a) == 0 && len(b) == 0 {
@@ -404,3 +507,159 @@ func TestPayloadTypeDistinct(t *testing.T) {
 		m[pt] = n
 	}
 }
+
+// blockingHistoryStore blocks all writes until release is closed.
+type blockingHistoryStore struct {
+	release chan struct{}
+	mu      sync.Mutex
+	entries []ChatHistoryEntry
+}
+
+func (s *blockingHistoryStore) Load(group string) ([]ChatHistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.entries), nil
+}
+
+func (s *blockingHistoryStore) Add(group string, entry ChatHistoryEntry) error {
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *blockingHistoryStore) Replace(group string, history []ChatHistoryEntry) error {
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = slices.Clone(history)
+	return nil
+}
+
+func TestHistoryStoreAsync(t *testing.T) {
+	store := &blockingHistoryStore{release: make(chan struct{})}
+	SetHistoryStore(store)
+	defer SetHistoryStore(nil)
+
+	groups.groups = nil
+	g, err := Add("async", &Description{})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			g.AddToChatHistory(
+				fmt.Sprintf("id-%v", i), "source", nil,
+				time.Now(), "", "hello",
+			)
+		}
+		g.ClearChatHistory("id-0", "source")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Chat history blocked on the store")
+	}
+	close(store.release)
+	FlushHistoryStore()
+	h, _ := store.Load("async")
+	if len(h) != 2 || h[0].Id != "id-1" || h[1].Id != "id-2" {
+		t.Errorf("Bad stored history %v", h)
+	}
+}
+
+// slowHistoryStore blocks loading until release is closed.
+type slowHistoryStore struct {
+	blockingHistoryStore
+}
+
+func (s *slowHistoryStore) Load(group string) ([]ChatHistoryEntry, error) {
+	<-s.release
+	return s.blockingHistoryStore.Load(group)
+}
+
+func TestHistoryLoadUnlocked(t *testing.T) {
+	store := &slowHistoryStore{
+		blockingHistoryStore{release: make(chan struct{})},
+	}
+	SetHistoryStore(store)
+	defer SetHistoryStore(nil)
+
+	groups.groups = nil
+	g, err := Add("slow", &Description{})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	loaded := make(chan struct{})
+	go func() {
+		g.GetChatHistory()
+		close(loaded)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		Add("other", &Description{})
+		Get("slow")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Loading chat history blocked other groups")
+	}
+
+	close(store.release)
+	<-loaded
+	FlushHistoryStore()
+}
+
+func TestHistoryStoreAge(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileHistoryStore(dir)
+	SetHistoryStore(store)
+	defer SetHistoryStore(nil)
+
+	desc := &Description{MaxHistoryAge: 60}
+	old := time.Now().Add(-time.Hour)
+
+	groups.groups = nil
+	g, err := Add("age", desc)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	g.AddToChatHistory("old", "source", nil, old, "", "old")
+	g.AddToChatHistory("new", "source", nil, time.Now(), "", "new")
+	FlushHistoryStore()
+	h, err := store.Load("age")
+	if err != nil || len(h) != 1 || h[0].Id != "new" {
+		t.Errorf("Obsolete entry not pruned when writing: %v %v", h, err)
+	}
+
+	// entries that became obsolete while the group was not running
+	err = store.Replace("age", []ChatHistoryEntry{
+		{Id: "old", Source: "source", Time: old, Value: "old"},
+		{Id: "new", Source: "source", Time: time.Now(), Value: "new"},
+	})
+	if err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	groups.groups = nil
+	g, err = Add("age", desc)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if h := g.GetChatHistory(); len(h) != 1 || h[0].Id != "new" {
+		t.Errorf("Expected one entry, got %v", h)
+	}
+	FlushHistoryStore()
+	h, err = store.Load("age")
+	if err != nil || len(h) != 1 || h[0].Id != "new" {
+		t.Errorf("Obsolete entry not pruned when loading: %v %v", h, err)
+	}
+}
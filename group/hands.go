@@ -0,0 +1,167 @@
+package group
+
+import (
+	"slices"
+)
+
+// A Hand describes a client in the speaking queue of a group.
+type Hand struct {
+	Id       string `json:"id"`
+	Username string `json:"username,omitempty"`
+}
+
+// Hands describes the speaking queue of a group: the client that holds
+// the floor, if any, and the clients that have raised their hand, in
+// order.
+type Hands struct {
+	Floor *Hand  `json:"floor,omitempty"`
+	Queue []Hand `json:"queue"`
+}
+
+// A Turn is the right to speak, given to a client by an operator.
+type Turn struct {
+	Client Client
+	// Present is true if the client has been given the present
+	// permission for the duration of its turn.
+	Present bool
+}
+
+// A handsAware client wishes to be notified whenever the speaking queue
+// of its group changes.
+type handsAware interface {
+	PushHands(group string, hands Hands) error
+}
+
+func makeHand(c Client) Hand {
+	return Hand{Id: c.Id(), Username: c.Username()}
+}
+
+// called locked
+func (g *Group) handsUnlocked() Hands {
+	hands := Hands{Queue: make([]Hand, 0, len(g.hands))}
+	if g.floor != nil {
+		h := makeHand(g.floor.Client)
+		hands.Floor = &h
+	}
+	for _, c := range g.hands {
+		hands.Queue = append(hands.Queue, makeHand(c))
+	}
+	return hands
+}
+
+// Hands returns the speaking queue of group g.
+func (g *Group) Hands() Hands {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.handsUnlocked()
+}
+
+// called locked
+func (g *Group) pushHandsUnlocked() {
+	hands := g.handsUnlocked()
+	for _, c := range g.clients {
+		w, ok := c.(handsAware)
+		if ok {
+			w.PushHands(g.name, hands)
+		}
+	}
+}
+
+// RaiseHand adds c at the end of the speaking queue.  It does nothing if
+// c is already in the queue or holds the floor.
+func (g *Group) RaiseHand(c Client) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.clients[c.Id()] != c {
+		return UserError("not a member of this group")
+	}
+	if slices.Contains(g.hands, c) ||
+		(g.floor != nil && g.floor.Client == c) {
+		return nil
+	}
+	g.hands = append(g.hands, c)
+	g.pushHandsUnlocked()
+	return nil
+}
+
+// LowerHand removes the client with the given id from the speaking queue.
+func (g *Group) LowerHand(id string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	i := slices.IndexFunc(g.hands, func(c Client) bool {
+		return c.Id() == id
+	})
+	if i < 0 {
+		return UserError("this user hasn't raised their hand")
+	}
+	g.hands = slices.Delete(g.hands, i, i+1)
+	g.pushHandsUnlocked()
+	return nil
+}
+
+// GrantFloor gives the floor to the client with the given id, or to the
+// first client in the speaking queue if id is empty, and removes it from
+// the queue.  It returns the new turn and the turn that has ended, if
+// any; the caller is responsible for updating the clients' permissions.
+func (g *Group) GrantFloor(id string) (*Turn, *Turn, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var c Client
+	if id == "" {
+		if len(g.hands) == 0 {
+			return nil, nil, UserError("nobody has raised their hand")
+		}
+		c = g.hands[0]
+	} else {
+		c = g.clients[id]
+		if c == nil {
+			return nil, nil, UserError("no such user")
+		}
+	}
+	ended := g.floor
+	if ended != nil && ended.Client == c {
+		return nil, nil, UserError("this user already has the floor")
+	}
+	g.hands = slices.DeleteFunc(g.hands, func(cc Client) bool {
+		return cc == c
+	})
+	g.floor = &Turn{
+		Client:  c,
+		Present: !slices.Contains(c.Permissions(), "present"),
+	}
+	g.pushHandsUnlocked()
+	return g.floor, ended, nil
+}
+
+// EndTurn ends the turn of the client that holds the floor, and returns
+// the turn that has ended.  If id is not empty, it must be the id of the
+// client that holds the floor.
+func (g *Group) EndTurn(id string) (*Turn, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.floor == nil || (id != "" && g.floor.Client.Id() != id) {
+		return nil, UserError("this user doesn't have the floor")
+	}
+	ended := g.floor
+	g.floor = nil
+	g.pushHandsUnlocked()
+	return ended, nil
+}
+
+// leaveHandsUnlocked removes c from the speaking queue, and ends its turn
+// if it holds the floor.  Called locked.
+func (g *Group) leaveHandsUnlocked(c Client) {
+	changed := false
+	if g.floor != nil && g.floor.Client == c {
+		g.floor = nil
+		changed = true
+	}
+	i := slices.Index(g.hands, c)
+	if i >= 0 {
+		g.hands = slices.Delete(g.hands, i, i+1)
+		changed = true
+	}
+	if changed {
+		g.pushHandsUnlocked()
+	}
+}
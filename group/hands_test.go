@@ -0,0 +1,135 @@
+package group
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type handsTestClient struct {
+	lobbyTestClient
+	hands Hands
+}
+
+func (c *handsTestClient) PushHands(group string, hands Hands) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hands = hands
+	return nil
+}
+
+func (c *handsTestClient) getHands() Hands {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hands
+}
+
+func TestHands(t *testing.T) {
+	groups.groups = nil
+	Directory = t.TempDir()
+	err := os.WriteFile(filepath.Join(Directory, "hands.json"), []byte(`
+{
+    "users": {"op": {"password": "op", "permissions": "op"}},
+    "wildcard-user": {"password": {"type": "wildcard"},
+                      "permissions": "message"}
+}`), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	add := func(id, username, password string) *handsTestClient {
+		c := &handsTestClient{}
+		c.id = id
+		g, err := AddClient("hands", c, ClientCredentials{
+			Username: &username, Password: password,
+		})
+		if err != nil {
+			t.Fatalf("AddClient (%v): %v", id, err)
+		}
+		c.group = g
+		return c
+	}
+	op := add("op", "op", "op")
+	u1 := add("u1", "user1", "")
+	u2 := add("u2", "user2", "")
+	g := op.group
+
+	_, _, err = g.GrantFloor("")
+	if err == nil {
+		t.Errorf("GrantFloor (empty queue) succeeded")
+	}
+
+	for _, c := range []Client{u1, u2, u1} {
+		err := g.RaiseHand(c)
+		if err != nil {
+			t.Errorf("RaiseHand: %v", err)
+		}
+	}
+	hands := op.getHands()
+	if hands.Floor != nil || len(hands.Queue) != 2 ||
+		hands.Queue[0].Id != "u1" || hands.Queue[1].Id != "u2" ||
+		hands.Queue[1].Username != "user2" {
+		t.Errorf("Bad hands %v", hands)
+	}
+
+	turn, ended, err := g.GrantFloor("")
+	if err != nil || turn.Client != u1 || !turn.Present || ended != nil {
+		t.Errorf("GrantFloor: %v %v %v", turn, ended, err)
+	}
+	hands = u2.getHands()
+	if hands.Floor == nil || hands.Floor.Id != "u1" ||
+		len(hands.Queue) != 1 {
+		t.Errorf("Bad hands %v", hands)
+	}
+
+	err = g.RaiseHand(u1)
+	if err != nil || len(g.Hands().Queue) != 1 {
+		t.Errorf("RaiseHand (floor): %v %v", err, g.Hands())
+	}
+
+	// operators may already present
+	turn, ended, err = g.GrantFloor("op")
+	if err != nil || turn.Client != op || turn.Present ||
+		ended == nil || ended.Client != u1 {
+		t.Errorf("GrantFloor (op): %v %v %v", turn, ended, err)
+	}
+
+	_, err = g.EndTurn("u2")
+	if err == nil {
+		t.Errorf("EndTurn (u2) succeeded")
+	}
+	ended, err = g.EndTurn("")
+	if err != nil || ended.Client != op {
+		t.Errorf("EndTurn: %v %v", ended, err)
+	}
+	_, err = g.EndTurn("")
+	if err == nil {
+		t.Errorf("EndTurn (twice) succeeded")
+	}
+
+	err = g.LowerHand("u1")
+	if err == nil {
+		t.Errorf("LowerHand (u1) succeeded")
+	}
+
+	_, _, err = g.GrantFloor("u2")
+	if err != nil {
+		t.Errorf("GrantFloor (u2): %v", err)
+	}
+	err = g.RaiseHand(u1)
+	if err != nil {
+		t.Errorf("RaiseHand: %v", err)
+	}
+	DelClient(u2)
+	hands = op.getHands()
+	if hands.Floor != nil || len(hands.Queue) != 1 {
+		t.Errorf("Bad hands after leaving %v", hands)
+	}
+	err = g.LowerHand("u1")
+	if err != nil || len(op.getHands().Queue) != 0 {
+		t.Errorf("LowerHand: %v %v", err, op.getHands())
+	}
+
+	DelClient(u1)
+	DelClient(op)
+}
@@ -0,0 +1,276 @@
+package group
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// A HistoryStore stores the chat history of groups persistently, so
+// that it survives server restarts.
+type HistoryStore interface {
+	// Load returns the stored history of a group, oldest first.
+	Load(group string) ([]ChatHistoryEntry, error)
+	// Add appends an entry to the stored history of a group.
+	Add(group string, entry ChatHistoryEntry) error
+	// Replace replaces the stored history of a group.  An empty
+	// history causes the stored history to be deleted.
+	Replace(group string, history []ChatHistoryEntry) error
+}
+
+var historyStore HistoryStore
+
+// SetHistoryStore sets the store used for chat history.  If it is nil,
+// which is the default, chat history is only kept in memory.  This must
+// be called before any groups are created.
+func SetHistoryStore(store HistoryStore) {
+	historyStore = store
+}
+
+// A historyWrite is a pending write to the history store.  If entry is
+// nil, the stored history is replaced with history.
+type historyWrite struct {
+	store   HistoryStore
+	group   string
+	entry   *ChatHistoryEntry
+	history []ChatHistoryEntry
+}
+
+// Writes to the history store are performed asynchronously, in order, so
+// that a slow disk doesn't stall the groups.
+var historyWrites struct {
+	mu      sync.Mutex
+	done    *sync.Cond
+	pending []historyWrite
+	busy    bool
+}
+
+func init() {
+	historyWrites.done = sync.NewCond(&historyWrites.mu)
+}
+
+// queueHistoryWrite schedules a write to the history store.
+func queueHistoryWrite(w historyWrite) {
+	historyWrites.mu.Lock()
+	defer historyWrites.mu.Unlock()
+	if w.entry == nil {
+		// a replacement supersedes pending writes to the same group
+		historyWrites.pending = slices.DeleteFunc(
+			historyWrites.pending,
+			func(ww historyWrite) bool {
+				return ww.store == w.store && ww.group == w.group
+			},
+		)
+	}
+	historyWrites.pending = append(historyWrites.pending, w)
+	if !historyWrites.busy {
+		historyWrites.busy = true
+		go writeHistory()
+	}
+}
+
+func writeHistory() {
+	for {
+		historyWrites.mu.Lock()
+		if len(historyWrites.pending) == 0 {
+			historyWrites.busy = false
+			historyWrites.done.Broadcast()
+			historyWrites.mu.Unlock()
+			return
+		}
+		w := historyWrites.pending[0]
+		historyWrites.pending = historyWrites.pending[1:]
+		historyWrites.mu.Unlock()
+
+		var err error
+		if w.entry != nil {
+			err = w.store.Add(w.group, *w.entry)
+		} else {
+			err = w.store.Replace(w.group, w.history)
+		}
+		if err != nil {
+			log.Printf("Store chat history of %v: %v", w.group, err)
+		}
+	}
+}
+
+// FlushHistoryStore waits until all pending writes to the history store
+// have completed.
+func FlushHistoryStore() {
+	historyWrites.mu.Lock()
+	defer historyWrites.mu.Unlock()
+	for historyWrites.busy {
+		historyWrites.done.Wait()
+	}
+}
+
+// loadHistory loads the stored history of g, with obsolete entries
+// discarded, the first time that the history is accessed.  This is not
+// done when the group is created, since that happens with the global
+// groups lock held.  Must not be called with g.mu held.
+func (g *Group) loadHistory() {
+	if historyStore == nil || g.name == "" {
+		return
+	}
+	g.historyOnce.Do(func() {
+		// the group might have been running recently
+		FlushHistoryStore()
+		h, err := historyStore.Load(g.name)
+		if err != nil {
+			log.Printf("Load chat history of %v: %v", g.name, err)
+		}
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		stored := len(h)
+		h = discardObsoleteHistory(h, maxHistoryAge(g.description))
+		if len(h) > maxChatHistory {
+			h = append([]ChatHistoryEntry(nil), h[len(h)-maxChatHistory:]...)
+		}
+		g.history = h
+		g.historyStored = stored
+		if len(h) > 0 {
+			g.historyOldest = h[0].Time
+		}
+		if len(h) < stored {
+			// drop the discarded entries from the store
+			g.storeHistory(nil)
+		}
+	})
+}
+
+// FileHistoryStore stores the chat history of each group in a JSONL file.
+type FileHistoryStore struct {
+	directory string
+	mu        sync.Mutex
+}
+
+func NewFileHistoryStore(directory string) *FileHistoryStore {
+	return &FileHistoryStore{directory: directory}
+}
+
+func (s *FileHistoryStore) filename(group string) (string, error) {
+	if !validGroupName(group) {
+		return "", UserError("illegal group name")
+	}
+	return filepath.Join(s.directory, filepath.FromSlash(group)+".jsonl"),
+		nil
+}
+
+func (s *FileHistoryStore) Load(group string) ([]ChatHistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filename, err := s.filename(group)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var h []ChatHistoryEntry
+	decoder := json.NewDecoder(f)
+	for {
+		var entry ChatHistoryEntry
+		err := decoder.Decode(&entry)
+		if err != nil {
+			if err == io.EOF {
+				return h, nil
+			}
+			// return what we've got so far, the last line
+			// might have been truncated by a crash
+			return h, err
+		}
+		h = append(h, entry)
+	}
+}
+
+func (s *FileHistoryStore) Add(group string, entry ChatHistoryEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filename, err := s.filename(group)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename,
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600,
+	)
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(f).Encode(entry)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *FileHistoryStore) Replace(group string, history []ChatHistoryEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filename, err := s.filename(group)
+	if err != nil {
+		return err
+	}
+
+	if len(history) == 0 {
+		err := os.Remove(filename)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	dir := filepath.Dir(filename)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return err
+	}
+	tmpfile, err := os.CreateTemp(dir, "chat")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(tmpfile)
+	for _, entry := range history {
+		err := encoder.Encode(entry)
+		if err != nil {
+			tmpfile.Close()
+			os.Remove(tmpfile.Name())
+			return err
+		}
+	}
+
+	err = tmpfile.Close()
+	if err != nil {
+		os.Remove(tmpfile.Name())
+		return err
+	}
+
+	err = os.Rename(tmpfile.Name(), filename)
+	if err != nil {
+		os.Remove(tmpfile.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package group
+
+import (
+	"errors"
+	"slices"
+)
+
+// ErrLobby is returned by AddClient when a client has been placed in the
+// lobby of a group, where it waits until it is admitted by an operator.
+var ErrLobby = errors.New("waiting to be admitted into the group")
+
+// A LobbyEntry describes a client waiting in the lobby of a group.
+type LobbyEntry struct {
+	Id       string `json:"id"`
+	Username string `json:"username,omitempty"`
+}
+
+type lobbyClient struct {
+	client   Client
+	admitted bool
+}
+
+// A lobbyAware client may wait in the lobby, and wishes to be notified
+// whenever the lobby of its group changes.  Only operators are notified.
+type lobbyAware interface {
+	PushLobby(group string, lobby []LobbyEntry) error
+}
+
+// called locked
+func (g *Group) lobbyUnlocked() []LobbyEntry {
+	lobby := make([]LobbyEntry, 0, len(g.lobby))
+	for _, l := range g.lobby {
+		lobby = append(lobby, LobbyEntry{
+			Id:       l.client.Id(),
+			Username: l.client.Username(),
+		})
+	}
+	return lobby
+}
+
+// Lobby returns the list of clients waiting to be admitted, in order of
+// arrival.
+func (g *Group) Lobby() []LobbyEntry {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.lobbyUnlocked()
+}
+
+// called locked
+func (g *Group) pushLobbyUnlocked() {
+	lobby := g.lobbyUnlocked()
+	for _, c := range g.clients {
+		w, ok := c.(lobbyAware)
+		if !ok || !slices.Contains(c.Permissions(), "op") {
+			continue
+		}
+		w.PushLobby(g.name, lobby)
+	}
+}
+
+// called locked
+func (g *Group) findLobbyUnlocked(id string) int {
+	return slices.IndexFunc(g.lobby, func(l *lobbyClient) bool {
+		return l.client.Id() == id
+	})
+}
+
+// enterLobbyUnlocked is called by AddClient.  If c has been admitted, it
+// removes it from the lobby and returns nil.  Otherwise, it places c in
+// the lobby and returns ErrLobby.  Called locked.
+func (g *Group) enterLobbyUnlocked(c Client) error {
+	if _, ok := c.(lobbyAware); !ok {
+		return UserError("this group requires admission by an operator")
+	}
+	i := g.findLobbyUnlocked(c.Id())
+	if i >= 0 && g.lobby[i].client == c {
+		if g.lobby[i].admitted {
+			g.lobby = slices.Delete(g.lobby, i, i+1)
+			g.pushLobbyUnlocked()
+			return nil
+		}
+		return ErrLobby
+	}
+	if i >= 0 {
+		return ProtocolError("duplicate client id")
+	}
+	g.lobby = append(g.lobby, &lobbyClient{client: c})
+	g.pushLobbyUnlocked()
+	return ErrLobby
+}
+
+// Admit marks the client with the given id as admitted, and returns it.
+// The caller should then cause the client to call AddClient again.
+func (g *Group) Admit(id string) (Client, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	i := g.findLobbyUnlocked(id)
+	if i < 0 {
+		return nil, UserError("no such user in the lobby")
+	}
+	g.lobby[i].admitted = true
+	return g.lobby[i].client, nil
+}
+
+// Reject removes the client with the given id from the lobby, and returns
+// it.
+func (g *Group) Reject(id string) (Client, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	i := g.findLobbyUnlocked(id)
+	if i < 0 {
+		return nil, UserError("no such user in the lobby")
+	}
+	c := g.lobby[i].client
+	g.lobby = slices.Delete(g.lobby, i, i+1)
+	g.pushLobbyUnlocked()
+	return c, nil
+}
+
+// LeaveLobby removes c from the lobby of group g.  It is called when a
+// client that is waiting in the lobby goes away.
+func (g *Group) LeaveLobby(c Client) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	i := g.findLobbyUnlocked(c.Id())
+	if i < 0 || g.lobby[i].client != c {
+		return
+	}
+	g.lobby = slices.Delete(g.lobby, i, i+1)
+	g.pushLobbyUnlocked()
+}
@@ -0,0 +1,240 @@
+package group
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/token"
+)
+
+type lobbyTestClient struct {
+	group       *Group
+	id          string
+	username    string
+	permissions []string
+
+	mu    sync.Mutex
+	lobby []LobbyEntry
+}
+
+func (c *lobbyTestClient) Group() *Group                   { return c.group }
+func (c *lobbyTestClient) Addr() net.Addr                  { return nil }
+func (c *lobbyTestClient) Id() string                      { return c.id }
+func (c *lobbyTestClient) Username() string                { return c.username }
+func (c *lobbyTestClient) Permissions() []string           { return c.permissions }
+func (c *lobbyTestClient) Data() map[string]interface{}    { return nil }
+func (c *lobbyTestClient) Joined(group, kind string) error { return nil }
+
+func (c *lobbyTestClient) Init(username string, perms []string) {
+	c.username = username
+	c.permissions = perms
+}
+
+func (c *lobbyTestClient) PushConn(g *Group, id string, up conn.Up, tracks []conn.UpTrack, replace string) error {
+	return nil
+}
+
+func (c *lobbyTestClient) RequestConns(target Client, g *Group, id string) error {
+	return nil
+}
+
+func (c *lobbyTestClient) PushClient(group, kind, id, username string, perms []string, data map[string]interface{}) error {
+	return nil
+}
+
+func (c *lobbyTestClient) Kick(id string, user *string, message string) error {
+	return nil
+}
+
+func (c *lobbyTestClient) PushLobby(group string, lobby []LobbyEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lobby = lobby
+	return nil
+}
+
+func (c *lobbyTestClient) getLobby() []LobbyEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lobby
+}
+
+func TestLobby(t *testing.T) {
+	groups.groups = nil
+	Directory = t.TempDir()
+	err := os.WriteFile(filepath.Join(Directory, "lobby.json"), []byte(`
+{
+    "lobby": true,
+    "users": {"op": {"password": "op", "permissions": "op"}},
+    "wildcard-user": {"password": {"type": "wildcard"}}
+}`), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	opname, username := "op", "user"
+	op := &lobbyTestClient{id: "op"}
+	g, err := AddClient("lobby", op, ClientCredentials{
+		Username: &opname, Password: "op",
+	})
+	if err != nil {
+		t.Fatalf("AddClient (op): %v", err)
+	}
+	op.group = g
+
+	user := &lobbyTestClient{id: "user", group: g}
+	_, err = AddClient("lobby", user, ClientCredentials{
+		Username: &username,
+	})
+	if !errors.Is(err, ErrLobby) {
+		t.Fatalf("AddClient (user): expected ErrLobby, got %v", err)
+	}
+	if g.GetClient("user") != nil {
+		t.Errorf("User joined the group")
+	}
+	lobby := op.getLobby()
+	if len(lobby) != 1 || lobby[0].Id != "user" ||
+		lobby[0].Username != "user" {
+		t.Errorf("Bad lobby %v", lobby)
+	}
+
+	// a second attempt doesn't admit the user
+	_, err = AddClient("lobby", user, ClientCredentials{
+		Username: &username,
+	})
+	if !errors.Is(err, ErrLobby) || len(g.Lobby()) != 1 {
+		t.Errorf("AddClient (user): expected ErrLobby, got %v", err)
+	}
+
+	// another client cannot take the place of the user
+	impostor := &lobbyTestClient{id: "user", group: g}
+	_, err = AddClient("lobby", impostor, ClientCredentials{
+		Username: &username,
+	})
+	if err == nil || errors.Is(err, ErrLobby) {
+		t.Errorf("AddClient (impostor): %v", err)
+	}
+
+	_, err = g.Admit("unknown")
+	if err == nil {
+		t.Errorf("Admit (unknown) succeeded")
+	}
+	c, err := g.Admit("user")
+	if err != nil || c != user {
+		t.Fatalf("Admit: %v %v", c, err)
+	}
+	_, err = AddClient("lobby", user, ClientCredentials{
+		Username: &username,
+	})
+	if err != nil {
+		t.Fatalf("AddClient (admitted): %v", err)
+	}
+	if g.GetClient("user") != user {
+		t.Errorf("User didn't join the group")
+	}
+	if len(op.getLobby()) != 0 || len(g.Lobby()) != 0 {
+		t.Errorf("Lobby is not empty")
+	}
+
+	other := &lobbyTestClient{id: "other", group: g}
+	_, err = AddClient("lobby", other, ClientCredentials{
+		Username: &username,
+	})
+	if !errors.Is(err, ErrLobby) {
+		t.Fatalf("AddClient (other): expected ErrLobby, got %v", err)
+	}
+	c, err = g.Reject("other")
+	if err != nil || c != other {
+		t.Errorf("Reject: %v %v", c, err)
+	}
+	if len(op.getLobby()) != 0 {
+		t.Errorf("Lobby is not empty")
+	}
+
+	_, err = AddClient("lobby", other, ClientCredentials{
+		Username: &username,
+	})
+	if !errors.Is(err, ErrLobby) {
+		t.Fatalf("AddClient (other): expected ErrLobby, got %v", err)
+	}
+	g.LeaveLobby(other)
+	if len(g.Lobby()) != 0 {
+		t.Errorf("Lobby is not empty")
+	}
+
+	DelClient(user)
+	DelClient(op)
+}
+
+func TestLobbyInternalToken(t *testing.T) {
+	groups.groups = nil
+	Directory = t.TempDir()
+	err := os.WriteFile(filepath.Join(Directory, "lobby.json"), []byte(`
+{
+    "lobby": true,
+    "users": {"op": {"password": "op", "permissions": "op"}}
+}`), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	opname, username := "op", "user"
+	op := &lobbyTestClient{id: "op"}
+	g, err := AddClient("lobby", op, ClientCredentials{
+		Username: &opname, Password: "op",
+	})
+	if err != nil {
+		t.Fatalf("AddClient (op): %v", err)
+	}
+	op.group = g
+
+	tok := token.AddInternal(
+		"lobby", &username, []string{"present"}, time.Minute,
+	)
+	creds := ClientCredentials{Token: tok.Token}
+
+	user := &lobbyTestClient{id: "user", group: g}
+	_, err = AddClient("lobby", user, creds)
+	if !errors.Is(err, ErrLobby) {
+		t.Fatalf("AddClient (user): expected ErrLobby, got %v", err)
+	}
+
+	// looking up the token doesn't consume it
+	_, _, err = g.Description().GetPermission("lobby", creds)
+	if err != nil {
+		t.Errorf("GetPermission: %v", err)
+	}
+
+	_, err = g.Admit("user")
+	if err != nil {
+		t.Fatalf("Admit: %v", err)
+	}
+	_, err = AddClient("lobby", user, creds)
+	if err != nil {
+		t.Fatalf("AddClient (admitted): %v", err)
+	}
+	if g.GetClient("user") != user || user.Username() != "user" {
+		t.Errorf("User didn't join the group")
+	}
+	DelClient(user)
+
+	// the token has been used, it cannot be used to reconnect
+	again := &lobbyTestClient{id: "again", group: g}
+	_, err = AddClient("lobby", again, creds)
+	var autherr *NotAuthorisedError
+	if !errors.As(err, &autherr) {
+		t.Errorf("AddClient (reconnect): expected "+
+			"NotAuthorisedError, got %v", err)
+	}
+	if len(g.Lobby()) != 0 {
+		t.Errorf("Lobby is not empty")
+	}
+
+	DelClient(op)
+}
@@ -0,0 +1,263 @@
+package group
+
+import (
+	crand "crypto/rand"
+	"encoding/base64"
+	"slices"
+	"time"
+)
+
+const (
+	// MaxPolls is the maximum number of polls in a group.
+	MaxPolls = 64
+	// MaxPollOptions is the maximum number of options of a poll.
+	MaxPollOptions = 32
+	// pollInterval is the minimum interval between two notifications
+	// of the results of a poll that is receiving votes.
+	pollInterval = 500 * time.Millisecond
+)
+
+// A Poll describes a poll together with its results.
+type Poll struct {
+	Id        string     `json:"id"`
+	Question  string     `json:"question"`
+	Options   []string   `json:"options"`
+	Anonymous bool       `json:"anonymous,omitempty"`
+	Multiple  bool       `json:"multiple,omitempty"`
+	Deadline  *time.Time `json:"deadline,omitempty"`
+	Created   time.Time  `json:"created"`
+	CreatedBy string     `json:"createdBy,omitempty"`
+	Closed    bool       `json:"closed,omitempty"`
+	// only set in the notification sent when a poll is deleted
+	Deleted bool `json:"deleted,omitempty"`
+	// the number of votes for each option
+	Counts []int `json:"counts"`
+	// the number of users who have voted
+	Voters int `json:"voters"`
+	// the choices of every user, only for polls that are not anonymous
+	Votes map[string][]int `json:"votes,omitempty"`
+}
+
+type poll struct {
+	Poll
+	// the choices of every user; for anonymous polls, only the fact
+	// that a user has voted is recorded
+	votes map[string][]int
+	timer *time.Timer
+	// whether votes have been received since the results were last
+	// sent to the clients
+	pending bool
+}
+
+// A pollAware client wishes to be notified whenever a poll is created,
+// receives a vote, is closed, or is deleted.
+type pollAware interface {
+	PushPoll(group string, poll Poll) error
+}
+
+// results returns the public view of a poll.  If votes is true, then the
+// choices of every user are included, unless the poll is anonymous.
+func (p *poll) results(votes bool) Poll {
+	r := p.Poll
+	r.Options = slices.Clone(p.Options)
+	r.Counts = slices.Clone(p.Counts)
+	r.Voters = len(p.votes)
+	if votes && !p.Anonymous {
+		r.Votes = make(map[string][]int, len(p.votes))
+		for u, v := range p.votes {
+			r.Votes[u] = slices.Clone(v)
+		}
+	}
+	return r
+}
+
+// called locked
+func (g *Group) findPollUnlocked(id string) *poll {
+	i := slices.IndexFunc(g.polls, func(p *poll) bool {
+		return p.Id == id
+	})
+	if i < 0 {
+		return nil
+	}
+	return g.polls[i]
+}
+
+// called locked
+func (g *Group) pushPollUnlocked(p *poll, deleted bool) {
+	p.pending = false
+	r := p.results(false)
+	r.Deleted = deleted
+	for _, c := range g.clients {
+		w, ok := c.(pollAware)
+		if ok {
+			w.PushPoll(g.name, r)
+		}
+	}
+}
+
+// schedulePollsUnlocked arranges for the results of the polls that have
+// received votes to be sent to the clients in the near future, so that
+// a burst of votes causes a single notification.  Called locked.
+func (g *Group) schedulePollsUnlocked() {
+	if g.pollTimer != nil {
+		return
+	}
+	g.pollTimer = time.AfterFunc(pollInterval, g.pushPolls)
+}
+
+func (g *Group) pushPolls() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.pollTimer = nil
+	for _, p := range g.polls {
+		if p.pending {
+			g.pushPollUnlocked(p, false)
+		}
+	}
+}
+
+// CreatePoll creates a new poll with the question, options and settings
+// of p, and returns its id.
+func (g *Group) CreatePoll(p Poll, creator string) (string, error) {
+	if p.Question == "" {
+		return "", UserError("empty question")
+	}
+	if len(p.Options) < 2 || len(p.Options) > MaxPollOptions {
+		return "", UserError("bad number of options")
+	}
+	now := time.Now()
+	if p.Deadline != nil && !p.Deadline.After(now) {
+		return "", UserError("deadline is in the past")
+	}
+
+	buf := make([]byte, 8)
+	crand.Read(buf)
+	pp := &poll{
+		Poll: Poll{
+			Id:        base64.RawURLEncoding.EncodeToString(buf),
+			Question:  p.Question,
+			Options:   slices.Clone(p.Options),
+			Anonymous: p.Anonymous,
+			Multiple:  p.Multiple,
+			Deadline:  p.Deadline,
+			Created:   now,
+			CreatedBy: creator,
+			Counts:    make([]int, len(p.Options)),
+		},
+		votes: make(map[string][]int),
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.polls) >= MaxPolls {
+		return "", UserError("too many polls")
+	}
+	if pp.Deadline != nil {
+		id := pp.Id
+		pp.timer = time.AfterFunc(pp.Deadline.Sub(now), func() {
+			g.ClosePoll(id)
+		})
+	}
+	g.polls = append(g.polls, pp)
+	g.pushPollUnlocked(pp, false)
+	return pp.Id, nil
+}
+
+// Vote records the vote of the user with the given username in the poll
+// with the given id.  Every user may only vote once.  The results are
+// sent to the clients after pollInterval, together with any other votes
+// received in the meantime.
+func (g *Group) Vote(id string, username string, choices []int) error {
+	if username == "" {
+		return UserError("anonymous users cannot vote")
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p := g.findPollUnlocked(id)
+	if p == nil {
+		return UserError("no such poll")
+	}
+	if p.Closed || (p.Deadline != nil && time.Now().After(*p.Deadline)) {
+		return UserError("this poll is closed")
+	}
+	if _, ok := p.votes[username]; ok {
+		return UserError("you have already voted")
+	}
+	if len(choices) == 0 || (!p.Multiple && len(choices) > 1) {
+		return UserError("bad number of choices")
+	}
+	for i, c := range choices {
+		if c < 0 || c >= len(p.Options) ||
+			slices.Contains(choices[:i], c) {
+			return UserError("bad choice")
+		}
+	}
+
+	for _, c := range choices {
+		p.Counts[c]++
+	}
+	if p.Anonymous {
+		p.votes[username] = nil
+	} else {
+		p.votes[username] = slices.Clone(choices)
+	}
+	p.pending = true
+	g.schedulePollsUnlocked()
+	return nil
+}
+
+// ClosePoll closes the poll with the given id, after which no more votes
+// are accepted.
+func (g *Group) ClosePoll(id string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p := g.findPollUnlocked(id)
+	if p == nil {
+		return UserError("no such poll")
+	}
+	if p.Closed {
+		return UserError("this poll is already closed")
+	}
+	p.Closed = true
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	g.pushPollUnlocked(p, false)
+	return nil
+}
+
+// DeletePoll deletes the poll with the given id, whether it is closed or
+// not.
+func (g *Group) DeletePoll(id string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	i := slices.IndexFunc(g.polls, func(p *poll) bool {
+		return p.Id == id
+	})
+	if i < 0 {
+		return UserError("no such poll")
+	}
+	p := g.polls[i]
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	g.polls = slices.Delete(g.polls, i, i+1)
+	g.pushPollUnlocked(p, true)
+	return nil
+}
+
+// Polls returns the polls of group g, in order of creation.  If votes is
+// true, then the choices of every user are included for polls that are
+// not anonymous.
+func (g *Group) Polls(votes bool) []Poll {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	polls := make([]Poll, 0, len(g.polls))
+	for _, p := range g.polls {
+		polls = append(polls, p.results(votes))
+	}
+	return polls
+}
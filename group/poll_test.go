@@ -0,0 +1,215 @@
+package group
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+type pollTestClient struct {
+	lobbyTestClient
+	polls []Poll
+}
+
+func (c *pollTestClient) PushPoll(group string, poll Poll) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.polls = append(c.polls, poll)
+	return nil
+}
+
+func (c *pollTestClient) lastPoll() *Poll {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.polls) == 0 {
+		return nil
+	}
+	return &c.polls[len(c.polls)-1]
+}
+
+func (c *pollTestClient) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.polls)
+}
+
+func TestPolls(t *testing.T) {
+	groups.groups = nil
+	Directory = t.TempDir()
+	err := os.WriteFile(filepath.Join(Directory, "polls.json"), []byte(`
+{
+    "wildcard-user": {"password": {"type": "wildcard"}}
+}`), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	username := "user"
+	c := &pollTestClient{}
+	c.id = "user"
+	g, err := AddClient("polls", c, ClientCredentials{Username: &username})
+	if err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	c.group = g
+
+	past := time.Now().Add(-time.Second)
+	bad := []Poll{
+		{Options: []string{"a", "b"}},
+		{Question: "q", Options: []string{"a"}},
+		{Question: "q", Options: []string{"a", "b"}, Deadline: &past},
+	}
+	for _, p := range bad {
+		_, err := g.CreatePoll(p, "op")
+		if err == nil {
+			t.Errorf("CreatePoll %v succeeded", p)
+		}
+	}
+
+	id, err := g.CreatePoll(Poll{
+		Question: "q", Options: []string{"a", "b", "c"},
+	}, "op")
+	if err != nil {
+		t.Fatalf("CreatePoll: %v", err)
+	}
+	p := c.lastPoll()
+	if p == nil || p.Id != id || p.CreatedBy != "op" ||
+		!slices.Equal(p.Counts, []int{0, 0, 0}) {
+		t.Errorf("Bad poll %v", p)
+	}
+
+	for _, choices := range [][]int{nil, {0, 1}, {3}, {-1}} {
+		err := g.Vote(id, "user", choices)
+		if err == nil {
+			t.Errorf("Vote %v succeeded", choices)
+		}
+	}
+	err = g.Vote(id, "", []int{0})
+	if err == nil {
+		t.Errorf("Vote (anonymous user) succeeded")
+	}
+	err = g.Vote(id, "user", []int{1})
+	if err != nil {
+		t.Errorf("Vote: %v", err)
+	}
+	err = g.Vote(id, "user", []int{2})
+	if err == nil {
+		t.Errorf("Vote (twice) succeeded")
+	}
+	err = g.Vote(id, "other", []int{1})
+	if err != nil {
+		t.Errorf("Vote (other): %v", err)
+	}
+	// votes are coalesced into a single notification
+	if n := c.count(); n != 1 {
+		t.Errorf("Expected 1 notification before timer, got %v", n)
+	}
+	g.pushPolls()
+	if n := c.count(); n != 2 {
+		t.Errorf("Expected 2 notifications, got %v", n)
+	}
+	g.pushPolls()
+	if n := c.count(); n != 2 {
+		t.Errorf("Spurious notification, got %v", n)
+	}
+	p = c.lastPoll()
+	if !slices.Equal(p.Counts, []int{0, 2, 0}) || p.Voters != 2 ||
+		p.Votes != nil {
+		t.Errorf("Bad results %v", p)
+	}
+
+	anon, err := g.CreatePoll(Poll{
+		Question: "q", Options: []string{"a", "b", "c"},
+		Anonymous: true, Multiple: true,
+	}, "op")
+	if err != nil {
+		t.Fatalf("CreatePoll (anonymous): %v", err)
+	}
+	err = g.Vote(anon, "user", []int{0, 2})
+	if err != nil {
+		t.Errorf("Vote (multiple): %v", err)
+	}
+	err = g.Vote(anon, "other", []int{2, 2})
+	if err == nil {
+		t.Errorf("Vote (duplicate choice) succeeded")
+	}
+
+	polls := g.Polls(true)
+	if len(polls) != 2 {
+		t.Fatalf("Expected 2 polls, got %v", len(polls))
+	}
+	if len(polls[0].Votes) != 2 ||
+		!slices.Equal(polls[0].Votes["user"], []int{1}) {
+		t.Errorf("Bad votes %v", polls[0].Votes)
+	}
+	if polls[1].Votes != nil ||
+		!slices.Equal(polls[1].Counts, []int{1, 0, 1}) {
+		t.Errorf("Bad anonymous poll %v", polls[1])
+	}
+
+	err = g.ClosePoll(id)
+	if err != nil {
+		t.Errorf("ClosePoll: %v", err)
+	}
+	if !c.lastPoll().Closed {
+		t.Errorf("Poll is not closed")
+	}
+	err = g.Vote(id, "third", []int{0})
+	if err == nil {
+		t.Errorf("Vote (closed) succeeded")
+	}
+
+	deadline := time.Now().Add(50 * time.Millisecond)
+	timed, err := g.CreatePoll(Poll{
+		Question: "q", Options: []string{"a", "b"}, Deadline: &deadline,
+	}, "op")
+	if err != nil {
+		t.Fatalf("CreatePoll (deadline): %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	p = c.lastPoll()
+	if p.Id != timed || !p.Closed {
+		t.Errorf("Poll didn't close at deadline: %v", p)
+	}
+
+	err = g.DeletePoll(anon)
+	if err != nil {
+		t.Errorf("DeletePoll: %v", err)
+	}
+	p = c.lastPoll()
+	if p.Id != anon || !p.Deleted {
+		t.Errorf("Bad deletion notification %v", p)
+	}
+	err = g.DeletePoll(anon)
+	if err == nil {
+		t.Errorf("DeletePoll (twice) succeeded")
+	}
+	err = g.Vote(anon, "other", []int{0})
+	if err == nil {
+		t.Errorf("Vote (deleted) succeeded")
+	}
+	polls = g.Polls(false)
+	if len(polls) != 2 || polls[0].Id != id || polls[1].Id != timed {
+		t.Errorf("Bad polls after deletion %v", polls)
+	}
+
+	last, err := g.CreatePoll(Poll{
+		Question: "q", Options: []string{"a", "b"},
+	}, "op")
+	if err != nil {
+		t.Fatalf("CreatePoll: %v", err)
+	}
+	err = g.Vote(last, "user", []int{0})
+	if err != nil {
+		t.Errorf("Vote: %v", err)
+	}
+	time.Sleep(pollInterval + 200*time.Millisecond)
+	p = c.lastPoll()
+	if p.Id != last || p.Voters != 1 {
+		t.Errorf("Results were not sent after vote: %v", p)
+	}
+
+	DelClient(c)
+}
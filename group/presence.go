@@ -0,0 +1,244 @@
+package group
+
+import (
+	"slices"
+	"time"
+)
+
+// presenceInterval is the minimum interval between two updates sent to
+// the observers of a group with scalable presence.
+const presenceInterval = time.Second
+
+// An observersAware client wishes to be notified of the number of
+// observers in groups with scalable presence.
+type observersAware interface {
+	PushObservers(group string, count int) error
+}
+
+// A presenceEntry is the state of an announced client as last sent to
+// the observers.
+type presenceEntry struct {
+	username string
+	perms    []string
+	data     map[string]interface{}
+}
+
+// announcedUnlocked returns true if a client with the given permissions
+// should be announced to the other clients.  Called locked.
+func (g *Group) announcedUnlocked(perms []string) bool {
+	if !g.description.ScalablePresence {
+		return true
+	}
+	return slices.Contains(perms, "present") ||
+		slices.Contains(perms, "op") ||
+		slices.Contains(perms, "system")
+}
+
+// Observers returns the number of clients that are not announced to the
+// other clients.  This is always 0 unless the group has scalable
+// presence.
+func (g *Group) Observers() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.clients) - len(g.announced)
+}
+
+// presenceRecipientsUnlocked returns the clients other than c that must
+// be told immediately that the client with the given id has been added,
+// changed or deleted.  With scalable presence, these are the announced
+// clients only, and the updates sent to the observers are coalesced and
+// sent by pushPresence.  Each observer therefore receives at most one
+// message per announced client and per presenceInterval, however often
+// the announced clients join, change or leave.  Called locked.
+func (g *Group) presenceRecipientsUnlocked(c Client, id string) []Client {
+	if !g.description.ScalablePresence {
+		return g.getClientsUnlocked(c)
+	}
+	clients := make([]Client, 0, len(g.announced))
+	for cid := range g.announced {
+		if cc := g.clients[cid]; cc != nil && cc != c {
+			clients = append(clients, cc)
+		}
+	}
+	if g.presencePending == nil {
+		g.presencePending = make(map[string]bool)
+	}
+	g.presencePending[id] = true
+	g.schedulePresenceUnlocked()
+	return clients
+}
+
+// pushAnnouncedUnlocked sends the announced clients to c, which has just
+// joined the group.  An observer is sent the state last sent to the other
+// observers, so that it is kept up to date by pushPresence.  Called locked.
+func (g *Group) pushAnnouncedUnlocked(c Client) {
+	if g.description.ScalablePresence && !g.announced[c.Id()] {
+		for id, e := range g.observersView {
+			c.PushClient(g.name, "add", id, e.username, e.perms, e.data)
+		}
+		return
+	}
+	for id := range g.announced {
+		cc := g.clients[id]
+		if cc != nil && cc != c {
+			c.PushClient(
+				g.name, "add", id,
+				cc.Username(), cc.Permissions(), cc.Data(),
+			)
+		}
+	}
+}
+
+// schedulePresenceUnlocked arranges for the presence updates to be sent
+// to the observers in the near future.  Called locked.
+func (g *Group) schedulePresenceUnlocked() {
+	if g.presenceTimer != nil {
+		return
+	}
+	// if scalable presence has just been disabled, the clients still
+	// need to be told that there are no more observers
+	if !g.description.ScalablePresence && g.observersSent == 0 {
+		return
+	}
+	g.presenceTimer = time.AfterFunc(presenceInterval, g.pushPresence)
+}
+
+func (g *Group) pushPresence() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.presenceTimer = nil
+	g.flushPresenceUnlocked()
+	count := len(g.clients) - len(g.announced)
+	if count == g.observersSent {
+		return
+	}
+	g.observersSent = count
+	for _, c := range g.clients {
+		if w, ok := c.(observersAware); ok {
+			w.PushObservers(g.name, count)
+		}
+	}
+}
+
+// flushPresenceUnlocked sends the pending presence updates to the
+// observers.  Called locked.
+func (g *Group) flushPresenceUnlocked() {
+	if len(g.presencePending) == 0 {
+		return
+	}
+	var observers []Client
+	for id, c := range g.clients {
+		if !g.announced[id] {
+			observers = append(observers, c)
+		}
+	}
+	if g.observersView == nil {
+		g.observersView = make(map[string]presenceEntry)
+	}
+	for id := range g.presencePending {
+		c := g.clients[id]
+		_, known := g.observersView[id]
+		if c == nil || !g.announced[id] {
+			if !known {
+				continue
+			}
+			delete(g.observersView, id)
+			for _, cc := range observers {
+				cc.PushClient(g.name, "delete", id, "", nil, nil)
+			}
+			continue
+		}
+		kind := "change"
+		if !known {
+			kind = "add"
+		}
+		e := presenceEntry{c.Username(), c.Permissions(), c.Data()}
+		g.observersView[id] = e
+		for _, cc := range observers {
+			cc.PushClient(g.name, kind, id, e.username, e.perms, e.data)
+		}
+	}
+	g.presencePending = nil
+}
+
+// updatePresence recomputes the set of announced clients after the
+// description of the group has changed, and announces or withdraws the
+// clients whose status has changed.
+func (g *Group) updatePresence() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	// bring the observers up to date, since all clients are
+	// informed immediately below
+	g.flushPresenceUnlocked()
+	changed := false
+	for id, c := range g.clients {
+		perms := c.Permissions()
+		was := g.announced[id]
+		now := g.announcedUnlocked(perms)
+		if was == now {
+			continue
+		}
+		kind := "add"
+		if now {
+			g.announced[id] = true
+		} else {
+			delete(g.announced, id)
+			kind = "delete"
+		}
+		changed = true
+		username := c.Username()
+		data := c.Data()
+		for _, cc := range g.clients {
+			if cc != c {
+				cc.PushClient(g.name, kind, id, username, perms, data)
+			}
+		}
+	}
+	if changed {
+		g.schedulePresenceUnlocked()
+	}
+	g.observersView = nil
+	if g.description.ScalablePresence {
+		g.observersView = make(map[string]presenceEntry)
+		for id := range g.announced {
+			c := g.clients[id]
+			g.observersView[id] = presenceEntry{
+				c.Username(), c.Permissions(), c.Data(),
+			}
+		}
+	}
+}
+
+// UpdateClient informs the clients of group g that the permissions or
+// data of c have changed.  With scalable presence, this may cause c to be
+// announced to, or withdrawn from, the other clients.
+func (g *Group) UpdateClient(c Client, username string, perms []string, data map[string]interface{}) {
+	id := c.Id()
+	g.mu.Lock()
+	if g.clients[id] != c {
+		g.mu.Unlock()
+		return
+	}
+	was := g.announced[id]
+	now := g.announcedUnlocked(perms)
+	kind := "change"
+	if now && !was {
+		g.announced[id] = true
+		kind = "add"
+		g.schedulePresenceUnlocked()
+	} else if was && !now {
+		delete(g.announced, id)
+		kind = "delete"
+		g.schedulePresenceUnlocked()
+	}
+	var clients []Client
+	if was || now {
+		clients = g.presenceRecipientsUnlocked(c, id)
+	}
+	g.mu.Unlock()
+
+	c.PushClient(g.name, "change", id, username, perms, data)
+	for _, cc := range clients {
+		cc.PushClient(g.name, kind, id, username, perms, data)
+	}
+}
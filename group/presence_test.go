@@ -0,0 +1,340 @@
+package group
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type presenceTestClient struct {
+	lobbyTestClient
+	// the kind of the last message received about every client
+	users     map[string]string
+	observers int
+	// the number of messages received about other clients
+	messages int
+}
+
+func (c *presenceTestClient) PushClient(group, kind, id, username string, perms []string, data map[string]interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.users == nil {
+		c.users = make(map[string]string)
+	}
+	c.users[id] = kind
+	if id != c.id {
+		c.messages++
+	}
+	return nil
+}
+
+func (c *presenceTestClient) PushObservers(group string, count int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.observers = count
+	return nil
+}
+
+func (c *presenceTestClient) get(id string) (string, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.users[id], c.observers
+}
+
+func (c *presenceTestClient) getMessages() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.messages
+}
+
+func TestScalablePresence(t *testing.T) {
+	groups.groups = nil
+	Directory = t.TempDir()
+	err := os.WriteFile(filepath.Join(Directory, "presence.json"), []byte(`
+{
+    "scalable-presence": true,
+    "users": {
+        "op": {"password": "op", "permissions": "op"},
+        "presenter": {"password": "presenter", "permissions": "present"}
+    },
+    "wildcard-user": {"password": {"type": "wildcard"},
+                      "permissions": "message"}
+}`), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	add := func(id, username, password string) *presenceTestClient {
+		c := &presenceTestClient{}
+		c.id = id
+		g, err := AddClient("presence", c, ClientCredentials{
+			Username: &username, Password: password,
+		})
+		if err != nil {
+			t.Fatalf("AddClient (%v): %v", id, err)
+		}
+		c.group = g
+		return c
+	}
+
+	op := add("op", "op", "op")
+	g := op.group
+	observer := add("observer", "observer", "")
+	presenter := add("presenter", "presenter", "presenter")
+
+	if kind, _ := op.get("observer"); kind != "" {
+		t.Errorf("Observer was announced: %v", kind)
+	}
+	if kind, _ := op.get("presenter"); kind != "add" {
+		t.Errorf("Presenter was not announced: %v", kind)
+	}
+	// observers are updated by the presence timer
+	if kind, _ := observer.get("presenter"); kind != "" {
+		t.Errorf("Presenter was announced to observer early: %v", kind)
+	}
+	g.pushPresence()
+	if kind, _ := observer.get("op"); kind != "add" {
+		t.Errorf("Operator was not announced to observer: %v", kind)
+	}
+	if kind, _ := observer.get("presenter"); kind != "add" {
+		t.Errorf("Presenter was not announced to observer: %v", kind)
+	}
+	if kind, _ := observer.get("observer"); kind != "add" {
+		t.Errorf("Observer was not announced to itself: %v", kind)
+	}
+
+	if n := g.Observers(); n != 1 {
+		t.Errorf("Expected 1 observer, got %v", n)
+	}
+	g.pushPresence()
+	if _, n := presenter.get(""); n != 1 {
+		t.Errorf("Expected 1 observer, got %v", n)
+	}
+
+	g.UpdateClient(observer, "observer", []string{"message"}, nil)
+	if kind, _ := op.get("observer"); kind != "" {
+		t.Errorf("Observer change was announced: %v", kind)
+	}
+	if kind, _ := observer.get("observer"); kind != "change" {
+		t.Errorf("Observer change was not sent to itself: %v", kind)
+	}
+
+	g.UpdateClient(observer, "observer", []string{"present"}, nil)
+	if kind, _ := op.get("observer"); kind != "add" {
+		t.Errorf("New presenter was not announced: %v", kind)
+	}
+	if n := g.Observers(); n != 0 {
+		t.Errorf("Expected no observers, got %v", n)
+	}
+
+	g.UpdateClient(observer, "observer", []string{"message"}, nil)
+	if kind, _ := op.get("observer"); kind != "delete" {
+		t.Errorf("Former presenter was not withdrawn: %v", kind)
+	}
+
+	DelClient(presenter)
+	if kind, _ := op.get("presenter"); kind != "delete" {
+		t.Errorf("Presenter leaving was not announced: %v", kind)
+	}
+
+	op.mu.Lock()
+	op.users = nil
+	op.mu.Unlock()
+	DelClient(observer)
+	if kind, _ := op.get("observer"); kind != "" {
+		t.Errorf("Observer leaving was announced: %v", kind)
+	}
+	g.pushPresence()
+	if _, n := op.get(""); n != 0 {
+		t.Errorf("Expected no observers, got %v", n)
+	}
+
+	DelClient(op)
+}
+
+func TestScalablePresenceChange(t *testing.T) {
+	groups.groups = nil
+	Directory = t.TempDir()
+	err := os.WriteFile(filepath.Join(Directory, "presence.json"), []byte(`
+{
+    "users": {"op": {"password": "op", "permissions": "op"}},
+    "wildcard-user": {"password": {"type": "wildcard"},
+                      "permissions": "message"}
+}`), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	add := func(id, username, password string) *presenceTestClient {
+		c := &presenceTestClient{}
+		c.id = id
+		g, err := AddClient("presence", c, ClientCredentials{
+			Username: &username, Password: password,
+		})
+		if err != nil {
+			t.Fatalf("AddClient (%v): %v", id, err)
+		}
+		c.group = g
+		return c
+	}
+
+	op := add("op", "op", "op")
+	g := op.group
+	observer := add("observer", "observer", "")
+	if kind, _ := op.get("observer"); kind != "add" {
+		t.Errorf("Observer was not announced: %v", kind)
+	}
+
+	// simulate an edit of the description file
+	desc := *g.Description()
+	desc.ScalablePresence = true
+	desc.modTime = desc.modTime.Add(time.Second)
+	_, err = Add("presence", &desc)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if kind, _ := op.get("observer"); kind != "delete" {
+		t.Errorf("Observer was not withdrawn: %v", kind)
+	}
+	if kind, _ := observer.get("op"); kind != "add" {
+		t.Errorf("Operator was withdrawn: %v", kind)
+	}
+	if n := g.Observers(); n != 1 {
+		t.Errorf("Expected 1 observer, got %v", n)
+	}
+	g.pushPresence()
+	if _, n := op.get(""); n != 1 {
+		t.Errorf("Expected 1 observer, got %v", n)
+	}
+
+	desc2 := desc
+	desc2.ScalablePresence = false
+	desc2.modTime = desc.modTime.Add(time.Second)
+	_, err = Add("presence", &desc2)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if kind, _ := op.get("observer"); kind != "add" {
+		t.Errorf("Observer was not announced again: %v", kind)
+	}
+	if n := g.Observers(); n != 0 {
+		t.Errorf("Expected no observers, got %v", n)
+	}
+	g.pushPresence()
+	if _, n := op.get(""); n != 0 {
+		t.Errorf("Expected no observers, got %v", n)
+	}
+
+	DelClient(observer)
+	DelClient(op)
+}
+
+func TestScalablePresenceCoalesce(t *testing.T) {
+	groups.groups = nil
+	Directory = t.TempDir()
+	err := os.WriteFile(filepath.Join(Directory, "presence.json"), []byte(`
+{
+    "scalable-presence": true,
+    "users": {
+        "presenter": {"password": "presenter", "permissions": "present"}
+    },
+    "wildcard-user": {"password": {"type": "wildcard"},
+                      "permissions": "message"}
+}`), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	add := func(id, username, password string) *presenceTestClient {
+		c := &presenceTestClient{}
+		c.id = id
+		g, err := AddClient("presence", c, ClientCredentials{
+			Username: &username, Password: password,
+		})
+		if err != nil {
+			t.Fatalf("AddClient (%v): %v", id, err)
+		}
+		c.group = g
+		return c
+	}
+
+	const n = 20
+	observers := make([]*presenceTestClient, n)
+	for i := range observers {
+		observers[i] = add(fmt.Sprintf("observer-%v", i), "", "")
+	}
+	g := observers[0].group
+	presenter := add("presenter", "presenter", "presenter")
+	presenter2 := add("presenter2", "presenter", "presenter")
+
+	// many changes within an interval cause a single message to
+	// each observer for each announced client
+	for i := 0; i < 10; i++ {
+		g.UpdateClient(presenter, "presenter", []string{"present"},
+			map[string]interface{}{"i": i},
+		)
+		g.UpdateClient(presenter2, "presenter", []string{"present"},
+			map[string]interface{}{"i": i},
+		)
+	}
+	for _, o := range observers {
+		if m := o.getMessages(); m != 0 {
+			t.Errorf("%v received %v messages early", o.id, m)
+		}
+	}
+	g.pushPresence()
+	for _, o := range observers {
+		if m := o.getMessages(); m != 2 {
+			t.Errorf("%v: expected 2 messages, got %v", o.id, m)
+		}
+		if kind, _ := o.get("presenter"); kind != "add" {
+			t.Errorf("%v: presenter was not announced: %v",
+				o.id, kind)
+		}
+	}
+
+	// a presenter that joins and leaves within an interval is never
+	// announced to the observers
+	transient := add("transient", "presenter", "presenter")
+	DelClient(transient)
+	g.UpdateClient(presenter, "presenter", []string{"present"}, nil)
+	g.pushPresence()
+	for _, o := range observers {
+		if m := o.getMessages(); m != 3 {
+			t.Errorf("%v: expected 3 messages, got %v", o.id, m)
+		}
+		if kind, _ := o.get("transient"); kind != "" {
+			t.Errorf("%v: transient was announced: %v", o.id, kind)
+		}
+		if kind, _ := o.get("presenter"); kind != "change" {
+			t.Errorf("%v: presenter change: %v", o.id, kind)
+		}
+	}
+	// but announced clients are informed immediately
+	if kind, _ := presenter2.get("transient"); kind != "delete" {
+		t.Errorf("Transient was not withdrawn: %v", kind)
+	}
+
+	// a new observer is sent what the other observers know
+	DelClient(presenter2)
+	late := add("late", "", "")
+	if kind, _ := late.get("presenter2"); kind != "add" {
+		t.Errorf("Late observer: presenter2: %v", kind)
+	}
+	g.pushPresence()
+	if kind, _ := late.get("presenter2"); kind != "delete" {
+		t.Errorf("Late observer: presenter2 was not withdrawn: %v",
+			kind)
+	}
+	if kind, _ := observers[0].get("presenter2"); kind != "delete" {
+		t.Errorf("Presenter2 was not withdrawn: %v", kind)
+	}
+
+	DelClient(late)
+	DelClient(presenter)
+	for _, o := range observers {
+		DelClient(o)
+	}
+}
@@ -0,0 +1,39 @@
+package mixer
+
+// The mixer works on 48kHz mono audio, in frames of 20ms.
+const (
+	sampleRate = 48000
+	frameSize  = sampleRate / 50
+	// the largest Opus packet is 120ms
+	maxFrameSize = 6 * frameSize
+	// the bitrate of the mixed stream, in bits per second
+	encoderBitrate = 48000
+)
+
+// A decoder decodes Opus packets into PCM samples.
+type decoder interface {
+	// decode decodes a packet into pcm, and returns the number of
+	// samples.  If data is nil, it performs packet loss concealment
+	// for len(pcm) samples.
+	decode(data []byte, pcm []int16) (int, error)
+	close()
+}
+
+// An encoder encodes frames of PCM samples into Opus packets.
+type encoder interface {
+	// encode encodes a frame into data, and returns the length of
+	// the packet.
+	encode(pcm []int16, data []byte) (int, error)
+	close()
+}
+
+// These are set by the codec implementation, if any.
+var (
+	newDecoder func() (decoder, error)
+	newEncoder func() (encoder, error)
+)
+
+// Available returns true if this build of Galene supports audio mixing.
+func Available() bool {
+	return newDecoder != nil && newEncoder != nil
+}
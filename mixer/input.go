@@ -0,0 +1,202 @@
+package mixer
+
+import (
+	"log"
+	"sync"
+
+	"github.com/pion/rtp"
+
+	"github.com/jech/galene/conn"
+)
+
+const (
+	// the amount of audio that we buffer before we start mixing
+	// a stream, in samples
+	jitterSamples = 2 * frameSize
+	// the maximum amount of audio buffered
+	maxBuffered = 10 * frameSize
+	// the maximum number of consecutive lost packets that we conceal
+	maxConcealed = 5
+)
+
+// A mixerConn holds the audio tracks of a stream being mixed.
+type mixerConn struct {
+	client   *Client
+	remote   conn.Up
+	clientId string
+	inputs   []*input
+}
+
+// newMixerConn creates a mixerConn for the mixable tracks of up, or
+// returns nil if there are none.
+func newMixerConn(c *Client, up conn.Up, tracks []conn.UpTrack) *mixerConn {
+	id, _ := up.User()
+	mc := &mixerConn{
+		client:   c,
+		remote:   up,
+		clientId: id,
+	}
+	for _, t := range tracks {
+		if !mixable(t) {
+			continue
+		}
+		d, err := newDecoder()
+		if err != nil {
+			log.Printf("Mixer: %v", err)
+			continue
+		}
+		mc.inputs = append(mc.inputs, &input{
+			conn:    mc,
+			remote:  t,
+			decoder: d,
+		})
+	}
+	if len(mc.inputs) == 0 {
+		return nil
+	}
+	return mc
+}
+
+func (mc *mixerConn) start() error {
+	for _, in := range mc.inputs {
+		err := in.remote.AddLocal(in)
+		if err != nil {
+			return err
+		}
+	}
+	return mc.remote.AddLocal(mc)
+}
+
+func (mc *mixerConn) close() {
+	mc.remote.DelLocal(mc)
+	for _, in := range mc.inputs {
+		in.remote.DelLocal(in)
+		in.close()
+	}
+}
+
+// An input is a single audio track being mixed.  It implements
+// conn.DownTrack.
+type input struct {
+	conn   *mixerConn
+	remote conn.UpTrack
+
+	mu      sync.Mutex
+	decoder decoder
+	// decoded samples that have not been mixed yet
+	pcm   []int16
+	frame []int16
+	// whether we have buffered enough samples to start mixing
+	playing   bool
+	seqno     uint16
+	haveSeqno bool
+	// the number of samples in the last packet, used for concealment
+	lastSize int
+}
+
+func (in *input) SetTimeOffset(ntp uint64, rtp uint32) {
+}
+
+func (in *input) SetCname(string) {
+}
+
+func (in *input) GetMaxBitrate() (uint64, int, int) {
+	return ^uint64(0), -1, -1
+}
+
+func (in *input) Write(buf []byte) (int, error) {
+	var p rtp.Packet
+	err := p.Unmarshal(buf)
+	if err != nil {
+		log.Printf("Mixer: %v", err)
+		return 0, nil
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if in.decoder == nil {
+		return 0, conn.ErrConnectionClosed
+	}
+
+	if in.haveSeqno {
+		delta := p.SequenceNumber - in.seqno
+		if delta == 0 || (delta&0x8000) != 0 {
+			// duplicate or late packet
+			return len(buf), nil
+		}
+		if delta-1 <= maxConcealed {
+			for i := uint16(1); i < delta; i++ {
+				in.decode(nil)
+			}
+		}
+	}
+	in.seqno = p.SequenceNumber
+	in.haveSeqno = true
+
+	in.decode(p.Payload)
+	return len(buf), nil
+}
+
+// decode decodes a packet and appends the result to the buffered
+// samples.  If data is nil, it conceals a lost packet.
+// Called locked.
+func (in *input) decode(data []byte) {
+	var buf [maxFrameSize]int16
+	pcm := buf[:]
+	if data == nil {
+		if in.lastSize == 0 {
+			return
+		}
+		pcm = pcm[:in.lastSize]
+	}
+	n, err := in.decoder.decode(data, pcm)
+	if err != nil {
+		return
+	}
+	if data != nil {
+		in.lastSize = n
+	}
+	in.pcm = append(in.pcm, pcm[:n]...)
+	if len(in.pcm) > maxBuffered {
+		// the sender's clock is faster than ours, drop the oldest
+		// samples
+		drop := len(in.pcm) - jitterSamples
+		in.pcm = in.pcm[:copy(in.pcm, in.pcm[drop:])]
+	}
+}
+
+// next returns the next frame of samples, or nil if no samples are
+// available.  The result is only valid until the next call.
+func (in *input) next() []int16 {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if !in.playing {
+		if len(in.pcm) < jitterSamples {
+			return nil
+		}
+		in.playing = true
+	}
+	if len(in.pcm) < frameSize {
+		// buffer underrun, wait for more samples
+		in.playing = false
+		return nil
+	}
+	if in.frame == nil {
+		in.frame = make([]int16, frameSize)
+	}
+	copy(in.frame, in.pcm)
+	in.pcm = in.pcm[:copy(in.pcm, in.pcm[frameSize:])]
+	return in.frame
+}
+
+func (in *input) close() {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if in.decoder != nil {
+		in.decoder.close()
+		in.decoder = nil
+	}
+	in.pcm = nil
+}
@@ -0,0 +1,371 @@
+// Package mixer implements server-side mixing of audio streams.
+//
+// A mixer is a system client that receives the Opus audio tracks of all
+// the streams published in a group, and produces a mixed stream that is
+// presented to the other clients as an ordinary up connection.  Every
+// client that publishes audio receives its own mix, from which its own
+// voice is excluded.
+package mixer
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+)
+
+var ErrNotAvailable = errors.New("audio mixing is not supported by this build")
+
+type Client struct {
+	group *group.Group
+	id    string
+	done  chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+	// the streams being mixed, indexed by up connection id
+	conns map[string]*mixerConn
+	// the mixed streams, indexed by the id of the client whose voice
+	// is excluded; the full mix is at the empty string
+	outputs map[string]*upConn
+}
+
+func newId() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// New creates a mixer for group g.  The caller should then add it to the
+// group.
+func New(g *group.Group) (*Client, error) {
+	if !Available() {
+		return nil, ErrNotAvailable
+	}
+
+	c := &Client{
+		group:   g,
+		id:      newId(),
+		done:    make(chan struct{}),
+		conns:   make(map[string]*mixerConn),
+		outputs: make(map[string]*upConn),
+	}
+	out, err := newUpConn(c, "")
+	if err != nil {
+		return nil, err
+	}
+	c.outputs[""] = out
+
+	go c.loop()
+	return c, nil
+}
+
+func (c *Client) Group() *group.Group {
+	return c.group
+}
+
+func (c *Client) Id() string {
+	return c.id
+}
+
+func (c *Client) Username() string {
+	return "MIXER"
+}
+
+func (c *Client) Init(string, []string) {
+	return
+}
+
+func (c *Client) Permissions() []string {
+	return []string{"system"}
+}
+
+func (c *Client) Data() map[string]interface{} {
+	return nil
+}
+
+func (c *Client) Addr() net.Addr {
+	return nil
+}
+
+func (c *Client) Joined(group, kind string) error {
+	return nil
+}
+
+func (c *Client) PushClient(group, kind, id, username string, perms []string, data map[string]interface{}) error {
+	return nil
+}
+
+func (c *Client) Kick(id string, user *string, message string) error {
+	err := c.Close()
+	group.DelClient(c)
+	return err
+}
+
+// Close stops the mixer, and withdraws the mixed streams from all clients.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	conns := c.conns
+	outputs := c.outputs
+	c.conns = nil
+	c.outputs = nil
+	c.mu.Unlock()
+
+	for _, mc := range conns {
+		mc.close()
+	}
+
+	for _, cc := range c.group.GetClients(c) {
+		out := outputs[cc.Id()]
+		if out == nil {
+			out = outputs[""]
+		}
+		if out != nil {
+			cc.PushConn(c.group, out.id, nil, nil, "")
+		}
+	}
+	for _, out := range outputs {
+		out.close()
+	}
+	return nil
+}
+
+// RequestConns pushes the mix destined to target.
+func (c *Client) RequestConns(target group.Client, g *group.Group, id string) error {
+	if g != c.group || slices.Contains(target.Permissions(), "system") {
+		return nil
+	}
+
+	c.mu.Lock()
+	out := c.outputs[target.Id()]
+	if out == nil {
+		out = c.outputs[""]
+	}
+	c.mu.Unlock()
+
+	if out == nil || (id != "" && id != out.id) {
+		return nil
+	}
+	return target.PushConn(g, out.id, out, out.tracks(), "")
+}
+
+// PushConn adds the audio tracks of a stream to the mix.
+func (c *Client) PushConn(g *group.Group, id string, up conn.Up, tracks []conn.UpTrack, replace string) error {
+	if g != c.group {
+		return nil
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return errors.New("mixer is closed")
+	}
+
+	var old []*mixerConn
+	if replace != "" {
+		if mc := c.conns[replace]; mc != nil {
+			old = append(old, mc)
+			delete(c.conns, replace)
+		}
+	}
+	if mc := c.conns[id]; mc != nil {
+		old = append(old, mc)
+		delete(c.conns, id)
+	}
+
+	var mc *mixerConn
+	if _, ok := up.(*upConn); up != nil && !ok {
+		mc = newMixerConn(c, up, tracks)
+		if mc != nil {
+			c.conns[id] = mc
+		}
+	}
+	c.mu.Unlock()
+
+	for _, o := range old {
+		o.close()
+	}
+
+	if mc != nil {
+		err := mc.start()
+		if err != nil {
+			log.Printf("Mixer: %v", err)
+		}
+	}
+
+	c.updateOutputs()
+	return nil
+}
+
+// updateOutputs ensures that there is a mix for every client that is
+// publishing audio, and pushes the mixes that have changed.
+func (c *Client) updateOutputs() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	speakers := make(map[string]bool)
+	for _, mc := range c.conns {
+		if mc.clientId != "" {
+			speakers[mc.clientId] = true
+		}
+	}
+	var added, removed []*upConn
+	for id := range speakers {
+		if c.outputs[id] != nil {
+			continue
+		}
+		out, err := newUpConn(c, id)
+		if err != nil {
+			log.Printf("Mixer: %v", err)
+			continue
+		}
+		c.outputs[id] = out
+		added = append(added, out)
+	}
+	for id, out := range c.outputs {
+		if id != "" && !speakers[id] {
+			delete(c.outputs, id)
+			removed = append(removed, out)
+		}
+	}
+	main := c.outputs[""]
+	c.mu.Unlock()
+
+	for _, out := range added {
+		cc := c.group.GetClient(out.clientId)
+		if cc != nil {
+			cc.PushConn(c.group, out.id, out, out.tracks(), main.id)
+		}
+	}
+	for _, out := range removed {
+		out.close()
+		cc := c.group.GetClient(out.clientId)
+		if cc != nil {
+			cc.PushConn(c.group, main.id, main, main.tracks(), out.id)
+		}
+	}
+}
+
+// mixable returns true if t is a track that the mixer knows how to mix.
+func mixable(t conn.UpTrack) bool {
+	return t.Kind() == webrtc.RTPCodecTypeAudio &&
+		strings.EqualFold(t.Codec().MimeType, "audio/opus")
+}
+
+// Filter returns the tracks of up that should be sent to clients of
+// group g: if the group has an audio mixer, then the tracks mixed by
+// the mixer are omitted.
+func Filter(g *group.Group, up conn.Up, tracks []conn.UpTrack) []conn.UpTrack {
+	if _, ok := up.(*upConn); ok {
+		return tracks
+	}
+	mixing := false
+	for _, c := range g.GetClients(nil) {
+		if _, ok := c.(*Client); ok {
+			mixing = true
+			break
+		}
+	}
+	if !mixing {
+		return tracks
+	}
+	result := make([]conn.UpTrack, 0, len(tracks))
+	for _, t := range tracks {
+		if !mixable(t) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+func (c *Client) loop() {
+	ticker := time.NewTicker(time.Second * frameSize / sampleRate)
+	defer ticker.Stop()
+
+	mix := make([]int32, frameSize)
+	out := make([]int16, frameSize)
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		c.mixOnce(mix, out)
+	}
+}
+
+// mixOnce mixes a single frame and sends it to all outputs.
+func (c *Client) mixOnce(mix []int32, out []int16) {
+	c.mu.Lock()
+	var inputs []*input
+	for _, mc := range c.conns {
+		inputs = append(inputs, mc.inputs...)
+	}
+	outputs := make([]*upConn, 0, len(c.outputs))
+	for _, o := range c.outputs {
+		outputs = append(outputs, o)
+	}
+	c.mu.Unlock()
+
+	clear(mix)
+	frames := make(map[string][][]int16)
+	for _, in := range inputs {
+		f := in.next()
+		if f == nil {
+			continue
+		}
+		for i, s := range f {
+			mix[i] += int32(s)
+		}
+		id := in.conn.clientId
+		frames[id] = append(frames[id], f)
+	}
+
+	for _, o := range outputs {
+		var exclude [][]int16
+		if o.clientId != "" {
+			exclude = frames[o.clientId]
+		}
+		mixFrame(out, mix, exclude)
+		err := o.track.writeFrame(out)
+		if err != nil && !errors.Is(err, conn.ErrConnectionClosed) {
+			log.Printf("Mixer: %v", err)
+		}
+	}
+}
+
+// mixFrame stores into out the sum of all inputs, stored in mix, minus
+// the frames in exclude.
+func mixFrame(out []int16, mix []int32, exclude [][]int16) {
+	for i := range out {
+		v := mix[i]
+		for _, f := range exclude {
+			v -= int32(f[i])
+		}
+		if v > math.MaxInt16 {
+			v = math.MaxInt16
+		} else if v < math.MinInt16 {
+			v = math.MinInt16
+		}
+		out[i] = int16(v)
+	}
+}
@@ -0,0 +1,186 @@
+package mixer
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+// pcmCodec is a trivial codec that stores samples in little-endian order.
+type pcmCodec struct{}
+
+func (c *pcmCodec) decode(data []byte, pcm []int16) (int, error) {
+	if data == nil {
+		clear(pcm)
+		return len(pcm), nil
+	}
+	n := len(data) / 2
+	for i := 0; i < n; i++ {
+		pcm[i] = int16(binary.LittleEndian.Uint16(data[2*i:]))
+	}
+	return n, nil
+}
+
+// encode only stores the first sample, since a frame doesn't fit in
+// a packet
+func (c *pcmCodec) encode(pcm []int16, data []byte) (int, error) {
+	binary.LittleEndian.PutUint16(data, uint16(pcm[0]))
+	return 2, nil
+}
+
+func (c *pcmCodec) close() {
+}
+
+func pcmPacket(seqno uint16, value int16) []byte {
+	payload := make([]byte, 2*frameSize)
+	for i := 0; i < frameSize; i++ {
+		binary.LittleEndian.PutUint16(payload[2*i:], uint16(value))
+	}
+	p := rtp.Packet{
+		Header: rtp.Header{
+			Version:        2,
+			SequenceNumber: seqno,
+			Timestamp:      uint32(seqno) * frameSize,
+		},
+		Payload: payload,
+	}
+	buf, err := p.Marshal()
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+func TestMixFrame(t *testing.T) {
+	a := []int16{1, 30000, -30000}
+	b := []int16{2, 10000, -10000}
+	mix := make([]int32, 3)
+	for i := range mix {
+		mix[i] = int32(a[i]) + int32(b[i])
+	}
+	out := make([]int16, 3)
+
+	mixFrame(out, mix, nil)
+	expected := []int16{3, 32767, -32768}
+	for i := range out {
+		if out[i] != expected[i] {
+			t.Errorf("Full mix: expected %v, got %v", expected, out)
+			break
+		}
+	}
+
+	mixFrame(out, mix, [][]int16{a})
+	for i := range out {
+		if out[i] != b[i] {
+			t.Errorf("Mix minus: expected %v, got %v", b, out)
+			break
+		}
+	}
+}
+
+func TestInput(t *testing.T) {
+	in := &input{decoder: &pcmCodec{}}
+
+	in.Write(pcmPacket(1, 42))
+	if f := in.next(); f != nil {
+		t.Errorf("Got frame before jitter buffer was full")
+	}
+
+	// packet 3 is lost and should be concealed
+	in.Write(pcmPacket(2, 42))
+	in.Write(pcmPacket(4, 43))
+	// late packet, ignored
+	in.Write(pcmPacket(3, 44))
+
+	expected := []int16{42, 42, 0, 43}
+	for i, e := range expected {
+		f := in.next()
+		if f == nil || len(f) != frameSize || f[0] != e {
+			t.Fatalf("Frame %v: expected %v, got %v", i, e, f)
+		}
+	}
+	if f := in.next(); f != nil {
+		t.Errorf("Got frame after end of input")
+	}
+
+	for i := uint16(0); i < 2*maxBuffered/frameSize; i++ {
+		in.Write(pcmPacket(5+i, 45))
+	}
+	if len(in.pcm) > maxBuffered {
+		t.Errorf("Buffered %v samples", len(in.pcm))
+	}
+}
+
+type testDownTrack struct {
+	packets []rtp.Packet
+}
+
+func (t *testDownTrack) Write(buf []byte) (int, error) {
+	var p rtp.Packet
+	err := p.Unmarshal(append([]byte(nil), buf...))
+	if err != nil {
+		return 0, err
+	}
+	t.packets = append(t.packets, p)
+	return len(buf), nil
+}
+
+func (t *testDownTrack) SetTimeOffset(ntp uint64, rtp uint32) {
+}
+
+func (t *testDownTrack) SetCname(string) {
+}
+
+func (t *testDownTrack) GetMaxBitrate() (uint64, int, int) {
+	return ^uint64(0), -1, -1
+}
+
+func TestOutput(t *testing.T) {
+	newEncoder = func() (encoder, error) {
+		return &pcmCodec{}, nil
+	}
+	defer func() {
+		newEncoder = nil
+	}()
+
+	up, err := newUpConn(&Client{id: "mixer"}, "")
+	if err != nil {
+		t.Fatalf("newUpConn: %v", err)
+	}
+	var down testDownTrack
+	up.track.AddLocal(&down)
+
+	frame := make([]int16, frameSize)
+	for i := 0; i < 3; i++ {
+		frame[0] = int16(i)
+		err := up.track.writeFrame(frame)
+		if err != nil {
+			t.Fatalf("writeFrame: %v", err)
+		}
+	}
+	up.close()
+	if up.track.writeFrame(frame) == nil {
+		t.Errorf("writeFrame succeeded after close")
+	}
+
+	if len(down.packets) != 3 {
+		t.Fatalf("Expected 3 packets, got %v", len(down.packets))
+	}
+	for i, p := range down.packets {
+		first := down.packets[0]
+		if p.SequenceNumber != first.SequenceNumber+uint16(i) ||
+			p.Timestamp != first.Timestamp+uint32(i*frameSize) ||
+			p.SSRC != first.SSRC {
+			t.Errorf("Packet %v: bad header %v", i, p.Header)
+		}
+		if len(p.Payload) != 2 || p.Payload[0] != byte(i) {
+			t.Errorf("Packet %v: bad payload", i)
+		}
+	}
+
+	buf := make([]byte, 1500)
+	if up.track.GetPacket(down.packets[1].SequenceNumber, buf, false) == 0 {
+		t.Errorf("Packet not in cache")
+	}
+}
@@ -0,0 +1,101 @@
+//go:build cgo && opus
+
+package mixer
+
+/*
+#cgo pkg-config: opus
+#include <opus.h>
+
+static int
+set_bitrate(OpusEncoder *enc, opus_int32 bitrate)
+{
+    return opus_encoder_ctl(enc, OPUS_SET_BITRATE(bitrate));
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+func init() {
+	newDecoder = newOpusDecoder
+	newEncoder = newOpusEncoder
+}
+
+func opusError(code C.int) error {
+	return errors.New("opus: " + C.GoString(C.opus_strerror(code)))
+}
+
+type opusDecoder struct {
+	decoder *C.OpusDecoder
+}
+
+func newOpusDecoder() (decoder, error) {
+	var err C.int
+	d := C.opus_decoder_create(sampleRate, 1, &err)
+	if err != C.OPUS_OK {
+		return nil, opusError(err)
+	}
+	return &opusDecoder{decoder: d}, nil
+}
+
+func (d *opusDecoder) decode(data []byte, pcm []int16) (int, error) {
+	if len(pcm) == 0 {
+		return 0, nil
+	}
+	var p *C.uchar
+	if len(data) > 0 {
+		p = (*C.uchar)(unsafe.Pointer(&data[0]))
+	}
+	n := C.opus_decode(
+		d.decoder, p, C.opus_int32(len(data)),
+		(*C.opus_int16)(unsafe.Pointer(&pcm[0])), C.int(len(pcm)),
+		0,
+	)
+	if n < 0 {
+		return 0, opusError(n)
+	}
+	return int(n), nil
+}
+
+func (d *opusDecoder) close() {
+	C.opus_decoder_destroy(d.decoder)
+}
+
+type opusEncoder struct {
+	encoder *C.OpusEncoder
+}
+
+func newOpusEncoder() (encoder, error) {
+	var err C.int
+	e := C.opus_encoder_create(
+		sampleRate, 1, C.OPUS_APPLICATION_VOIP, &err,
+	)
+	if err != C.OPUS_OK {
+		return nil, opusError(err)
+	}
+	err = C.set_bitrate(e, encoderBitrate)
+	if err != C.OPUS_OK {
+		C.opus_encoder_destroy(e)
+		return nil, opusError(err)
+	}
+	return &opusEncoder{encoder: e}, nil
+}
+
+func (e *opusEncoder) encode(pcm []int16, data []byte) (int, error) {
+	n := C.opus_encode(
+		e.encoder,
+		(*C.opus_int16)(unsafe.Pointer(&pcm[0])), C.int(len(pcm)),
+		(*C.uchar)(unsafe.Pointer(&data[0])), C.opus_int32(len(data)),
+	)
+	if n < 0 {
+		return 0, opusError(C.int(n))
+	}
+	return int(n), nil
+}
+
+func (e *opusEncoder) close() {
+	C.opus_encoder_destroy(e.encoder)
+}
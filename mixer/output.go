@@ -0,0 +1,225 @@
+package mixer
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/packetcache"
+	"github.com/jech/galene/rtptime"
+)
+
+var codec = webrtc.RTPCodecCapability{
+	MimeType:     "audio/opus",
+	ClockRate:    48000,
+	Channels:     2,
+	SDPFmtpLine:  "minptime=10;useinbandfec=1",
+	RTCPFeedback: group.AudioRTCPFeedback,
+}
+
+// An upConn is a mixed stream.  It implements conn.Up.
+type upConn struct {
+	id     string
+	client *Client
+	// the client whose voice is excluded from the mix, if any
+	clientId string
+	track    *upTrack
+
+	mu    sync.Mutex
+	local []conn.Down
+}
+
+func newUpConn(c *Client, clientId string) (*upConn, error) {
+	e, err := newEncoder()
+	if err != nil {
+		return nil, err
+	}
+	ptype, err := group.CodecPayloadType(codec)
+	if err != nil {
+		e.close()
+		return nil, err
+	}
+	up := &upConn{
+		id:       newId(),
+		client:   c,
+		clientId: clientId,
+	}
+	up.track = &upTrack{
+		conn:      up,
+		ptype:     uint8(ptype),
+		ssrc:      rand.Uint32(),
+		cache:     packetcache.New(64),
+		encoder:   e,
+		seqno:     uint16(rand.Uint32()),
+		timestamp: rand.Uint32(),
+		buf:       make([]byte, 1500),
+	}
+	return up, nil
+}
+
+func (up *upConn) Id() string {
+	return up.id
+}
+
+func (up *upConn) Label() string {
+	return "mix"
+}
+
+func (up *upConn) User() (string, string) {
+	return up.client.Id(), up.client.Username()
+}
+
+func (up *upConn) AddLocal(local conn.Down) error {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	for _, l := range up.local {
+		if l == local {
+			return nil
+		}
+	}
+	up.local = append(up.local, local)
+	return nil
+}
+
+func (up *upConn) DelLocal(local conn.Down) bool {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	for i, l := range up.local {
+		if l == local {
+			up.local = append(up.local[:i], up.local[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (up *upConn) tracks() []conn.UpTrack {
+	return []conn.UpTrack{up.track}
+}
+
+func (up *upConn) close() {
+	up.track.close()
+}
+
+// An upTrack is the audio track of a mixed stream.  It implements
+// conn.UpTrack.
+type upTrack struct {
+	conn  *upConn
+	ptype uint8
+	ssrc  uint32
+	cache *packetcache.Cache
+
+	mu        sync.Mutex
+	encoder   encoder
+	seqno     uint16
+	timestamp uint32
+	buf       []byte
+	local     []conn.DownTrack
+}
+
+func (t *upTrack) AddLocal(local conn.DownTrack) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.encoder == nil {
+		return conn.ErrConnectionClosed
+	}
+	for _, l := range t.local {
+		if l == local {
+			return nil
+		}
+	}
+	// the timestamp of the next packet corresponds to the current time
+	local.SetTimeOffset(rtptime.TimeToNTP(time.Now()), t.timestamp)
+	t.local = append(t.local, local)
+	return nil
+}
+
+func (t *upTrack) DelLocal(local conn.DownTrack) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, l := range t.local {
+		if l == local {
+			t.local = append(t.local[:i], t.local[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *upTrack) Kind() webrtc.RTPCodecType {
+	return webrtc.RTPCodecTypeAudio
+}
+
+func (t *upTrack) Label() string {
+	return ""
+}
+
+func (t *upTrack) Codec() webrtc.RTPCodecCapability {
+	return codec
+}
+
+func (t *upTrack) GetPacket(seqno uint16, result []byte, nack bool) uint16 {
+	return t.cache.Get(seqno, result)
+}
+
+func (t *upTrack) RequestKeyframe() error {
+	return nil
+}
+
+// writeFrame encodes a frame and sends it to all local tracks.
+func (t *upTrack) writeFrame(pcm []int16) error {
+	t.mu.Lock()
+	if t.encoder == nil {
+		t.mu.Unlock()
+		return conn.ErrConnectionClosed
+	}
+	header := rtp.Header{
+		Version:        2,
+		PayloadType:    t.ptype,
+		SequenceNumber: t.seqno,
+		Timestamp:      t.timestamp,
+		SSRC:           t.ssrc,
+	}
+	hlen, err := header.MarshalTo(t.buf)
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	n, err := t.encoder.encode(pcm, t.buf[hlen:])
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	packet := t.buf[:hlen+n]
+	t.cache.Store(t.seqno, t.timestamp, false, false, packet)
+	t.seqno++
+	t.timestamp += uint32(len(pcm))
+	local := make([]conn.DownTrack, len(t.local))
+	copy(local, t.local)
+	t.mu.Unlock()
+
+	// writeFrame is only called by the mixing loop, so the buffer
+	// will not be modified until we return
+	for _, l := range local {
+		_, err := l.Write(packet)
+		if err != nil && err != conn.ErrConnectionClosed {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *upTrack) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.encoder != nil {
+		t.encoder.close()
+		t.encoder = nil
+	}
+	t.local = nil
+}
@@ -45,6 +45,8 @@ type Cache struct {
 	totalExpected uint32
 	received      uint32
 	totalReceived uint32
+	nacked        uint32
+	unordered     uint32
 	// last seen keyframe
 	keyframe      uint16
 	keyframeValid bool
@@ -180,6 +182,7 @@ func (cache *Cache) Store(seqno uint16, timestamp uint32, keyframe bool, marker
 			if cache.received < cache.expected {
 				cache.received++
 			}
+			cache.unordered++
 		}
 	}
 	cache.bitmap.set(seqno)
@@ -211,6 +214,7 @@ func (cache *Cache) Expect(n int) {
 	cache.mu.Lock()
 	defer cache.mu.Unlock()
 	cache.expected += uint32(n)
+	cache.nacked += uint32(n)
 }
 
 // get retrieves a packet from a slice of entries.
@@ -339,11 +343,15 @@ func (cache *Cache) ResizeCond(capacity int) bool {
 	return true
 }
 
-// Stats contains cache statistics
+// Stats contains cache statistics.  TotalNACKed is the number of packets
+// that were requested again, and TotalUnordered the number of packets
+// that were received out of order; they are never reset.
 type Stats struct {
 	Received, TotalReceived uint32
 	Expected, TotalExpected uint32
 	ESeqno                  uint32
+	TotalNACKed             uint32
+	TotalUnordered          uint32
 }
 
 // GetStats returns statistics about received packets.  If reset is true,
@@ -353,11 +361,13 @@ func (cache *Cache) GetStats(reset bool) Stats {
 	defer cache.mu.Unlock()
 
 	s := Stats{
-		Received:      cache.received,
-		TotalReceived: cache.totalReceived + cache.received,
-		Expected:      cache.expected,
-		TotalExpected: cache.totalExpected + cache.expected,
-		ESeqno:        uint32(cache.cycle)<<16 | uint32(cache.last),
+		Received:       cache.received,
+		TotalReceived:  cache.totalReceived + cache.received,
+		Expected:       cache.expected,
+		TotalExpected:  cache.totalExpected + cache.expected,
+		ESeqno:         uint32(cache.cycle)<<16 | uint32(cache.last),
+		TotalNACKed:    cache.nacked,
+		TotalUnordered: cache.unordered,
 	}
 
 	if reset {
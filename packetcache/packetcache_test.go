@@ -461,8 +461,9 @@ func TestCacheStatsUnordered(t *testing.T) {
 		stats.TotalReceived != 32 ||
 		stats.Expected != 32 ||
 		stats.TotalExpected != 32 ||
-		stats.ESeqno != 31 {
-		t.Errorf("Expected 32, 32, 32, 32, 31, got %v", stats)
+		stats.ESeqno != 31 ||
+		stats.TotalUnordered != 2 {
+		t.Errorf("Expected 32, 32, 32, 32, 31, 2, got %v", stats)
 	}
 }
 
@@ -481,7 +482,8 @@ func TestCacheStatsNack(t *testing.T) {
 		stats.TotalReceived != 32 ||
 		stats.Expected != 34 ||
 		stats.TotalExpected != 34 ||
-		stats.ESeqno != 31 {
-		t.Errorf("Expected 32, 32, 34, 34, 31, got %v", stats)
+		stats.ESeqno != 31 ||
+		stats.TotalNACKed != 2 {
+		t.Errorf("Expected 32, 32, 34, 34, 31, 2, got %v", stats)
 	}
 }
@@ -0,0 +1,494 @@
+// Package player implements server-side playback of recorded files.
+//
+// A player is a system client that reads a WebM file from the recordings
+// directory of a group, and publishes its VP8, VP9 and Opus tracks as an
+// ordinary up connection.  Playback can be paused, resumed and moved to
+// a different position in the file.
+package player
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/diskwriter"
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtptime"
+)
+
+var ErrClosed = errors.New("playback has stopped")
+
+// The state of a player, as returned by Status.
+const (
+	StatePlaying = "playing"
+	StatePaused  = "paused"
+)
+
+// A Status describes the state of a player.  Times are in seconds.
+type Status struct {
+	File     string  `json:"file"`
+	State    string  `json:"state"`
+	Position float64 `json:"position"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+type action struct {
+	kind     string
+	position time.Duration
+	result   chan error
+}
+
+type Client struct {
+	group    *group.Group
+	id       string
+	file     string
+	root     *os.Root
+	duration time.Duration
+	// the tracks being played, indexed by track number
+	tracks  map[uint64]*upTrack
+	up      *upConn
+	actions chan action
+	done    chan struct{}
+
+	mu       sync.Mutex
+	closed   bool
+	started  bool
+	paused   bool
+	position time.Duration
+}
+
+func newId() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// checkFilename returns an error if file doesn't look like the name of
+// a WebM or Matroska file in the recordings directory.
+func checkFilename(file string) error {
+	if file == "" || strings.HasPrefix(file, ".") ||
+		strings.ContainsAny(file, "/\\") {
+		return group.UserError("bad file name")
+	}
+	ext := strings.ToLower(filepath.Ext(file))
+	if ext != ".webm" && ext != ".mkv" {
+		return group.UserError("unsupported file type")
+	}
+	return nil
+}
+
+// New creates a player for the given file in the recordings directory
+// of group g.  The caller should then add it to the group and call
+// Start.
+func New(g *group.Group, file string) (*Client, error) {
+	err := checkFilename(file)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := os.OpenRoot(filepath.Join(diskwriter.Directory, g.Name()))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		group:   g,
+		id:      newId(),
+		file:    file,
+		root:    root,
+		tracks:  make(map[uint64]*upTrack),
+		actions: make(chan action),
+		done:    make(chan struct{}),
+	}
+
+	f, r, err := c.open()
+	if err != nil {
+		root.Close()
+		return nil, err
+	}
+	f.Close()
+	c.duration = r.duration
+
+	c.up = newUpConn(c, time.Now())
+	for _, entry := range r.tracks {
+		codec, name, ok := codecFromId(entry.CodecID)
+		if !ok || !g.CodecEnabled(name) {
+			log.Printf("Playback of %v: skipping track %v (%v)",
+				file, entry.TrackNumber, entry.CodecID)
+			continue
+		}
+		t, err := c.up.addTrack(codec)
+		if err != nil {
+			root.Close()
+			return nil, err
+		}
+		c.tracks[entry.TrackNumber] = t
+	}
+	if len(c.tracks) == 0 {
+		root.Close()
+		return nil, group.UserError("no playable tracks in file")
+	}
+	return c, nil
+}
+
+// open opens the file and reads its headers.
+func (c *Client) open() (*os.File, *webmReader, error) {
+	f, err := c.root.Open(c.file)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := newWebmReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, r, nil
+}
+
+// seek opens the file and skips to the last video keyframe before
+// position.  It returns the first frame to be played.
+func (c *Client) seek(position time.Duration) (*os.File, *webmReader, frame, error) {
+	keyframe := position
+	video := false
+	for _, t := range c.tracks {
+		if t.kind == webrtc.RTPCodecTypeVideo {
+			video = true
+			keyframe = 0
+		}
+	}
+
+	if video {
+		f, r, err := c.open()
+		if err != nil {
+			return nil, nil, frame{}, err
+		}
+		for {
+			fr, err := r.next()
+			if err != nil {
+				f.Close()
+				if err == io.EOF {
+					err = group.UserError("position beyond end of file")
+				}
+				return nil, nil, frame{}, err
+			}
+			if fr.time > position {
+				break
+			}
+			t := c.tracks[fr.track]
+			if fr.keyframe &&
+				t != nil && t.kind == webrtc.RTPCodecTypeVideo {
+				keyframe = fr.time
+			}
+		}
+		f.Close()
+	}
+
+	f, r, err := c.open()
+	if err != nil {
+		return nil, nil, frame{}, err
+	}
+	for {
+		fr, err := r.next()
+		if err != nil {
+			f.Close()
+			if err == io.EOF {
+				err = group.UserError("position beyond end of file")
+			}
+			return nil, nil, frame{}, err
+		}
+		if fr.time >= keyframe {
+			return f, r, fr, nil
+		}
+	}
+}
+
+func (c *Client) Group() *group.Group {
+	return c.group
+}
+
+func (c *Client) Id() string {
+	return c.id
+}
+
+func (c *Client) Username() string {
+	return c.file
+}
+
+func (c *Client) Init(string, []string) {
+	return
+}
+
+func (c *Client) Permissions() []string {
+	return []string{"system"}
+}
+
+func (c *Client) Data() map[string]interface{} {
+	return nil
+}
+
+func (c *Client) Addr() net.Addr {
+	return nil
+}
+
+func (c *Client) Joined(group, kind string) error {
+	return nil
+}
+
+func (c *Client) PushClient(group, kind, id, username string, perms []string, data map[string]interface{}) error {
+	return nil
+}
+
+func (c *Client) PushConn(g *group.Group, id string, up conn.Up, tracks []conn.UpTrack, replace string) error {
+	return nil
+}
+
+// RequestConns pushes the stream being played.
+func (c *Client) RequestConns(target group.Client, g *group.Group, id string) error {
+	if g != c.group {
+		return nil
+	}
+
+	c.mu.Lock()
+	started := c.started && !c.closed
+	c.mu.Unlock()
+
+	if !started || (id != "" && id != c.up.id) {
+		return nil
+	}
+	return target.PushConn(g, c.up.id, c.up, c.up.getTracks(), "")
+}
+
+func (c *Client) Kick(id string, user *string, message string) error {
+	c.Stop()
+	return nil
+}
+
+// Start starts playback at the given position, and pushes the stream to
+// all the clients in the group.
+func (c *Client) Start(position time.Duration) error {
+	var f *os.File
+	var r *webmReader
+	var fr frame
+	var err error
+	if position > 0 {
+		f, r, fr, err = c.seek(position)
+	} else {
+		f, r, err = c.open()
+		if err == nil {
+			fr, err = r.next()
+			if err != nil {
+				f.Close()
+				if err == io.EOF {
+					err = group.UserError("file is empty")
+				}
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	if c.closed || c.started {
+		c.mu.Unlock()
+		f.Close()
+		return ErrClosed
+	}
+	c.started = true
+	c.position = fr.time
+	c.mu.Unlock()
+
+	for _, cc := range c.group.GetClients(c) {
+		cc.PushConn(c.group, c.up.id, c.up, c.up.getTracks(), "")
+	}
+
+	go c.loop(f, r, fr)
+	return nil
+}
+
+// loop plays the file, starting with frame fr.
+func (c *Client) loop(f *os.File, r *webmReader, fr frame) {
+	defer func() {
+		f.Close()
+	}()
+
+	// the time at which the start of the file would have been played
+	start := time.Now().Add(-fr.time)
+	paused := false
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		var wait <-chan time.Time
+		if !paused {
+			d := time.Until(start.Add(fr.time))
+			if d <= 0 {
+				t := c.tracks[fr.track]
+				if t != nil {
+					ts := rtptime.FromDuration(
+						start.Add(fr.time).Sub(c.up.epoch),
+						t.codec.ClockRate,
+					)
+					err := t.writeFrame(uint32(ts), fr.data)
+					if err != nil &&
+						err != conn.ErrConnectionClosed {
+						log.Printf("Playback of %v: %v",
+							c.file, err)
+					}
+				}
+				var err error
+				fr, err = r.next()
+				if err != nil {
+					if err != io.EOF {
+						log.Printf("Playback of %v: %v",
+							c.file, err)
+					}
+					c.Stop()
+					return
+				}
+				c.setPosition(fr.time)
+				continue
+			}
+			timer.Reset(d)
+			wait = timer.C
+		}
+
+		select {
+		case <-wait:
+		case a := <-c.actions:
+			var err error
+			switch a.kind {
+			case "pause":
+				paused = true
+			case "resume":
+				if paused {
+					start = time.Now().Add(-fr.time)
+					paused = false
+				}
+			case "seek":
+				var ff *os.File
+				var rr *webmReader
+				var ffr frame
+				ff, rr, ffr, err = c.seek(a.position)
+				if err == nil {
+					f.Close()
+					f, r, fr = ff, rr, ffr
+					start = time.Now().Add(-fr.time)
+					c.setPosition(fr.time)
+				}
+			default:
+				err = errors.New("unknown action")
+			}
+			c.mu.Lock()
+			c.paused = paused
+			c.mu.Unlock()
+			a.result <- err
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) setPosition(position time.Duration) {
+	c.mu.Lock()
+	c.position = position
+	c.mu.Unlock()
+}
+
+func (c *Client) do(kind string, position time.Duration) error {
+	a := action{
+		kind:     kind,
+		position: position,
+		result:   make(chan error, 1),
+	}
+	select {
+	case c.actions <- a:
+	case <-c.done:
+		return ErrClosed
+	}
+	select {
+	case err := <-a.result:
+		return err
+	case <-c.done:
+		return ErrClosed
+	}
+}
+
+// Pause suspends playback.
+func (c *Client) Pause() error {
+	return c.do("pause", 0)
+}
+
+// Resume resumes playback after a call to Pause.
+func (c *Client) Resume() error {
+	return c.do("resume", 0)
+}
+
+// Seek continues playback from the last keyframe before position.
+func (c *Client) Seek(position time.Duration) error {
+	if position < 0 {
+		return group.UserError("negative position")
+	}
+	return c.do("seek", position)
+}
+
+// Status returns the current state of the player.
+func (c *Client) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := StatePlaying
+	if c.paused {
+		state = StatePaused
+	}
+	return Status{
+		File:     c.file,
+		State:    state,
+		Position: c.position.Seconds(),
+		Duration: c.duration.Seconds(),
+	}
+}
+
+// Close stops playback, and withdraws the stream from all clients.
+func (c *Client) Close() error {
+	c.close()
+	return nil
+}
+
+func (c *Client) close() bool {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return false
+	}
+	c.closed = true
+	close(c.done)
+	started := c.started
+	c.mu.Unlock()
+
+	if started {
+		for _, cc := range c.group.GetClients(c) {
+			cc.PushConn(c.group, c.up.id, nil, nil, "")
+		}
+	}
+	c.up.close()
+	c.root.Close()
+	return true
+}
+
+// Stop stops playback and removes the player from its group.
+func (c *Client) Stop() {
+	if c.close() {
+		group.DelClient(c)
+	}
+}
@@ -0,0 +1,360 @@
+package player
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/at-wat/ebml-go/webm"
+	"github.com/pion/rtp"
+
+	"github.com/jech/galene/diskwriter"
+	"github.com/jech/galene/group"
+)
+
+func TestReadVint(t *testing.T) {
+	tests := []struct {
+		data       []byte
+		keepMarker bool
+		value      uint64
+	}{
+		{[]byte{0x81}, false, 1},
+		{[]byte{0x81}, true, 0x81},
+		{[]byte{0x40, 0x02}, false, 2},
+		{[]byte{0x1A, 0x45, 0xDF, 0xA3}, true, 0x1A45DFA3},
+		{[]byte{0x10, 0x00, 0x01, 0x00}, false, 256},
+		{[]byte{0xFF}, false, unknownSize},
+		{[]byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF},
+			false, unknownSize},
+	}
+	for _, test := range tests {
+		r := bufio.NewReader(bytes.NewReader(test.data))
+		v, raw, err := readVint(r, test.keepMarker)
+		if err != nil {
+			t.Errorf("readVint %v: %v", test.data, err)
+			continue
+		}
+		if v != test.value || !bytes.Equal(raw, test.data) {
+			t.Errorf("readVint %v: expected %v, got %v %v",
+				test.data, test.value, v, raw)
+		}
+	}
+
+	r := bufio.NewReader(bytes.NewReader([]byte{0x00}))
+	_, _, err := readVint(r, false)
+	if err == nil {
+		t.Errorf("readVint succeeded on a bad integer")
+	}
+}
+
+// writeTestFile writes a WebM file with three seconds of VP8 video at
+// 10 frames per second, with a keyframe every second, and Opus audio
+// with 20ms frames.
+func writeTestFile(t *testing.T, fn string) {
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	ws, err := webm.NewSimpleBlockWriter(f, []webm.TrackEntry{
+		{
+			Name:        "Video",
+			TrackNumber: 1,
+			TrackUID:    1,
+			CodecID:     "V_VP8",
+			TrackType:   1,
+			Video: &webm.Video{
+				PixelWidth:  320,
+				PixelHeight: 240,
+			},
+		},
+		{
+			Name:        "Audio",
+			TrackNumber: 2,
+			TrackUID:    2,
+			CodecID:     "A_OPUS",
+			TrackType:   2,
+			Audio: &webm.Audio{
+				SamplingFrequency: 48000,
+				Channels:          2,
+			},
+		},
+		{
+			Name:        "Other",
+			TrackNumber: 3,
+			TrackUID:    3,
+			CodecID:     "V_MPEGH/ISO/HEVC",
+			TrackType:   1,
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewSimpleBlockWriter: %v", err)
+	}
+
+	for ms := int64(0); ms < 3000; ms += 20 {
+		if ms%100 == 0 {
+			keyframe := ms%1000 == 0
+			data := []byte{0x01, 0x02, 0x03, byte(ms / 100)}
+			if keyframe {
+				data[0] = 0x10
+			}
+			_, err := ws[0].Write(keyframe, ms, data)
+			if err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			_, err = ws[2].Write(true, ms, []byte{0})
+			if err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+		}
+		_, err := ws[1].Write(true, ms, []byte{0xFC, byte(ms / 20)})
+		if err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	for _, w := range ws {
+		w.Close()
+	}
+}
+
+func TestWebmReader(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "test.webm")
+	writeTestFile(t, fn)
+
+	f, err := os.Open(fn)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	r, err := newWebmReader(f)
+	if err != nil {
+		t.Fatalf("newWebmReader: %v", err)
+	}
+	if len(r.tracks) != 3 || r.tracks[0].CodecID != "V_VP8" ||
+		r.tracks[1].CodecID != "A_OPUS" {
+		t.Fatalf("Bad tracks %v", r.tracks)
+	}
+
+	var video, audio, keyframes int
+	var last time.Duration
+	for {
+		fr, err := r.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		if fr.time < last {
+			t.Errorf("Time went backwards: %v < %v", fr.time, last)
+		}
+		last = fr.time
+		switch fr.track {
+		case 1:
+			video++
+			if fr.keyframe {
+				keyframes++
+				if fr.time%time.Second != 0 {
+					t.Errorf("Keyframe at %v", fr.time)
+				}
+			}
+			if fr.data[3] != byte(fr.time/(100*time.Millisecond)) {
+				t.Errorf("Bad data %v at %v", fr.data, fr.time)
+			}
+		case 2:
+			audio++
+			if fr.data[1] != byte(fr.time/(20*time.Millisecond)) {
+				t.Errorf("Bad data %v at %v", fr.data, fr.time)
+			}
+		}
+	}
+	if video != 30 || audio != 150 || keyframes != 3 {
+		t.Errorf("Got %v video frames (%v keyframes), %v audio frames",
+			video, keyframes, audio)
+	}
+
+	_, err = newWebmReader(bytes.NewReader([]byte("not a webm file")))
+	if err == nil {
+		t.Errorf("newWebmReader succeeded on garbage")
+	}
+}
+
+func newTestPlayer(t *testing.T) *Client {
+	diskwriter.Directory = t.TempDir()
+	err := os.Mkdir(filepath.Join(diskwriter.Directory, "test"), 0700)
+	if err != nil {
+		t.Fatalf("Mkdir: %v", err)
+	}
+	writeTestFile(t,
+		filepath.Join(diskwriter.Directory, "test", "test.webm"),
+	)
+
+	g, err := group.Add("test", &group.Description{})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	c, err := New(g, "test.webm")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return c
+}
+
+func TestNew(t *testing.T) {
+	c := newTestPlayer(t)
+	defer c.Close()
+
+	// the HEVC track is skipped
+	if len(c.tracks) != 2 || len(c.up.getTracks()) != 2 {
+		t.Errorf("Expected 2 tracks, got %v", len(c.tracks))
+	}
+
+	for _, file := range []string{
+		"", ".webm", "../test/test.webm", "test.txt", "missing.webm",
+	} {
+		_, err := New(c.group, file)
+		if err == nil {
+			t.Errorf("New %#v succeeded", file)
+		}
+	}
+}
+
+func TestSeek(t *testing.T) {
+	c := newTestPlayer(t)
+	defer c.Close()
+
+	tests := []struct {
+		position, expected time.Duration
+	}{
+		{0, 0},
+		{500 * time.Millisecond, 0},
+		{time.Second, time.Second},
+		{1500 * time.Millisecond, time.Second},
+		{2900 * time.Millisecond, 2 * time.Second},
+	}
+	for _, test := range tests {
+		f, _, fr, err := c.seek(test.position)
+		if err != nil {
+			t.Errorf("Seek %v: %v", test.position, err)
+			continue
+		}
+		f.Close()
+		if fr.time != test.expected {
+			t.Errorf("Seek %v: expected %v, got %v",
+				test.position, test.expected, fr.time)
+		}
+	}
+
+	_, _, _, err := c.seek(10 * time.Second)
+	if err == nil {
+		t.Errorf("Seek beyond end of file succeeded")
+	}
+}
+
+type testDownTrack struct {
+	mu      sync.Mutex
+	packets []rtp.Packet
+}
+
+func (t *testDownTrack) Write(buf []byte) (int, error) {
+	var p rtp.Packet
+	err := p.Unmarshal(append([]byte(nil), buf...))
+	if err != nil {
+		return 0, err
+	}
+	t.mu.Lock()
+	t.packets = append(t.packets, p)
+	t.mu.Unlock()
+	return len(buf), nil
+}
+
+func (t *testDownTrack) SetTimeOffset(ntp uint64, rtp uint32) {
+}
+
+func (t *testDownTrack) SetCname(string) {
+}
+
+func (t *testDownTrack) GetMaxBitrate() (uint64, int, int) {
+	return ^uint64(0), -1, -1
+}
+
+func (t *testDownTrack) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.packets)
+}
+
+func TestPlayback(t *testing.T) {
+	c := newTestPlayer(t)
+	defer c.Close()
+
+	var down testDownTrack
+	c.tracks[1].AddLocal(&down)
+
+	err := c.Start(2500 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	err = c.Pause()
+	if err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	status := c.Status()
+	if status.State != StatePaused || status.Position < 2 {
+		t.Errorf("Bad status %v", status)
+	}
+
+	n := down.count()
+	if n == 0 {
+		t.Fatalf("No packets received")
+	}
+	time.Sleep(150 * time.Millisecond)
+	if down.count() != n {
+		t.Errorf("Packets received while paused")
+	}
+
+	down.mu.Lock()
+	first := down.packets[0]
+	down.mu.Unlock()
+	if first.PayloadType != 96 || first.Payload[len(first.Payload)-4] != 0x10 {
+		t.Errorf("Expected keyframe, got %v", first)
+	}
+
+	err = c.Seek(2900 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	status = c.Status()
+	if status.Position != 2 {
+		t.Errorf("Bad position after seek: %v", status.Position)
+	}
+
+	err = c.Resume()
+	if err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if down.count() == n {
+		t.Errorf("No packets received after resume")
+	}
+
+	down.mu.Lock()
+	for i := 1; i < len(down.packets); i++ {
+		if int32(down.packets[i].Timestamp-
+			down.packets[i-1].Timestamp) < 0 {
+			t.Errorf("Timestamp went backwards at packet %v", i)
+		}
+	}
+	down.mu.Unlock()
+
+	c.Close()
+	if c.Pause() != ErrClosed {
+		t.Errorf("Pause succeeded after close")
+	}
+}
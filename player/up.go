@@ -0,0 +1,296 @@
+package player
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v4"
+
+	gcodecs "github.com/jech/galene/codecs"
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/packetcache"
+	"github.com/jech/galene/rtptime"
+)
+
+// These must match the codecs negotiated by the group, see
+// group.APIFromNames.
+var (
+	vp8Codec = webrtc.RTPCodecCapability{
+		MimeType:     "video/VP8",
+		ClockRate:    90000,
+		RTCPFeedback: group.VideoRTCPFeedback,
+	}
+	vp9Codec = webrtc.RTPCodecCapability{
+		MimeType:     "video/VP9",
+		ClockRate:    90000,
+		SDPFmtpLine:  "profile-id=0",
+		RTCPFeedback: group.VideoRTCPFeedback,
+	}
+	opusCodec = webrtc.RTPCodecCapability{
+		MimeType:     "audio/opus",
+		ClockRate:    48000,
+		Channels:     2,
+		SDPFmtpLine:  "minptime=10;useinbandfec=1;stereo=1;sprop-stereo=1",
+		RTCPFeedback: group.AudioRTCPFeedback,
+	}
+)
+
+// codecFromId returns the RTP codec corresponding to a Matroska codec id,
+// together with the name of the codec as used in the group description.
+func codecFromId(id string) (webrtc.RTPCodecCapability, string, bool) {
+	switch id {
+	case "V_VP8":
+		return vp8Codec, "vp8", true
+	case "V_VP9":
+		return vp9Codec, "vp9", true
+	case "A_OPUS":
+		return opusCodec, "opus", true
+	default:
+		return webrtc.RTPCodecCapability{}, "", false
+	}
+}
+
+// the maximum size of the payload of an RTP packet
+const mtu = 1200
+
+// An upConn is a stream played from a file.  It implements conn.Up.
+type upConn struct {
+	id     string
+	client *Client
+	// the time corresponding to an RTP timestamp equal to each track's base
+	epoch time.Time
+
+	mu     sync.Mutex
+	local  []conn.Down
+	tracks []*upTrack
+}
+
+func newUpConn(c *Client, epoch time.Time) *upConn {
+	return &upConn{
+		id:     newId(),
+		client: c,
+		epoch:  epoch,
+	}
+}
+
+func (up *upConn) Id() string {
+	return up.id
+}
+
+func (up *upConn) Label() string {
+	return ""
+}
+
+func (up *upConn) User() (string, string) {
+	return up.client.Id(), up.client.Username()
+}
+
+func (up *upConn) AddLocal(local conn.Down) error {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	for _, l := range up.local {
+		if l == local {
+			return nil
+		}
+	}
+	up.local = append(up.local, local)
+	return nil
+}
+
+func (up *upConn) DelLocal(local conn.Down) bool {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	for i, l := range up.local {
+		if l == local {
+			up.local = append(up.local[:i], up.local[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (up *upConn) getTracks() []conn.UpTrack {
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	ts := make([]conn.UpTrack, len(up.tracks))
+	for i, t := range up.tracks {
+		ts[i] = t
+	}
+	return ts
+}
+
+// addTrack creates a new track with the given codec.
+func (up *upConn) addTrack(codec webrtc.RTPCodecCapability) (*upTrack, error) {
+	ptype, err := group.CodecPayloadType(codec)
+	if err != nil {
+		return nil, err
+	}
+	kind := webrtc.RTPCodecTypeVideo
+	var payloader rtp.Payloader
+	switch codec.MimeType {
+	case vp8Codec.MimeType:
+		payloader = &codecs.VP8Payloader{EnablePictureID: true}
+	case vp9Codec.MimeType:
+		payloader = &codecs.VP9Payloader{}
+	default:
+		kind = webrtc.RTPCodecTypeAudio
+		payloader = &codecs.OpusPayloader{}
+	}
+	t := &upTrack{
+		conn:      up,
+		kind:      kind,
+		codec:     codec,
+		ptype:     uint8(ptype),
+		ssrc:      rand.Uint32(),
+		base:      rand.Uint32(),
+		cache:     packetcache.New(64),
+		payloader: payloader,
+		seqno:     uint16(rand.Uint32()),
+	}
+	if kind == webrtc.RTPCodecTypeVideo {
+		t.cache = packetcache.New(256)
+	}
+
+	up.mu.Lock()
+	up.tracks = append(up.tracks, t)
+	up.mu.Unlock()
+	return t, nil
+}
+
+func (up *upConn) close() {
+	up.mu.Lock()
+	tracks := up.tracks
+	up.mu.Unlock()
+	for _, t := range tracks {
+		t.close()
+	}
+}
+
+// An upTrack is a track of a stream played from a file.  It implements
+// conn.UpTrack.
+type upTrack struct {
+	conn      *upConn
+	kind      webrtc.RTPCodecType
+	codec     webrtc.RTPCodecCapability
+	ptype     uint8
+	ssrc      uint32
+	base      uint32
+	cache     *packetcache.Cache
+	payloader rtp.Payloader
+
+	mu     sync.Mutex
+	closed bool
+	seqno  uint16
+	local  []conn.DownTrack
+}
+
+func (t *upTrack) AddLocal(local conn.DownTrack) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return conn.ErrConnectionClosed
+	}
+	for _, l := range t.local {
+		if l == local {
+			return nil
+		}
+	}
+	local.SetTimeOffset(rtptime.TimeToNTP(t.conn.epoch), t.base)
+	t.local = append(t.local, local)
+	return nil
+}
+
+func (t *upTrack) DelLocal(local conn.DownTrack) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, l := range t.local {
+		if l == local {
+			t.local = append(t.local[:i], t.local[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *upTrack) Kind() webrtc.RTPCodecType {
+	return t.kind
+}
+
+func (t *upTrack) Label() string {
+	return ""
+}
+
+func (t *upTrack) Codec() webrtc.RTPCodecCapability {
+	return t.codec
+}
+
+func (t *upTrack) GetPacket(seqno uint16, result []byte, nack bool) uint16 {
+	return t.cache.Get(seqno, result)
+}
+
+// RequestKeyframe does nothing, keyframes are sent whenever they occur
+// in the file.
+func (t *upTrack) RequestKeyframe() error {
+	return nil
+}
+
+// writeFrame packetises a frame and sends it to all local tracks.  The
+// timestamp is relative to the epoch of the connection, in units of the
+// track's clock rate.
+func (t *upTrack) writeFrame(timestamp uint32, frame []byte) error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return conn.ErrConnectionClosed
+	}
+
+	payloads := t.payloader.Payload(mtu, frame)
+	packets := make([][]byte, 0, len(payloads))
+	for i, payload := range payloads {
+		p := rtp.Packet{
+			Header: rtp.Header{
+				Version:        2,
+				PayloadType:    t.ptype,
+				SequenceNumber: t.seqno,
+				Timestamp:      t.base + timestamp,
+				SSRC:           t.ssrc,
+				Marker: t.kind == webrtc.RTPCodecTypeVideo &&
+					i == len(payloads)-1,
+			},
+			Payload: payload,
+		}
+		packet, err := p.Marshal()
+		if err != nil {
+			t.mu.Unlock()
+			return err
+		}
+		kf, _ := gcodecs.Keyframe(t.codec.MimeType, &p)
+		t.cache.Store(t.seqno, p.Timestamp, kf, p.Marker, packet)
+		packets = append(packets, packet)
+		t.seqno++
+	}
+	local := make([]conn.DownTrack, len(t.local))
+	copy(local, t.local)
+	t.mu.Unlock()
+
+	for _, packet := range packets {
+		for _, l := range local {
+			_, err := l.Write(packet)
+			if err != nil && err != conn.ErrConnectionClosed {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *upTrack) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	t.local = nil
+}
@@ -0,0 +1,326 @@
+package player
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/at-wat/ebml-go"
+	"github.com/at-wat/ebml-go/webm"
+)
+
+// element ids
+const (
+	idSegment        = 0x18538067
+	idInfo           = 0x1549A966
+	idTracks         = 0x1654AE6B
+	idCluster        = 0x1F43B675
+	idTimecode       = 0xE7
+	idSimpleBlock    = 0xA3
+	idBlockGroup     = 0xA0
+	idBlock          = 0xA1
+	idReferenceBlock = 0xFB
+)
+
+// the size of an element of unknown size
+const unknownSize = ^uint64(0)
+
+// the largest element that we read into memory
+const maxElementSize = 64 * 1024 * 1024
+
+// A frame is a single frame read from a WebM file.
+type frame struct {
+	track    uint64
+	time     time.Duration
+	keyframe bool
+	data     []byte
+}
+
+// A webmReader reads the frames of a WebM or Matroska file sequentially,
+// without loading the whole file into memory.
+type webmReader struct {
+	r *bufio.Reader
+	// the timecode scale, in nanoseconds
+	scale    uint64
+	duration time.Duration
+	tracks   []webm.TrackEntry
+	// the timecode of the current cluster
+	cluster uint64
+	// frames read but not yet returned, in the case of lacing
+	pending []frame
+}
+
+// readVint reads a variable-length integer.  If keepMarker is true, the
+// length marker is kept, as is customary for element ids.  It returns
+// the value and the raw bytes.
+func readVint(r *bufio.Reader, keepMarker bool) (uint64, []byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length := 1
+	for mask := byte(0x80); mask != 0 && b&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, nil, errors.New("bad EBML variable-length integer")
+	}
+	raw := make([]byte, length)
+	raw[0] = b
+	_, err = io.ReadFull(r, raw[1:])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	v := uint64(b)
+	if !keepMarker {
+		v &= 0xFF >> length
+	}
+	allOnes := v == 0xFF>>length
+	for _, c := range raw[1:] {
+		v = v<<8 | uint64(c)
+		allOnes = allOnes && c == 0xFF
+	}
+	if !keepMarker && allOnes {
+		return unknownSize, raw, nil
+	}
+	return v, raw, nil
+}
+
+// readHeader reads the header of an element, and returns its id, its
+// size and the raw header.
+func readHeader(r *bufio.Reader) (uint64, uint64, []byte, error) {
+	id, rawId, err := readVint(r, true)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	size, rawSize, err := readVint(r, false)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, 0, nil, err
+	}
+	return id, size, append(rawId, rawSize...), nil
+}
+
+func readElement(r *bufio.Reader, size uint64) ([]byte, error) {
+	if size == unknownSize || size > maxElementSize {
+		return nil, errors.New("EBML element too large")
+	}
+	buf := make([]byte, size)
+	_, err := io.ReadFull(r, buf)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf, err
+}
+
+func readUint(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+// newWebmReader reads the headers of a file, up to the first cluster.
+func newWebmReader(r io.Reader) (*webmReader, error) {
+	wr := &webmReader{
+		r:     bufio.NewReader(r),
+		scale: 1000000,
+	}
+
+	var header struct {
+		EBML webm.EBMLHeader `ebml:"EBML"`
+	}
+	id, size, raw, err := readHeader(wr.r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readElement(wr.r, size)
+	if err != nil {
+		return nil, err
+	}
+	err = ebml.Unmarshal(
+		bytes.NewReader(append(raw, data...)), &header,
+		ebml.WithIgnoreUnknown(true),
+	)
+	if err != nil || id != 0x1A45DFA3 {
+		return nil, errors.New("not an EBML file")
+	}
+	if header.EBML.DocType != "webm" && header.EBML.DocType != "matroska" {
+		return nil, errors.New("unsupported document type " +
+			header.EBML.DocType)
+	}
+
+	for {
+		id, size, raw, err := readHeader(wr.r)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch id {
+		case idSegment:
+			// descend
+		case idInfo:
+			data, err := readElement(wr.r, size)
+			if err != nil {
+				return nil, err
+			}
+			var info struct {
+				Info webm.Info `ebml:"Info"`
+			}
+			err = ebml.Unmarshal(
+				bytes.NewReader(append(raw, data...)), &info,
+				ebml.WithIgnoreUnknown(true),
+			)
+			if err != nil {
+				return nil, err
+			}
+			if info.Info.TimecodeScale > 0 {
+				wr.scale = info.Info.TimecodeScale
+			}
+			wr.duration = time.Duration(
+				info.Info.Duration * float64(wr.scale),
+			)
+		case idTracks:
+			data, err := readElement(wr.r, size)
+			if err != nil {
+				return nil, err
+			}
+			var tracks struct {
+				Tracks webm.Tracks `ebml:"Tracks"`
+			}
+			err = ebml.Unmarshal(
+				bytes.NewReader(append(raw, data...)), &tracks,
+				ebml.WithIgnoreUnknown(true),
+			)
+			if err != nil {
+				return nil, err
+			}
+			wr.tracks = tracks.Tracks.TrackEntry
+		case idCluster:
+			if wr.tracks == nil {
+				return nil, errors.New("no tracks in file")
+			}
+			return wr, nil
+		default:
+			_, err := readElement(wr.r, size)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (wr *webmReader) blockFrames(block *ebml.Block, keyframe bool) {
+	t := time.Duration(
+		int64(wr.cluster)+int64(block.Timecode),
+	) * time.Duration(wr.scale)
+	for i, data := range block.Data {
+		// laced frames carry no timestamps; lacing is only used
+		// for audio in practice, assume 20ms frames.
+		wr.pending = append(wr.pending, frame{
+			track:    block.TrackNumber,
+			time:     t + time.Duration(i)*20*time.Millisecond,
+			keyframe: keyframe,
+			data:     data,
+		})
+	}
+}
+
+// next returns the next frame in the file, or io.EOF.
+func (wr *webmReader) next() (frame, error) {
+	for len(wr.pending) == 0 {
+		id, size, _, err := readHeader(wr.r)
+		if err != nil {
+			return frame{}, err
+		}
+		switch id {
+		case idSegment, idCluster:
+			// descend
+		case idTimecode:
+			data, err := readElement(wr.r, size)
+			if err != nil {
+				return frame{}, err
+			}
+			wr.cluster = readUint(data)
+		case idSimpleBlock:
+			if size == unknownSize {
+				return frame{}, errors.New("block of unknown size")
+			}
+			block, err := ebml.UnmarshalBlock(
+				io.LimitReader(wr.r, int64(size)), int64(size),
+			)
+			if err != nil {
+				return frame{}, err
+			}
+			wr.blockFrames(block, block.Keyframe)
+		case idBlockGroup:
+			data, err := readElement(wr.r, size)
+			if err != nil {
+				return frame{}, err
+			}
+			err = wr.blockGroup(data)
+			if err != nil {
+				return frame{}, err
+			}
+		default:
+			_, err := wr.r.Discard(int(min(size, maxElementSize)))
+			if err != nil {
+				return frame{}, err
+			}
+			if size > maxElementSize {
+				return frame{}, errors.New("EBML element too large")
+			}
+		}
+	}
+	f := wr.pending[0]
+	wr.pending = wr.pending[1:]
+	return f, nil
+}
+
+// blockGroup parses the contents of a BlockGroup element.  A block is a
+// keyframe if it doesn't reference any other block.
+func (wr *webmReader) blockGroup(data []byte) error {
+	r := bufio.NewReader(bytes.NewReader(data))
+	var block *ebml.Block
+	keyframe := true
+	for {
+		id, size, _, err := readHeader(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		switch id {
+		case idBlock:
+			if size == unknownSize {
+				return errors.New("block of unknown size")
+			}
+			block, err = ebml.UnmarshalBlock(
+				io.LimitReader(r, int64(size)), int64(size),
+			)
+			if err != nil {
+				return err
+			}
+		case idReferenceBlock:
+			keyframe = false
+			fallthrough
+		default:
+			_, err := readElement(r, size)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if block != nil {
+		wr.blockFrames(block, keyframe)
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// AMF0 markers
+const (
+	amfNumber      = 0x00
+	amfBoolean     = 0x01
+	amfString      = 0x02
+	amfObject      = 0x03
+	amfNull        = 0x05
+	amfUndefined   = 0x06
+	amfECMAArray   = 0x08
+	amfObjectEnd   = 0x09
+	amfStrictArray = 0x0A
+	amfLongString  = 0x0C
+)
+
+var errAMFTruncated = errors.New("truncated AMF value")
+
+// amfDecode decodes a sequence of AMF0 values.  Numbers are decoded as
+// float64, objects and ECMA arrays as map[string]any, strict arrays as
+// []any, and null and undefined as nil.
+func amfDecode(data []byte) ([]any, error) {
+	var values []any
+	for len(data) > 0 {
+		v, n, err := amfDecodeValue(data)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, v)
+		data = data[n:]
+	}
+	return values, nil
+}
+
+func amfDecodeString(data []byte) (string, int, error) {
+	if len(data) < 2 {
+		return "", 0, errAMFTruncated
+	}
+	l := int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+l {
+		return "", 0, errAMFTruncated
+	}
+	return string(data[2 : 2+l]), 2 + l, nil
+}
+
+// amfDecodeProperties decodes the properties of an object, up to and
+// including the object end marker.
+func amfDecodeProperties(data []byte) (map[string]any, int, error) {
+	m := make(map[string]any)
+	i := 0
+	for {
+		k, n, err := amfDecodeString(data[i:])
+		if err != nil {
+			return nil, 0, err
+		}
+		i += n
+		if k == "" {
+			if i >= len(data) {
+				return nil, 0, errAMFTruncated
+			}
+			if data[i] != amfObjectEnd {
+				return nil, 0, errors.New("missing AMF object end")
+			}
+			return m, i + 1, nil
+		}
+		v, n, err := amfDecodeValue(data[i:])
+		if err != nil {
+			return nil, 0, err
+		}
+		i += n
+		m[k] = v
+	}
+}
+
+func amfDecodeValue(data []byte) (any, int, error) {
+	if len(data) < 1 {
+		return nil, 0, errAMFTruncated
+	}
+	switch data[0] {
+	case amfNumber:
+		if len(data) < 9 {
+			return nil, 0, errAMFTruncated
+		}
+		v := math.Float64frombits(binary.BigEndian.Uint64(data[1:]))
+		return v, 9, nil
+	case amfBoolean:
+		if len(data) < 2 {
+			return nil, 0, errAMFTruncated
+		}
+		return data[1] != 0, 2, nil
+	case amfString:
+		s, n, err := amfDecodeString(data[1:])
+		return s, 1 + n, err
+	case amfLongString:
+		if len(data) < 5 {
+			return nil, 0, errAMFTruncated
+		}
+		l := binary.BigEndian.Uint32(data[1:])
+		if uint64(len(data)) < 5+uint64(l) {
+			return nil, 0, errAMFTruncated
+		}
+		return string(data[5 : 5+l]), 5 + int(l), nil
+	case amfObject:
+		m, n, err := amfDecodeProperties(data[1:])
+		return m, 1 + n, err
+	case amfECMAArray:
+		if len(data) < 5 {
+			return nil, 0, errAMFTruncated
+		}
+		m, n, err := amfDecodeProperties(data[5:])
+		return m, 5 + n, err
+	case amfStrictArray:
+		if len(data) < 5 {
+			return nil, 0, errAMFTruncated
+		}
+		l := int(binary.BigEndian.Uint32(data[1:]))
+		i := 5
+		var a []any
+		for j := 0; j < l; j++ {
+			v, n, err := amfDecodeValue(data[i:])
+			if err != nil {
+				return nil, 0, err
+			}
+			i += n
+			a = append(a, v)
+		}
+		return a, i, nil
+	case amfNull, amfUndefined:
+		return nil, 1, nil
+	default:
+		return nil, 0, fmt.Errorf("unsupported AMF marker %v", data[0])
+	}
+}
+
+// amfEncode encodes a sequence of values in AMF0.  It is the inverse of
+// amfDecode, except that integers are accepted and encoded as numbers.
+func amfEncode(values ...any) ([]byte, error) {
+	var buf []byte
+	var err error
+	for _, v := range values {
+		buf, err = amfAppendValue(buf, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func amfAppendString(buf []byte, s string) []byte {
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(s)))
+	return append(buf, s...)
+}
+
+func amfAppendValue(buf []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, amfNull), nil
+	case float64:
+		buf = append(buf, amfNumber)
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(v)), nil
+	case int:
+		return amfAppendValue(buf, float64(v))
+	case bool:
+		b := byte(0)
+		if v {
+			b = 1
+		}
+		return append(buf, amfBoolean, b), nil
+	case string:
+		if len(v) > 0xFFFF {
+			buf = append(buf, amfLongString)
+			buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+			return append(buf, v...), nil
+		}
+		buf = append(buf, amfString)
+		return amfAppendString(buf, v), nil
+	case map[string]any:
+		buf = append(buf, amfObject)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		var err error
+		for _, k := range keys {
+			buf = amfAppendString(buf, k)
+			buf, err = amfAppendValue(buf, v[k])
+			if err != nil {
+				return nil, err
+			}
+		}
+		buf = amfAppendString(buf, "")
+		return append(buf, amfObjectEnd), nil
+	case []any:
+		buf = append(buf, amfStrictArray)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(v)))
+		var err error
+		for _, w := range v {
+			buf, err = amfAppendValue(buf, w)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	default:
+		return nil, fmt.Errorf("cannot encode %T in AMF", v)
+	}
+}
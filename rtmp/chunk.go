@@ -0,0 +1,312 @@
+package rtmp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// message types
+const (
+	msgSetChunkSize     = 1
+	msgAbort            = 2
+	msgAck              = 3
+	msgUserControl      = 4
+	msgWindowAckSize    = 5
+	msgSetPeerBandwidth = 6
+	msgAudio            = 8
+	msgVideo            = 9
+	msgDataAMF3         = 15
+	msgCommandAMF3      = 17
+	msgDataAMF0         = 18
+	msgCommandAMF0      = 20
+)
+
+// the chunk size used before a Set Chunk Size message is received
+const defaultChunkSize = 128
+
+// the largest chunk size that we accept
+const maxChunkSize = 1 << 24
+
+const (
+	// the largest message that we accept before the client has been
+	// authenticated, which is plenty for command messages
+	maxUnauthenticatedLength = 1 << 20
+	// the largest message that we accept from a publisher
+	maxMessageLength = 8 << 20
+	// the maximum number of chunk streams in a session
+	maxChunkStreams = 64
+)
+
+// A message is a complete RTMP message.
+type message struct {
+	typ       uint8
+	stream    uint32
+	timestamp uint32
+	payload   []byte
+}
+
+// chunkStream is the state of the reader for a single chunk stream.
+type chunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	typ       uint8
+	stream    uint32
+	extended  bool
+	// the message being reassembled, nil if none
+	buf []byte
+}
+
+// countingReader counts the bytes read from the network, for the sake
+// of acknowledgments.
+type countingReader struct {
+	r     io.Reader
+	count uint64
+}
+
+func (r *countingReader) Read(buf []byte) (int, error) {
+	n, err := r.r.Read(buf)
+	r.count += uint64(n)
+	return n, err
+}
+
+// A chunkReader reassembles messages from a sequence of chunks.
+type chunkReader struct {
+	r         *bufio.Reader
+	chunkSize uint32
+	streams   map[uint32]*chunkStream
+	// the largest message that we accept
+	maxLength uint32
+	// the number of bytes in partial messages
+	buffered uint32
+}
+
+func newChunkReader(r *bufio.Reader) *chunkReader {
+	return &chunkReader{
+		r:         r,
+		chunkSize: defaultChunkSize,
+		streams:   make(map[uint32]*chunkStream),
+		maxLength: maxUnauthenticatedLength,
+	}
+}
+
+// setMaxLength sets the largest message that we accept.  The total size
+// of partial messages is limited to twice this value.
+func (cr *chunkReader) setMaxLength(length uint32) {
+	cr.maxLength = length
+}
+
+func (cr *chunkReader) setChunkSize(size uint32) error {
+	if size < 1 || size > maxChunkSize {
+		return errors.New("bad chunk size")
+	}
+	cr.chunkSize = size
+	return nil
+}
+
+// abort discards the partial message on chunk stream csid.
+func (cr *chunkReader) abort(csid uint32) {
+	if cs := cr.streams[csid]; cs != nil {
+		cr.buffered -= uint32(len(cs.buf))
+		cs.buf = nil
+	}
+}
+
+func (cr *chunkReader) readUint(n int) (uint32, error) {
+	var buf [4]byte
+	_, err := io.ReadFull(cr.r, buf[4-n:])
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(buf[:]), nil
+}
+
+// readMessage reads chunks until a message is complete.
+func (cr *chunkReader) readMessage() (*message, error) {
+	for {
+		m, err := cr.readChunk()
+		if err != nil || m != nil {
+			return m, err
+		}
+	}
+}
+
+// readChunk reads a single chunk, and returns a message if it is the
+// last chunk of a message.
+func (cr *chunkReader) readChunk() (*message, error) {
+	b, err := cr.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	format := b >> 6
+	csid := uint32(b & 0x3F)
+	switch csid {
+	case 0:
+		v, err := cr.readUint(1)
+		if err != nil {
+			return nil, err
+		}
+		csid = 64 + v
+	case 1:
+		v, err := cr.readUint(2)
+		if err != nil {
+			return nil, err
+		}
+		csid = 64 + (v >> 8) + ((v & 0xFF) << 8)
+	}
+
+	cs := cr.streams[csid]
+	if cs == nil {
+		if format != 0 {
+			return nil, errors.New("chunk stream doesn't start with full header")
+		}
+		if len(cr.streams) >= maxChunkStreams {
+			return nil, errors.New("too many chunk streams")
+		}
+		cs = &chunkStream{}
+		cr.streams[csid] = cs
+	}
+
+	var ts uint32
+	if format <= 2 {
+		ts, err = cr.readUint(3)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if format <= 1 {
+		cs.length, err = cr.readUint(3)
+		if err != nil {
+			return nil, err
+		}
+		typ, err := cr.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		cs.typ = typ
+	}
+	if format == 0 {
+		var buf [4]byte
+		_, err := io.ReadFull(cr.r, buf[:])
+		if err != nil {
+			return nil, err
+		}
+		cs.stream = binary.LittleEndian.Uint32(buf[:])
+	}
+	if format <= 2 {
+		cs.extended = ts == 0xFFFFFF
+	}
+	if cs.extended {
+		ts, err = cr.readUint(4)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cs.buf == nil {
+		switch format {
+		case 0:
+			cs.timestamp = ts
+			cs.delta = 0
+		case 1, 2:
+			cs.delta = ts
+			cs.timestamp += ts
+		case 3:
+			cs.timestamp += cs.delta
+		}
+		if cs.length > cr.maxLength {
+			return nil, errors.New("message too large")
+		}
+		cs.buf = make([]byte, 0, min(cs.length, cr.chunkSize))
+	} else if format != 3 {
+		return nil, errors.New("unexpected chunk header")
+	}
+
+	n := min(cs.length-uint32(len(cs.buf)), cr.chunkSize)
+	if cr.buffered+n > 2*cr.maxLength {
+		return nil, errors.New("too much data in partial messages")
+	}
+	cr.buffered += n
+	start := len(cs.buf)
+	cs.buf = append(cs.buf, make([]byte, n)...)
+	_, err = io.ReadFull(cr.r, cs.buf[start:])
+	if err != nil {
+		return nil, err
+	}
+
+	if uint32(len(cs.buf)) < cs.length {
+		return nil, nil
+	}
+	m := &message{
+		typ:       cs.typ,
+		stream:    cs.stream,
+		timestamp: cs.timestamp,
+		payload:   cs.buf,
+	}
+	cr.buffered -= uint32(len(cs.buf))
+	cs.buf = nil
+	return m, nil
+}
+
+// A chunkWriter splits messages into chunks.
+type chunkWriter struct {
+	w         *bufio.Writer
+	chunkSize uint32
+}
+
+// writeMessage writes a message on chunk stream csid, which must be
+// between 2 and 63.
+func (cw *chunkWriter) writeMessage(csid uint32, m *message) error {
+	ts := m.timestamp
+	extended := ts >= 0xFFFFFF
+	if extended {
+		ts = 0xFFFFFF
+	}
+
+	var header [16]byte
+	header[0] = byte(csid)
+	header[1] = byte(ts >> 16)
+	header[2] = byte(ts >> 8)
+	header[3] = byte(ts)
+	l := len(m.payload)
+	header[4] = byte(l >> 16)
+	header[5] = byte(l >> 8)
+	header[6] = byte(l)
+	header[7] = m.typ
+	binary.LittleEndian.PutUint32(header[8:], m.stream)
+	n := 12
+	if extended {
+		binary.BigEndian.PutUint32(header[12:], m.timestamp)
+		n = 16
+	}
+	_, err := cw.w.Write(header[:n])
+	if err != nil {
+		return err
+	}
+
+	payload := m.payload
+	for {
+		n := min(uint32(len(payload)), cw.chunkSize)
+		_, err := cw.w.Write(payload[:n])
+		if err != nil {
+			return err
+		}
+		payload = payload[n:]
+		if len(payload) == 0 {
+			break
+		}
+		err = cw.w.WriteByte(0xC0 | byte(csid))
+		if err != nil {
+			return err
+		}
+		if extended {
+			err = binary.Write(cw.w, binary.BigEndian, m.timestamp)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return cw.w.Flush()
+}
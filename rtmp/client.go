@@ -0,0 +1,132 @@
+package rtmp
+
+import (
+	"net"
+	"sync"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+)
+
+// A Client is an RTMP publisher.  It implements group.Client.
+type Client struct {
+	group   *group.Group
+	id      string
+	addr    net.Addr
+	session *session
+
+	mu          sync.Mutex
+	username    string
+	permissions []string
+	up          *upConn
+}
+
+func newClient(g *group.Group, id string, s *session) *Client {
+	return &Client{
+		group:   g,
+		id:      id,
+		addr:    s.conn.RemoteAddr(),
+		session: s,
+	}
+}
+
+func (c *Client) Group() *group.Group {
+	return c.group
+}
+
+func (c *Client) Addr() net.Addr {
+	return c.addr
+}
+
+func (c *Client) Id() string {
+	return c.id
+}
+
+func (c *Client) Username() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.username
+}
+
+func (c *Client) Init(username string, perms []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.username = username
+	c.permissions = perms
+}
+
+func (c *Client) Permissions() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.permissions
+}
+
+func (c *Client) Data() map[string]interface{} {
+	return nil
+}
+
+func (c *Client) PushConn(g *group.Group, id string, up conn.Up, tracks []conn.UpTrack, replace string) error {
+	return nil
+}
+
+func (c *Client) RequestConns(target group.Client, g *group.Group, id string) error {
+	if g != c.group {
+		return nil
+	}
+
+	c.mu.Lock()
+	up := c.up
+	c.mu.Unlock()
+	if up == nil || (id != "" && id != up.id) {
+		return nil
+	}
+	tracks := up.getTracks()
+	if len(tracks) == 0 {
+		return nil
+	}
+	return target.PushConn(g, up.id, up, tracks, "")
+}
+
+func (c *Client) Joined(group, kind string) error {
+	return nil
+}
+
+func (c *Client) PushClient(group, kind, id, username string, perms []string, data map[string]interface{}) error {
+	return nil
+}
+
+// Kick terminates the RTMP session.  The client is removed from the
+// group once the session has terminated.
+func (c *Client) Kick(id string, user *string, message string) error {
+	return c.session.conn.Close()
+}
+
+// setUp sets the stream published by the client, and pushes it to all
+// the clients in the group.
+func (c *Client) setUp(up *upConn) {
+	c.mu.Lock()
+	c.up = up
+	c.mu.Unlock()
+
+	tracks := up.getTracks()
+	for _, cc := range c.group.GetClients(c) {
+		cc.PushConn(c.group, up.id, up, tracks, "")
+	}
+}
+
+// close withdraws the stream published by the client and removes it from
+// the group.
+func (c *Client) close() {
+	c.mu.Lock()
+	up := c.up
+	c.up = nil
+	c.mu.Unlock()
+
+	if up != nil {
+		for _, cc := range c.group.GetClients(c) {
+			cc.PushConn(c.group, up.id, nil, nil, "")
+		}
+		up.close()
+	}
+	group.DelClient(c)
+}
@@ -0,0 +1,167 @@
+//go:build cgo && opus && fdkaac
+
+package rtmp
+
+/*
+#cgo pkg-config: fdk-aac opus
+#include <fdk-aac/aacdecoder_lib.h>
+#include <opus.h>
+
+static int
+config_raw(HANDLE_AACDECODER dec, unsigned char *buf, unsigned len)
+{
+    UCHAR *bufs[1] = {buf};
+    UINT lens[1] = {len};
+    return aacDecoder_ConfigRaw(dec, bufs, lens);
+}
+
+static int
+decode_frame(HANDLE_AACDECODER dec, unsigned char *buf, unsigned len,
+             INT_PCM *pcm, int size)
+{
+    UCHAR *bufs[1] = {buf};
+    UINT lens[1] = {len};
+    UINT valid = len;
+    int rc;
+
+    rc = aacDecoder_Fill(dec, bufs, lens, &valid);
+    if(rc != AAC_DEC_OK)
+        return rc;
+    return aacDecoder_DecodeFrame(dec, pcm, size, 0);
+}
+
+static int
+stream_info(HANDLE_AACDECODER dec, int *rate, int *channels, int *size)
+{
+    CStreamInfo *info = aacDecoder_GetStreamInfo(dec);
+    if(info == NULL)
+        return -1;
+    *rate = info->sampleRate;
+    *channels = info->numChannels;
+    *size = info->frameSize;
+    return 0;
+}
+
+static int
+set_bitrate(OpusEncoder *enc, opus_int32 bitrate)
+{
+    return opus_encoder_ctl(enc, OPUS_SET_BITRATE(bitrate));
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// the bitrate of the transcoded audio, in bits per second
+const transcoderBitrate = 64000
+
+// the largest AAC frame, in samples, for up to 8 channels
+const maxAACFrameSize = 8 * 2048
+
+func init() {
+	newTranscoder = newFdkTranscoder
+}
+
+type fdkTranscoder struct {
+	decoder   C.HANDLE_AACDECODER
+	encoder   *C.OpusEncoder
+	resampler *resampler
+	pcm       []int16
+	buffered  []int16
+}
+
+func newFdkTranscoder(config []byte) (transcoder, error) {
+	if len(config) == 0 {
+		return nil, errors.New("empty AAC configuration")
+	}
+	d := C.aacDecoder_Open(C.TT_MP4_RAW, 1)
+	if d == nil {
+		return nil, errors.New("couldn't create AAC decoder")
+	}
+	rc := C.config_raw(d,
+		(*C.uchar)(unsafe.Pointer(&config[0])), C.uint(len(config)),
+	)
+	if rc != C.AAC_DEC_OK {
+		C.aacDecoder_Close(d)
+		return nil, fmt.Errorf("AAC configuration error %v", int(rc))
+	}
+
+	var err C.int
+	e := C.opus_encoder_create(
+		opusRate, 1, C.OPUS_APPLICATION_AUDIO, &err,
+	)
+	if err != C.OPUS_OK {
+		C.aacDecoder_Close(d)
+		return nil, errors.New(
+			"opus: " + C.GoString(C.opus_strerror(err)),
+		)
+	}
+	err = C.set_bitrate(e, transcoderBitrate)
+	if err != C.OPUS_OK {
+		C.opus_encoder_destroy(e)
+		C.aacDecoder_Close(d)
+		return nil, errors.New(
+			"opus: " + C.GoString(C.opus_strerror(err)),
+		)
+	}
+
+	return &fdkTranscoder{
+		decoder: d,
+		encoder: e,
+		pcm:     make([]int16, maxAACFrameSize),
+	}, nil
+}
+
+func (t *fdkTranscoder) transcode(frame []byte) ([][]byte, error) {
+	if len(frame) == 0 {
+		return nil, nil
+	}
+	rc := C.decode_frame(t.decoder,
+		(*C.uchar)(unsafe.Pointer(&frame[0])), C.uint(len(frame)),
+		(*C.INT_PCM)(unsafe.Pointer(&t.pcm[0])), C.int(len(t.pcm)),
+	)
+	if rc != C.AAC_DEC_OK {
+		return nil, fmt.Errorf("AAC decoding error %v", int(rc))
+	}
+	var rate, channels, size C.int
+	if C.stream_info(t.decoder, &rate, &channels, &size) < 0 ||
+		rate <= 0 || channels <= 0 ||
+		int(size*channels) > len(t.pcm) {
+		return nil, errors.New("bad AAC stream")
+	}
+
+	if t.resampler == nil || t.resampler.from != int(rate) {
+		t.resampler = newResampler(int(rate), opusRate)
+	}
+	mono := downmix(t.pcm[:size*channels], int(channels))
+	t.buffered = t.resampler.resample(t.buffered, mono)
+
+	var packets [][]byte
+	for len(t.buffered) >= opusFrameSize {
+		buf := make([]byte, 1500)
+		n := C.opus_encode(
+			t.encoder,
+			(*C.opus_int16)(unsafe.Pointer(&t.buffered[0])),
+			opusFrameSize,
+			(*C.uchar)(unsafe.Pointer(&buf[0])),
+			C.opus_int32(len(buf)),
+		)
+		if n < 0 {
+			return packets, errors.New(
+				"opus: " + C.GoString(C.opus_strerror(C.int(n))),
+			)
+		}
+		packets = append(packets, buf[:n])
+		t.buffered = t.buffered[:copy(t.buffered, t.buffered[opusFrameSize:])]
+	}
+	return packets, nil
+}
+
+func (t *fdkTranscoder) close() {
+	C.opus_encoder_destroy(t.encoder)
+	C.aacDecoder_Close(t.decoder)
+}
@@ -0,0 +1,261 @@
+package rtmp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// RTMP carries media in the format of FLV tag bodies, possibly using the
+// "enhanced RTMP" extensions, in which the codec is identified by a FourCC.
+
+// packet types, common to AVC and AAC, and to the enhanced headers
+const (
+	packetConfig = 0
+	packetFrames = 1
+	// any other packet type, which is ignored
+	packetOther = -1
+)
+
+var errUnsupportedCodec = errors.New("unsupported codec")
+
+type videoTag struct {
+	packetType int
+	keyframe   bool
+	// the composition time offset, in milliseconds
+	cts  int32
+	data []byte
+}
+
+func int24(b []byte) int32 {
+	v := int32(b[0])<<16 | int32(b[1])<<8 | int32(b[2])
+	if v&0x800000 != 0 {
+		v -= 0x1000000
+	}
+	return v
+}
+
+// parseVideoTag parses the body of a video message.  Only H.264 is
+// supported.
+func parseVideoTag(data []byte) (videoTag, error) {
+	if len(data) < 1 {
+		return videoTag{}, errors.New("empty video tag")
+	}
+
+	if data[0]&0x80 != 0 {
+		// enhanced RTMP
+		if len(data) < 5 {
+			return videoTag{}, errors.New("truncated video tag")
+		}
+		tag := videoTag{keyframe: (data[0]>>4)&0x07 == 1}
+		if fourcc := string(data[1:5]); fourcc != "avc1" {
+			return videoTag{}, fmt.Errorf(
+				"%w %q", errUnsupportedCodec, fourcc,
+			)
+		}
+		switch data[0] & 0x0F {
+		case 0:
+			tag.packetType = packetConfig
+			tag.data = data[5:]
+		case 1:
+			if len(data) < 8 {
+				return videoTag{}, errors.New("truncated video tag")
+			}
+			tag.packetType = packetFrames
+			tag.cts = int24(data[5:])
+			tag.data = data[8:]
+		case 3:
+			tag.packetType = packetFrames
+			tag.data = data[5:]
+		default:
+			tag.packetType = packetOther
+		}
+		return tag, nil
+	}
+
+	if data[0]&0x0F != 7 {
+		return videoTag{}, fmt.Errorf(
+			"%w %v", errUnsupportedCodec, data[0]&0x0F,
+		)
+	}
+	if len(data) < 5 {
+		return videoTag{}, errors.New("truncated video tag")
+	}
+	tag := videoTag{
+		keyframe: data[0]>>4 == 1,
+		cts:      int24(data[2:]),
+		data:     data[5:],
+	}
+	switch data[1] {
+	case 0:
+		tag.packetType = packetConfig
+	case 1:
+		tag.packetType = packetFrames
+	default:
+		tag.packetType = packetOther
+	}
+	return tag, nil
+}
+
+// avcConfig is a parsed AVCDecoderConfigurationRecord.
+type avcConfig struct {
+	lengthSize int
+	sps        [][]byte
+	pps        [][]byte
+}
+
+func parseAVCConfig(data []byte) (*avcConfig, error) {
+	if len(data) < 6 || data[0] != 1 {
+		return nil, errors.New("bad AVC configuration")
+	}
+	config := &avcConfig{lengthSize: int(data[4]&0x03) + 1}
+	if config.lengthSize == 3 {
+		return nil, errors.New("bad NAL length size")
+	}
+
+	i := 6
+	readNALs := func(count int) ([][]byte, error) {
+		var nals [][]byte
+		for j := 0; j < count; j++ {
+			if len(data) < i+2 {
+				return nil, errors.New("truncated AVC configuration")
+			}
+			l := int(binary.BigEndian.Uint16(data[i:]))
+			i += 2
+			if len(data) < i+l {
+				return nil, errors.New("truncated AVC configuration")
+			}
+			nals = append(nals, data[i:i+l])
+			i += l
+		}
+		return nals, nil
+	}
+
+	var err error
+	config.sps, err = readNALs(int(data[5] & 0x1F))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < i+1 {
+		return nil, errors.New("truncated AVC configuration")
+	}
+	count := int(data[i])
+	i++
+	config.pps, err = readNALs(count)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+var startCode = []byte{0, 0, 0, 1}
+
+// annexB converts a frame consisting of length-prefixed NALUs into the
+// Annex B format, as expected by the RTP payloader.  If the frame is a
+// keyframe that carries no parameter sets, the ones from the
+// configuration are prepended, since browsers need them in band.
+func (config *avcConfig) annexB(data []byte, keyframe bool) ([]byte, error) {
+	var nals [][]byte
+	hasSPS := false
+	for len(data) > 0 {
+		if len(data) < config.lengthSize {
+			return nil, errors.New("truncated NALU")
+		}
+		l := 0
+		for _, b := range data[:config.lengthSize] {
+			l = l<<8 | int(b)
+		}
+		data = data[config.lengthSize:]
+		if len(data) < l {
+			return nil, errors.New("truncated NALU")
+		}
+		if l > 0 {
+			if data[0]&0x1F == 7 {
+				hasSPS = true
+			}
+			nals = append(nals, data[:l])
+		}
+		data = data[l:]
+	}
+
+	if keyframe && !hasSPS {
+		ps := make([][]byte, 0, len(config.sps)+len(config.pps)+len(nals))
+		ps = append(ps, config.sps...)
+		ps = append(ps, config.pps...)
+		nals = append(ps, nals...)
+	}
+
+	var buf []byte
+	for _, nal := range nals {
+		buf = append(buf, startCode...)
+		buf = append(buf, nal...)
+	}
+	return buf, nil
+}
+
+type audioTag struct {
+	// either "aac" or "opus"
+	codec      string
+	packetType int
+	data       []byte
+}
+
+// parseAudioTag parses the body of an audio message.  AAC and Opus are
+// supported.
+func parseAudioTag(data []byte) (audioTag, error) {
+	if len(data) < 1 {
+		return audioTag{}, errors.New("empty audio tag")
+	}
+
+	format := data[0] >> 4
+	switch format {
+	case 9:
+		// enhanced RTMP
+		if len(data) < 5 {
+			return audioTag{}, errors.New("truncated audio tag")
+		}
+		var tag audioTag
+		switch fourcc := string(data[1:5]); fourcc {
+		case "mp4a":
+			tag.codec = "aac"
+		case "Opus":
+			tag.codec = "opus"
+		default:
+			return audioTag{}, fmt.Errorf(
+				"%w %q", errUnsupportedCodec, fourcc,
+			)
+		}
+		switch data[0] & 0x0F {
+		case 0:
+			tag.packetType = packetConfig
+		case 1:
+			tag.packetType = packetFrames
+		default:
+			tag.packetType = packetOther
+		}
+		tag.data = data[5:]
+		return tag, nil
+	case 10, 13:
+		// AAC, and Opus as implemented by some servers
+		if len(data) < 2 {
+			return audioTag{}, errors.New("truncated audio tag")
+		}
+		tag := audioTag{codec: "aac", data: data[2:]}
+		if format == 13 {
+			tag.codec = "opus"
+		}
+		switch data[1] {
+		case 0:
+			tag.packetType = packetConfig
+		case 1:
+			tag.packetType = packetFrames
+		default:
+			tag.packetType = packetOther
+		}
+		return tag, nil
+	default:
+		return audioTag{}, fmt.Errorf(
+			"%w %v", errUnsupportedCodec, format,
+		)
+	}
+}
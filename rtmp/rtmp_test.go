@@ -0,0 +1,484 @@
+package rtmp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+func TestAMF(t *testing.T) {
+	values := []any{
+		"connect", 1.0, true, nil,
+		map[string]any{"app": "test", "n": 3.5},
+		[]any{"a", 2.0},
+	}
+	buf, err := amfEncode(values...)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	decoded, err := amfDecode(buf)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !reflect.DeepEqual(values, decoded) {
+		t.Errorf("Expected %v, got %v", values, decoded)
+	}
+
+	// ECMA array, as sent in onMetaData
+	ecma := []byte{
+		amfECMAArray, 0, 0, 0, 1,
+		0, 1, 'w', amfNumber, 0x40, 0x94, 0, 0, 0, 0, 0, 0,
+		0, 0, amfObjectEnd,
+	}
+	decoded, err = amfDecode(ecma)
+	if err != nil || len(decoded) != 1 ||
+		!reflect.DeepEqual(decoded[0], map[string]any{"w": 1280.0}) {
+		t.Errorf("ECMA array: got %v %v", decoded, err)
+	}
+
+	_, err = amfDecode([]byte{amfString, 0, 10, 'a'})
+	if err == nil {
+		t.Errorf("Truncated string decoded successfully")
+	}
+}
+
+func TestChunks(t *testing.T) {
+	var buf bytes.Buffer
+	w := &chunkWriter{w: bufio.NewWriter(&buf), chunkSize: 100}
+	messages := []*message{
+		{typ: msgVideo, stream: 1, timestamp: 42,
+			payload: bytes.Repeat([]byte{1}, 250)},
+		{typ: msgAudio, stream: 1, timestamp: 0x1000000,
+			payload: bytes.Repeat([]byte{2}, 150)},
+		{typ: msgCommandAMF0, payload: nil},
+	}
+	for i, m := range messages {
+		err := w.writeMessage(uint32(4+i), m)
+		if err != nil {
+			t.Fatalf("writeMessage: %v", err)
+		}
+	}
+
+	r := newChunkReader(bufio.NewReader(&buf))
+	r.setChunkSize(100)
+	for _, m := range messages {
+		mm, err := r.readMessage()
+		if err != nil {
+			t.Fatalf("readMessage: %v", err)
+		}
+		if mm.typ != m.typ || mm.stream != m.stream ||
+			mm.timestamp != m.timestamp ||
+			!bytes.Equal(mm.payload, m.payload) {
+			t.Errorf("Expected %v, got %v", m, mm)
+		}
+	}
+	_, err := r.readMessage()
+	if err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+}
+
+func TestChunkDeltas(t *testing.T) {
+	data := []byte{
+		// format 0, csid 6, timestamp 1000, length 2, audio, stream 1
+		0x06, 0, 0x03, 0xE8, 0, 0, 2, 8, 1, 0, 0, 0, 'a', 'b',
+		// format 2, delta 20
+		0x86, 0, 0, 20, 'c', 'd',
+		// format 3, same delta
+		0xC6, 'e', 'f',
+		// format 1, delta 10, length 1, video
+		0x46, 0, 0, 10, 0, 0, 1, 9, 'g',
+		// two-byte basic header, csid 64
+		0x00, 0, 0, 0, 0, 0, 0, 1, 8, 1, 0, 0, 0, 'h',
+	}
+	r := newChunkReader(bufio.NewReader(bytes.NewReader(data)))
+	expected := []struct {
+		typ       uint8
+		timestamp uint32
+		payload   string
+	}{
+		{8, 1000, "ab"}, {8, 1020, "cd"}, {8, 1040, "ef"},
+		{9, 1050, "g"}, {8, 0, "h"},
+	}
+	for _, e := range expected {
+		m, err := r.readMessage()
+		if err != nil {
+			t.Fatalf("readMessage: %v", err)
+		}
+		if m.typ != e.typ || m.timestamp != e.timestamp ||
+			string(m.payload) != e.payload {
+			t.Errorf("Expected %v, got %v", e, m)
+		}
+	}
+}
+
+func TestChunkLimits(t *testing.T) {
+	// a full header on chunk stream csid, for a message of length n
+	header := func(csid byte, n uint32) []byte {
+		return []byte{
+			csid, 0, 0, 0,
+			byte(n >> 16), byte(n >> 8), byte(n), msgVideo,
+			1, 0, 0, 0,
+		}
+	}
+
+	data := header(4, maxUnauthenticatedLength+1)
+	r := newChunkReader(bufio.NewReader(bytes.NewReader(data)))
+	_, err := r.readMessage()
+	if err == nil || err == io.EOF {
+		t.Errorf("Large message: got %v", err)
+	}
+
+	data = nil
+	for i := 0; i <= maxChunkStreams; i++ {
+		// two-byte basic header
+		data = append(data, 0, byte(i))
+		data = append(data, header(0, 0)[1:]...)
+	}
+	r = newChunkReader(bufio.NewReader(bytes.NewReader(data)))
+	for i := 0; i < maxChunkStreams; i++ {
+		_, err := r.readMessage()
+		if err != nil {
+			t.Fatalf("readMessage: %v", err)
+		}
+	}
+	_, err = r.readMessage()
+	if err == nil || err == io.EOF {
+		t.Errorf("Too many chunk streams: got %v", err)
+	}
+
+	data = nil
+	for csid := byte(4); csid < 8; csid++ {
+		data = append(data, header(csid, 150)...)
+		data = append(data, bytes.Repeat([]byte{0}, 128)...)
+	}
+	r = newChunkReader(bufio.NewReader(bytes.NewReader(data)))
+	r.setMaxLength(200)
+	_, err = r.readMessage()
+	if err == nil || err == io.EOF {
+		t.Errorf("Partial messages: got %v", err)
+	}
+	if r.buffered > 400 {
+		t.Errorf("Buffered %v bytes", r.buffered)
+	}
+}
+
+var testAVCConfig = []byte{
+	1, 0x42, 0xC0, 0x1F, 0xFF,
+	0xE1, 0, 3, 0x67, 0x42, 0xC0,
+	1, 0, 2, 0x68, 0xCE,
+}
+
+func TestVideoTag(t *testing.T) {
+	tag, err := parseVideoTag(append([]byte{0x17, 0, 0, 0, 0},
+		testAVCConfig...))
+	if err != nil || tag.packetType != packetConfig || !tag.keyframe {
+		t.Fatalf("Config: got %v %v", tag, err)
+	}
+	config, err := parseAVCConfig(tag.data)
+	if err != nil {
+		t.Fatalf("parseAVCConfig: %v", err)
+	}
+	if config.lengthSize != 4 || len(config.sps) != 1 ||
+		len(config.pps) != 1 || len(config.sps[0]) != 3 ||
+		len(config.pps[0]) != 2 {
+		t.Errorf("Bad config %v", config)
+	}
+
+	frame := []byte{0, 0, 0, 2, 0x65, 0x88, 0, 0, 0, 1, 0x06}
+	tag, err = parseVideoTag(append([]byte{0x17, 1, 0, 0, 40}, frame...))
+	if err != nil || tag.packetType != packetFrames || tag.cts != 40 {
+		t.Fatalf("Frame: got %v %v", tag, err)
+	}
+	annexB, err := config.annexB(tag.data, tag.keyframe)
+	if err != nil {
+		t.Fatalf("annexB: %v", err)
+	}
+	expected := []byte{
+		0, 0, 0, 1, 0x67, 0x42, 0xC0,
+		0, 0, 0, 1, 0x68, 0xCE,
+		0, 0, 0, 1, 0x65, 0x88,
+		0, 0, 0, 1, 0x06,
+	}
+	if !bytes.Equal(annexB, expected) {
+		t.Errorf("Expected %v, got %v", expected, annexB)
+	}
+
+	_, err = config.annexB([]byte{0, 0, 0, 5, 0x41}, false)
+	if err == nil {
+		t.Errorf("Truncated frame converted successfully")
+	}
+
+	tag, err = parseVideoTag([]byte{0x91, 'a', 'v', 'c', '1', 0, 0, 1})
+	if err != nil || tag.packetType != packetFrames || !tag.keyframe ||
+		tag.cts != 1 {
+		t.Errorf("Enhanced: got %v %v", tag, err)
+	}
+
+	_, err = parseVideoTag([]byte{0x12, 1, 0, 0, 0})
+	if err == nil {
+		t.Errorf("Sorenson video parsed successfully")
+	}
+}
+
+func TestAudioTag(t *testing.T) {
+	tag, err := parseAudioTag([]byte{0xAF, 0, 0x12, 0x10})
+	if err != nil || tag.codec != "aac" || tag.packetType != packetConfig ||
+		len(tag.data) != 2 {
+		t.Errorf("AAC: got %v %v", tag, err)
+	}
+	tag, err = parseAudioTag([]byte{0x91, 'O', 'p', 'u', 's', 0xFC})
+	if err != nil || tag.codec != "opus" ||
+		tag.packetType != packetFrames || len(tag.data) != 1 {
+		t.Errorf("Opus: got %v %v", tag, err)
+	}
+	_, err = parseAudioTag([]byte{0x2F, 0xFF})
+	if err == nil {
+		t.Errorf("MP3 parsed successfully")
+	}
+}
+
+func TestResampler(t *testing.T) {
+	r := newResampler(44100, 48000)
+	var out []int16
+	in := make([]int16, 441)
+	for i := range in {
+		in[i] = 1000
+	}
+	for i := 0; i < 100; i++ {
+		out = r.resample(out, in)
+	}
+	if len(out) < 47990 || len(out) > 48000 {
+		t.Errorf("Expected 48000 samples, got %v", len(out))
+	}
+	for i, v := range out[1:] {
+		if v != 1000 {
+			t.Errorf("Sample %v: expected 1000, got %v", i, v)
+			break
+		}
+	}
+
+	// a ramp is preserved across chunk boundaries
+	r = newResampler(48000, 24000)
+	out = nil
+	for i := 0; i < 10; i++ {
+		in := make([]int16, 5)
+		for j := range in {
+			in[j] = int16(5*i + j)
+		}
+		out = r.resample(out, in)
+	}
+	for i, v := range out {
+		if v != int16(2*i) {
+			t.Errorf("Expected %v, got %v", 2*i, v)
+			break
+		}
+	}
+
+	same := newResampler(48000, 48000)
+	if len(same.resample(nil, in)) != len(in) {
+		t.Errorf("Resampling at the same rate changed length")
+	}
+}
+
+func TestDownmix(t *testing.T) {
+	pcm := downmix([]int16{1, 3, -2, -4, math.MaxInt16, math.MaxInt16}, 2)
+	expected := []int16{2, -3, math.MaxInt16}
+	if !reflect.DeepEqual(pcm, expected) {
+		t.Errorf("Expected %v, got %v", expected, pcm)
+	}
+}
+
+type testDownTrack struct {
+	packets []rtp.Packet
+}
+
+func (t *testDownTrack) Write(buf []byte) (int, error) {
+	var p rtp.Packet
+	err := p.Unmarshal(append([]byte(nil), buf...))
+	if err != nil {
+		return 0, err
+	}
+	t.packets = append(t.packets, p)
+	return len(buf), nil
+}
+
+func (t *testDownTrack) SetTimeOffset(ntp uint64, rtp uint32) {
+}
+
+func (t *testDownTrack) SetCname(string) {
+}
+
+func (t *testDownTrack) GetMaxBitrate() (uint64, int, int) {
+	return ^uint64(0), -1, -1
+}
+
+func TestUpTrack(t *testing.T) {
+	up := newUpConn(&Client{id: "rtmp"}, time.Now())
+	track, err := up.addTrack(h264Codec)
+	if err != nil {
+		t.Fatalf("addTrack: %v", err)
+	}
+	if track.Kind() != webrtc.RTPCodecTypeVideo {
+		t.Errorf("Bad kind %v", track.Kind())
+	}
+	var down testDownTrack
+	track.AddLocal(&down)
+
+	config, _ := parseAVCConfig(testAVCConfig)
+	idr := make([]byte, 4+3000)
+	idr[2] = 3000 >> 8
+	idr[3] = 3000 & 0xFF
+	idr[4] = 0x65
+	frame, err := config.annexB(idr, true)
+	if err != nil {
+		t.Fatalf("annexB: %v", err)
+	}
+	err = track.writeFrame(9000, frame)
+	if err != nil {
+		t.Fatalf("writeFrame: %v", err)
+	}
+
+	if len(down.packets) < 4 {
+		t.Fatalf("Expected at least 4 packets, got %v",
+			len(down.packets))
+	}
+	for i, p := range down.packets {
+		if p.Timestamp != track.base+9000 ||
+			p.SequenceNumber != down.packets[0].SequenceNumber+uint16(i) ||
+			p.Marker != (i == len(down.packets)-1) {
+			t.Errorf("Packet %v: bad header %v", i, p.Header)
+		}
+	}
+	// SPS and PPS are aggregated
+	if down.packets[0].Payload[0]&0x1F != 24 {
+		t.Errorf("Expected STAP-A, got %v", down.packets[0].Payload[0])
+	}
+
+	buf := make([]byte, 1500)
+	if track.GetPacket(down.packets[1].SequenceNumber, buf, false) == 0 {
+		t.Errorf("Packet not in cache")
+	}
+
+	up.close()
+	if track.writeFrame(18000, frame) == nil {
+		t.Errorf("writeFrame succeeded after close")
+	}
+}
+
+func TestSession(t *testing.T) {
+	server.mu.Lock()
+	server.sessions = make(map[*session]bool)
+	server.mu.Unlock()
+
+	client, conn := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		serveConn(conn)
+		close(done)
+	}()
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	c0c1 := make([]byte, 1+1536)
+	c0c1[0] = 3
+	c0c1[100] = 42
+	go client.Write(c0c1)
+	s0s1s2 := make([]byte, 1+1536+1536)
+	_, err := io.ReadFull(client, s0s1s2)
+	if err != nil {
+		t.Fatalf("Handshake: %v", err)
+	}
+	if s0s1s2[0] != 3 || !bytes.Equal(s0s1s2[1+1536:], c0c1[1:]) {
+		t.Errorf("Bad handshake")
+	}
+	_, err = client.Write(make([]byte, 1536))
+	if err != nil {
+		t.Fatalf("C2: %v", err)
+	}
+
+	w := &chunkWriter{w: bufio.NewWriter(client), chunkSize: 128}
+	r := newChunkReader(bufio.NewReader(client))
+	// the pipe is synchronous, so commands are written asynchronously
+	written := make(chan error, 1)
+	command := func(values ...any) {
+		payload, err := amfEncode(values...)
+		if err != nil {
+			t.Fatalf("amfEncode: %v", err)
+		}
+		go func() {
+			written <- w.writeMessage(3, &message{
+				typ: msgCommandAMF0, payload: payload,
+			})
+		}()
+	}
+	// readCommand returns the next command, handling control messages
+	readCommand := func() []any {
+		err := <-written
+		if err != nil {
+			t.Fatalf("writeMessage: %v", err)
+		}
+		for {
+			m, err := r.readMessage()
+			if err != nil {
+				t.Fatalf("readMessage: %v", err)
+			}
+			switch m.typ {
+			case msgSetChunkSize:
+				r.setChunkSize(
+					uint32(m.payload[0])<<24 |
+						uint32(m.payload[1])<<16 |
+						uint32(m.payload[2])<<8 |
+						uint32(m.payload[3]),
+				)
+			case msgCommandAMF0:
+				values, err := amfDecode(m.payload)
+				if err != nil {
+					t.Fatalf("amfDecode: %v", err)
+				}
+				return values
+			}
+		}
+	}
+
+	command("connect", 1, map[string]any{"app": "/"})
+	values := readCommand()
+	if len(values) < 4 || values[0] != "_result" || values[1] != 1.0 {
+		t.Fatalf("connect: got %v", values)
+	}
+	status, _ := values[3].(map[string]any)
+	if status["code"] != "NetConnection.Connect.Success" {
+		t.Errorf("connect: got %v", status)
+	}
+
+	command("createStream", 2, nil)
+	values = readCommand()
+	if len(values) < 4 || values[0] != "_result" ||
+		values[3] != float64(publishStream) {
+		t.Fatalf("createStream: got %v", values)
+	}
+
+	// no group was specified in connect
+	command("publish", 3, nil, "key", "live")
+	values = readCommand()
+	status, _ = values[3].(map[string]any)
+	if values[0] != "onStatus" ||
+		status["code"] != "NetStream.Publish.BadName" {
+		t.Errorf("publish: got %v", values)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Session didn't terminate")
+	}
+}
@@ -0,0 +1,610 @@
+// Package rtmp implements an RTMP ingest server, which allows encoders
+// that only speak RTMP to publish in a group.
+//
+// The encoder connects to rtmp://server/group and uses a token for the
+// group as its stream key; the token must grant the present permission.
+// Video must be H.264.  Audio may be Opus, or AAC if Galene was built with
+// the opus and fdkaac tags, in which case it is transcoded to Opus.
+package rtmp
+
+import (
+	"bufio"
+	crand "crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtpconn"
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	readTimeout      = 30 * time.Second
+	writeTimeout     = 10 * time.Second
+	// the acknowledgement window and bandwidth that we announce
+	windowSize = 2500000
+	// the chunk size that we use for sending
+	outChunkSize = 4096
+	// the RTMP stream id that we allocate to the publisher
+	publishStream = 1
+)
+
+var server struct {
+	mu       sync.Mutex
+	listener net.Listener
+	sessions map[*session]bool
+}
+
+func newId() string {
+	b := make([]byte, 16)
+	crand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Serve starts an RTMP server listening on address.
+func Serve(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	server.mu.Lock()
+	server.listener = l
+	server.sessions = make(map[*session]bool)
+	server.mu.Unlock()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				log.Printf("RTMP: %v", err)
+				time.Sleep(time.Second)
+				continue
+			}
+			go serveConn(conn)
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops the RTMP server and terminates all sessions.
+func Shutdown() {
+	server.mu.Lock()
+	l := server.listener
+	server.listener = nil
+	var sessions []*session
+	for s := range server.sessions {
+		sessions = append(sessions, s)
+	}
+	server.mu.Unlock()
+
+	if l != nil {
+		l.Close()
+	}
+	for _, s := range sessions {
+		s.conn.Close()
+	}
+}
+
+// A session is an RTMP connection.
+type session struct {
+	conn    net.Conn
+	counter *countingReader
+	reader  *chunkReader
+	writer  *chunkWriter
+	// the acknowledgement window requested by the peer, and the
+	// number of bytes read when we last sent an acknowledgement
+	window uint32
+	acked  uint64
+
+	app    string
+	client *Client
+	up     *upConn
+	video  *upTrack
+	audio  *upTrack
+	avc    *avcConfig
+
+	transcoder transcoder
+	// the timestamp of the next transcoded packet, valid if
+	// aacStarted is true
+	aacTimestamp uint32
+	aacStarted   bool
+
+	warned map[string]bool
+}
+
+func serveConn(conn net.Conn) {
+	counter := &countingReader{r: conn}
+	s := &session{
+		conn:    conn,
+		counter: counter,
+		reader:  newChunkReader(bufio.NewReader(counter)),
+		writer: &chunkWriter{
+			w:         bufio.NewWriter(conn),
+			chunkSize: defaultChunkSize,
+		},
+		warned: make(map[string]bool),
+	}
+
+	server.mu.Lock()
+	if server.sessions == nil {
+		server.mu.Unlock()
+		conn.Close()
+		return
+	}
+	server.sessions[s] = true
+	server.mu.Unlock()
+
+	defer func() {
+		server.mu.Lock()
+		delete(server.sessions, s)
+		server.mu.Unlock()
+	}()
+	defer conn.Close()
+	defer s.close()
+
+	err := s.run()
+	if err != nil && !errors.Is(err, io.EOF) &&
+		!errors.Is(err, net.ErrClosed) {
+		log.Printf("RTMP %v: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// warn logs a message, at most once per session for a given key.
+func (s *session) warn(key string, format string, args ...any) {
+	if s.warned[key] {
+		return
+	}
+	s.warned[key] = true
+	log.Printf("RTMP %v: %v", s.conn.RemoteAddr(), fmt.Sprintf(format, args...))
+}
+
+func (s *session) close() {
+	if s.transcoder != nil {
+		s.transcoder.close()
+		s.transcoder = nil
+	}
+	if s.client != nil {
+		s.client.close()
+	}
+}
+
+func (s *session) run() error {
+	s.conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	err := s.handshake()
+	if err != nil {
+		return err
+	}
+
+	for {
+		s.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		m, err := s.reader.readMessage()
+		if err != nil {
+			return err
+		}
+		err = s.ack()
+		if err != nil {
+			return err
+		}
+		err = s.handleMessage(m)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// handshake performs the simple handshake, in which the server echoes
+// the client's random data.
+func (s *session) handshake() error {
+	c0c1 := make([]byte, 1+1536)
+	_, err := io.ReadFull(s.reader.r, c0c1)
+	if err != nil {
+		return err
+	}
+	if c0c1[0] != 3 {
+		return fmt.Errorf("unsupported RTMP version %v", c0c1[0])
+	}
+
+	s0s1s2 := make([]byte, 1+1536+1536)
+	s0s1s2[0] = 3
+	crand.Read(s0s1s2[1+8 : 1+1536])
+	copy(s0s1s2[1+1536:], c0c1[1:])
+	_, err = s.conn.Write(s0s1s2)
+	if err != nil {
+		return err
+	}
+
+	c2 := make([]byte, 1536)
+	_, err = io.ReadFull(s.reader.r, c2)
+	return err
+}
+
+func (s *session) write(csid uint32, m *message) error {
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return s.writer.writeMessage(csid, m)
+}
+
+// control sends a protocol control message.
+func (s *session) control(typ uint8, values ...uint32) error {
+	var payload []byte
+	for _, v := range values {
+		payload = binary.BigEndian.AppendUint32(payload, v)
+	}
+	return s.write(2, &message{typ: typ, payload: payload})
+}
+
+// command sends an AMF0 command.
+func (s *session) command(stream uint32, values ...any) error {
+	payload, err := amfEncode(values...)
+	if err != nil {
+		return err
+	}
+	return s.write(3, &message{
+		typ:     msgCommandAMF0,
+		stream:  stream,
+		payload: payload,
+	})
+}
+
+// ack sends an acknowledgement if the peer's window has been reached.
+func (s *session) ack() error {
+	if s.window == 0 || s.counter.count-s.acked < uint64(s.window) {
+		return nil
+	}
+	s.acked = s.counter.count
+	return s.control(msgAck, uint32(s.acked))
+}
+
+func (s *session) handleMessage(m *message) error {
+	switch m.typ {
+	case msgSetChunkSize:
+		if len(m.payload) < 4 {
+			return errors.New("truncated control message")
+		}
+		return s.reader.setChunkSize(
+			binary.BigEndian.Uint32(m.payload) & 0x7FFFFFFF,
+		)
+	case msgAbort:
+		if len(m.payload) < 4 {
+			return errors.New("truncated control message")
+		}
+		s.reader.abort(binary.BigEndian.Uint32(m.payload))
+		return nil
+	case msgWindowAckSize:
+		if len(m.payload) < 4 {
+			return errors.New("truncated control message")
+		}
+		s.window = binary.BigEndian.Uint32(m.payload)
+		return nil
+	case msgUserControl:
+		// answer ping requests
+		if len(m.payload) >= 6 &&
+			binary.BigEndian.Uint16(m.payload) == 6 {
+			payload := binary.BigEndian.AppendUint16(nil, 7)
+			payload = append(payload, m.payload[2:6]...)
+			return s.write(2, &message{
+				typ:     msgUserControl,
+				payload: payload,
+			})
+		}
+		return nil
+	case msgCommandAMF0, msgCommandAMF3:
+		payload := m.payload
+		if m.typ == msgCommandAMF3 && len(payload) > 0 &&
+			payload[0] == 0 {
+			payload = payload[1:]
+		}
+		values, err := amfDecode(payload)
+		if err != nil {
+			return err
+		}
+		return s.handleCommand(m.stream, values)
+	case msgVideo:
+		return s.handleVideo(m)
+	case msgAudio:
+		return s.handleAudio(m)
+	default:
+		// acknowledgements, peer bandwidth, metadata
+		return nil
+	}
+}
+
+func (s *session) handleCommand(stream uint32, values []any) error {
+	if len(values) < 2 {
+		return errors.New("malformed command")
+	}
+	name, _ := values[0].(string)
+	txn, _ := values[1].(float64)
+
+	switch name {
+	case "connect":
+		if len(values) < 3 {
+			return errors.New("malformed connect command")
+		}
+		obj, _ := values[2].(map[string]any)
+		app, _ := obj["app"].(string)
+		s.app = strings.Trim(app, "/")
+
+		err := s.control(msgWindowAckSize, windowSize)
+		if err != nil {
+			return err
+		}
+		err = s.write(2, &message{
+			typ: msgSetPeerBandwidth,
+			payload: binary.BigEndian.AppendUint32(
+				nil, windowSize,
+			),
+		})
+		if err != nil {
+			return err
+		}
+		err = s.control(msgSetChunkSize, outChunkSize)
+		if err != nil {
+			return err
+		}
+		s.writer.chunkSize = outChunkSize
+		return s.command(0, "_result", txn,
+			map[string]any{
+				"fmsVer":       "FMS/3,0,1,123",
+				"capabilities": 31,
+			},
+			map[string]any{
+				"level":          "status",
+				"code":           "NetConnection.Connect.Success",
+				"description":    "Connection succeeded.",
+				"objectEncoding": 0,
+			},
+		)
+	case "releaseStream", "FCPublish":
+		return s.command(0, "_result", txn, nil, nil)
+	case "createStream":
+		return s.command(0, "_result", txn, nil, publishStream)
+	case "publish":
+		if len(values) < 4 {
+			return errors.New("malformed publish command")
+		}
+		key, _ := values[3].(string)
+		return s.publish(stream, key)
+	case "play":
+		s.command(stream, "onStatus", 0, nil, map[string]any{
+			"level":       "error",
+			"code":        "NetStream.Play.Failed",
+			"description": "Playback is not supported.",
+		})
+		return errors.New("client attempted to play")
+	case "FCUnpublish", "deleteStream", "closeStream":
+		return io.EOF
+	default:
+		return nil
+	}
+}
+
+func (s *session) publishError(stream uint32, code string, err error) error {
+	s.command(stream, "onStatus", 0, nil, map[string]any{
+		"level":       "error",
+		"code":        code,
+		"description": err.Error(),
+	})
+	return err
+}
+
+// publish authenticates the publisher and adds it to the group named by
+// the application.  The stream key is used as a token.
+func (s *session) publish(stream uint32, key string) error {
+	if s.client != nil {
+		return errors.New("duplicate publish")
+	}
+	if s.app == "" {
+		return s.publishError(stream, "NetStream.Publish.BadName",
+			errors.New("no group specified"),
+		)
+	}
+
+	g, err := group.Add(s.app, nil)
+	if err != nil {
+		return s.publishError(stream, "NetStream.Publish.BadName", err)
+	}
+
+	c := newClient(g, newId(), s)
+	username := "rtmp"
+	_, err = group.AddClient(g.Name(), c, group.ClientCredentials{
+		Username: &username,
+		Token:    key,
+	})
+	if err != nil {
+		return s.publishError(
+			stream, "NetStream.Publish.Unauthorized", err,
+		)
+	}
+	if !slices.Contains(c.Permissions(), "present") {
+		group.DelClient(c)
+		return s.publishError(
+			stream, "NetStream.Publish.Unauthorized",
+			errors.New("not authorised to present"),
+		)
+	}
+	s.client = c
+	s.reader.setMaxLength(maxMessageLength)
+
+	// Stream Begin
+	payload := binary.BigEndian.AppendUint16(nil, 0)
+	payload = binary.BigEndian.AppendUint32(payload, stream)
+	err = s.write(2, &message{typ: msgUserControl, payload: payload})
+	if err != nil {
+		return err
+	}
+	return s.command(stream, "onStatus", 0, nil, map[string]any{
+		"level":       "status",
+		"code":        "NetStream.Publish.Start",
+		"description": "Publishing.",
+	})
+}
+
+// getTrack returns the track stored in *track, creating it if necessary.
+// Timestamp is the RTMP timestamp of the current message.
+func (s *session) getTrack(track **upTrack, codec string, timestamp uint32) (*upTrack, error) {
+	if *track != nil {
+		return *track, nil
+	}
+
+	if s.up == nil {
+		epoch := time.Now().Add(
+			-time.Duration(timestamp) * time.Millisecond,
+		)
+		s.up = newUpConn(s.client, epoch)
+	}
+
+	capability := opusCodec
+	if codec == "h264" {
+		capability = h264Codec
+	}
+	t, err := s.up.addTrack(capability)
+	if err != nil {
+		return nil, err
+	}
+	*track = t
+	s.client.setUp(s.up)
+	rtpconn.AutoStart(s.client.group)
+	return t, nil
+}
+
+func (s *session) handleVideo(m *message) error {
+	if s.client == nil {
+		return nil
+	}
+
+	tag, err := parseVideoTag(m.payload)
+	if err != nil {
+		s.warn("video", "%v, dropping video", err)
+		return nil
+	}
+
+	switch tag.packetType {
+	case packetConfig:
+		avc, err := parseAVCConfig(tag.data)
+		if err != nil {
+			s.warn("avc", "%v", err)
+			return nil
+		}
+		s.avc = avc
+		return nil
+	case packetFrames:
+	default:
+		return nil
+	}
+
+	if s.avc == nil {
+		return nil
+	}
+	if !s.client.group.CodecEnabled("h264") {
+		s.warn("video", "H.264 is not enabled in group %v, "+
+			"dropping video", s.client.group.Name())
+		return nil
+	}
+
+	track, err := s.getTrack(&s.video, "h264", m.timestamp)
+	if err != nil {
+		return err
+	}
+	frame, err := s.avc.annexB(tag.data, tag.keyframe)
+	if err != nil {
+		s.warn("avc", "%v", err)
+		return nil
+	}
+	ts := uint32(int64(m.timestamp)+int64(tag.cts)) * 90
+	err = track.writeFrame(ts, frame)
+	if err != nil {
+		s.warn("write", "%v", err)
+	}
+	return nil
+}
+
+func (s *session) handleAudio(m *message) error {
+	if s.client == nil {
+		return nil
+	}
+
+	tag, err := parseAudioTag(m.payload)
+	if err != nil {
+		s.warn("audio", "%v, dropping audio", err)
+		return nil
+	}
+	if !s.client.group.CodecEnabled("opus") {
+		s.warn("audio", "Opus is not enabled in group %v, "+
+			"dropping audio", s.client.group.Name())
+		return nil
+	}
+
+	var packets [][]byte
+	var ts uint32
+	switch tag.codec {
+	case "opus":
+		if tag.packetType != packetFrames {
+			return nil
+		}
+		packets = [][]byte{tag.data}
+		ts = m.timestamp * (opusRate / 1000)
+	case "aac":
+		if tag.packetType == packetConfig {
+			if s.transcoder != nil {
+				s.transcoder.close()
+				s.transcoder = nil
+			}
+			if newTranscoder == nil {
+				s.warn("audio", "AAC is not supported by "+
+					"this build, dropping audio")
+				return nil
+			}
+			s.transcoder, err = newTranscoder(tag.data)
+			if err != nil {
+				s.warn("audio", "%v, dropping audio", err)
+			}
+			s.aacStarted = false
+			return nil
+		}
+		if tag.packetType != packetFrames || s.transcoder == nil {
+			return nil
+		}
+		packets, err = s.transcoder.transcode(tag.data)
+		if err != nil {
+			s.warn("aac", "%v", err)
+		}
+		if len(packets) == 0 {
+			return nil
+		}
+		// the transcoder counts samples, resynchronise if we have
+		// drifted too far from the sender's timestamps
+		expected := m.timestamp * (opusRate / 1000)
+		delta := int32(expected - s.aacTimestamp)
+		if !s.aacStarted || delta > opusRate/5 || delta < -opusRate/5 {
+			s.aacTimestamp = expected
+			s.aacStarted = true
+		}
+		ts = s.aacTimestamp
+		s.aacTimestamp += uint32(len(packets) * opusFrameSize)
+	}
+
+	track, err := s.getTrack(&s.audio, "opus", m.timestamp)
+	if err != nil {
+		return err
+	}
+	for i, p := range packets {
+		err := track.writeFrame(ts+uint32(i*opusFrameSize), p)
+		if err != nil {
+			s.warn("write", "%v", err)
+		}
+	}
+	return nil
+}
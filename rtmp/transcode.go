@@ -0,0 +1,87 @@
+package rtmp
+
+import (
+	"math"
+)
+
+// Opus is transmitted at 48kHz, in frames of 20ms.
+const (
+	opusRate      = 48000
+	opusFrameSize = opusRate / 50
+)
+
+// A transcoder converts AAC frames into Opus packets.
+type transcoder interface {
+	// transcode decodes an AAC frame, and returns the Opus packets,
+	// each of which holds opusFrameSize samples, that are ready to be
+	// sent.
+	transcode(frame []byte) ([][]byte, error)
+	close()
+}
+
+// newTranscoder is set by the AAC implementation, if any.  Config is
+// the AudioSpecificConfig of the stream.
+var newTranscoder func(config []byte) (transcoder, error)
+
+// downmix averages the channels of an interleaved buffer into mono, in
+// place, and returns the result.
+func downmix(pcm []int16, channels int) []int16 {
+	if channels <= 1 {
+		return pcm
+	}
+	n := len(pcm) / channels
+	for i := 0; i < n; i++ {
+		sum := 0
+		for j := 0; j < channels; j++ {
+			sum += int(pcm[i*channels+j])
+		}
+		pcm[i] = int16(sum / channels)
+	}
+	return pcm[:n]
+}
+
+// A resampler converts mono audio between sample rates by linear
+// interpolation.  It keeps enough state to process a stream in chunks
+// of arbitrary size.
+type resampler struct {
+	from, to int
+	// the position of the next output sample, in input samples,
+	// relative to the start of the next chunk; -1 is the last sample
+	// of the previous chunk
+	pos  float64
+	last int16
+}
+
+func newResampler(from, to int) *resampler {
+	return &resampler{from: from, to: to}
+}
+
+// resample appends the samples of in, resampled, to out.
+func (r *resampler) resample(out, in []int16) []int16 {
+	if r.from == r.to {
+		return append(out, in...)
+	}
+
+	step := float64(r.from) / float64(r.to)
+	for {
+		i := int(math.Floor(r.pos))
+		if i+1 >= len(in) {
+			break
+		}
+		a := r.last
+		if i >= 0 {
+			a = in[i]
+		}
+		b := in[i+1]
+		f := r.pos - float64(i)
+		out = append(out,
+			int16(float64(a)+f*(float64(b)-float64(a))),
+		)
+		r.pos += step
+	}
+	if len(in) > 0 {
+		r.last = in[len(in)-1]
+		r.pos -= float64(len(in))
+	}
+	return out
+}
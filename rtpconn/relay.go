@@ -0,0 +1,252 @@
+package rtpconn
+
+import (
+	crand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/unbounded"
+)
+
+// A relay is a webClient that connects to a group on a remote server,
+// as described by a group.CascadeLink.  Streams published in the local
+// group are offered to the remote server, and streams offered by the
+// remote server are published in the local group.  The remote side sees
+// an ordinary client with the relay permission.
+//
+// In order to avoid forwarding loops, a client with the relay
+// permission, whether local or remote, is never sent the streams
+// published by another relay.  Hence, streams are only relayed over
+// a single link.
+
+// relayRetry is the time we wait before reconnecting a relay.
+const relayRetry = 10 * time.Second
+
+type relayKey struct {
+	group *group.Group
+	link  group.CascadeLink
+}
+
+// relayMu protects relays, the set of running relays.
+var relayMu sync.Mutex
+var relays = make(map[relayKey]bool)
+
+// isRelay returns true if c forwards streams on behalf of another server.
+func isRelay(c group.Client) bool {
+	return slices.Contains(c.Permissions(), "relay")
+}
+
+// autoCascade starts the relays configured for group g that are not
+// already running.
+func autoCascade(g *group.Group) {
+	links := g.Description().Cascade
+	if len(links) == 0 {
+		return
+	}
+
+	relayMu.Lock()
+	defer relayMu.Unlock()
+	for _, link := range links {
+		key := relayKey{g, link}
+		if relays[key] {
+			continue
+		}
+		relays[key] = true
+		go runRelay(g, link)
+	}
+}
+
+// relayWanted returns true if the relay described by link should keep
+// running.  Called with relayMu held.
+func relayWanted(g *group.Group, link group.CascadeLink) bool {
+	if group.Get(g.Name()) != g {
+		return false
+	}
+	if !slices.Contains(g.Description().Cascade, link) {
+		return false
+	}
+	for _, c := range g.GetClients(nil) {
+		if !slices.Contains(c.Permissions(), "system") {
+			return true
+		}
+	}
+	return false
+}
+
+func runRelay(g *group.Group, link group.CascadeLink) {
+	for {
+		err := relayOnce(g, link)
+		if err != nil {
+			log.Printf("Relay %v: %v", link.URL, err)
+		}
+
+		time.Sleep(relayRetry)
+
+		relayMu.Lock()
+		if !relayWanted(g, link) {
+			delete(relays, relayKey{g, link})
+			relayMu.Unlock()
+			return
+		}
+		relayMu.Unlock()
+	}
+}
+
+// relayOnce connects to the remote server and runs the relay until
+// the connection is closed.
+func relayOnce(g *group.Group, link group.CascadeLink) (err error) {
+	dialer := websocket.Dialer{HandshakeTimeout: 30 * time.Second}
+	ws, _, err := dialer.Dial(link.URL, nil)
+	if err != nil {
+		return err
+	}
+	ws.SetReadLimit(maxWSMessageSize)
+
+	buf := make([]byte, 16)
+	crand.Read(buf)
+	c := &webClient{
+		id:          base64.RawURLEncoding.EncodeToString(buf),
+		username:    "RELAY",
+		permissions: []string{"system", "present", "relay"},
+		requested:   map[string][]string{"": {"audio", "video"}},
+		lastN:       0,
+		relay:       &link,
+		actions:     unbounded.New[any](),
+		done:        make(chan struct{}),
+	}
+
+	defer close(c.done)
+
+	c.writeCh = make(chan interface{}, 100)
+	c.writerDone = make(chan struct{})
+	go clientWriter(ws, c.writeCh, c.writerDone)
+	defer func() {
+		_, e := errorToWSCloseMessage(c.id, err)
+		if isWSNormalError(err) {
+			err = nil
+		} else if _, ok := err.(group.KickError); ok {
+			err = nil
+		}
+		c.close(e)
+	}()
+
+	gg, err := group.AddClient(g.Name(), c,
+		group.ClientCredentials{
+			System: true,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	c.group = gg
+	if gg != g {
+		leaveGroup(c)
+		return errors.New("group has been deleted")
+	}
+	requestConns(c, g, "")
+
+	return clientLoop(c, ws, false)
+}
+
+// relayJoin sends the messages required to join the remote group.
+func relayJoin(c *webClient) error {
+	name := c.relay.Group
+	if name == "" {
+		name = c.group.Name()
+	}
+	err := c.write(clientMessage{
+		Type:  "join",
+		Kind:  "join",
+		Group: name,
+		Token: c.relay.Token,
+	})
+	if err != nil {
+		return err
+	}
+	lastN := 0
+	return c.write(clientMessage{
+		Type:    "request",
+		Request: c.requested,
+		LastN:   &lastN,
+	})
+}
+
+// relayMessages is the set of messages that are sent to the remote
+// server; other messages, such as user lists and chat, are only
+// meaningful to local clients.
+var relayMessages = map[string]bool{
+	"handshake":     true,
+	"join":          true,
+	"request":       true,
+	"requestStream": true,
+	"offer":         true,
+	"answer":        true,
+	"ice":           true,
+	"renegotiate":   true,
+	"close":         true,
+	"abort":         true,
+	"ping":          true,
+	"pong":          true,
+}
+
+// relayForwarded is the set of signalling messages that a relay peer
+// sends on behalf of the clients of the remote server.
+var relayForwarded = map[string]bool{
+	"request":       true,
+	"requestStream": true,
+	"offer":         true,
+	"answer":        true,
+	"ice":           true,
+	"renegotiate":   true,
+	"close":         true,
+	"abort":         true,
+}
+
+// checkRelayMessage checks a message received from a relay peer.  It
+// returns true if the message is forwarded on behalf of a remote client,
+// in which case the source and username need not match the relay's own.
+// The only other messages accepted are the ones needed to maintain the
+// connection.
+func checkRelayMessage(m clientMessage) (bool, error) {
+	if relayForwarded[m.Type] {
+		return true, nil
+	}
+	switch m.Type {
+	case "handshake", "join", "ping", "pong":
+		return false, nil
+	}
+	return false, group.ProtocolError(
+		"unexpected message " + m.Type + " from relay",
+	)
+}
+
+// handleRelayMessage handles a message received by a relay from the
+// remote server.
+func handleRelayMessage(c *webClient, m clientMessage) error {
+	switch m.Type {
+	case "joined":
+		switch m.Kind {
+		case "fail", "redirect", "leave":
+			return fmt.Errorf("couldn't join group: %v", m.Value)
+		}
+		return nil
+	case "usermessage":
+		if m.Kind == "error" || m.Kind == "warning" {
+			log.Printf("Relay %v: %v", c.relay.URL, m.Value)
+		}
+		return nil
+	case "offer", "answer", "ice", "renegotiate", "close", "abort",
+		"requestStream", "ping", "pong":
+		return handleClientMessage(c, m)
+	default:
+		return nil
+	}
+}
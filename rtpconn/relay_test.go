@@ -0,0 +1,92 @@
+package rtpconn
+
+import (
+	"testing"
+
+	"github.com/jech/galene/group"
+)
+
+func TestRelayWrite(t *testing.T) {
+	c := &webClient{
+		relay:   &group.CascadeLink{},
+		writeCh: make(chan interface{}, 10),
+	}
+	c.write(clientMessage{Type: "chat"})
+	c.write(clientMessage{Type: "user"})
+	c.write(clientMessage{Type: "offer"})
+	if len(c.writeCh) != 1 {
+		t.Fatalf("Expected 1 message, got %v", len(c.writeCh))
+	}
+	m := (<-c.writeCh).(clientMessage)
+	if m.Type != "offer" {
+		t.Errorf("Expected offer, got %v", m.Type)
+	}
+}
+
+func TestIsRelay(t *testing.T) {
+	c := &webClient{permissions: []string{"present", "relay"}}
+	if !isRelay(c) {
+		t.Errorf("Expected relay")
+	}
+	c.permissions = []string{"present"}
+	if isRelay(c) {
+		t.Errorf("Expected not relay")
+	}
+}
+
+func TestRelayMessages(t *testing.T) {
+	c := &webClient{
+		id:          "relay",
+		username:    "relay",
+		permissions: []string{"present", "relay"},
+		writeCh:     make(chan interface{}, 10),
+	}
+	other := "other"
+
+	for _, tpe := range []string{
+		"chat", "chathistory", "usermessage", "useraction",
+		"groupaction",
+	} {
+		err := handleClientMessage(c, clientMessage{
+			Type:     tpe,
+			Source:   "other",
+			Username: &other,
+		})
+		if _, ok := err.(group.ProtocolError); !ok {
+			t.Errorf("%v from relay: got %v", tpe, err)
+		}
+		err = handleClientMessage(c, clientMessage{Type: tpe})
+		if _, ok := err.(group.ProtocolError); !ok {
+			t.Errorf("%v from relay (own): got %v", tpe, err)
+		}
+	}
+
+	for tpe := range relayForwarded {
+		forwarded, err := checkRelayMessage(clientMessage{Type: tpe})
+		if !forwarded || err != nil {
+			t.Errorf("%v from relay: %v %v", tpe, forwarded, err)
+		}
+	}
+
+	err := handleClientMessage(c, clientMessage{
+		Type:   "ping",
+		Source: "other",
+	})
+	if _, ok := err.(group.ProtocolError); !ok {
+		t.Errorf("spoofed ping from relay: got %v", err)
+	}
+	err = handleClientMessage(c, clientMessage{Type: "ping"})
+	if err != nil {
+		t.Errorf("ping from relay: %v", err)
+	}
+
+	// other clients may never spoof their source
+	c.permissions = []string{"present"}
+	err = handleClientMessage(c, clientMessage{
+		Type:   "offer",
+		Source: "other",
+	})
+	if _, ok := err.(group.ProtocolError); !ok {
+		t.Errorf("spoofed offer: got %v", err)
+	}
+}
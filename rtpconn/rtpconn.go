@@ -78,6 +78,7 @@ type downTrackAtomics struct {
 	remoteNTP uint64
 	remoteRTP uint32
 	layerInfo uint32
+	nacks     uint32
 }
 
 type rtpDownTrack struct {
@@ -106,6 +107,10 @@ func (down *rtpDownTrack) getTimeOffset() (uint64, uint32) {
 	return ntp, rtp
 }
 
+func (down *rtpDownTrack) getNACKs() uint32 {
+	return atomic.LoadUint32(&down.atomics.nacks)
+}
+
 func (down *rtpDownTrack) getRTT() uint64 {
 	return atomic.LoadUint64(&down.atomics.rtt)
 }
@@ -404,6 +409,13 @@ type rtpUpTrack struct {
 	jitter   *jitter.Estimator
 	cname    atomic.Value
 
+	// the id of the ssrc-audio-level header extension, or 0
+	audioLevelId uint8
+	// the smoothed audio level, in -dBov times 256
+	audioLevel atomic.Uint32
+	// the time of the last audio level update, in jiffies
+	audioLevelTime atomic.Uint64
+
 	actions    *unbounded.Channel[trackAction]
 	readerDone chan struct{}
 
@@ -503,9 +515,12 @@ func (up *rtpUpTrack) hasRtcpFb(tpe, parameter string) bool {
 }
 
 type rtpUpConnection struct {
-	id            string
-	client        group.Client
-	label         string
+	id     string
+	client group.Client
+	label  string
+	// the username of the stream's originator, if it is not the
+	// client's, used for streams received over a relay
+	username      string
 	pc            *webrtc.PeerConnection
 	iceCandidates []*webrtc.ICECandidateInit
 
@@ -544,6 +559,9 @@ func (up *rtpUpConnection) Label() string {
 }
 
 func (up *rtpUpConnection) User() (string, string) {
+	if up.username != "" {
+		return up.client.Id(), up.username
+	}
 	return up.client.Id(), up.client.Username()
 }
 
@@ -613,6 +631,10 @@ func pushConnNow(up *rtpUpConnection, g *group.Group, cs []group.Client) {
 	for _, c := range cs {
 		c.PushConn(g, up.id, up, tracks, replace)
 	}
+
+	if len(tracks) > 0 {
+		AutoStart(g)
+	}
 }
 
 // pushConn schedules a call to pushConnNow
@@ -633,7 +655,7 @@ func pushConn(up *rtpUpConnection, g *group.Group, cs []group.Client) {
 	}(g, cs)
 }
 
-func newUpConn(c group.Client, id string, label string, offer string) (*rtpUpConnection, error) {
+func newUpConn(c group.Client, id, label, username string, offer string) (*rtpUpConnection, error) {
 	var o sdp.SessionDescription
 	err := o.Unmarshal([]byte(offer))
 	if err != nil {
@@ -662,7 +684,13 @@ func newUpConn(c group.Client, id string, label string, offer string) (*rtpUpCon
 		}
 	}
 
-	up := &rtpUpConnection{id: id, client: c, label: label, pc: pc}
+	up := &rtpUpConnection{
+		id:       id,
+		client:   c,
+		label:    label,
+		username: username,
+		pc:       pc,
+	}
 
 	pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
 		up.mu.Lock()
@@ -677,6 +705,9 @@ func newUpConn(c group.Client, id string, label string, offer string) (*rtpUpCon
 			actions:    unbounded.New[trackAction](),
 			readerDone: make(chan struct{}),
 		}
+		if remote.Kind() == webrtc.RTPCodecTypeAudio {
+			track.audioLevelId = audioLevelId(receiver)
+		}
 
 		up.tracks = append(up.tracks, track)
 
@@ -691,6 +722,7 @@ func newUpConn(c group.Client, id string, label string, offer string) (*rtpUpCon
 
 	pushConn(up, c.Group(), c.Group().GetClients(c))
 	go rtcpUpSender(up)
+	startSpeakerDetector()
 
 	return up, nil
 }
@@ -767,6 +799,7 @@ func gotNACK(track *rtpDownTrack, p *rtcp.TransportLayerNack) {
 	buf := make([]byte, packetcache.BufSize)
 	for _, nack := range p.Nacks {
 		nack.Range(func(s uint16) bool {
+			atomic.AddUint32(&track.atomics.nacks, 1)
 			ok, seqno, _ := track.packetmap.Reverse(s)
 			if !ok {
 				return true
@@ -929,6 +962,29 @@ func maxUpBitrate(t *rtpUpTrack) uint64 {
 	return maxrate
 }
 
+// upBitrateQuota returns the maximum bitrate of an up connection allowed
+// by the publisher's quota, or 0 if unlimited.  The quota is shared
+// equally between all the connections of a client.
+func upBitrateQuota(up *rtpUpConnection) uint64 {
+	g := up.client.Group()
+	if g == nil {
+		return 0
+	}
+	quota := g.Description().GetQuota(up.client.Username())
+	if quota.MaxUpBitrate == 0 {
+		return 0
+	}
+	n := 1
+	if c, ok := up.client.(*webClient); ok {
+		c.mu.Lock()
+		if len(c.up) > 1 {
+			n = len(c.up)
+		}
+		c.mu.Unlock()
+	}
+	return quota.MaxUpBitrate / uint64(n)
+}
+
 func sendUpRTCP(up *rtpUpConnection) error {
 	tracks := up.getTracks()
 
@@ -1006,6 +1062,9 @@ func sendUpRTCP(up *rtpUpConnection) error {
 	if rate > group.MaxBitrate {
 		rate = group.MaxBitrate
 	}
+	if quota := upBitrateQuota(up); quota > 0 && rate > quota {
+		rate = quota
+	}
 	if len(ssrcs) > 0 {
 		packets = append(packets,
 			&rtcp.ReceiverEstimatedMaximumBitrate{
@@ -77,6 +77,16 @@ func readLoop(track *rtpUpTrack) {
 			kfNeeded = false
 		}
 		if packet.Extension {
+			if track.audioLevelId != 0 {
+				ext := packet.GetExtension(track.audioLevelId)
+				if ext != nil {
+					var level rtp.AudioLevelExtension
+					err := level.Unmarshal(ext)
+					if err == nil {
+						track.updateAudioLevel(level.Level)
+					}
+				}
+			}
 			packet.Extension = false
 			packet.Extensions = nil
 			bytes, err = packet.MarshalTo(buf)
@@ -2,12 +2,24 @@ package rtpconn
 
 import (
 	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/jech/galene/rtptime"
 	"github.com/jech/galene/stats"
 )
 
+var websocketStats struct {
+	received atomic.Uint64
+	sent     atomic.Uint64
+}
+
+// WebsocketStats returns the number of messages received and sent over
+// websockets since the server was started.
+func WebsocketStats() (received uint64, sent uint64) {
+	return websocketStats.received.Load(), websocketStats.sent.Load()
+}
+
 func (c *webClient) GetStats() *stats.Client {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -16,6 +28,7 @@ func (c *webClient) GetStats() *stats.Client {
 		Id: c.id,
 	}
 
+	now := rtptime.Jiffies()
 	for _, up := range c.up {
 		conns := stats.Conn{
 			Id: up.id,
@@ -31,11 +44,26 @@ func (c *webClient) GetStats() *stats.Client {
 			jitter := time.Duration(t.jitter.Jitter()) *
 				(time.Second / time.Duration(t.jitter.HZ()))
 			rate, _ := t.rate.Estimate()
+			var lost uint32
+			if s.TotalExpected > s.TotalReceived {
+				lost = s.TotalExpected - s.TotalReceived
+			}
+			var audioLevel *float64
+			if l, ok := t.getAudioLevel(now); ok {
+				l = -l
+				audioLevel = &l
+			}
 			conns.Tracks = append(conns.Tracks, stats.Track{
+				Kind:       t.Kind().String(),
 				Bitrate:    uint64(rate) * 8,
 				MaxBitrate: maxUpBitrate(t),
 				Loss:       loss,
 				Jitter:     stats.Duration(jitter),
+				Packets:    s.TotalReceived,
+				Lost:       lost,
+				Unordered:  s.TotalUnordered,
+				NACKs:      s.TotalNACKed,
+				AudioLevel: audioLevel,
 			})
 		}
 		cs.Up = append(cs.Up, conns)
@@ -63,6 +91,7 @@ func (c *webClient) GetStats() *stats.Client {
 			j := time.Duration(jitter) * time.Second /
 				time.Duration(t.track.Codec().ClockRate)
 			conns.Tracks = append(conns.Tracks, stats.Track{
+				Kind:       t.remote.Kind().String(),
 				Tid:        &tid,
 				MaxTid:     &maxTid,
 				Sid:        &sid,
@@ -72,6 +101,7 @@ func (c *webClient) GetStats() *stats.Client {
 				Loss:       float64(loss) / 256.0,
 				Rtt:        stats.Duration(rtt),
 				Jitter:     stats.Duration(j),
+				NACKs:      t.getNACKs(),
 			})
 		}
 		cs.Down = append(cs.Down, conns)
@@ -0,0 +1,287 @@
+package rtpconn
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtptime"
+)
+
+const (
+	// the interval at which we check for a change of active speaker
+	speakerInterval = 300 * time.Millisecond
+	// audio levels, in -dBov, below which a client is considered to
+	// be speaking
+	speakerThreshold = 50
+	// the minimum time during which a speaker remains active
+	speakerHold = time.Second
+	// the amount, in dB, by which a client needs to be louder than the
+	// active speaker in order to replace it
+	speakerMargin = 6
+	// the time after which the audio level of a track is considered
+	// to be stale
+	audioLevelTimeout = 500 * time.Millisecond
+)
+
+// audioLevelId returns the id of the ssrc-audio-level header extension
+// negotiated for receiver, or 0 if it was not negotiated.
+func audioLevelId(receiver *webrtc.RTPReceiver) uint8 {
+	for _, e := range receiver.GetParameters().HeaderExtensions {
+		if e.URI == sdp.AudioLevelURI && e.ID > 0 && e.ID < 256 {
+			return uint8(e.ID)
+		}
+	}
+	return 0
+}
+
+// updateAudioLevel updates the smoothed audio level of a track.  Level
+// is in -dBov, as carried by the ssrc-audio-level extension (RFC 6464).
+// Only called from the reader loop.
+func (up *rtpUpTrack) updateAudioLevel(level uint8) {
+	l := uint32(level&0x7F) << 8
+	if up.audioLevelTime.Load() != 0 {
+		old := up.audioLevel.Load()
+		l = old - old/16 + l/16
+	}
+	up.audioLevel.Store(l)
+	up.audioLevelTime.Store(rtptime.Jiffies())
+}
+
+// getAudioLevel returns the smoothed audio level of a track in -dBov,
+// and false if it is unknown.
+func (up *rtpUpTrack) getAudioLevel(now uint64) (float64, bool) {
+	if up.audioLevelId == 0 {
+		return 0, false
+	}
+	t := up.audioLevelTime.Load()
+	if t == 0 || now < t || rtptime.ToDuration(int64(now-t),
+		rtptime.JiffiesPerSec) > audioLevelTimeout {
+		return 0, false
+	}
+	return float64(up.audioLevel.Load()) / 256, true
+}
+
+// connAudioLevel returns the audio level of the loudest track of up.
+func connAudioLevel(up *rtpUpConnection, now uint64) (float64, bool) {
+	level := 0.0
+	found := false
+	for _, t := range up.getTracks() {
+		l, ok := t.getAudioLevel(now)
+		if ok && (!found || l < level) {
+			level = l
+			found = true
+		}
+	}
+	return level, found
+}
+
+// clientAudioLevel returns the audio level of the loudest track
+// published by c.
+func clientAudioLevel(c group.Client, now uint64) (float64, bool) {
+	var conns []*rtpUpConnection
+	switch c := c.(type) {
+	case *webClient:
+		c.mu.Lock()
+		for _, up := range c.up {
+			conns = append(conns, up)
+		}
+		c.mu.Unlock()
+	case *WhipClient:
+		c.mu.Lock()
+		if c.connection != nil {
+			conns = append(conns, c.connection)
+		}
+		c.mu.Unlock()
+	}
+
+	level := 0.0
+	found := false
+	for _, up := range conns {
+		l, ok := connAudioLevel(up, now)
+		if ok && (!found || l < level) {
+			level = l
+			found = true
+		}
+	}
+	return level, found
+}
+
+// chooseSpeaker returns the new active speaker given the current speaker,
+// the time during which it has been active, and the audio levels of the
+// clients, in -dBov.  During silence, the current speaker is kept, unless
+// it has stopped publishing.
+func chooseSpeaker(current string, active time.Duration, levels map[string]float64) string {
+	loudest := ""
+	for id, l := range levels {
+		if l < speakerThreshold &&
+			(loudest == "" || l < levels[loudest]) {
+			loudest = id
+		}
+	}
+
+	currentLevel, ok := levels[current]
+	if !ok {
+		return loudest
+	}
+	if loudest == "" || loudest == current || active < speakerHold {
+		return current
+	}
+	if currentLevel < speakerThreshold &&
+		levels[loudest] > currentLevel-speakerMargin {
+		return current
+	}
+	return loudest
+}
+
+func activeSpeakerMessage(g *group.Group, id string) clientMessage {
+	var username *string
+	if c := g.GetClient(id); c != nil {
+		u := c.Username()
+		username = &u
+	}
+	return clientMessage{
+		Type:     "activespeaker",
+		Source:   id,
+		Username: username,
+	}
+}
+
+var speakerDetectorOnce sync.Once
+
+// startSpeakerDetector starts the active speaker detector if it is not
+// already running.
+func startSpeakerDetector() {
+	speakerDetectorOnce.Do(func() {
+		go speakerDetector()
+	})
+}
+
+func speakerDetector() {
+	ticker := time.NewTicker(speakerInterval)
+	defer ticker.Stop()
+
+	// the time at which the active speaker of each group was chosen
+	since := make(map[*group.Group]time.Time)
+
+	for now := range ticker.C {
+		seen := make(map[*group.Group]bool)
+		for _, name := range group.GetNames() {
+			g := group.Get(name)
+			if g == nil {
+				continue
+			}
+			seen[g] = true
+			detectSpeaker(g, now, since)
+		}
+		for g := range since {
+			if !seen[g] {
+				delete(since, g)
+			}
+		}
+	}
+}
+
+func detectSpeaker(g *group.Group, now time.Time, since map[*group.Group]time.Time) {
+	jiffies := rtptime.Jiffies()
+	clients := g.GetClients(nil)
+	levels := make(map[string]float64)
+	for _, c := range clients {
+		l, ok := clientAudioLevel(c, jiffies)
+		if ok {
+			levels[c.Id()] = l
+		}
+	}
+
+	current := g.ActiveSpeaker()
+	speaker := chooseSpeaker(current, now.Sub(since[g]), levels)
+	previous := g.RecentSpeakers()
+	if !g.SetActiveSpeaker(speaker) {
+		return
+	}
+	since[g] = now
+
+	err := broadcast(clients, activeSpeakerMessage(g, speaker))
+	if err != nil {
+		log.Printf("broadcast(activespeaker): %v", err)
+	}
+
+	speakers := g.RecentSpeakers()
+	for _, c := range clients {
+		if c, ok := c.(*webClient); ok {
+			c.action(speakersAction{g, previous, speakers})
+		}
+	}
+}
+
+// getLastN returns the number of recent speakers whose video is
+// forwarded to c at full quality, or 0 if unlimited.
+func (c *webClient) getLastN() int {
+	if c.lastN >= 0 {
+		return c.lastN
+	}
+	if c.group == nil {
+		return 0
+	}
+	return max(c.group.Description().LastN, 0)
+}
+
+// lastNSpeakers returns the n most recent speakers.
+func lastNSpeakers(speakers []string, n int) []string {
+	return speakers[:min(n, len(speakers))]
+}
+
+// sameSpeakers returns true if a and b contain the same speakers,
+// irrespective of order.
+func sameSpeakers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, s := range a {
+		if !slices.Contains(b, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// scalable returns true if codec supports spatial scalability.
+func scalable(codec string) bool {
+	return strings.EqualFold(codec, "video/vp9") ||
+		strings.EqualFold(codec, "video/av1")
+}
+
+// downgradedTracks is like requestedTracks, but is used for streams
+// published by clients that are not among the last-n speakers.  Video is
+// downgraded to the lowest layer if the stream is simulcast or uses a
+// scalable codec, and is omitted otherwise.
+func downgradedTracks(requested []string, tracks []conn.UpTrack) ([]conn.UpTrack, bool) {
+	req := make([]string, len(requested))
+	for i, r := range requested {
+		if r == "video" {
+			r = "video-low"
+		}
+		req[i] = r
+	}
+	ts, limitSid := requestedTracks(req, tracks)
+	if !limitSid {
+		return ts, false
+	}
+
+	result := make([]conn.UpTrack, 0, len(ts))
+	for _, t := range ts {
+		if t.Kind() == webrtc.RTPCodecTypeVideo &&
+			!scalable(t.Codec().MimeType) {
+			continue
+		}
+		result = append(result, t)
+	}
+	return result, true
+}
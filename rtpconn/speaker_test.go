@@ -0,0 +1,157 @@
+package rtpconn
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/rtptime"
+)
+
+func TestAudioLevel(t *testing.T) {
+	up := &rtpUpTrack{audioLevelId: 1}
+	now := rtptime.Jiffies()
+
+	if _, ok := up.getAudioLevel(now); ok {
+		t.Errorf("Got audio level before any update")
+	}
+
+	up.updateAudioLevel(127)
+	l, ok := up.getAudioLevel(rtptime.Jiffies())
+	if !ok || l != 127 {
+		t.Errorf("Expected 127, got %v %v", l, ok)
+	}
+
+	// the voice activity bit should be ignored
+	for i := 0; i < 100; i++ {
+		up.updateAudioLevel(0x80 | 20)
+	}
+	l, ok = up.getAudioLevel(rtptime.Jiffies())
+	if !ok || l < 20 || l > 21 {
+		t.Errorf("Expected 20, got %v %v", l, ok)
+	}
+
+	up.updateAudioLevel(127)
+	l, ok = up.getAudioLevel(rtptime.Jiffies())
+	if !ok || l > 40 {
+		t.Errorf("Level is not smoothed: %v", l)
+	}
+
+	later := rtptime.Jiffies() + uint64(rtptime.FromDuration(
+		2*audioLevelTimeout, rtptime.JiffiesPerSec,
+	))
+	if _, ok := up.getAudioLevel(later); ok {
+		t.Errorf("Got stale audio level")
+	}
+}
+
+func TestChooseSpeaker(t *testing.T) {
+	type test struct {
+		current  string
+		active   time.Duration
+		levels   map[string]float64
+		expected string
+	}
+	tests := []test{
+		{"", time.Hour, nil, ""},
+		{"", time.Hour, map[string]float64{"a": 127}, ""},
+		{"", time.Hour, map[string]float64{"a": 30, "b": 20}, "b"},
+		// silence, keep the current speaker
+		{"a", time.Hour, map[string]float64{"a": 127, "b": 90}, "a"},
+		// the speaker is gone
+		{"a", 0, map[string]float64{"b": 127}, ""},
+		{"a", 0, map[string]float64{"b": 30}, "b"},
+		// hold time
+		{"a", 0, map[string]float64{"a": 127, "b": 20}, "a"},
+		{"a", time.Hour, map[string]float64{"a": 127, "b": 20}, "b"},
+		// margin
+		{"a", time.Hour, map[string]float64{"a": 30, "b": 27}, "a"},
+		{"a", time.Hour, map[string]float64{"a": 30, "b": 20}, "b"},
+	}
+
+	for _, tt := range tests {
+		s := chooseSpeaker(tt.current, tt.active, tt.levels)
+		if s != tt.expected {
+			t.Errorf("chooseSpeaker(%v, %v, %v): expected %v, got %v",
+				tt.current, tt.active, tt.levels, tt.expected, s)
+		}
+	}
+}
+
+type testUpTrack struct {
+	kind     webrtc.RTPCodecType
+	mimeType string
+}
+
+func (t *testUpTrack) AddLocal(conn.DownTrack) error {
+	return nil
+}
+
+func (t *testUpTrack) DelLocal(conn.DownTrack) bool {
+	return false
+}
+
+func (t *testUpTrack) Kind() webrtc.RTPCodecType {
+	return t.kind
+}
+
+func (t *testUpTrack) Label() string {
+	return ""
+}
+
+func (t *testUpTrack) Codec() webrtc.RTPCodecCapability {
+	return webrtc.RTPCodecCapability{MimeType: t.mimeType}
+}
+
+func (t *testUpTrack) GetPacket(uint16, []byte, bool) uint16 {
+	return 0
+}
+
+func (t *testUpTrack) RequestKeyframe() error {
+	return nil
+}
+
+func TestDowngradedTracks(t *testing.T) {
+	audio := &testUpTrack{webrtc.RTPCodecTypeAudio, "audio/opus"}
+	high := &testUpTrack{webrtc.RTPCodecTypeVideo, "video/VP8"}
+	low := &testUpTrack{webrtc.RTPCodecTypeVideo, "video/VP8"}
+	vp9 := &testUpTrack{webrtc.RTPCodecTypeVideo, "video/VP9"}
+	req := []string{"audio", "video"}
+
+	// simulcast, use the low layer
+	ts, limitSid := downgradedTracks(req, []conn.UpTrack{audio, high, low})
+	if len(ts) != 2 || ts[0] != audio || ts[1] != low || limitSid {
+		t.Errorf("Simulcast: got %v %v", ts, limitSid)
+	}
+
+	// scalable codec, limit the spatial layer
+	ts, limitSid = downgradedTracks(req, []conn.UpTrack{audio, vp9})
+	if len(ts) != 2 || ts[1] != vp9 || !limitSid {
+		t.Errorf("Scalable: got %v %v", ts, limitSid)
+	}
+
+	// neither, pause video
+	ts, _ = downgradedTracks(req, []conn.UpTrack{audio, high})
+	if len(ts) != 1 || ts[0] != audio {
+		t.Errorf("Single layer: got %v", ts)
+	}
+
+	if len(req) != 2 || req[1] != "video" {
+		t.Errorf("Request was modified: %v", req)
+	}
+}
+
+func TestSameSpeakers(t *testing.T) {
+	speakers := []string{"a", "b", "c"}
+	if !sameSpeakers(lastNSpeakers(speakers, 2), []string{"b", "a"}) {
+		t.Errorf("Expected same speakers")
+	}
+	if sameSpeakers(lastNSpeakers(speakers, 2), []string{"a", "c"}) {
+		t.Errorf("Expected different speakers")
+	}
+	if len(lastNSpeakers(speakers, 5)) != 3 {
+		t.Errorf("Expected 3 speakers")
+	}
+}
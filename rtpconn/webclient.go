@@ -8,7 +8,9 @@ import (
 	"fmt"
 	"log"
 	"maps"
+	"math/rand/v2"
 	"net"
+	"net/url"
 	"os"
 	"slices"
 	"strings"
@@ -23,6 +25,8 @@ import (
 	"github.com/jech/galene/estimator"
 	"github.com/jech/galene/group"
 	"github.com/jech/galene/ice"
+	"github.com/jech/galene/mixer"
+	"github.com/jech/galene/player"
 	"github.com/jech/galene/token"
 	"github.com/jech/galene/unbounded"
 )
@@ -68,10 +72,17 @@ type webClient struct {
 	permissions []string
 	data        map[string]interface{}
 	requested   map[string][]string
-	done        chan struct{}
-	writeCh     chan interface{}
-	writerDone  chan struct{}
-	actions     *unbounded.Channel[any]
+	// the client's override of the group's last-n setting, or -1
+	lastN int
+	// the link to a remote server, if this is a relay
+	relay *group.CascadeLink
+	// the group whose lobby we are waiting in, and our join request
+	lobby      *group.Group
+	lobbyJoin  clientMessage
+	done       chan struct{}
+	writeCh    chan interface{}
+	writerDone chan struct{}
+	actions    *unbounded.Channel[any]
 
 	mu   sync.Mutex
 	down map[string]*rtpDownConnection
@@ -138,6 +149,7 @@ type clientMessage struct {
 	Candidate        *webrtc.ICECandidateInit `json:"candidate,omitempty"`
 	Label            string                   `json:"label,omitempty"`
 	Request          interface{}              `json:"request,omitempty"`
+	LastN            *int                     `json:"lastN,omitempty"`
 	RTCConfiguration *webrtc.Configuration    `json:"rtcConfiguration,omitempty"`
 }
 
@@ -165,7 +177,9 @@ func getUpConns(c *webClient) []*rtpUpConnection {
 	return up
 }
 
-func addUpConn(c *webClient, id, label string, offer string) (*rtpUpConnection, bool, error) {
+// addUpConn adds an up connection.  If replace is not empty, it is the id
+// of a connection that is about to be closed.
+func addUpConn(c *webClient, id, label, username string, offer string, replace string) (*rtpUpConnection, bool, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -181,7 +195,21 @@ func addUpConn(c *webClient, id, label string, offer string) (*rtpUpConnection,
 		return old, false, nil
 	}
 
-	conn, err := newUpConn(c, id, label, offer)
+	if c.group != nil && !isRelay(c) {
+		quota := c.group.Description().GetQuota(c.username)
+		n := len(c.up)
+		if replace != "" && c.up[replace] != nil {
+			n--
+		}
+		if quota.MaxUpStreams > 0 && n >= quota.MaxUpStreams {
+			return nil, false, group.UserError(fmt.Sprintf(
+				"you may not publish more than %v streams",
+				quota.MaxUpStreams,
+			))
+		}
+	}
+
+	conn, err := newUpConn(c, id, label, username, offer)
 	if err != nil {
 		return nil, false, err
 	}
@@ -292,6 +320,17 @@ func addDownConn(c *webClient, remote conn.Up) (*rtpDownConnection, bool, error)
 		return down, false, nil
 	}
 
+	if c.group != nil && !isRelay(c) {
+		quota := c.group.Description().GetQuota(c.username)
+		if quota.MaxDownStreams > 0 &&
+			len(c.down) >= quota.MaxDownStreams {
+			return nil, false, group.UserError(fmt.Sprintf(
+				"you may not receive more than %v streams",
+				quota.MaxDownStreams,
+			))
+		}
+	}
+
 	down, err := newDownConn(c, id, remote)
 	if err != nil {
 		return nil, false, err
@@ -351,31 +390,28 @@ func delDownConnHelper(c *webClient, id string) *rtpDownConnection {
 	return conn
 }
 
-var errUnexpectedTrackType = errors.New("unexpected track type, this shouldn't happen")
-
-func addDownTrackUnlocked(conn *rtpDownConnection, remoteTrack *rtpUpTrack) error {
+func addDownTrackUnlocked(conn *rtpDownConnection, remoteTrack conn.UpTrack) error {
 	for _, t := range conn.tracks {
-		tt, ok := t.remote.(*rtpUpTrack)
-		if !ok {
-			return errUnexpectedTrackType
-		}
-		if tt == remoteTrack {
+		if t.remote == remoteTrack {
 			return os.ErrExist
 		}
 	}
 
-	id := remoteTrack.track.ID()
-	if id == "" {
-		log.Println("Got track with empty id")
-		id = remoteTrack.track.RID()
+	var id, msid string
+	if rt, ok := remoteTrack.(*rtpUpTrack); ok {
+		id = rt.track.ID()
+		if id == "" {
+			log.Println("Got track with empty id")
+			id = rt.track.RID()
+		}
+		msid = rt.track.StreamID()
+		if msid == "" || msid == "-" {
+			log.Println("Got track with empty msid")
+			msid = rt.conn.Label()
+		}
 	}
 	if id == "" {
-		id = remoteTrack.track.Kind().String()
-	}
-	msid := remoteTrack.track.StreamID()
-	if msid == "" || msid == "-" {
-		log.Println("Got track with empty msid")
-		msid = remoteTrack.conn.Label()
+		id = remoteTrack.Kind().String()
 	}
 	if msid == "" {
 		msid = "dummy"
@@ -462,25 +498,17 @@ func delDownTrackUnlocked(conn *rtpDownConnection, track *rtpDownTrack) error {
 	return os.ErrNotExist
 }
 
-func replaceTracks(conn *rtpDownConnection, remote []conn.UpTrack, limitSid bool) (bool, error) {
-	conn.mu.Lock()
-	defer conn.mu.Unlock()
+func replaceTracks(down *rtpDownConnection, remote []conn.UpTrack, limitSid bool) (bool, error) {
+	down.mu.Lock()
+	defer down.mu.Unlock()
 
-	var add []*rtpUpTrack
+	var add []conn.UpTrack
 	var del []*rtpDownTrack
 
 outer:
-	for _, rtrack := range remote {
-		rt, ok := rtrack.(*rtpUpTrack)
-		if !ok {
-			return false, errUnexpectedTrackType
-		}
-		for _, track := range conn.tracks {
-			rt2, ok := track.remote.(*rtpUpTrack)
-			if !ok {
-				return false, errUnexpectedTrackType
-			}
-			if rt == rt2 {
+	for _, rt := range remote {
+		for _, track := range down.tracks {
+			if rt == track.remote {
 				continue outer
 			}
 		}
@@ -488,17 +516,9 @@ outer:
 	}
 
 outer2:
-	for _, track := range conn.tracks {
-		rt, ok := track.remote.(*rtpUpTrack)
-		if !ok {
-			return false, errUnexpectedTrackType
-		}
-		for _, rtrack := range remote {
-			rt2, ok := rtrack.(*rtpUpTrack)
-			if !ok {
-				return false, errUnexpectedTrackType
-			}
-			if rt == rt2 {
+	for _, track := range down.tracks {
+		for _, rt := range remote {
+			if rt == track.remote {
 				continue outer2
 			}
 		}
@@ -506,7 +526,7 @@ outer2:
 	}
 
 	defer func() {
-		for _, t := range conn.tracks {
+		for _, t := range down.tracks {
 			layer := t.getLayerInfo()
 			layer.limitSid = limitSid
 			if limitSid {
@@ -521,14 +541,14 @@ outer2:
 	}
 
 	for _, t := range del {
-		err := delDownTrackUnlocked(conn, t)
+		err := delDownTrackUnlocked(down, t)
 		if err != nil {
 			return false, err
 		}
 	}
 
 	for _, rt := range add {
-		err := addDownTrackUnlocked(conn, rt)
+		err := addDownTrackUnlocked(down, rt)
 		if err != nil {
 			return false, err
 		}
@@ -586,8 +606,8 @@ func sendICE(c *webClient, id string, candidate *webrtc.ICECandidate) error {
 	})
 }
 
-func gotOffer(c *webClient, id, label string, sdp string, replace string) error {
-	up, _, err := addUpConn(c, id, label, sdp)
+func gotOffer(c *webClient, id, label, username string, sdp string, replace string) error {
+	up, _, err := addUpConn(c, id, label, username, sdp, replace)
 	if err != nil {
 		return err
 	}
@@ -755,7 +775,7 @@ func requestConns(target group.Client, g *group.Group, id string) {
 	}
 }
 
-func requestedTracks(c *webClient, requested []string, tracks []conn.UpTrack) ([]conn.UpTrack, bool) {
+func requestedTracks(requested []string, tracks []conn.UpTrack) ([]conn.UpTrack, bool) {
 	if len(requested) == 0 {
 		return nil, false
 	}
@@ -815,6 +835,233 @@ func requestedTracks(c *webClient, requested []string, tracks []conn.UpTrack) ([
 	return ts, limitSid
 }
 
+// recordingMu serialises starting recording, in order to avoid starting
+// two disk writers in a single group.
+var recordingMu sync.Mutex
+
+// startRecording starts recording group g.  It returns false if the
+// group is already being recorded.
+func startRecording(g *group.Group) (bool, error) {
+	recordingMu.Lock()
+	defer recordingMu.Unlock()
+
+	for _, cc := range g.GetClients(nil) {
+		_, ok := cc.(*diskwriter.Client)
+		if ok {
+			return false, nil
+		}
+	}
+	disk, err := diskwriter.New(g)
+	if err != nil {
+		return false, err
+	}
+	_, err = group.AddClient(g.Name(), disk,
+		group.ClientCredentials{
+			System: true,
+		},
+	)
+	if err != nil {
+		disk.Close()
+		return false, err
+	}
+	requestConns(disk, g, "")
+	return true, nil
+}
+
+// stopRecording stops all disk writers in group g.
+func stopRecording(g *group.Group) {
+	for _, cc := range g.GetClients(nil) {
+		disk, ok := cc.(*diskwriter.Client)
+		if ok {
+			disk.Close()
+			group.DelClient(disk)
+		}
+	}
+}
+
+// AutoStart starts the system clients, such as the disk writer and the
+// audio mixer, that are configured to start when a stream is published
+// in group g.  It is called by stream sources implemented outside this
+// package.
+func AutoStart(g *group.Group) {
+	autoRecord(g)
+	autoMix(g)
+}
+
+// autoRecord starts recording group g if automatic recording is enabled.
+func autoRecord(g *group.Group) {
+	desc := g.Description()
+	if !desc.AutoRecord || !desc.AllowRecording {
+		return
+	}
+	if !g.ClaimAutoRecord() {
+		return
+	}
+	_, err := startRecording(g)
+	if err != nil {
+		log.Printf("Automatic recording: %v", err)
+		g.WallOps("Automatic recording: " + err.Error())
+	}
+}
+
+var mixerMu sync.Mutex
+var mixerUnavailable sync.Once
+
+// autoMix starts the audio mixer of group g if audio mixing is enabled.
+func autoMix(g *group.Group) {
+	if !g.Description().AudioMixing {
+		return
+	}
+
+	mixerMu.Lock()
+	defer mixerMu.Unlock()
+
+	for _, cc := range g.GetClients(nil) {
+		if _, ok := cc.(*mixer.Client); ok {
+			return
+		}
+	}
+
+	m, err := mixer.New(g)
+	if errors.Is(err, mixer.ErrNotAvailable) {
+		mixerUnavailable.Do(func() {
+			log.Printf("Audio mixing: %v", err)
+		})
+		return
+	} else if err != nil {
+		log.Printf("Audio mixing: %v", err)
+		return
+	}
+	_, err = group.AddClient(g.Name(), m,
+		group.ClientCredentials{
+			System: true,
+		},
+	)
+	if err != nil {
+		m.Close()
+		log.Printf("Audio mixing: %v", err)
+		return
+	}
+	requestConns(m, g, "")
+
+	// push the streams again, without the audio tracks that are now
+	// being mixed
+	for _, cc := range g.GetClients(m) {
+		if !slices.Contains(cc.Permissions(), "system") {
+			requestConns(cc, g, "")
+		}
+	}
+}
+
+// playbackMu serialises starting playback, in order to avoid starting
+// two players in a single group.
+var playbackMu sync.Mutex
+
+// GetPlayer returns the player of group g, or nil if no file is being
+// played.
+func GetPlayer(g *group.Group) *player.Client {
+	for _, cc := range g.GetClients(nil) {
+		p, ok := cc.(*player.Client)
+		if ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// StartPlayback starts playing the given file from the recordings
+// directory of group g.
+func StartPlayback(g *group.Group, file string, position time.Duration) error {
+	playbackMu.Lock()
+	defer playbackMu.Unlock()
+
+	if GetPlayer(g) != nil {
+		return group.UserError("already playing")
+	}
+
+	p, err := player.New(g, file)
+	if err != nil {
+		return err
+	}
+	_, err = group.AddClient(g.Name(), p,
+		group.ClientCredentials{
+			System: true,
+		},
+	)
+	if err != nil {
+		p.Close()
+		return err
+	}
+	err = p.Start(position)
+	if err != nil {
+		p.Stop()
+		return err
+	}
+	AutoStart(g)
+	return nil
+}
+
+// StopPlayback stops playback in group g.  It returns false if no file
+// was being played.
+func StopPlayback(g *group.Group) bool {
+	p := GetPlayer(g)
+	if p == nil {
+		return false
+	}
+	p.Stop()
+	return true
+}
+
+// playbackAction implements the playback group actions.  The value of
+// "play" is a file name, or nil in order to resume playback; the value
+// of "seek" is a position in seconds.
+func playbackAction(g *group.Group, kind string, value interface{}) error {
+	if kind == "play" && value != nil {
+		file, ok := value.(string)
+		if !ok {
+			return group.UserError("bad value in play")
+		}
+		return StartPlayback(g, file, 0)
+	}
+
+	p := GetPlayer(g)
+	if p == nil {
+		return group.UserError("not playing")
+	}
+	switch kind {
+	case "play":
+		return p.Resume()
+	case "pause":
+		return p.Pause()
+	case "seek":
+		position, ok := value.(float64)
+		if !ok {
+			return group.UserError("bad value in seek")
+		}
+		return p.Seek(time.Duration(position * float64(time.Second)))
+	case "unplay":
+		p.Stop()
+		return nil
+	default:
+		return group.UserError("unknown playback action")
+	}
+}
+
+// playbackError converts an error returned by playbackAction into an
+// error suitable for sending to the client.  Internal errors are logged
+// and replaced with a generic message.
+func playbackError(groupname string, err error) group.UserError {
+	var uerr group.UserError
+	if errors.As(err, &uerr) {
+		return uerr
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return group.UserError("no such file")
+	}
+	log.Printf("Playback in %v: %v", groupname, err)
+	return group.UserError("playback failed")
+}
+
 func (c *webClient) PushConn(g *group.Group, id string, up conn.Up, tracks []conn.UpTrack, replace string) error {
 	c.action(pushConnAction{g, id, up, tracks, replace})
 	return nil
@@ -827,7 +1074,11 @@ func readMessage(conn *websocket.Conn, m *clientMessage) error {
 	}
 	defer conn.SetReadDeadline(time.Time{})
 
-	return conn.ReadJSON(&m)
+	err = conn.ReadJSON(&m)
+	if err == nil {
+		websocketStats.received.Add(1)
+	}
+	return err
 }
 
 const maxWSMessageSize = 1024 * 1024
@@ -868,6 +1119,7 @@ func StartClient(conn *websocket.Conn, addr net.Addr) (err error) {
 	c := &webClient{
 		addr:    addr,
 		id:      m.Id,
+		lastN:   -1,
 		actions: unbounded.New[any](),
 		done:    make(chan struct{}),
 	}
@@ -907,6 +1159,12 @@ type requestConnsAction struct {
 	id     string
 }
 
+type speakersAction struct {
+	group    *group.Group
+	previous []string
+	current  []string
+}
+
 type connectionFailedAction struct {
 	id string
 }
@@ -931,6 +1189,37 @@ type joinedAction struct {
 	kind  string
 }
 
+type pushLobbyAction struct {
+	group string
+	lobby []group.LobbyEntry
+}
+
+type admitAction struct{}
+
+type pushPollAction struct {
+	group string
+	poll  group.Poll
+}
+
+type pushObserversAction struct {
+	group string
+	count int
+}
+
+type pushHandsAction struct {
+	group string
+	hands group.Hands
+}
+
+type rejectAction struct {
+	message string
+}
+
+type moveAction struct {
+	from  string
+	group string
+}
+
 type kickAction struct {
 	id       string
 	username *string
@@ -968,14 +1257,27 @@ func clientLoop(c *webClient, ws *websocket.Conn, versionError bool) error {
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
+	var id string
+	if c.relay != nil {
+		// we are the websocket client, announce our id
+		id = c.id
+	}
 	err := c.write(clientMessage{
 		Type:    "handshake",
 		Version: []string{protocolVersion},
+		Id:      id,
 	})
 	if err != nil {
 		return err
 	}
 
+	if c.relay != nil {
+		err := relayJoin(c)
+		if err != nil {
+			return err
+		}
+	}
+
 	if versionError {
 		c.write(clientMessage{
 			Type:       "usermessage",
@@ -997,7 +1299,12 @@ func clientLoop(c *webClient, ws *websocket.Conn, versionError bool) error {
 			switch m := m.(type) {
 			case clientMessage:
 				readTime = time.Now()
-				err := handleClientMessage(c, m)
+				var err error
+				if c.relay != nil {
+					err = handleRelayMessage(c, m)
+				} else {
+					err = handleClientMessage(c, m)
+				}
 				if err != nil {
 					return err
 				}
@@ -1051,7 +1358,18 @@ func pushDownConn(c *webClient, id string, up conn.Up, tracks []conn.UpTrack, re
 				req = c.requested[""]
 			}
 		}
-		requested, limitSid = requestedTracks(c, req, tracks)
+		full := true
+		if n := c.getLastN(); n > 0 {
+			id, _ := up.User()
+			full = slices.Contains(
+				lastNSpeakers(c.group.RecentSpeakers(), n), id,
+			)
+		}
+		if full {
+			requested, limitSid = requestedTracks(req, tracks)
+		} else {
+			requested, limitSid = downgradedTracks(req, tracks)
+		}
 	}
 
 	if replace != "" {
@@ -1078,6 +1396,9 @@ func pushDownConn(c *webClient, id string, up conn.Up, tracks []conn.UpTrack, re
 		if errors.Is(err, os.ErrClosed) {
 			return nil
 		}
+		if _, ok := err.(group.UserError); ok {
+			return c.error(err)
+		}
 		return err
 	}
 	done, err := replaceTracks(down, requested, limitSid)
@@ -1101,7 +1422,17 @@ func handleAction(c *webClient, a any) error {
 			log.Printf("Got connectsions for wrong group")
 			return nil
 		}
-		return pushDownConn(c, a.id, a.conn, a.tracks, a.replace)
+		tracks := a.tracks
+		if a.conn != nil && isRelay(c) {
+			// avoid forwarding loops
+			up, ok := a.conn.(*rtpUpConnection)
+			if ok && isRelay(up.client) {
+				return nil
+			}
+		} else if a.conn != nil {
+			tracks = mixer.Filter(c.group, a.conn, tracks)
+		}
+		return pushDownConn(c, a.id, a.conn, tracks, a.replace)
 	case requestConnsAction:
 		g := c.group
 		if g == nil || a.group != g {
@@ -1124,6 +1455,19 @@ func handleAction(c *webClient, a any) error {
 				log.Printf("PushConn: %v", err)
 			}
 		}
+	case speakersAction:
+		if c.group == nil || a.group != c.group {
+			return nil
+		}
+		n := c.getLastN()
+		if n <= 0 {
+			return nil
+		}
+		previous := lastNSpeakers(a.previous, n)
+		current := lastNSpeakers(a.current, n)
+		if !sameSpeakers(previous, current) {
+			requestConns(c, c.group, "")
+		}
 	case connectionFailedAction:
 		if down := getDownConn(c, a.id); down != nil {
 			err := negotiate(c, down, true, "")
@@ -1214,6 +1558,22 @@ func handleAction(c *webClient, a any) error {
 					return err
 				}
 			}
+			if id := g.ActiveSpeaker(); id != "" {
+				err := c.write(activeSpeakerMessage(g, id))
+				if err != nil {
+					return err
+				}
+			}
+			if lobby := g.Lobby(); len(lobby) > 0 {
+				c.action(pushLobbyAction{g.Name(), lobby})
+			}
+			hands := g.Hands()
+			if hands.Floor != nil || len(hands.Queue) > 0 {
+				c.action(pushHandsAction{g.Name(), hands})
+			}
+			for _, p := range g.Polls(false) {
+				c.action(pushPollAction{g.Name(), p})
+			}
 		}
 	case changePermissionsAction:
 		switch a.kind {
@@ -1223,6 +1583,11 @@ func handleAction(c *webClient, a any) error {
 			if g != nil && g.Description().AllowRecording {
 				c.permissions = addnew("record", c.permissions)
 			}
+			if g != nil {
+				if lobby := g.Lobby(); len(lobby) > 0 {
+					c.action(pushLobbyAction{g.Name(), lobby})
+				}
+			}
 		case "unop":
 			c.permissions = remove("op", c.permissions)
 			c.permissions = remove("record", c.permissions)
@@ -1269,17 +1634,89 @@ func handleAction(c *webClient, a any) error {
 				}
 			}
 		}
-		id := c.Id()
-		user := c.Username()
-		d := c.Data()
-		clients := g.GetClients(nil)
-		go func(clients []group.Client) {
-			for _, cc := range clients {
-				cc.PushClient(
-					g.Name(), "change", id, user, perms, d,
-				)
-			}
-		}(clients)
+		g.UpdateClient(c, c.Username(), perms, c.Data())
+	case pushLobbyAction:
+		if c.group == nil || a.group != c.group.Name() ||
+			!slices.Contains(c.permissions, "op") {
+			return nil
+		}
+		return c.write(clientMessage{
+			Type:  "lobby",
+			Group: a.group,
+			Value: a.lobby,
+		})
+	case pushPollAction:
+		if c.group == nil || a.group != c.group.Name() {
+			return nil
+		}
+		return c.write(clientMessage{
+			Type:  "poll",
+			Group: a.group,
+			Value: a.poll,
+		})
+	case pushObserversAction:
+		if c.group == nil || a.group != c.group.Name() {
+			return nil
+		}
+		return c.write(clientMessage{
+			Type:  "observers",
+			Group: a.group,
+			Value: a.count,
+		})
+	case pushHandsAction:
+		if c.group == nil || a.group != c.group.Name() {
+			return nil
+		}
+		return c.write(clientMessage{
+			Type:  "hands",
+			Group: a.group,
+			Value: a.hands,
+		})
+	case admitAction:
+		if c.lobby == nil || c.group != nil {
+			return nil
+		}
+		return joinGroup(c, c.lobbyJoin)
+	case rejectAction:
+		if c.lobby == nil || c.group != nil {
+			return nil
+		}
+		name := c.lobby.Name()
+		leaveLobby(c)
+		message := a.message
+		if message == "" {
+			message = "you were not admitted into the group"
+		}
+		username := c.username
+		return c.write(clientMessage{
+			Type:     "joined",
+			Kind:     "fail",
+			Group:    name,
+			Username: &username,
+			Value:    message,
+		})
+	case moveAction:
+		if c.group == nil || c.group.Name() != a.from {
+			return nil
+		}
+		username := c.username
+		var user *string
+		if username != "" {
+			user = &username
+		}
+		tok := token.AddInternal(
+			a.group, user, c.permissions, moveTokenLifetime,
+		)
+		err := c.write(clientMessage{
+			Type:     "joined",
+			Kind:     "redirect",
+			Group:    a.from,
+			Username: &username,
+			Value: relativeGroupURL(a.from, a.group) +
+				"?token=" + url.QueryEscape(tok.Token),
+		})
+		leaveGroup(c)
+		return err
 	case kickAction:
 		return group.KickError{
 			a.id, a.username, a.message,
@@ -1310,7 +1747,99 @@ func failUpConnection(c *webClient, id string, message string) error {
 	return nil
 }
 
+// joinGroup handles a request to join a group.  If the group has a lobby,
+// the client may be placed in the lobby, in which case the request is
+// repeated once an operator admits the client.
+func joinGroup(c *webClient, m clientMessage) error {
+	if c.group != nil {
+		return group.ProtocolError(
+			"cannot join multiple groups",
+		)
+	}
+	if c.lobby != nil && c.lobby.Name() != m.Group {
+		leaveLobby(c)
+	}
+	c.data = m.Data
+	g, err := group.AddClient(m.Group, c,
+		group.ClientCredentials{
+			Username: m.Username,
+			Password: m.Password,
+			Token:    m.Token,
+		},
+	)
+	if errors.Is(err, group.ErrLobby) {
+		c.lobby = group.Get(m.Group)
+		c.lobbyJoin = m
+		username := c.username
+		return c.write(clientMessage{
+			Type:     "joined",
+			Kind:     "lobby",
+			Group:    m.Group,
+			Username: &username,
+			Value:    err.Error(),
+		})
+	}
+	leaveLobby(c)
+	if err != nil {
+		var e, s string
+		var autherr *group.NotAuthorisedError
+		if errors.Is(err, group.ErrUsernameRequired) {
+			s = err.Error()
+			e = "need-username"
+		} else if errors.Is(err, group.ErrDuplicateUsername) {
+			s = err.Error()
+			e = "duplicate-username"
+		} else if errors.As(err, &autherr) {
+			s = "not authorised"
+			time.Sleep(200 * time.Millisecond)
+			log.Printf("Join group: %v", err)
+		} else if errors.Is(err, os.ErrNotExist) {
+			s = "group does not exist"
+		} else if _, ok := err.(group.UserError); ok {
+			s = err.Error()
+		} else {
+			s = "internal server error"
+			log.Printf("Join group: %v", err)
+		}
+		username := c.username
+		return c.write(clientMessage{
+			Type:     "joined",
+			Kind:     "fail",
+			Error:    e,
+			Group:    m.Group,
+			Username: &username,
+			Value:    s,
+		})
+	}
+	if redirect := g.Description().Redirect; redirect != "" {
+		// We normally redirect at the HTTP level, but the group
+		// description could have been edited in the meantime.
+		username := c.username
+		return c.write(clientMessage{
+			Type:     "joined",
+			Kind:     "redirect",
+			Group:    m.Group,
+			Username: &username,
+			Value:    redirect,
+		})
+	}
+	c.group = g
+	autoCascade(g)
+	return nil
+}
+
+// leaveLobby removes the client from the lobby it is waiting in, if any.
+func leaveLobby(c *webClient) {
+	if c.lobby == nil {
+		return
+	}
+	c.lobby.LeaveLobby(c)
+	c.lobby = nil
+	c.lobbyJoin = clientMessage{}
+}
+
 func leaveGroup(c *webClient) {
+	leaveLobby(c)
 	if c.group == nil {
 		return
 	}
@@ -1359,6 +1888,26 @@ func (c *webClient) Kick(id string, user *string, message string) error {
 	return nil
 }
 
+func (c *webClient) PushLobby(group string, lobby []group.LobbyEntry) error {
+	c.action(pushLobbyAction{group, lobby})
+	return nil
+}
+
+func (c *webClient) PushPoll(group string, poll group.Poll) error {
+	c.action(pushPollAction{group, poll})
+	return nil
+}
+
+func (c *webClient) PushObservers(group string, count int) error {
+	c.action(pushObserversAction{group, count})
+	return nil
+}
+
+func (c *webClient) PushHands(group string, hands group.Hands) error {
+	c.action(pushHandsAction{group, hands})
+	return nil
+}
+
 func (c *webClient) Joined(group, kind string) error {
 	c.action(joinedAction{group, kind})
 	return nil
@@ -1373,24 +1922,213 @@ func kickClient(g *group.Group, id string, user *string, dest string, message st
 	return client.Kick(id, user, message)
 }
 
-func handleClientMessage(c *webClient, m clientMessage) error {
-	if m.Source != "" {
-		if m.Source != c.Id() {
-			return group.ProtocolError("spoofed client id")
-		}
-	}
+// KickClient kicks the client with the given id out of a group.
+func KickClient(g *group.Group, dest string, message string) error {
+	return kickClient(g, "", nil, dest, message)
+}
 
-	if m.Type != "join" {
-		if m.Username != nil {
-			if *m.Username != c.Username() {
-				return group.ProtocolError("spoofed username")
-			}
-		}
+// admitClient admits the client with the given id from the lobby of
+// group g.
+func admitClient(g *group.Group, id string) error {
+	client, err := g.Admit(id)
+	if err != nil {
+		return err
+	}
+	target, ok := client.(*webClient)
+	if !ok {
+		return group.UserError("this is not a real user")
+	}
+	target.action(admitAction{})
+	return nil
+}
+
+// rejectClient removes the client with the given id from the lobby of
+// group g, and informs it that it has not been admitted.
+func rejectClient(g *group.Group, id string, message string) error {
+	client, err := g.Reject(id)
+	if err != nil {
+		return err
+	}
+	target, ok := client.(*webClient)
+	if !ok {
+		return group.UserError("this is not a real user")
+	}
+	target.action(rejectAction{message})
+	return nil
+}
+
+// grantFloor gives the floor to the client with the given id, or to the
+// first client in the speaking queue if id is empty.  The client is given
+// the present permission for the duration of its turn.
+func grantFloor(g *group.Group, id string) error {
+	turn, ended, err := g.GrantFloor(id)
+	if err != nil {
+		return err
+	}
+	endTurn(ended)
+	if turn.Present {
+		c, ok := turn.Client.(*webClient)
+		if ok {
+			c.action(changePermissionsAction{"present"})
+		}
+	}
+	return nil
+}
+
+// endTurn revokes the present permission that was given to a client for
+// the duration of its turn.
+func endTurn(turn *group.Turn) {
+	if turn == nil || !turn.Present {
+		return
+	}
+	c, ok := turn.Client.(*webClient)
+	if ok {
+		c.action(changePermissionsAction{"unpresent"})
+	}
+}
+
+// moveTokenLifetime is the lifetime of the tokens that allow a client
+// that has been moved to a different group to join that group.  These
+// tokens end up in the browser's history, so they are short-lived.
+const moveTokenLifetime = time.Minute
+
+// relativeGroupURL returns the URL of group to relative to the URL of
+// group from.  One of the groups must be a subgroup of the other.
+func relativeGroupURL(from, to string) string {
+	if strings.HasPrefix(to, from+"/") {
+		return url.PathEscape(strings.TrimPrefix(to, from+"/")) + "/"
+	}
+	n := strings.Count(strings.TrimPrefix(from, to+"/"), "/") + 1
+	return strings.Repeat("../", n)
+}
+
+// openBreakouts opens n breakout rooms for group g, and moves clients
+// into them.  Assign maps client ids to room numbers, starting at 1; if
+// it is empty, all clients that are not operators are distributed
+// randomly across the rooms.
+func openBreakouts(g *group.Group, n int, assign map[string]int) error {
+	clients := make(map[string]*webClient, len(assign))
+	for id, room := range assign {
+		if room < 1 || room > n {
+			return group.UserError("bad breakout room number")
+		}
+		c, ok := g.GetClient(id).(*webClient)
+		if !ok {
+			return group.UserError("no such user")
+		}
+		clients[id] = c
+	}
+
+	rooms, err := group.OpenBreakouts(g, n)
+	if err != nil {
+		return err
+	}
+
+	if len(assign) > 0 {
+		for id, c := range clients {
+			c.action(moveAction{g.Name(), rooms[assign[id]-1]})
+		}
+		return nil
+	}
+
+	var cs []*webClient
+	for _, cc := range g.GetClients(nil) {
+		c, ok := cc.(*webClient)
+		if ok && !slices.Contains(c.Permissions(), "op") {
+			cs = append(cs, c)
+		}
+	}
+	rand.Shuffle(len(cs), func(i, j int) {
+		cs[i], cs[j] = cs[j], cs[i]
+	})
+	for i, c := range cs {
+		c.action(moveAction{g.Name(), rooms[i%n]})
+	}
+	return nil
+}
+
+// closeBreakouts closes the breakout rooms of group g, and moves the
+// clients that are in them back into g.
+func closeBreakouts(g *group.Group) error {
+	rooms, err := group.CloseBreakouts(g)
+	if err != nil {
+		return err
+	}
+	for _, name := range rooms {
+		sg := group.Get(name)
+		if sg == nil {
+			continue
+		}
+		for _, cc := range sg.GetClients(nil) {
+			c, ok := cc.(*webClient)
+			if ok {
+				c.action(moveAction{name, g.Name()})
+			}
+		}
+	}
+	return nil
+}
+
+// ChangePermissions changes the permissions of the client with the given
+// id.  Kind is one of "op", "unop", "present", "unpresent", "shutup" or
+// "unshutup".
+func ChangePermissions(g *group.Group, dest string, kind string) error {
+	switch kind {
+	case "op", "unop", "present", "unpresent", "shutup", "unshutup":
+	default:
+		return group.ErrUnknownPermission
+	}
+	t := g.GetClient(dest)
+	if t == nil {
+		return group.UserError("no such user")
+	}
+	target, ok := t.(*webClient)
+	if !ok {
+		return group.UserError("this is not a real user")
+	}
+	target.action(changePermissionsAction{kind})
+	return nil
+}
+
+func handleClientMessage(c *webClient, m clientMessage) error {
+	// relays forward signalling messages on behalf of the clients of
+	// the remote server
+	forwarded := false
+	if isRelay(c) {
+		var err error
+		forwarded, err = checkRelayMessage(m)
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.Source != "" && !forwarded {
+		if m.Source != c.Id() {
+			return group.ProtocolError("spoofed client id")
+		}
+	}
+
+	if m.Type != "join" && !forwarded {
+		if m.Username != nil {
+			if *m.Username != c.Username() {
+				return group.ProtocolError("spoofed username")
+			}
+		}
 	}
 
 	switch m.Type {
 	case "join":
 		if m.Kind == "leave" {
+			if c.lobby != nil && c.lobby.Name() == m.Group {
+				leaveLobby(c)
+				username := c.username
+				return c.write(clientMessage{
+					Type:     "joined",
+					Kind:     "leave",
+					Group:    m.Group,
+					Username: &username,
+				})
+			}
 			if c.group == nil || c.group.Name() != m.Group {
 				return group.UserError("you are not joined")
 			}
@@ -1402,68 +2140,15 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			return group.ProtocolError("unknown kind")
 		}
 
-		if c.group != nil {
-			return group.ProtocolError(
-				"cannot join multiple groups",
-			)
-		}
-		c.data = m.Data
-		g, err := group.AddClient(m.Group, c,
-			group.ClientCredentials{
-				Username: m.Username,
-				Password: m.Password,
-				Token:    m.Token,
-			},
-		)
-		if err != nil {
-			var e, s string
-			var autherr *group.NotAuthorisedError
-			if errors.Is(err, group.ErrUsernameRequired) {
-				s = err.Error()
-				e = "need-username"
-			} else if errors.Is(err, group.ErrDuplicateUsername) {
-				s = err.Error()
-				e = "duplicate-username"
-			} else if errors.As(err, &autherr) {
-				s = "not authorised"
-				time.Sleep(200 * time.Millisecond)
-				log.Printf("Join group: %v", err)
-			} else if errors.Is(err, os.ErrNotExist) {
-				s = "group does not exist"
-			} else if _, ok := err.(group.UserError); ok {
-				s = err.Error()
-			} else {
-				s = "internal server error"
-				log.Printf("Join group: %v", err)
-			}
-			username := c.username
-			return c.write(clientMessage{
-				Type:     "joined",
-				Kind:     "fail",
-				Error:    e,
-				Group:    m.Group,
-				Username: &username,
-				Value:    s,
-			})
-		}
-		if redirect := g.Description().Redirect; redirect != "" {
-			// We normally redirect at the HTTP level, but the group
-			// description could have been edited in the meantime.
-			username := c.username
-			return c.write(clientMessage{
-				Type:     "joined",
-				Kind:     "redirect",
-				Group:    m.Group,
-				Username: &username,
-				Value:    redirect,
-			})
-		}
-		c.group = g
+		return joinGroup(c, m)
 	case "request":
 		requested, err := parseRequested(m.Request)
 		if err != nil {
 			return err
 		}
+		if m.LastN != nil {
+			c.lastN = max(*m.LastN, -1)
+		}
 		return c.setRequested(requested)
 	case "requestStream":
 		down := getDownConn(c, m.Id)
@@ -1489,7 +2174,11 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			})
 			return c.error(group.UserError("not authorised"))
 		}
-		err := gotOffer(c, m.Id, m.Label, m.SDP, m.Replace)
+		username := ""
+		if isRelay(c) && m.Username != nil {
+			username = *m.Username
+		}
+		err := gotOffer(c, m.Id, m.Label, username, m.SDP, m.Replace)
 		if err != nil {
 			log.Printf("gotOffer: %v", err)
 			return failUpConnection(c, m.Id, err.Error())
@@ -1650,14 +2339,7 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 					))
 				}
 			}
-			g.ClearChatHistory(id, userId)
-			m := clientMessage{
-				Type:       "usermessage",
-				Kind:       "clearchat",
-				Value:      m.Value,
-				Privileged: true,
-			}
-			err := broadcast(g.GetClients(nil), m)
+			err := ClearChatHistory(g, id, userId)
 			if err != nil {
 				log.Printf("broadcast(clearchat): %v", err)
 			}
@@ -1675,36 +2357,46 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			if !slices.Contains(c.permissions, "record") {
 				return c.error(group.UserError("not authorised"))
 			}
-			for _, cc := range g.GetClients(c) {
-				_, ok := cc.(*diskwriter.Client)
-				if ok {
-					return c.error(group.UserError("already recording"))
-				}
-			}
-			disk, err := diskwriter.New(g)
+			started, err := startRecording(g)
 			if err != nil {
 				return c.error(err)
 			}
-			_, err = group.AddClient(g.Name(), disk,
-				group.ClientCredentials{
-					System: true,
-				},
-			)
-			if err != nil {
-				disk.Close()
-				return c.error(err)
+			if !started {
+				return c.error(group.UserError("already recording"))
 			}
-			requestConns(disk, c.group, "")
 		case "unrecord":
 			if !slices.Contains(c.permissions, "record") {
 				return c.error(group.UserError("not authorised"))
 			}
-			for _, cc := range g.GetClients(c) {
-				disk, ok := cc.(*diskwriter.Client)
-				if ok {
-					disk.Close()
-					group.DelClient(disk)
-				}
+			// don't let the next stream restart recording
+			g.InhibitAutoRecord()
+			stopRecording(g)
+		case "admit", "reject":
+			if !slices.Contains(c.permissions, "op") {
+				return c.error(group.UserError("not authorised"))
+			}
+			id, ok := m.Value.(string)
+			if !ok {
+				return c.error(group.UserError(
+					"Bad value in " + m.Kind,
+				))
+			}
+			var err error
+			if m.Kind == "admit" {
+				err = admitClient(g, id)
+			} else {
+				err = rejectClient(g, id, "")
+			}
+			if err != nil {
+				return c.error(err)
+			}
+		case "play", "pause", "seek", "unplay":
+			if !slices.Contains(c.permissions, "op") {
+				return c.error(group.UserError("not authorised"))
+			}
+			err := playbackAction(g, m.Kind, m.Value)
+			if err != nil {
+				return c.error(playbackError(g.Name(), err))
 			}
 		case "subgroups":
 			if !slices.Contains(c.permissions, "op") {
@@ -1727,6 +2419,75 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 				Time:     time.Now().Format(time.RFC3339),
 				Value:    s,
 			})
+		case "breakout":
+			if !slices.Contains(c.permissions, "op") {
+				return c.error(group.UserError("not authorised"))
+			}
+			n, assign, err := parseBreakout(m.Value)
+			if err != nil {
+				return c.error(err)
+			}
+			err = openBreakouts(g, n, assign)
+			if err != nil {
+				return c.error(err)
+			}
+		case "unbreakout":
+			if !slices.Contains(c.permissions, "op") {
+				return c.error(group.UserError("not authorised"))
+			}
+			parent := g
+			if name := group.BreakoutParent(g.Name()); name != "" {
+				parent = group.Get(name)
+				if parent == nil {
+					return c.error(group.UserError(
+						"no such group",
+					))
+				}
+			}
+			err := closeBreakouts(parent)
+			if err != nil {
+				return c.error(err)
+			}
+		case "poll":
+			if !slices.Contains(c.permissions, "op") {
+				return c.error(group.UserError("not authorised"))
+			}
+			p, err := parsePoll(m.Value)
+			if err != nil {
+				return c.error(err)
+			}
+			_, err = g.CreatePoll(p, c.username)
+			if err != nil {
+				return c.error(err)
+			}
+		case "closepoll":
+			if !slices.Contains(c.permissions, "op") {
+				return c.error(group.UserError("not authorised"))
+			}
+			id, ok := m.Value.(string)
+			if !ok {
+				return c.error(group.UserError(
+					"bad value in closepoll",
+				))
+			}
+			err := g.ClosePoll(id)
+			if err != nil {
+				return c.error(err)
+			}
+		case "deletepoll":
+			if !slices.Contains(c.permissions, "op") {
+				return c.error(group.UserError("not authorised"))
+			}
+			id, ok := m.Value.(string)
+			if !ok {
+				return c.error(group.UserError(
+					"bad value in deletepoll",
+				))
+			}
+			err := g.DeletePoll(id)
+			if err != nil {
+				return c.error(err)
+			}
 		case "setdata":
 			if !slices.Contains(c.permissions, "op") {
 				return c.error(group.UserError("not authorised"))
@@ -1896,17 +2657,58 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 			if !slices.Contains(c.permissions, "op") {
 				return c.error(group.UserError("not authorised"))
 			}
-			t := g.GetClient(m.Dest)
-			if t == nil {
-				return c.error(group.UserError("no suck user"))
+			err := ChangePermissions(g, m.Dest, m.Kind)
+			if err != nil {
+				return c.error(err)
+			}
+		case "raisehand":
+			if m.Dest != "" && m.Dest != c.id {
+				return c.error(group.UserError("not authorised"))
+			}
+			err := g.RaiseHand(c)
+			if err != nil {
+				return c.error(err)
 			}
-			target, ok := t.(*webClient)
-			if !ok {
-				return c.error(group.UserError(
-					"this is not a real user",
-				))
+		case "lowerhand":
+			if m.Dest != "" && m.Dest != c.id &&
+				!slices.Contains(c.permissions, "op") {
+				return c.error(group.UserError("not authorised"))
+			}
+			id := m.Dest
+			if id == "" {
+				id = c.id
+			}
+			err := g.LowerHand(id)
+			if err != nil {
+				return c.error(err)
+			}
+		case "floor":
+			if !slices.Contains(c.permissions, "op") {
+				return c.error(group.UserError("not authorised"))
+			}
+			err := grantFloor(g, m.Dest)
+			if err != nil {
+				return c.error(err)
+			}
+		case "unfloor":
+			if m.Dest != c.id &&
+				!slices.Contains(c.permissions, "op") {
+				return c.error(group.UserError("not authorised"))
+			}
+			turn, err := g.EndTurn(m.Dest)
+			if err != nil {
+				return c.error(err)
+			}
+			endTurn(turn)
+		case "vote":
+			id, choices, err := parseVote(m.Value)
+			if err != nil {
+				return c.error(err)
+			}
+			err = g.Vote(id, c.username, choices)
+			if err != nil {
+				return c.error(err)
 			}
-			target.action(changePermissionsAction{m.Kind})
 		case "identify":
 			if !slices.Contains(c.permissions, "op") {
 				return c.error(group.UserError("not authorised"))
@@ -1973,18 +2775,8 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 					c.data[k] = v
 				}
 			}
-			id := c.Id()
-			user := c.Username()
-			perms := c.Permissions()
-			data = c.Data()
-			go func(clients []group.Client) {
-				for _, cc := range clients {
-					cc.PushClient(
-						g.Name(), "change",
-						id, user, perms, data,
-					)
-				}
-			}(g.GetClients(nil))
+			perms := append([]string(nil), c.permissions...)
+			g.UpdateClient(c, c.Username(), perms, c.Data())
 		default:
 			return group.UserError("unknown user action")
 		}
@@ -2001,6 +2793,123 @@ func handleClientMessage(c *webClient, m clientMessage) error {
 	return nil
 }
 
+// parsePoll parses the value of a poll group action, which is
+// a dictionary with the question, the options, and optionally the fields
+// anonymous, multiple and deadline.
+func parsePoll(value interface{}) (group.Poll, error) {
+	var p group.Poll
+	data, ok := value.(map[string]interface{})
+	if !ok || data == nil {
+		return p, group.UserError("bad value in poll")
+	}
+	p.Question, ok = data["question"].(string)
+	if !ok {
+		return p, group.UserError("bad question in poll")
+	}
+	options, ok := data["options"].([]interface{})
+	if !ok {
+		return p, group.UserError("bad options in poll")
+	}
+	for _, o := range options {
+		option, ok := o.(string)
+		if !ok {
+			return p, group.UserError("bad options in poll")
+		}
+		p.Options = append(p.Options, option)
+	}
+	parseBool := func(key string) (bool, error) {
+		v := data[key]
+		if v == nil {
+			return false, nil
+		}
+		vv, ok := v.(bool)
+		if !ok {
+			return false, group.UserError("bad " + key + " in poll")
+		}
+		return vv, nil
+	}
+	var err error
+	p.Anonymous, err = parseBool("anonymous")
+	if err != nil {
+		return p, err
+	}
+	p.Multiple, err = parseBool("multiple")
+	if err != nil {
+		return p, err
+	}
+	if data["deadline"] != nil {
+		d, ok := data["deadline"].(string)
+		if !ok {
+			return p, group.UserError("bad deadline in poll")
+		}
+		deadline, err := time.Parse(time.RFC3339, d)
+		if err != nil {
+			return p, group.UserError("bad deadline in poll")
+		}
+		p.Deadline = &deadline
+	}
+	return p, nil
+}
+
+// parseVote parses the value of a vote user action, which is
+// a dictionary with the id of a poll and the indices of the chosen
+// options.
+func parseVote(value interface{}) (string, []int, error) {
+	data, ok := value.(map[string]interface{})
+	if !ok || data == nil {
+		return "", nil, group.UserError("bad value in vote")
+	}
+	id, ok := data["poll"].(string)
+	if !ok {
+		return "", nil, group.UserError("bad poll in vote")
+	}
+	cs, ok := data["choices"].([]interface{})
+	if !ok {
+		return "", nil, group.UserError("bad choices in vote")
+	}
+	choices := make([]int, 0, len(cs))
+	for _, c := range cs {
+		choice, ok := c.(float64)
+		if !ok || choice != float64(int(choice)) {
+			return "", nil, group.UserError("bad choices in vote")
+		}
+		choices = append(choices, int(choice))
+	}
+	return id, choices, nil
+}
+
+// parseBreakout parses the value of a breakout group action, which is a
+// dictionary with the number of rooms and an optional mapping from client
+// ids to room numbers.
+func parseBreakout(value interface{}) (int, map[string]int, error) {
+	data, ok := value.(map[string]interface{})
+	if !ok || data == nil {
+		return 0, nil, group.UserError("bad value in breakout")
+	}
+	rooms, ok := data["rooms"].(float64)
+	if !ok || rooms != float64(int(rooms)) {
+		return 0, nil, group.UserError("bad number of breakout rooms")
+	}
+	var assign map[string]int
+	if data["assign"] != nil {
+		a, ok := data["assign"].(map[string]interface{})
+		if !ok {
+			return 0, nil, group.UserError("bad value in breakout")
+		}
+		assign = make(map[string]int, len(a))
+		for id, v := range a {
+			room, ok := v.(float64)
+			if !ok || room != float64(int(room)) {
+				return 0, nil, group.UserError(
+					"bad breakout room number",
+				)
+			}
+			assign[id] = int(room)
+		}
+	}
+	return int(rooms), assign, nil
+}
+
 func parseStatefulToken(value interface{}) (*token.Stateful, error) {
 	data, ok := value.(map[string]interface{})
 	if !ok || data == nil {
@@ -2076,7 +2985,12 @@ func parseStatefulToken(value interface{}) (*token.Stateful, error) {
 	if g != nil {
 		gg = *g
 	}
-	p, err := parseStringList("permissions")
+	var p []string
+	if role, ok := data["permissions"].(string); ok {
+		p, err = group.ExpandPermissions(gg, role)
+	} else {
+		p, err = parseStringList("permissions")
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -2111,6 +3025,7 @@ func clientReader(conn *websocket.Conn, read chan<- interface{}, done <-chan str
 				return
 			}
 		}
+		websocketStats.received.Add(1)
 		select {
 		case read <- m:
 		case <-done:
@@ -2142,11 +3057,13 @@ func clientWriter(conn *websocket.Conn, ch <-chan interface{}, done chan<- struc
 			if err != nil {
 				return
 			}
+			websocketStats.sent.Add(1)
 		case []byte:
 			err := conn.WriteMessage(websocket.TextMessage, m)
 			if err != nil {
 				return
 			}
+			websocketStats.sent.Add(1)
 		case closeMessage:
 			if m.data != nil {
 				conn.WriteMessage(
@@ -2183,6 +3100,9 @@ func (c *webClient) action(a interface{}) {
 }
 
 func (c *webClient) write(m clientMessage) error {
+	if c.relay != nil && !relayMessages[m.Type] {
+		return nil
+	}
 	select {
 	case c.writeCh <- m:
 		return nil
@@ -2191,6 +3111,26 @@ func (c *webClient) write(m clientMessage) error {
 	}
 }
 
+// ClearChatHistory clears the chat history of a group, as described in
+// group.ClearChatHistory, and notifies the clients.
+func ClearChatHistory(g *group.Group, id, userId string) error {
+	g.ClearChatHistory(id, userId)
+	var value any
+	if userId != "" {
+		v := map[string]any{"userId": userId}
+		if id != "" {
+			v["id"] = id
+		}
+		value = v
+	}
+	return broadcast(g.GetClients(nil), clientMessage{
+		Type:       "usermessage",
+		Kind:       "clearchat",
+		Value:      value,
+		Privileged: true,
+	})
+}
+
 func broadcast(cs []group.Client, m clientMessage) error {
 	b, err := json.Marshal(m)
 	if err != nil {
@@ -2198,7 +3138,7 @@ func broadcast(cs []group.Client, m clientMessage) error {
 	}
 	for _, c := range cs {
 		cc, ok := c.(*webClient)
-		if !ok {
+		if !ok || cc.relay != nil {
 			continue
 		}
 		select {
@@ -2,9 +2,13 @@ package rtpconn
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
 	"reflect"
 	"testing"
 
+	"github.com/jech/galene/group"
 	"github.com/jech/galene/token"
 )
 
@@ -50,3 +54,147 @@ func TestParseStatefulToken(t *testing.T) {
 		}
 	}
 }
+
+func TestParseBreakout(t *testing.T) {
+	good := []struct {
+		value  string
+		rooms  int
+		assign map[string]int
+	}{
+		{`{"rooms": 3}`, 3, nil},
+		{`{"rooms": 2, "assign": {"a": 1, "b": 2}}`, 2,
+			map[string]int{"a": 1, "b": 2}},
+	}
+	for _, v := range good {
+		var m interface{}
+		err := json.Unmarshal([]byte(v.value), &m)
+		if err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		rooms, assign, err := parseBreakout(m)
+		if err != nil || rooms != v.rooms ||
+			!reflect.DeepEqual(assign, v.assign) {
+			t.Errorf("parseBreakout %v: %v %v %v",
+				v.value, rooms, assign, err)
+		}
+	}
+
+	bad := []string{
+		`"a"`, `{}`, `{"rooms": "a"}`, `{"rooms": 1.5}`,
+		`{"rooms": 2, "assign": ["a"]}`,
+		`{"rooms": 2, "assign": {"a": "b"}}`,
+	}
+	for _, v := range bad {
+		var m interface{}
+		err := json.Unmarshal([]byte(v), &m)
+		if err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		_, _, err = parseBreakout(m)
+		if err == nil {
+			t.Errorf("parseBreakout %v succeeded", v)
+		}
+	}
+}
+
+func TestRelativeGroupURL(t *testing.T) {
+	urls := []struct{ from, to, url string }{
+		{"a", "a/breakout-1", "breakout-1/"},
+		{"a/b", "a/b/c", "c/"},
+		{"a/breakout-1", "a", "../"},
+		{"a/b/c", "a", "../../"},
+	}
+	for _, u := range urls {
+		v := relativeGroupURL(u.from, u.to)
+		if v != u.url {
+			t.Errorf("%v -> %v: expected %v, got %v",
+				u.from, u.to, u.url, v)
+		}
+	}
+}
+
+func TestParsePoll(t *testing.T) {
+	var m interface{}
+	err := json.Unmarshal([]byte(`{
+	    "question": "q",
+	    "options": ["a", "b"],
+	    "multiple": true,
+	    "deadline": "2030-05-03T20:24:47+02:00"
+	}`), &m)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	p, err := parsePoll(m)
+	if err != nil {
+		t.Fatalf("parsePoll: %v", err)
+	}
+	if p.Question != "q" || !reflect.DeepEqual(p.Options, []string{"a", "b"}) ||
+		p.Anonymous || !p.Multiple || p.Deadline == nil ||
+		p.Deadline.Year() != 2030 {
+		t.Errorf("Bad poll %v", p)
+	}
+
+	bad := []string{
+		`"q"`, `{"options": ["a", "b"]}`, `{"question": "q"}`,
+		`{"question": "q", "options": [1, 2]}`,
+		`{"question": "q", "options": ["a"], "anonymous": "yes"}`,
+		`{"question": "q", "options": ["a"], "deadline": "tomorrow"}`,
+	}
+	for _, v := range bad {
+		var m interface{}
+		err := json.Unmarshal([]byte(v), &m)
+		if err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		_, err = parsePoll(m)
+		if err == nil {
+			t.Errorf("parsePoll %v succeeded", v)
+		}
+	}
+}
+
+func TestParseVote(t *testing.T) {
+	var m interface{}
+	err := json.Unmarshal([]byte(`{"poll": "p", "choices": [0, 2]}`), &m)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	id, choices, err := parseVote(m)
+	if err != nil || id != "p" || !reflect.DeepEqual(choices, []int{0, 2}) {
+		t.Errorf("parseVote: %v %v %v", id, choices, err)
+	}
+
+	bad := []string{
+		`{"choices": [0]}`, `{"poll": "p"}`, `{"poll": "p", "choices": [0.5]}`,
+	}
+	for _, v := range bad {
+		var m interface{}
+		err := json.Unmarshal([]byte(v), &m)
+		if err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		_, _, err = parseVote(m)
+		if err == nil {
+			t.Errorf("parseVote %v succeeded", v)
+		}
+	}
+}
+
+func TestPlaybackError(t *testing.T) {
+	tests := []struct {
+		err error
+		msg string
+	}{
+		{group.UserError("not playing"), "not playing"},
+		{fmt.Errorf("open: %w", os.ErrNotExist), "no such file"},
+		{errors.New("/var/lib/galene/recordings: disk error"),
+			"playback failed"},
+	}
+	for _, test := range tests {
+		err := playbackError("test", test.err)
+		if string(err) != test.msg {
+			t.Errorf("playbackError(%v): expected %v, got %v",
+				test.err, test.msg, err)
+		}
+	}
+}
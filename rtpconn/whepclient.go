@@ -0,0 +1,386 @@
+package rtpconn
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+
+	"github.com/jech/galene/conn"
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/sdpfrag"
+)
+
+// ErrNoStream is returned by NewConnection when there is nothing to play.
+var ErrNoStream = errors.New("no stream available")
+
+// whepWait is the time we wait for a stream to become available after
+// a WHEP client joins a group.
+const whepWait = 2 * time.Second
+
+type whepStream struct {
+	up     conn.Up
+	tracks []conn.UpTrack
+}
+
+// WhepClient is a client that receives streams over WHEP.  It forwards
+// a single up connection, which is selected when the connection is
+// established.
+type WhepClient struct {
+	// immutable, so it may be accessed without holding mu
+	group    *group.Group
+	addr     net.Addr
+	id       string
+	token    string
+	username string
+
+	mu          sync.Mutex
+	permissions []string
+	streams     []whepStream
+	available   chan struct{}
+	connection  *rtpDownConnection
+	etag        string
+	closed      bool
+}
+
+func NewWhepClient(g *group.Group, id string, token string, addr net.Addr) *WhepClient {
+	return &WhepClient{
+		group:     g,
+		id:        id,
+		token:     token,
+		addr:      addr,
+		available: make(chan struct{}, 1),
+	}
+}
+
+func (c *WhepClient) Group() *group.Group {
+	return c.group
+}
+
+func (c *WhepClient) Addr() net.Addr {
+	return c.addr
+}
+
+func (c *WhepClient) Id() string {
+	return c.id
+}
+
+func (c *WhepClient) Token() string {
+	return c.token
+}
+
+func (c *WhepClient) Username() string {
+	return c.username
+}
+
+func (c *WhepClient) Init(username string, perms []string) {
+	c.username = username
+	c.permissions = perms
+}
+
+func (c *WhepClient) Permissions() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.permissions
+}
+
+func (c *WhepClient) Data() map[string]interface{} {
+	return nil
+}
+
+func (c *WhepClient) ETag() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.etag
+}
+
+func (c *WhepClient) SetETag(etag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.etag = etag
+}
+
+func (c *WhepClient) PushConn(g *group.Group, id string, up conn.Up, tracks []conn.UpTrack, replace string) error {
+	if g != c.group {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+
+	closed := false
+	del := func(id string) {
+		for i, s := range c.streams {
+			if s.up.Id() == id {
+				c.streams = append(c.streams[:i], c.streams[i+1:]...)
+				break
+			}
+		}
+		if c.connection != nil && c.connection.id == id {
+			closed = true
+		}
+	}
+
+	if replace != "" {
+		del(replace)
+	}
+	del(id)
+
+	if closed {
+		// WHEP has no server-initiated renegotiation, so the
+		// player will need to reconnect.
+		go c.Close()
+		return nil
+	}
+
+	if up == nil {
+		return nil
+	}
+
+	c.streams = append(c.streams, whepStream{up, tracks})
+	select {
+	case c.available <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (c *WhepClient) RequestConns(target group.Client, g *group.Group, id string) error {
+	return nil
+}
+
+func (c *WhepClient) Joined(group, kind string) error {
+	return nil
+}
+
+func (c *WhepClient) PushClient(group, kind, id, username string, permissions []string, status map[string]interface{}) error {
+	return nil
+}
+
+func (c *WhepClient) Kick(id string, user *string, message string) error {
+	return c.Close()
+}
+
+func (c *WhepClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.closeConnection()
+	c.streams = nil
+	c.mu.Unlock()
+
+	// DelClient calls back into c, so don't hold the lock
+	group.DelClient(c)
+	return nil
+}
+
+// called locked
+func (c *WhepClient) closeConnection() {
+	down := c.connection
+	if down == nil {
+		return
+	}
+	down.pc.OnICEConnectionStateChange(nil)
+	down.pc.OnConnectionStateChange(nil)
+	down.remote.DelLocal(down)
+	for _, t := range down.getTracks() {
+		t.remote.DelLocal(t)
+	}
+	down.pc.Close()
+	c.connection = nil
+}
+
+// getStream waits until a stream is available, and returns the one that
+// was pushed most recently.
+func (c *WhepClient) getStream(ctx context.Context) (whepStream, error) {
+	timer := time.NewTimer(whepWait)
+	defer timer.Stop()
+	for {
+		c.mu.Lock()
+		if len(c.streams) > 0 {
+			s := c.streams[len(c.streams)-1]
+			c.mu.Unlock()
+			return s, nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.available:
+		case <-timer.C:
+			return whepStream{}, ErrNoStream
+		case <-ctx.Done():
+			return whepStream{}, ctx.Err()
+		}
+	}
+}
+
+func (c *WhepClient) NewConnection(ctx context.Context, offer []byte) ([]byte, error) {
+	requestConns(c, c.group, "")
+
+	stream, err := c.getStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks, _ := requestedTracks(
+		[]string{"audio", "video"}, stream.tracks,
+	)
+	if len(tracks) == 0 {
+		return nil, ErrNoStream
+	}
+
+	down, err := newDownConn(c, stream.up.Id(), stream.up)
+	if err != nil {
+		return nil, err
+	}
+
+	down.mu.Lock()
+	for _, t := range tracks {
+		err := addDownTrackUnlocked(down, t)
+		if err != nil {
+			down.mu.Unlock()
+			down.pc.Close()
+			return nil, err
+		}
+	}
+	down.mu.Unlock()
+
+	err = stream.up.AddLocal(down)
+	if err != nil {
+		down.pc.Close()
+		if errors.Is(err, os.ErrClosed) {
+			return nil, ErrNoStream
+		}
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.closed || c.connection != nil {
+		closed := c.closed
+		c.mu.Unlock()
+		stream.up.DelLocal(down)
+		down.pc.Close()
+		if closed {
+			return nil, os.ErrClosed
+		}
+		return nil, errors.New("duplicate connection")
+	}
+	c.connection = down
+	c.mu.Unlock()
+
+	down.pc.OnICEConnectionStateChange(
+		func(state webrtc.ICEConnectionState) {
+			switch state {
+			case webrtc.ICEConnectionStateFailed,
+				webrtc.ICEConnectionStateClosed:
+				c.Close()
+			}
+		})
+
+	add := func() {
+		down.pc.OnConnectionStateChange(nil)
+		for _, t := range down.getTracks() {
+			err := t.remote.AddLocal(t)
+			if err != nil && err != os.ErrClosed {
+				log.Printf("Add track: %v", err)
+			}
+		}
+	}
+	down.pc.OnConnectionStateChange(
+		func(state webrtc.PeerConnectionState) {
+			if state == webrtc.PeerConnectionStateConnected {
+				add()
+			}
+		})
+
+	answer, err := c.gotOffer(ctx, down, offer)
+	if err != nil {
+		c.mu.Lock()
+		if c.connection == down {
+			c.closeConnection()
+		}
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	go rtcpDownSender(down)
+
+	return answer, nil
+}
+
+func (c *WhepClient) gotOffer(ctx context.Context, down *rtpDownConnection, offer []byte) ([]byte, error) {
+	err := down.pc.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  string(offer),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	answer, err := down.pc.CreateAnswer(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(down.pc)
+
+	err = down.pc.SetLocalDescription(answer)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	down.flushICECandidates()
+	c.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-gatherComplete:
+	}
+
+	return []byte(down.pc.CurrentLocalDescription().SDP), nil
+}
+
+func (c *WhepClient) UFragPwd() (string, string, error) {
+	c.mu.Lock()
+	down := c.connection
+	c.mu.Unlock()
+	if down == nil {
+		return "", "", errors.New("no connection in WHEP client")
+	}
+
+	return remoteUFragPwd(down.pc)
+}
+
+func (c *WhepClient) GotICECandidate(init webrtc.ICECandidateInit) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.connection == nil {
+		return nil
+	}
+	return c.connection.addICECandidate(&init)
+}
+
+func (c *WhepClient) Restart(ctx context.Context, frag sdpfrag.SDPFrag) (sdpfrag.SDPFrag, error) {
+	c.mu.Lock()
+	down := c.connection
+	c.mu.Unlock()
+	if down == nil {
+		return sdpfrag.SDPFrag{}, errors.New("no connection")
+	}
+
+	return restartICE(ctx, down.pc, frag)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"net"
+	"os"
 	"sync"
 
 	"github.com/jech/galene/conn"
@@ -15,6 +16,7 @@ import (
 )
 
 type WhipClient struct {
+	// immutable, so it may be accessed without holding mu
 	group    *group.Group
 	addr     net.Addr
 	id       string
@@ -25,6 +27,7 @@ type WhipClient struct {
 	permissions []string
 	connection  *rtpUpConnection
 	etag        string
+	closed      bool
 }
 
 func NewWhipClient(g *group.Group, id string, token string, addr net.Addr) *WhipClient {
@@ -116,11 +119,12 @@ func (c *WhipClient) Kick(id string, user *string, message string) error {
 
 func (c *WhipClient) Close() error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	g := c.group
-	if g == nil {
+	if c.closed {
+		c.mu.Unlock()
 		return nil
 	}
+	c.closed = true
+	g := c.group
 	if c.connection != nil {
 		id := c.connection.Id()
 		c.connection.pc.OnICEConnectionStateChange(nil)
@@ -129,15 +133,16 @@ func (c *WhipClient) Close() error {
 		for _, c := range g.GetClients(c) {
 			c.PushConn(g, id, nil, nil, "")
 		}
-		c.connection = nil
 	}
+	c.mu.Unlock()
+
+	// DelClient calls back into c, so don't hold the lock
 	group.DelClient(c)
-	c.group = nil
 	return nil
 }
 
 func (c *WhipClient) NewConnection(ctx context.Context, offer []byte) ([]byte, error) {
-	conn, err := newUpConn(c, c.id, "", string(offer))
+	conn, err := newUpConn(c, c.id, "", "", string(offer))
 	if err != nil {
 		return nil, err
 	}
@@ -153,9 +158,12 @@ func (c *WhipClient) NewConnection(ctx context.Context, offer []byte) ([]byte, e
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.connection != nil {
+	if c.closed || c.connection != nil {
 		conn.pc.OnICEConnectionStateChange(nil)
 		conn.pc.Close()
+		if c.closed {
+			return nil, os.ErrClosed
+		}
 		return nil, errors.New("duplicate connection")
 	}
 	c.connection = conn
@@ -184,18 +192,26 @@ func (c *WhipClient) UFragPwd() (string, string, error) {
 		return "", "", errors.New("no connection in WHIP client")
 	}
 
-	rs := conn.pc.GetReceivers()
-	if len(rs) < 1 {
-		return "", "", errors.New("no receivers in PeerConnection")
+	return remoteUFragPwd(conn.pc)
+}
+
+// remoteUFragPwd returns the remote ICE credentials of a peer connection.
+func remoteUFragPwd(pc *webrtc.PeerConnection) (string, string, error) {
+	var transport *webrtc.DTLSTransport
+	if rs := pc.GetReceivers(); len(rs) > 0 {
+		transport = rs[0].Transport()
+	} else if ss := pc.GetSenders(); len(ss) > 0 {
+		transport = ss[0].Transport()
+	} else {
+		return "", "", errors.New("no transceivers in PeerConnection")
 	}
 
-	parms, err := rs[0].Transport().ICETransport().GetRemoteParameters()
+	parms, err := transport.ICETransport().GetRemoteParameters()
 	if err != nil {
 		return "", "", err
 	}
 
 	return parms.UsernameFragment, parms.Password, nil
-
 }
 
 // called locked
@@ -249,7 +265,13 @@ func (c *WhipClient) Restart(ctx context.Context, frag sdpfrag.SDPFrag) (sdpfrag
 		return sdpfrag.SDPFrag{}, errors.New("no connection")
 	}
 
-	offer := conn.pc.RemoteDescription()
+	return restartICE(ctx, conn.pc, frag)
+}
+
+// restartICE performs an ICE restart on a peer connection for which the
+// remote peer is the offerer, as required by WHIP and WHEP.
+func restartICE(ctx context.Context, pc *webrtc.PeerConnection, frag sdpfrag.SDPFrag) (sdpfrag.SDPFrag, error) {
+	offer := pc.RemoteDescription()
 	var sdpOffer sdp.SessionDescription
 	err := sdpOffer.Unmarshal([]byte(offer.SDP))
 	if err != nil {
@@ -260,7 +282,7 @@ func (c *WhipClient) Restart(ctx context.Context, frag sdpfrag.SDPFrag) (sdpfrag
 	if err != nil {
 		return sdpfrag.SDPFrag{}, nil
 	}
-	err = conn.pc.SetRemoteDescription(webrtc.SessionDescription{
+	err = pc.SetRemoteDescription(webrtc.SessionDescription{
 		Type: webrtc.SDPTypeOffer,
 		SDP:  string(offer2),
 	})
@@ -268,14 +290,14 @@ func (c *WhipClient) Restart(ctx context.Context, frag sdpfrag.SDPFrag) (sdpfrag
 		return sdpfrag.SDPFrag{}, err
 	}
 
-	answer, err := conn.pc.CreateAnswer(nil)
+	answer, err := pc.CreateAnswer(nil)
 	if err != nil {
 		return sdpfrag.SDPFrag{}, err
 	}
 
-	gatherComplete := webrtc.GatheringCompletePromise(conn.pc)
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
 
-	err = conn.pc.SetLocalDescription(answer)
+	err = pc.SetLocalDescription(answer)
 	if err != nil {
 		return sdpfrag.SDPFrag{}, err
 	}
@@ -286,7 +308,7 @@ func (c *WhipClient) Restart(ctx context.Context, frag sdpfrag.SDPFrag) (sdpfrag
 	case <-gatherComplete:
 	}
 
-	sdpAnswer2 := conn.pc.LocalDescription()
+	sdpAnswer2 := pc.LocalDescription()
 	var answer2 sdp.SessionDescription
 	err = answer2.Unmarshal([]byte(sdpAnswer2.SDP))
 	if err != nil {
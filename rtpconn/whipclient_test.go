@@ -0,0 +1,61 @@
+package rtpconn
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+func TestWhipClose(t *testing.T) {
+	group.Directory = t.TempDir()
+	group.DataDirectory = t.TempDir()
+	err := os.WriteFile(filepath.Join(group.Directory, "whip.json"),
+		[]byte(`{"wildcard-user": {"password": {"type": "wildcard"},
+                         "permissions": "present"}}`),
+		0600,
+	)
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	g, err := group.Add("whip", nil)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	username := "whip"
+	creds := group.ClientCredentials{Username: &username}
+	whip := NewWhipClient(g, "whip", "", nil)
+	_, err = group.AddClient("whip", whip, creds)
+	if err != nil {
+		t.Fatalf("AddClient (WHIP): %v", err)
+	}
+	whep := NewWhepClient(g, "whep", "", nil)
+	_, err = group.AddClient("whip", whep, creds)
+	if err != nil {
+		t.Fatalf("AddClient (WHEP): %v", err)
+	}
+
+	for _, c := range []group.Client{whip, whep} {
+		done := make(chan struct{})
+		go func() {
+			c.Kick("", nil, "")
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Closing %v deadlocked", c.Id())
+		}
+		if g.GetClient(c.Id()) != nil {
+			t.Errorf("Client %v was not deleted", c.Id())
+		}
+	}
+
+	// closing twice is harmless
+	whip.Close()
+	whep.Close()
+}
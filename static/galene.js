@@ -2077,18 +2077,17 @@ function userMenu(elt) {
         throw new Error("Couldn't find user")
     let items = [];
     if(id === serverConnection.id) {
-        let mydata = serverConnection.users[serverConnection.id].data;
-        if(mydata['raisehand'])
+        if(hasFloor(id))
+            items.push({label: 'Yield the floor', onClick: () => {
+                serverConnection.userAction('unfloor', id);
+            }});
+        else if(handRaised(id))
             items.push({label: 'Unraise hand', onClick: () => {
-                serverConnection.userAction(
-                    'setdata', serverConnection.id, {'raisehand': null},
-                );
+                serverConnection.userAction('lowerhand', id);
             }});
         else
             items.push({label: 'Raise hand', onClick: () => {
-                serverConnection.userAction(
-                    'setdata', serverConnection.id, {'raisehand': true},
-                );
+                serverConnection.userAction('raisehand', id);
             }});
         if(serverConnection.version !== "1" &&
            serverConnection.permissions.indexOf('token') >= 0) {
@@ -2113,6 +2112,14 @@ function userMenu(elt) {
                 items.push({label: 'Allow presenting', onClick: () => {
                     serverConnection.userAction('present', id);
                 }});
+            if(hasFloor(id))
+                items.push({label: 'End turn', onClick: () => {
+                    serverConnection.userAction('unfloor', id);
+                }});
+            else
+                items.push({label: 'Give the floor', onClick: () => {
+                    serverConnection.userAction('floor', id);
+                }});
             items.push({label: 'Mute', onClick: () => {
                 serverConnection.userMessage('mute', id);
             }});
@@ -2195,10 +2202,16 @@ function changeUser(id, userinfo) {
  */
 function setUserStatus(id, elt, userinfo) {
     elt.textContent = userinfo.username ? userinfo.username : '(anon)';
-    if(userinfo.data.raisehand)
+    if(hasFloor(id)) {
+        elt.classList.remove('user-status-raisehand');
+        elt.classList.add('user-status-floor');
+    } else if(handRaised(id)) {
+        elt.classList.remove('user-status-floor');
         elt.classList.add('user-status-raisehand');
-    else
+    } else {
+        elt.classList.remove('user-status-floor');
         elt.classList.remove('user-status-raisehand');
+    }
 
     let microphone=false, camera = false;
     for(let label in userinfo.streams) {
@@ -2328,6 +2341,11 @@ async function closeSafariStream() {
  * @param {string} message
  */
 async function gotJoined(kind, group, perms, status, data, error, message) {
+    if(kind === 'lobby' && probingState !== 'probing') {
+        displayWarning('Waiting for an operator to admit you');
+        return;
+    }
+
     let present = presentRequested;
     presentRequested = null;
 
@@ -2358,6 +2376,7 @@ async function gotJoined(kind, group, perms, status, data, error, message) {
         return;
     case 'join':
     case 'change':
+    case 'lobby':
         if(probingState === 'probing') {
             probingState = 'success';
             setVisibility('userform', false);
@@ -3181,6 +3200,43 @@ commands.unrecord = {
     }
 };
 
+commands.play = {
+    predicate: operatorPredicate,
+    description: 'play a recorded file, or resume playback',
+    parameters: '[file]',
+    f: (c, r) => {
+        serverConnection.groupAction('play', r || null);
+    }
+};
+
+commands.pause = {
+    predicate: operatorPredicate,
+    description: 'pause playback',
+    f: (c, r) => {
+        serverConnection.groupAction('pause');
+    }
+};
+
+commands.seek = {
+    predicate: operatorPredicate,
+    description: 'move playback to the given position',
+    parameters: 'seconds',
+    f: (c, r) => {
+        let position = parseFloat(r);
+        if(isNaN(position) || position < 0)
+            throw new Error('Bad position');
+        serverConnection.groupAction('seek', position);
+    }
+};
+
+commands.unplay = {
+    predicate: operatorPredicate,
+    description: 'stop playback',
+    f: (c, r) => {
+        serverConnection.groupAction('unplay');
+    }
+};
+
 commands.subgroups = {
     predicate: operatorPredicate,
     description: 'list subgroups',
@@ -3189,6 +3245,43 @@ commands.subgroups = {
     }
 };
 
+commands.breakout = {
+    predicate: operatorPredicate,
+    description: 'open breakout rooms and move users into them',
+    parameters: 'rooms [user room]...',
+    f: (c, r) => {
+        let p = parseCommand(r);
+        let rooms = parseInt(p[0]);
+        if(!(rooms > 0))
+            throw new Error('/breakout requires a number of rooms');
+        let v = {rooms: rooms};
+        r = p[1];
+        while(r) {
+            p = parseCommand(r);
+            let id = findUserId(p[0]);
+            if(!id)
+                throw new Error(`Unknown user ${p[0]}`);
+            let q = parseCommand(p[1]);
+            let room = parseInt(q[0]);
+            if(!(room > 0))
+                throw new Error(`Bad room number for user ${p[0]}`);
+            if(!v.assign)
+                v.assign = {};
+            v.assign[id] = room;
+            r = q[1];
+        }
+        serverConnection.groupAction('breakout', v);
+    }
+};
+
+commands.unbreakout = {
+    predicate: operatorPredicate,
+    description: 'close breakout rooms and bring users back',
+    f: (c, r) => {
+        serverConnection.groupAction('unbreakout');
+    }
+};
+
 /**
  * @type {Record<string,number>}
  */
@@ -3428,6 +3521,52 @@ commands.msg = {
     }
 };
 
+/**
+ * @param {Array<{id: string, username: string}>} lobby
+ * @returns {string}
+ */
+function formatLobby(lobby) {
+    return lobby.map(l => l.username || '(anonymous)').join(', ');
+}
+
+/**
+ * @this {ServerConnection}
+ */
+function gotLobby() {
+    if(this.lobby.length > 0)
+        displayWarning('Waiting in the lobby: ' + formatLobby(this.lobby) +
+                       '; use /admit or /reject');
+}
+
+/**
+ * @param {string} user
+ * @returns {string}
+ */
+function findLobbyId(user) {
+    for(let l of serverConnection.lobby) {
+        if(l.id === user || l.username === user)
+            return l.id;
+    }
+    return null;
+}
+
+/**
+ * @param {string} c
+ * @param {string} r
+ */
+function lobbyCommand(c, r) {
+    let p = parseCommand(r);
+    if(!p[0]) {
+        localMessage('Waiting in the lobby: ' +
+                     (formatLobby(serverConnection.lobby) || 'nobody'));
+        return;
+    }
+    let id = findLobbyId(p[0]);
+    if(!id)
+        throw new Error(`Unknown user ${p[0]}`);
+    serverConnection.groupAction(c, id);
+}
+
 /**
    @param {string} c
    @param {string} r
@@ -3452,6 +3591,20 @@ function userMessage(c, r) {
     serverConnection.userMessage(c, id, p[1]);
 }
 
+commands.admit = {
+    parameters: '[user]',
+    description: 'admit a user waiting in the lobby, or list the lobby',
+    predicate: operatorPredicate,
+    f: lobbyCommand,
+};
+
+commands.reject = {
+    parameters: 'user',
+    description: 'reject a user waiting in the lobby',
+    predicate: operatorPredicate,
+    f: lobbyCommand,
+};
+
 commands.kick = {
     parameters: 'user [message]',
     description: 'kick out a user',
@@ -3543,24 +3696,248 @@ commands.wall = {
     },
 };
 
+/**
+ * @param {string} id
+ * @returns {boolean}
+ */
+function handRaised(id) {
+    return serverConnection.hands.queue.some(h => h.id === id);
+}
+
+/**
+ * @param {string} id
+ * @returns {boolean}
+ */
+function hasFloor(id) {
+    let floor = serverConnection.hands.floor;
+    return !!floor && floor.id === id;
+}
+
+/**
+ * @this {ServerConnection}
+ */
+function gotObservers() {
+    let div = document.getElementById('observers');
+    if(this.observers > 0) {
+        div.textContent = this.observers === 1 ?
+            '1 observer' : `${this.observers} observers`;
+        div.classList.remove('invisible');
+    } else {
+        div.textContent = '';
+        div.classList.add('invisible');
+    }
+}
+
+/**
+ * @this {ServerConnection}
+ */
+function gotHands() {
+    for(let id in this.users)
+        changeUser(id, this.users[id]);
+}
+
 commands.raise = {
     description: 'raise hand',
     f: (c, r) => {
-        serverConnection.userAction(
-            "setdata", serverConnection.id, {"raisehand": true},
-        );
+        serverConnection.userAction('raisehand', serverConnection.id);
     }
 }
 
 commands.unraise = {
     description: 'unraise hand',
     f: (c, r) => {
-        serverConnection.userAction(
-            "setdata", serverConnection.id, {"raisehand": null},
-        );
+        serverConnection.userAction('lowerhand', serverConnection.id);
+    }
+}
+
+commands.hands = {
+    description: 'list the users who have raised their hand',
+    f: (c, r) => {
+        let hands = serverConnection.hands;
+        let s = 'Raised hands: ' +
+            (hands.queue.map(h => h.username || '(anon)').join(', ') ||
+             'none');
+        if(hands.floor)
+            s = `${hands.floor.username || '(anon)'} has the floor. ${s}`;
+        localMessage(s);
+    }
+}
+
+commands.floor = {
+    parameters: '[user]',
+    description: 'give the floor to a user, or to the next raised hand',
+    predicate: operatorPredicate,
+    f: (c, r) => {
+        let p = parseCommand(r);
+        let id = '';
+        if(p[0]) {
+            id = findUserId(p[0]);
+            if(!id)
+                throw new Error(`Unknown user ${p[0]}`);
+        }
+        serverConnection.userAction('floor', id);
+    }
+}
+
+commands.unfloor = {
+    description: 'end the current turn',
+    f: (c, r) => {
+        let floor = serverConnection.hands.floor;
+        if(!floor)
+            throw new Error('Nobody has the floor');
+        serverConnection.userAction('unfloor', floor.id);
+    }
+}
+
+/**
+ * @param {poll} poll
+ * @param {boolean} results
+ * @returns {string}
+ */
+function formatPoll(poll, results) {
+    let s = poll.question;
+    for(let i = 0; i < poll.options.length; i++) {
+        s = s + `\n${i + 1}. ${poll.options[i]}`;
+        if(results)
+            s = s + ` (${poll.counts[i]})`;
+    }
+    if(results)
+        s = s + `\n${poll.voters} ${poll.voters === 1 ? 'voter' : 'voters'}` +
+            (poll.closed ? ', closed' : '');
+    return s;
+}
+
+/**
+ * @this {ServerConnection}
+ * @param {poll} poll
+ * @param {poll} old
+ */
+function gotPoll(poll, old) {
+    if(poll.deleted) {
+        localMessage(`Poll deleted: ${poll.question}`);
+    } else if(!old) {
+        let by = poll.createdBy ? ` by ${poll.createdBy}` : '';
+        localMessage(`New poll${by}: ${formatPoll(poll, false)}\n` +
+                     (poll.multiple ?
+                      'Use /vote followed by the numbers of your choices.' :
+                      'Use /vote followed by the number of your choice.'));
+    } else if(poll.closed && !old.closed) {
+        localMessage(`Poll closed: ${formatPoll(poll, true)}`);
     }
 }
 
+/**
+ * @returns {poll}
+ */
+function currentPoll() {
+    let polls = Object.values(serverConnection.polls);
+    for(let i = polls.length - 1; i >= 0; i--) {
+        if(!polls[i].closed)
+            return polls[i];
+    }
+    return null;
+}
+
+commands.poll = {
+    parameters: '[-anonymous] [-multiple] [-deadline time] question | option | option...',
+    description: 'create a poll',
+    predicate: operatorPredicate,
+    f: (c, r) => {
+        let v = {};
+        while(true) {
+            let p = parseCommand(r);
+            if(p[0] === '-anonymous') {
+                v.anonymous = true;
+            } else if(p[0] === '-multiple') {
+                v.multiple = true;
+            } else if(p[0] === '-deadline') {
+                let q = parseCommand(p[1]);
+                let d = parseExpiration(q[0]);
+                if(!d)
+                    throw new Error('-deadline requires a parameter');
+                if(typeof d === 'number')
+                    d = new Date(Date.now() + d);
+                v.deadline = d.toISOString();
+                p[1] = q[1];
+            } else {
+                break;
+            }
+            r = p[1];
+        }
+        let fields = r.split('|').map(f => f.trim());
+        if(fields.length < 3 || fields.some(f => !f))
+            throw new Error('a poll requires a question and two options');
+        v.question = fields[0];
+        v.options = fields.slice(1);
+        serverConnection.groupAction('poll', v);
+    },
+};
+
+commands.vote = {
+    parameters: 'choice...',
+    description: 'vote in the current poll',
+    f: (c, r) => {
+        let poll = currentPoll();
+        if(!poll)
+            throw new Error('No poll is open');
+        let choices = r.split(/[\s,]+/).filter(x => x).map(x => {
+            let n = parseInt(x);
+            if(!(n >= 1 && n <= poll.options.length))
+                throw new Error(`Bad choice ${x}`);
+            return n - 1;
+        });
+        if(choices.length === 0)
+            throw new Error('/vote requires parameters');
+        serverConnection.userAction('vote', '', {
+            poll: poll.id,
+            choices: choices,
+        });
+    },
+};
+
+commands.closepoll = {
+    description: 'close the current poll',
+    predicate: operatorPredicate,
+    f: (c, r) => {
+        let poll = currentPoll();
+        if(!poll)
+            throw new Error('No poll is open');
+        serverConnection.groupAction('closepoll', poll.id);
+    },
+};
+
+commands.deletepoll = {
+    parameters: '[number]',
+    description: 'delete a poll, by default the most recent one',
+    predicate: operatorPredicate,
+    f: (c, r) => {
+        let polls = Object.values(serverConnection.polls);
+        if(polls.length === 0)
+            throw new Error('No polls');
+        let n = polls.length;
+        if(r) {
+            n = parseInt(r);
+            if(!(n >= 1 && n <= polls.length))
+                throw new Error(`Bad poll number ${r}`);
+        }
+        serverConnection.groupAction('deletepoll', polls[n - 1].id);
+    },
+};
+
+commands.polls = {
+    description: 'display the results of all polls',
+    f: (c, r) => {
+        let polls = Object.values(serverConnection.polls);
+        if(polls.length === 0) {
+            localMessage('No polls');
+            return;
+        }
+        localMessage(polls.map(
+            (p, i) => `Poll ${i + 1}: ${formatPoll(p, true)}`,
+        ).join('\n\n'));
+    },
+};
+
 /** @returns {boolean} */
 function canFile() {
     let v =
@@ -3970,6 +4347,10 @@ async function serverConnect() {
     serverConnection.ondownstream = gotDownStream;
     serverConnection.onuser = gotUser;
     serverConnection.onjoined = gotJoined;
+    serverConnection.onlobby = gotLobby;
+    serverConnection.onhands = gotHands;
+    serverConnection.onpoll = gotPoll;
+    serverConnection.onobservers = gotObservers;
     serverConnection.onchat = addToChatbox;
     serverConnection.onusermessage = gotUserMessage;
     serverConnection.onfiletransfer = gotFileTransfer;
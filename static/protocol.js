@@ -69,6 +69,22 @@ function newLocalId() {
  * @property {Record<string,Record<string,boolean>>} streams
  */
 
+/**
+ * @typedef {Object} poll
+ * @property {string} id
+ * @property {string} question
+ * @property {Array<string>} options
+ * @property {boolean} [anonymous]
+ * @property {boolean} [multiple]
+ * @property {string} [deadline]
+ * @property {string} created
+ * @property {string} [createdBy]
+ * @property {boolean} [closed]
+ * @property {boolean} [deleted]
+ * @property {Array<number>} counts
+ * @property {number} voters
+ */
+
 /**
  * ServerConnection encapsulates a websocket connection to the server and
  * all the associated streams.
@@ -196,7 +212,9 @@ function ServerConnection() {
      * onjoined is called whenever we join or leave a group or whenever the
      * permissions we have in a group change.
      *
-     * kind is one of 'join', 'fail', 'change' or 'leave'.
+     * kind is one of 'join', 'fail', 'change', 'lobby' or 'leave'.  The
+     * kind 'lobby' indicates that we are waiting for an operator to admit
+     * us into the group.
      *
      * @type{(this: ServerConnection, kind: string, group: string, permissions: Array<string>, status: Record<string,any>, data: Record<string,any>, error: string, message: string) => void}
      */
@@ -227,6 +245,73 @@ function ServerConnection() {
      * @type {(this: ServerConnection, id: string, dest: string, username: string, time: Date, privileged: boolean, kind: string, error: string, message: unknown) => void}
      */
     this.onusermessage = null;
+    /**
+     * onactivespeaker is called whenever the server's idea of the user
+     * currently speaking changes.  Id is null when nobody is speaking.
+     *
+     * @type {(this: ServerConnection, id: string, username: string) => void}
+     */
+    this.onactivespeaker = null;
+    /**
+     * The clients waiting in the lobby of the group, only maintained if
+     * we are an operator.
+     *
+     * @type {Array<{id: string, username: string}>}
+     */
+    this.lobby = [];
+    /**
+     * onlobby is called whenever the lobby of the group changes.  The
+     * lobby array has already been updated.
+     *
+     * @type {(this: ServerConnection) => void}
+     */
+    this.onlobby = null;
+    /**
+     * The speaking queue of the group: the user that has been given the
+     * floor by an operator, if any, and the users that have raised their
+     * hand, in order.
+     *
+     * @type {{floor?: {id: string, username: string},
+     *         queue: Array<{id: string, username: string}>}}
+     */
+    this.hands = {queue: []};
+    /**
+     * onhands is called whenever the speaking queue of the group changes.
+     * The hands field has already been updated.
+     *
+     * @type {(this: ServerConnection) => void}
+     */
+    this.onhands = null;
+    /**
+     * The polls of the group, indexed by id.
+     *
+     * @type {Record<string,poll>}
+     */
+    this.polls = {};
+    /**
+     * onpoll is called whenever a poll is created, receives a vote, is
+     * closed or is deleted.  The polls field has already been updated; old
+     * is the previous state of the poll, or null if the poll is new.  If
+     * the poll has been deleted, its deleted field is set.
+     *
+     * @type {(this: ServerConnection, poll: poll, old: poll) => void}
+     */
+    this.onpoll = null;
+    /**
+     * The number of users in the group that are not announced
+     * individually.  This is only non-zero in groups with scalable
+     * presence, where only users that may present, operators and system
+     * clients are announced.
+     *
+     * @type {number}
+     */
+    this.observers = 0;
+    /**
+     * onobservers is called whenever the number of observers changes.
+     *
+     * @type {(this: ServerConnection) => void}
+     */
+    this.onobservers = null;
     /**
      * The set of files currently being transferred.
      *
@@ -270,6 +355,7 @@ function ServerConnection() {
   * @property {RTCIceCandidateInit} [candidate]
   * @property {string} [label]
   * @property {Record<string,Array<string>>|Array<string>} [request]
+  * @property {number} [lastN]
   * @property {Record<string,any>} [rtcConfiguration]
   */
 
@@ -431,6 +517,14 @@ ServerConnection.prototype.connect = function(url) {
                 sc.username = null;
                 sc.permissions = [];
                 sc.rtcConfiguration = null;
+                sc.lobby = [];
+                sc.hands = {queue: []};
+                sc.polls = {};
+                if(sc.observers) {
+                    sc.observers = 0;
+                    if(sc.onobservers)
+                        sc.onobservers.call(sc);
+                }
             } else if(m.kind === 'join' || m.kind == 'change') {
                 if(m.kind === 'join' && sc.group) {
                     throw new Error('Joined multiple groups');
@@ -511,6 +605,38 @@ ServerConnection.prototype.connect = function(url) {
                     m.privileged, m.kind, m.error, m.value,
                 );
             break;
+        case 'lobby':
+            sc.lobby = Array.isArray(m.value) ? m.value : [];
+            if(sc.onlobby)
+                sc.onlobby.call(sc);
+            break;
+        case 'poll': {
+            let old = sc.polls[m.value.id] || null;
+            if(m.value.deleted)
+                delete(sc.polls[m.value.id]);
+            else
+                sc.polls[m.value.id] = m.value;
+            if(sc.onpoll)
+                sc.onpoll.call(sc, m.value, old);
+            break;
+        }
+        case 'observers':
+            sc.observers = typeof m.value === 'number' ? m.value : 0;
+            if(sc.onobservers)
+                sc.onobservers.call(sc);
+            break;
+        case 'hands':
+            sc.hands = m.value && Array.isArray(m.value.queue) ?
+                m.value : {queue: []};
+            if(sc.onhands)
+                sc.onhands.call(sc);
+            break;
+        case 'activespeaker':
+            if(sc.onactivespeaker)
+                sc.onactivespeaker.call(
+                    sc, m.source || null, m.username || null,
+                );
+            break;
         case 'ping':
             sc.send({
                 type: 'pong',
@@ -648,11 +774,16 @@ ServerConnection.prototype.leave = function(group) {
  * @param {Record<string,Array<string>>} what
  *     - A dictionary that maps labels to a sequence of 'audio', 'video'
  *       or 'video-low.  An entry with an empty label '' provides the default.
+ * @param {number} [lastN]
+ *     - If defined, the number of recent speakers whose video is received
+ *       at full quality, overriding the group's setting.  0 means
+ *       unlimited, -1 reverts to the group's setting.
  */
-ServerConnection.prototype.request = function(what) {
+ServerConnection.prototype.request = function(what, lastN) {
     this.send({
         type: 'request',
         request: what,
+        lastN: lastN,
     });
 };
 
@@ -772,7 +903,8 @@ ServerConnection.prototype.chat = function(kind, dest, value) {
 /**
  * userAction sends a request to act on a user.
  *
- * @param {string} kind - One of "op", "unop", "kick", "present", "unpresent".
+ * @param {string} kind - One of "op", "unop", "kick", "present", "unpresent",
+ *                        "raisehand", "lowerhand", "floor", "unfloor".
  * @param {string} dest - The id of the user to act upon.
  * @param {any} [value] - An action-dependent parameter.
  */
@@ -9,8 +9,9 @@ import (
 )
 
 type GroupStats struct {
-	Name    string    `json:"name"`
-	Clients []*Client `json:"clients,omitempty"`
+	Name          string    `json:"name"`
+	ActiveSpeaker string    `json:"activeSpeaker,omitempty"`
+	Clients       []*Client `json:"clients,omitempty"`
 }
 
 type Client struct {
@@ -47,6 +48,8 @@ func (d *Duration) UnmarshalJSON(buf []byte) error {
 }
 
 type Track struct {
+	// either "audio" or "video"
+	Kind       string   `json:"kind,omitempty"`
 	Sid        *uint8   `json:"sid,omitempty"`
 	MaxSid     *uint8   `json:"maxSid,omitempty"`
 	Tid        *uint8   `json:"tid,omitempty"`
@@ -56,6 +59,15 @@ type Track struct {
 	Loss       float64  `json:"loss"`
 	Rtt        Duration `json:"rtt,omitempty"`
 	Jitter     Duration `json:"jitter,omitempty"`
+	// cumulative packet counts, only for up tracks
+	Packets   uint32 `json:"packets,omitempty"`
+	Lost      uint32 `json:"lost,omitempty"`
+	Unordered uint32 `json:"unordered,omitempty"`
+	// the number of packets NACKed, by us for up tracks, by the
+	// receiver for down tracks
+	NACKs uint32 `json:"nacks,omitempty"`
+	// the smoothed audio level in dBov, only for up audio tracks
+	AudioLevel *float64 `json:"audioLevel,omitempty"`
 }
 
 func GetGroups() []GroupStats {
@@ -69,8 +81,9 @@ func GetGroups() []GroupStats {
 		}
 		clients := g.GetClients(nil)
 		stats := GroupStats{
-			Name:    name,
-			Clients: make([]*Client, 0, len(clients)),
+			Name:          name,
+			ActiveSpeaker: g.ActiveSpeaker(),
+			Clients:       make([]*Client, 0, len(clients)),
 		}
 		for _, c := range clients {
 			s, ok := c.(Statable)
@@ -0,0 +1,100 @@
+package token
+
+import (
+	crand "crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Internal tokens are stateful tokens that are created by the server for
+// its own use, for example in order to move a client to a different
+// group.  They are kept in memory, are neither stored nor listed, and
+// may only be used to join a group once.
+var internal struct {
+	mu     sync.Mutex
+	tokens map[string]*internalToken
+}
+
+type internalToken struct {
+	token *Stateful
+	// set once the token has been used to join a group; the token is
+	// kept until it expires, so that it is not mistaken for a
+	// stateful token.
+	redeemed bool
+}
+
+// AddInternal creates an internal token for the given group that grants
+// the given username and permissions, and that expires after lifetime.
+func AddInternal(group string, username *string, permissions []string, lifetime time.Duration) *Stateful {
+	buf := make([]byte, 16)
+	crand.Read(buf)
+	now := time.Now()
+	expires := now.Add(lifetime)
+	t := &Stateful{
+		Token:       base64.RawURLEncoding.EncodeToString(buf),
+		Group:       group,
+		Username:    username,
+		Permissions: append([]string(nil), permissions...),
+		Expires:     &expires,
+		IssuedAt:    &now,
+	}
+
+	internal.mu.Lock()
+	defer internal.mu.Unlock()
+	expireInternalUnlocked(now)
+	if internal.tokens == nil {
+		internal.tokens = make(map[string]*internalToken)
+	}
+	internal.tokens[t.Token] = &internalToken{token: t}
+	return t
+}
+
+// getInternal returns the internal token with the given value, or nil.
+// It only looks the token up, and may therefore be called multiple times
+// for a single join, for example when a client is admitted from the lobby.
+// The second result is true if the token is an internal token, even if
+// it has expired or has already been redeemed.
+func getInternal(token string) (*Stateful, bool) {
+	internal.mu.Lock()
+	defer internal.mu.Unlock()
+	t := internal.tokens[token]
+	if t == nil {
+		return nil, false
+	}
+	if t.redeemed || time.Now().After(*t.token.Expires) {
+		return nil, true
+	}
+	return t.token, true
+}
+
+// Redeem marks an internal token as used, so that it cannot be used to
+// join a group again.  It should be called when a client successfully
+// joins a group, and returns an error if the token has already been
+// redeemed or has expired.  It does nothing for other kinds of tokens.
+func Redeem(token string) error {
+	internal.mu.Lock()
+	defer internal.mu.Unlock()
+	t := internal.tokens[token]
+	if t == nil {
+		return nil
+	}
+	if t.redeemed {
+		return errors.New("token has already been used")
+	}
+	if time.Now().After(*t.token.Expires) {
+		return errors.New("token has expired")
+	}
+	t.redeemed = true
+	return nil
+}
+
+// called locked
+func expireInternalUnlocked(now time.Time) {
+	for k, t := range internal.tokens {
+		if now.After(*t.token.Expires) {
+			delete(internal.tokens, k)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package token
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestInternal(t *testing.T) {
+	tokens = state{}
+	user := "user"
+	tok := AddInternal(
+		"group/sub", &user, []string{"present"}, time.Minute,
+	)
+
+	token, err := Parse(tok.Token, nil)
+	if err != nil || token == nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	u, perms, err := token.Check("galene.org:8443", "group/sub")
+	if err != nil || u != "user" ||
+		!slices.Equal(perms, []string{"present"}) {
+		t.Errorf("Check: %v %v %v", u, perms, err)
+	}
+	_, _, err = token.Check("galene.org:8443", "group")
+	if err == nil {
+		t.Errorf("Check (parent) succeeded")
+	}
+
+	// parsing doesn't consume the token
+	_, err = Parse(tok.Token, nil)
+	if err != nil {
+		t.Errorf("Parse (again): %v", err)
+	}
+
+	err = Redeem(tok.Token)
+	if err != nil {
+		t.Errorf("Redeem: %v", err)
+	}
+	_, err = Parse(tok.Token, nil)
+	if err == nil {
+		t.Errorf("Parse (redeemed) succeeded")
+	}
+	err = Redeem(tok.Token)
+	if err == nil {
+		t.Errorf("Redeem (redeemed) succeeded")
+	}
+	err = Redeem("not-an-internal-token")
+	if err != nil {
+		t.Errorf("Redeem (not internal): %v", err)
+	}
+
+	l, _, err := List("group/sub")
+	if err != nil || len(l) != 0 {
+		t.Errorf("List: %v %v", l, err)
+	}
+
+	expired := AddInternal("group", nil, nil, -time.Second)
+	_, err = Parse(expired.Token, nil)
+	if err == nil {
+		t.Errorf("Parse (expired) succeeded")
+	}
+	err = Redeem(expired.Token)
+	if err == nil {
+		t.Errorf("Redeem (expired) succeeded")
+	}
+	AddInternal("group", nil, nil, -time.Second)
+	AddInternal("group", nil, nil, time.Minute)
+	err = Expire()
+	if err != nil {
+		t.Errorf("Expire: %v", err)
+	}
+	internal.mu.Lock()
+	n := len(internal.tokens)
+	internal.mu.Unlock()
+	// the redeemed token is kept until it expires
+	if n != 2 {
+		t.Errorf("Expected 2 internal tokens, got %v", n)
+	}
+}
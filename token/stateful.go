@@ -449,5 +449,8 @@ func (state *state) Expire() error {
 }
 
 func Expire() error {
+	internal.mu.Lock()
+	expireInternalUnlocked(time.Now())
+	internal.mu.Unlock()
 	return tokens.Expire()
 }
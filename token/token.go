@@ -1,5 +1,7 @@
 package token
 
+import "errors"
+
 type Token interface {
 	Check(host, group string) (string, []string, error)
 	NeedsUsername() bool
@@ -17,6 +19,13 @@ func Parse(token string, keys []map[string]interface{}) (Token, error) {
 		return jwt, nil
 	}
 
+	if s, ok := getInternal(token); ok {
+		if s == nil {
+			return nil, errors.New("token has expired or was used")
+		}
+		return s, nil
+	}
+
 	s, _, err := Get(token)
 	return s, err
 }
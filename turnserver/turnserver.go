@@ -236,6 +236,18 @@ func ICEServers() []webrtc.ICEServer {
 
 }
 
+// Allocations returns the number of allocations on the built-in TURN
+// server, and false if it is not running.
+func Allocations() (int, bool) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if server.server == nil {
+		return 0, false
+	}
+	return server.server.AllocationCount(), true
+}
+
 func Stop() error {
 	server.mu.Lock()
 	defer server.mu.Unlock()
@@ -0,0 +1,163 @@
+// Package webhook implements outbound HTTP notifications of events.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Hook describes a receiver of notifications.
+type Hook struct {
+	// The URL to which events are POSTed.
+	URL string `json:"url"`
+	// If not empty, the key used to sign the body of requests.
+	Secret string `json:"secret,omitempty"`
+	// The types of events to send; all events if empty.
+	Events []string `json:"events,omitempty"`
+}
+
+func (hook Hook) wants(kind string) bool {
+	return len(hook.Events) == 0 || slices.Contains(hook.Events, kind)
+}
+
+// Event is the body of a notification.
+type Event struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Group    string    `json:"group"`
+	Id       string    `json:"id,omitempty"`
+	Username string    `json:"username,omitempty"`
+	Filename string    `json:"filename,omitempty"`
+}
+
+const (
+	// the maximum number of pending notifications
+	queueSize = 256
+	// the number of notifications delivered concurrently
+	workers = 4
+	// the number of delivery attempts of a notification
+	maxAttempts = 5
+)
+
+// the delay before the first retry, doubled after each attempt
+var initialBackoff = time.Second
+
+var client = http.Client{
+	Timeout: 10 * time.Second,
+}
+
+type delivery struct {
+	hook Hook
+	kind string
+	body []byte
+}
+
+var queue struct {
+	once sync.Once
+	ch   chan delivery
+}
+
+// Send queues an event for delivery to the hooks that are interested in
+// it.  It never blocks: if too many notifications are pending, the event
+// is dropped.
+func Send(hooks []Hook, event Event) {
+	var body []byte
+	for _, hook := range hooks {
+		if !hook.wants(event.Type) {
+			continue
+		}
+		if body == nil {
+			if event.Time.Equal(time.Time{}) {
+				event.Time = time.Now()
+			}
+			var err error
+			body, err = json.Marshal(event)
+			if err != nil {
+				log.Printf("Webhook: %v", err)
+				return
+			}
+			queue.once.Do(start)
+		}
+		select {
+		case queue.ch <- delivery{hook, event.Type, body}:
+		default:
+			log.Printf("Webhook %v: queue full, dropping %v event",
+				hook.URL, event.Type)
+		}
+	}
+}
+
+func start() {
+	queue.ch = make(chan delivery, queueSize)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for d := range queue.ch {
+				deliver(d)
+			}
+		}()
+	}
+}
+
+func deliver(d delivery) {
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := post(d)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= maxAttempts {
+			log.Printf("Webhook %v: %v", d.hook.URL, err)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Signature returns the signature of the body of a request, as sent in
+// the X-Galene-Signature header.
+func Signature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// post performs a single delivery attempt.  It returns true if the
+// attempt failed and should be retried.
+func post(d delivery) (bool, error) {
+	req, err := http.NewRequest("POST", d.hook.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Galene-Event", d.kind)
+	if d.hook.Secret != "" {
+		req.Header.Set("X-Galene-Signature",
+			Signature(d.hook.Secret, d.body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 ||
+		resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("server returned %v", resp.Status)
+}
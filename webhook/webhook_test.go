@@ -0,0 +1,72 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSend(t *testing.T) {
+	initialBackoff = time.Millisecond
+
+	var attempts atomic.Int32
+	done := make(chan Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if attempts.Add(1) < 3 {
+				http.Error(w, "busy", http.StatusServiceUnavailable)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			sig := r.Header.Get("X-Galene-Signature")
+			if sig != Signature("secret", body) {
+				t.Errorf("Bad signature %v", sig)
+			}
+			if e := r.Header.Get("X-Galene-Event"); e != "join" {
+				t.Errorf("Bad event %v", e)
+			}
+			var event Event
+			err := json.Unmarshal(body, &event)
+			if err != nil {
+				t.Errorf("Unmarshal: %v", err)
+			}
+			done <- event
+		},
+	))
+	defer server.Close()
+
+	hooks := []Hook{
+		{URL: server.URL, Secret: "secret"},
+		{URL: server.URL, Events: []string{"leave"}},
+	}
+	Send(hooks, Event{Type: "join", Group: "test", Username: "jch"})
+
+	select {
+	case event := <-done:
+		if event.Group != "test" || event.Username != "jch" ||
+			event.Time.Equal(time.Time{}) {
+			t.Errorf("Unexpected event %v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timeout")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if a := attempts.Load(); a != 3 {
+		t.Errorf("Expected 3 attempts, got %v", a)
+	}
+}
+
+func TestSignature(t *testing.T) {
+	// RFC 4231, test case 2
+	sig := Signature("Jefe", []byte("what do ya want for nothing?"))
+	expected := "sha256=5bdcc146bf60754e6a042426089575c7" +
+		"5a003f089d2739839dec58b964ec3843"
+	if sig != expected {
+		t.Errorf("Expected %v, got %v", expected, sig)
+	}
+}
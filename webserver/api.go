@@ -115,6 +115,49 @@ func checkAdminOrExplicitPassword(w http.ResponseWriter, r *http.Request, groupn
 	return true
 }
 
+// checkAdminOrOp checks whether the client authentifies as either an
+// administrator or a user with the op permission in the given group.
+func checkAdminOrOp(w http.ResponseWriter, r *http.Request, groupname string) bool {
+	var creds group.ClientCredentials
+	username, password, ok := r.BasicAuth()
+	if ok {
+		creds.Username = &username
+		creds.Password = password
+	}
+	creds.Token = parseBearerToken(r.Header.Get("Authorization"))
+
+	ok = isAdminOrExplicitPassword(groupname, "", creds)
+	if !ok && groupname != "" {
+		ok = isOp(groupname, creds)
+	}
+	if !ok {
+		failAuthentication(w, "/galene-api/")
+		return false
+	}
+	return true
+}
+
+// isOp checks whether creds grant the op permission in the given group.
+func isOp(groupname string, creds group.ClientCredentials) bool {
+	desc, err := group.GetDescription(groupname)
+	if err != nil {
+		return false
+	}
+
+	if creds.Token != "" && creds.Username == nil {
+		// tokens don't need to specify a username in order to
+		// access the API
+		username := ""
+		creds.Username = &username
+	}
+
+	_, perms, err := desc.GetPermission(groupname, creds)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(perms, "op")
+}
+
 func sendJSON(w http.ResponseWriter, r *http.Request, v any) {
 	w.Header().Set("content-type", "application/json")
 	if r.Method == "HEAD" {
@@ -167,6 +210,42 @@ func getJSON(w http.ResponseWriter, r *http.Request, v any) bool {
 	return false
 }
 
+// getToken is like getJSON, but expands a role name in the permissions
+// field of a token.
+func getToken(w http.ResponseWriter, r *http.Request, g string, tok *token.Stateful) bool {
+	var fields map[string]json.RawMessage
+	done := getJSON(w, r, &fields)
+	if done {
+		return done
+	}
+
+	var role string
+	if json.Unmarshal(fields["permissions"], &role) == nil {
+		perms, err := group.ExpandPermissions(g, role)
+		if err != nil {
+			httpError(w, err)
+			return true
+		}
+		fields["permissions"], err = json.Marshal(perms)
+		if err != nil {
+			httpError(w, err)
+			return true
+		}
+	}
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		httpError(w, err)
+		return true
+	}
+	err = json.Unmarshal(b, tok)
+	if err != nil {
+		httpError(w, err)
+		return true
+	}
+	return false
+}
+
 func apiCORS(w http.ResponseWriter, r *http.Request, methods string) bool {
 	CheckOrigin(w, r, true)
 	if r.Method == "OPTIONS" {
@@ -257,6 +336,21 @@ func apiGroupHandler(w http.ResponseWriter, r *http.Request, pth string) {
 	} else if kind == ".tokens" {
 		tokensHandler(w, r, g, rest)
 		return
+	} else if kind == ".chat" && rest == "" {
+		chatHandler(w, r, g)
+		return
+	} else if kind == ".clients" {
+		clientsHandler(w, r, g, rest)
+		return
+	} else if kind == ".lock" && rest == "" {
+		lockHandler(w, r, g)
+		return
+	} else if kind == ".playback" && rest == "" {
+		playbackHandler(w, r, g)
+		return
+	} else if kind == ".polls" && rest == "" {
+		pollsHandler(w, r, g)
+		return
 	} else if kind != "" {
 		if !checkAdmin(w, r, g) {
 			return
@@ -634,7 +728,7 @@ func tokensHandler(w http.ResponseWriter, r *http.Request, g, pth string) {
 			return
 		} else if r.Method == "POST" {
 			var newtoken token.Stateful
-			done := getJSON(w, r, &newtoken)
+			done := getToken(w, r, g, &newtoken)
 			if done {
 				return
 			}
@@ -707,7 +801,7 @@ func tokensHandler(w http.ResponseWriter, r *http.Request, g, pth string) {
 		}
 
 		var newtoken token.Stateful
-		done = getJSON(w, r, &newtoken)
+		done = getToken(w, r, g, &newtoken)
 		if done {
 			return
 		}
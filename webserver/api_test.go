@@ -403,6 +403,170 @@ func TestApi(t *testing.T) {
 		t.Errorf("Token list: %v %v", tokens, err)
 	}
 
+	// the history of a group that is not running is read from the store
+	store := group.NewFileHistoryStore(t.TempDir())
+	group.SetHistoryStore(store)
+	defer group.SetHistoryStore(nil)
+	err = store.Replace("test", []group.ChatHistoryEntry{
+		{Id: "stored", Source: "source", Time: time.Now(), Value: "hi"},
+	})
+	if err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	if group.Get("test") != nil {
+		t.Fatalf("Group is running")
+	}
+	var history []group.ChatHistoryEntry
+	err = getJSON("/galene-api/v0/.groups/test/.chat", &history)
+	if err != nil || len(history) != 1 || history[0].Id != "stored" {
+		t.Errorf("Get chat (not running): %v %v", err, history)
+	}
+
+	resp, err = do("GET", "/galene-api/v0/.groups/nosuchgroup/.chat",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Get chat (no group): %v %v", err, resp.StatusCode)
+	}
+
+	g, err := group.Add("test", nil)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	username := "jch"
+	g.AddToChatHistory("id", "source", &username, time.Now(), "", "hello")
+	err = getJSON("/galene-api/v0/.groups/test/.chat", &history)
+	if err != nil || len(history) != 2 || history[1].Value != "hello" {
+		t.Errorf("Get chat: %v %v", err, history)
+	}
+
+	resp, err = do("DELETE", "/galene-api/v0/.groups/test/.chat",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Delete chat: %v %v", err, resp.StatusCode)
+	}
+	if h := g.GetChatHistory(); len(h) != 0 {
+		t.Errorf("Chat not cleared: %v", h)
+	}
+
+	var clients []map[string]any
+	err = getJSON("/galene-api/v0/.groups/test/.clients/", &clients)
+	if err != nil || len(clients) != 0 {
+		t.Errorf("Get clients: %v %v", err, clients)
+	}
+
+	resp, err = do("DELETE", "/galene-api/v0/.groups/test/.clients/foo",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Kick unknown client: %v %v", err, resp.StatusCode)
+	}
+
+	resp, err = do("GET", "/galene-api/v0/.groups/test/.lock",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Get lock (unlocked): %v %v", err, resp.StatusCode)
+	}
+
+	resp, err = do("PUT", "/galene-api/v0/.groups/test/.lock",
+		"text/plain", "", "", "Closed for maintenance")
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Lock: %v %v", err, resp.StatusCode)
+	}
+	if locked, message := g.Locked(); !locked ||
+		message != "Closed for maintenance" {
+		t.Errorf("Locked: %v %v", locked, message)
+	}
+
+	resp, err = do("DELETE", "/galene-api/v0/.groups/test/.lock",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Unlock: %v %v", err, resp.StatusCode)
+	}
+	if locked, _ := g.Locked(); locked {
+		t.Errorf("Group is still locked")
+	}
+
+	resp, err = do("GET", "/galene-api/v0/.groups/test/.playback",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Get playback (not playing): %v %v",
+			err, resp.StatusCode)
+	}
+
+	resp, err = do("PUT", "/galene-api/v0/.groups/test/.playback",
+		"application/json", "", "", `{"file": "../test.webm"}`)
+	if err != nil || resp.StatusCode != http.StatusConflict {
+		t.Errorf("Play bad file: %v %v", err, resp.StatusCode)
+	}
+
+	resp, err = do("DELETE", "/galene-api/v0/.groups/test/.playback",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Stop playback (not playing): %v %v",
+			err, resp.StatusCode)
+	}
+
+	id, err := g.CreatePoll(group.Poll{
+		Question: "Tea?", Options: []string{"yes", "no"},
+	}, "jch")
+	if err != nil {
+		t.Fatalf("CreatePoll: %v", err)
+	}
+	err = g.Vote(id, "john", []int{0})
+	if err != nil {
+		t.Errorf("Vote: %v", err)
+	}
+	var polls []group.Poll
+	err = getJSON("/galene-api/v0/.groups/test/.polls", &polls)
+	if err != nil || len(polls) != 1 || polls[0].Id != id ||
+		polls[0].Counts[0] != 1 || len(polls[0].Votes["john"]) != 1 {
+		t.Errorf("Get polls: %v %v", err, polls)
+	}
+
+	resp, err = do("GET", "/galene-api/v0/.groups/test/.polls?format=csv",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusOK ||
+		resp.Header.Get("content-type") != "text/csv; charset=utf-8" {
+		t.Errorf("Get polls (csv): %v %v", err, resp.StatusCode)
+	}
+
+	resp, err = do("DELETE", "/galene-api/v0/.groups/test/.polls?id=nosuch",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Delete poll (bad id): %v %v", err, resp.StatusCode)
+	}
+
+	resp, err = do("DELETE", "/galene-api/v0/.groups/test/.polls?id="+id,
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Delete poll: %v %v", err, resp.StatusCode)
+	}
+	if len(g.Polls(false)) != 0 {
+		t.Errorf("Poll was not deleted")
+	}
+
+	for range 2 {
+		_, err = g.CreatePoll(group.Poll{
+			Question: "Coffee?", Options: []string{"yes", "no"},
+		}, "jch")
+		if err != nil {
+			t.Errorf("CreatePoll: %v", err)
+		}
+	}
+	resp, err = do("DELETE", "/galene-api/v0/.groups/test/.polls",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Delete polls: %v %v", err, resp.StatusCode)
+	}
+	if len(g.Polls(false)) != 0 {
+		t.Errorf("Polls were not deleted")
+	}
+
+	resp, err = do("GET", "/galene-api/v0/.groups/nosuchgroup/.polls",
+		"", "", "", "")
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Get polls (no group): %v %v", err, resp.StatusCode)
+	}
+
 	resp, err = do("DELETE", "/galene-api/v0/.groups/test/.keys",
 		"", "", "", "")
 	if err != nil || resp.StatusCode != http.StatusNoContent {
@@ -449,6 +613,7 @@ func TestApiBadAuth(t *testing.T) {
 	}
 
 	do("GET", "/galene-api/v0/.stats")
+	do("GET", "/metrics")
 	do("GET", "/galene-api/v0/.groups/")
 	do("PUT", "/galene-api/v0/.groups/test/")
 
@@ -477,4 +642,9 @@ func TestApiBadAuth(t *testing.T) {
 	do("GET", "/galene-api/v0/.groups/test/.tokens/token")
 	do("PUT", "/galene-api/v0/.groups/test/.tokens/token")
 	do("DELETE", "/galene-api/v0/.groups/test/.tokens/token")
+	do("GET", "/galene-api/v0/.groups/test/.chat")
+	do("DELETE", "/galene-api/v0/.groups/test/.chat")
+	do("GET", "/galene-api/v0/.groups/test/.clients/")
+	do("DELETE", "/galene-api/v0/.groups/test/.clients/id")
+	do("PUT", "/galene-api/v0/.groups/test/.lock")
 }
@@ -0,0 +1,179 @@
+package webserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtpconn"
+)
+
+func chatHandler(w http.ResponseWriter, r *http.Request, g string) {
+	if apiCORS(w, r, "HEAD, GET, DELETE") {
+		return
+	}
+	if !checkAdminOrOp(w, r, g) {
+		return
+	}
+
+	// this causes the history to be loaded from the history store if
+	// the group is not running, so that a transcript can be obtained
+	// after the end of a meeting, and that the history is cleared
+	gg, err := group.Add(g, nil)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	if r.Method == "HEAD" || r.Method == "GET" {
+		h := gg.GetChatHistory()
+		w.Header().Set("cache-control", "no-cache")
+		switch r.URL.Query().Get("format") {
+		case "", "json":
+			sendJSON(w, r, h)
+		case "text":
+			w.Header().Set("content-type", "text/plain; charset=utf-8")
+			if r.Method == "HEAD" {
+				return
+			}
+			writeChatText(w, h)
+		case "vtt":
+			w.Header().Set("content-type", "text/vtt; charset=utf-8")
+			if r.Method == "HEAD" {
+				return
+			}
+			writeCaptionsVTT(w, h)
+		default:
+			http.Error(w, "unknown format", http.StatusBadRequest)
+		}
+		return
+	} else if r.Method == "DELETE" {
+		q := r.URL.Query()
+		id := q.Get("id")
+		source := q.Get("source")
+		if id != "" && source == "" {
+			http.Error(w, "id without source",
+				http.StatusBadRequest)
+			return
+		}
+		err := rtpconn.ClearChatHistory(gg, id, source)
+		if err != nil {
+			log.Printf("broadcast(clearchat): %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	methodNotAllowed(w, "HEAD, GET, DELETE")
+	return
+}
+
+func chatUsername(e group.ChatHistoryEntry) string {
+	if e.User == nil || *e.User == "" {
+		return "(anonymous)"
+	}
+	return *e.User
+}
+
+// writeChatText writes the textual entries of a chat history in
+// a human-readable format.
+func writeChatText(w io.Writer, h []group.ChatHistoryEntry) error {
+	b := bufio.NewWriter(w)
+	for _, e := range h {
+		value, ok := e.Value.(string)
+		if !ok {
+			continue
+		}
+		value = strings.ReplaceAll(value, "\n", "\n\t")
+		tm := e.Time.UTC().Format(time.RFC3339)
+		switch e.Kind {
+		case "":
+			fmt.Fprintf(b, "%v %v: %v\n", tm, chatUsername(e), value)
+		case "me":
+			fmt.Fprintf(b, "%v * %v %v\n", tm, chatUsername(e), value)
+		default:
+			fmt.Fprintf(b, "%v [%v] %v: %v\n",
+				tm, e.Kind, chatUsername(e), value,
+			)
+		}
+	}
+	return b.Flush()
+}
+
+// captionDuration is the maximum time during which a caption is
+// displayed, the same as in the web client.
+const captionDuration = 3 * time.Second
+
+func vttEscape(s string) string {
+	return strings.NewReplacer(
+		"&", "&amp;", "<", "&lt;", ">", "&gt;",
+	).Replace(s)
+}
+
+func vttTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		ms/3600000, (ms/60000)%60, (ms/1000)%60, ms%1000,
+	)
+}
+
+// writeCaptionsVTT writes the captions in a chat history in WebVTT
+// format.  Times are relative to the first caption, whose absolute time
+// is indicated in a comment.
+func writeCaptionsVTT(w io.Writer, h []group.ChatHistoryEntry) error {
+	var captions []group.ChatHistoryEntry
+	for _, e := range h {
+		if _, ok := e.Value.(string); ok && e.Kind == "caption" {
+			captions = append(captions, e)
+		}
+	}
+
+	b := bufio.NewWriter(w)
+	b.WriteString("WEBVTT\n")
+	if len(captions) == 0 {
+		return b.Flush()
+	}
+
+	origin := captions[0].Time
+	fmt.Fprintf(b, "\nNOTE start %v\n",
+		origin.UTC().Format(time.RFC3339Nano),
+	)
+
+	for i, e := range captions {
+		start := e.Time.Sub(origin)
+		end := start + captionDuration
+		if i+1 < len(captions) {
+			next := captions[i+1].Time.Sub(origin)
+			if next < end {
+				end = next
+			}
+		}
+		if end <= start {
+			end = start + time.Millisecond
+		}
+
+		// empty lines would terminate the cue
+		var lines []string
+		for _, l := range strings.Split(e.Value.(string), "\n") {
+			if strings.TrimSpace(l) != "" {
+				lines = append(lines, vttEscape(l))
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(b, "\n%v --> %v\n", vttTime(start), vttTime(end))
+		if e.User != nil && *e.User != "" {
+			fmt.Fprintf(b, "<v %v>", vttEscape(*e.User))
+		}
+		b.WriteString(strings.Join(lines, "\n"))
+		b.WriteString("\n")
+	}
+	return b.Flush()
+}
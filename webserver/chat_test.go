@@ -0,0 +1,58 @@
+package webserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jech/galene/group"
+)
+
+func TestWriteChat(t *testing.T) {
+	tm := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	user := "jch"
+	h := []group.ChatHistoryEntry{
+		{Time: tm, User: &user, Value: "hello\nworld"},
+		{Time: tm.Add(time.Second), Kind: "caption", User: &user,
+			Value: "a <caption>"},
+		{Time: tm.Add(2 * time.Second), Kind: "caption",
+			Value: "another\n\ncaption"},
+		{Time: tm.Add(3 * time.Second), Kind: "me", User: &user,
+			Value: "waves"},
+		{Time: tm.Add(4 * time.Second), Value: 42},
+	}
+
+	var text strings.Builder
+	err := writeChatText(&text, h)
+	if err != nil {
+		t.Fatalf("writeChatText: %v", err)
+	}
+	expected := "2025-01-01T12:00:00Z jch: hello\n\tworld\n" +
+		"2025-01-01T12:00:01Z [caption] jch: a <caption>\n" +
+		"2025-01-01T12:00:02Z [caption] (anonymous): " +
+		"another\n\t\n\tcaption\n" +
+		"2025-01-01T12:00:03Z * jch waves\n"
+	if text.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, text.String())
+	}
+
+	var vtt strings.Builder
+	err = writeCaptionsVTT(&vtt, h)
+	if err != nil {
+		t.Fatalf("writeCaptionsVTT: %v", err)
+	}
+	expected = `WEBVTT
+
+NOTE start 2025-01-01T12:00:01Z
+
+00:00:00.000 --> 00:00:01.000
+<v jch>a &lt;caption&gt;
+
+00:00:01.000 --> 00:00:04.000
+another
+caption
+`
+	if vtt.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, vtt.String())
+	}
+}
@@ -0,0 +1,264 @@
+package webserver
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtpconn"
+)
+
+type apiClient struct {
+	Id          string   `json:"id"`
+	Username    string   `json:"username,omitempty"`
+	Permissions []string `json:"permissions"`
+	Address     string   `json:"address,omitempty"`
+}
+
+func makeAPIClient(c group.Client) apiClient {
+	client := apiClient{
+		Id:          c.Id(),
+		Username:    c.Username(),
+		Permissions: c.Permissions(),
+	}
+	if client.Permissions == nil {
+		client.Permissions = []string{}
+	}
+	if addr := c.Addr(); addr != nil {
+		client.Address = addr.String()
+	}
+	return client
+}
+
+func clientsHandler(w http.ResponseWriter, r *http.Request, g, pth string) {
+	if pth == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if apiCORS(w, r, "HEAD, GET, POST, DELETE") {
+		return
+	}
+	if !checkAdminOrOp(w, r, g) {
+		return
+	}
+
+	// check that the group exists
+	_, err := group.GetDescription(g)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	gg := group.Get(g)
+
+	if pth == "/" {
+		if r.Method != "HEAD" && r.Method != "GET" {
+			methodNotAllowed(w, "HEAD, GET")
+			return
+		}
+		clients := make([]apiClient, 0)
+		if gg != nil {
+			for _, c := range gg.GetClients(nil) {
+				clients = append(clients, makeAPIClient(c))
+			}
+		}
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, clients)
+		return
+	}
+
+	if pth[0] != '/' || strings.ContainsRune(pth[1:], '/') {
+		http.NotFound(w, r)
+		return
+	}
+	id := pth[1:]
+
+	var c group.Client
+	if gg != nil {
+		c = gg.GetClient(id)
+	}
+	if c == nil {
+		notFound(w)
+		return
+	}
+
+	if r.Method == "HEAD" || r.Method == "GET" {
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, makeAPIClient(c))
+		return
+	} else if r.Method == "POST" {
+		body, done := getText(w, r)
+		if done {
+			return
+		}
+		err := rtpconn.ChangePermissions(
+			gg, id, strings.TrimSpace(string(body)),
+		)
+		if err != nil {
+			var userErr group.UserError
+			if errors.As(err, &userErr) {
+				http.Error(w, err.Error(),
+					http.StatusConflict)
+				return
+			}
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	} else if r.Method == "DELETE" {
+		err := rtpconn.KickClient(gg, id, r.URL.Query().Get("message"))
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	methodNotAllowed(w, "HEAD, GET, POST, DELETE")
+	return
+}
+
+func lockHandler(w http.ResponseWriter, r *http.Request, g string) {
+	if apiCORS(w, r, "HEAD, GET, PUT, DELETE") {
+		return
+	}
+	if !checkAdminOrOp(w, r, g) {
+		return
+	}
+
+	gg, err := group.Add(g, nil)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	if r.Method == "HEAD" || r.Method == "GET" {
+		locked, message := gg.Locked()
+		if !locked {
+			notFound(w)
+			return
+		}
+		w.Header().Set("content-type", "text/plain; charset=utf-8")
+		w.Header().Set("cache-control", "no-cache")
+		if r.Method == "HEAD" {
+			return
+		}
+		w.Write([]byte(message))
+		return
+	} else if r.Method == "PUT" {
+		body, done := getText(w, r)
+		if done {
+			return
+		}
+		gg.SetLocked(true, string(body))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	} else if r.Method == "DELETE" {
+		gg.SetLocked(false, "")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	methodNotAllowed(w, "HEAD, GET, PUT, DELETE")
+	return
+}
+
+type apiPlayback struct {
+	File     string  `json:"file,omitempty"`
+	Action   string  `json:"action,omitempty"`
+	Position float64 `json:"position,omitempty"`
+}
+
+func playbackHandler(w http.ResponseWriter, r *http.Request, g string) {
+	if apiCORS(w, r, "HEAD, GET, PUT, POST, DELETE") {
+		return
+	}
+	if !checkAdminOrOp(w, r, g) {
+		return
+	}
+
+	gg, err := group.Add(g, nil)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	playbackError := func(err error) {
+		var userErr group.UserError
+		if errors.As(err, &userErr) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		httpError(w, err)
+	}
+
+	if r.Method == "HEAD" || r.Method == "GET" {
+		p := rtpconn.GetPlayer(gg)
+		if p == nil {
+			notFound(w)
+			return
+		}
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, p.Status())
+		return
+	} else if r.Method == "PUT" {
+		var playback apiPlayback
+		done := getJSON(w, r, &playback)
+		if done {
+			return
+		}
+		err := rtpconn.StartPlayback(gg, playback.File,
+			time.Duration(playback.Position*float64(time.Second)),
+		)
+		if err != nil {
+			playbackError(err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	} else if r.Method == "POST" {
+		var playback apiPlayback
+		done := getJSON(w, r, &playback)
+		if done {
+			return
+		}
+		p := rtpconn.GetPlayer(gg)
+		if p == nil {
+			notFound(w)
+			return
+		}
+		switch playback.Action {
+		case "pause":
+			err = p.Pause()
+		case "resume":
+			err = p.Resume()
+		case "seek":
+			err = p.Seek(time.Duration(
+				playback.Position * float64(time.Second),
+			))
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			playbackError(err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	} else if r.Method == "DELETE" {
+		if !rtpconn.StopPlayback(gg) {
+			notFound(w)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	methodNotAllowed(w, "HEAD, GET, PUT, POST, DELETE")
+	return
+}
@@ -0,0 +1,308 @@
+package webserver
+
+import (
+	"bufio"
+	"cmp"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jech/galene/rtpconn"
+	"github.com/jech/galene/stats"
+	"github.com/jech/galene/turnserver"
+)
+
+// A metricFamily is a set of samples in the Prometheus text exposition
+// format.
+type metricFamily struct {
+	name, kind, help string
+	samples          []string
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// add adds a sample to the family.  Labels is a list of names and values.
+func (f *metricFamily) add(value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(f.name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(labels[i])
+			b.WriteString(`="`)
+			b.WriteString(labelEscaper.Replace(labels[i+1]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	f.samples = append(f.samples, b.String())
+}
+
+func writeMetrics(w io.Writer, families []*metricFamily) error {
+	b := bufio.NewWriter(w)
+	for _, f := range families {
+		if len(f.samples) == 0 {
+			continue
+		}
+		b.WriteString("# HELP " + f.name + " " + f.help + "\n")
+		b.WriteString("# TYPE " + f.name + " " + f.kind + "\n")
+		for _, s := range f.samples {
+			b.WriteString(s)
+			b.WriteByte('\n')
+		}
+	}
+	return b.Flush()
+}
+
+type metrics struct {
+	groups, clients, tracks                metricFamily
+	bitrate, maxBitrate, loss, rtt, jitter metricFamily
+	packets, lost, unordered, nacks        metricFamily
+	turn, websocketReceived, websocketSent metricFamily
+}
+
+func newMetrics() *metrics {
+	family := func(name, kind, help string) metricFamily {
+		return metricFamily{name: name, kind: kind, help: help}
+	}
+	return &metrics{
+		groups: family("galene_groups", "gauge",
+			"Number of active groups."),
+		clients: family("galene_clients", "gauge",
+			"Number of clients in a group."),
+		tracks: family("galene_tracks", "gauge",
+			"Number of tracks in a group."),
+		bitrate: family("galene_bitrate_bits_per_second", "gauge",
+			"Total estimated bitrate of the tracks."),
+		maxBitrate: family("galene_max_bitrate_bits_per_second",
+			"gauge", "Total maximum bitrate of the tracks."),
+		loss: family("galene_loss_ratio", "gauge",
+			"Mean recent packet loss rate of the tracks."),
+		rtt: family("galene_rtt_seconds", "gauge",
+			"Mean round-trip time of the down tracks."),
+		jitter: family("galene_jitter_seconds", "gauge",
+			"Mean jitter of the tracks."),
+		packets: family("galene_packets_received_total",
+			"counter", "Number of packets received on up tracks."),
+		lost: family("galene_packets_lost_total", "counter",
+			"Number of packets lost on up tracks."),
+		unordered: family("galene_packets_unordered_total",
+			"counter",
+			"Number of packets received out of order on up tracks."),
+		nacks: family("galene_nacks_total", "counter",
+			"Number of packets NACKed, by us for up tracks, "+
+				"by the receiver for down tracks."),
+		turn: family("galene_turn_allocations", "gauge",
+			"Number of allocations on the built-in TURN server."),
+		websocketReceived: family(
+			"galene_websocket_messages_received_total", "counter",
+			"Number of messages received over websockets."),
+		websocketSent: family(
+			"galene_websocket_messages_sent_total", "counter",
+			"Number of messages sent over websockets."),
+	}
+}
+
+func (m *metrics) families() []*metricFamily {
+	return []*metricFamily{
+		&m.groups, &m.clients, &m.tracks,
+		&m.bitrate, &m.maxBitrate, &m.loss, &m.rtt, &m.jitter,
+		&m.packets, &m.lost, &m.unordered, &m.nacks,
+		&m.turn, &m.websocketReceived, &m.websocketSent,
+	}
+}
+
+func seconds(d stats.Duration) float64 {
+	return time.Duration(d).Seconds()
+}
+
+// Track statistics are aggregated by group, direction and kind, since
+// labelling them with client and connection ids would create new time
+// series for every connection.
+type seriesKey struct {
+	group, direction, kind string
+}
+
+func (k seriesKey) labels() []string {
+	return []string{
+		"group", k.group, "direction", k.direction, "kind", k.kind,
+	}
+}
+
+func compareSeriesKeys(a, b seriesKey) int {
+	return cmp.Or(
+		strings.Compare(a.group, b.group),
+		strings.Compare(a.direction, b.direction),
+		strings.Compare(a.kind, b.kind),
+	)
+}
+
+// trackKey identifies a track across scrapes.
+type trackKey struct {
+	group, client, conn, direction string
+	index                          int
+}
+
+type trackCounters struct {
+	packets, lost, unordered, nacks uint64
+}
+
+// The packet counters of a track are lost when the track goes away.  In
+// order to export monotonic counters, we remember the values seen at the
+// previous scrape, and accumulate the increments.
+var counters struct {
+	mu sync.Mutex
+	// the counters of each track at the previous scrape
+	last map[trackKey]trackCounters
+	// the accumulated counters, never deleted, which is fine since
+	// there are few series per group
+	totals map[seriesKey]trackCounters
+}
+
+// trackGauges holds the aggregated gauges of a series.
+type trackGauges struct {
+	tracks                 int
+	rtts                   int
+	bitrate, maxBitrate    uint64
+	loss, jitter, rttTotal float64
+}
+
+func increment(last, cur uint64) uint64 {
+	if cur < last {
+		// the track was replaced
+		return cur
+	}
+	return cur - last
+}
+
+// addTracks aggregates the statistics of a set of connections.  Called
+// with counters.mu held.
+func addTracks(gauges map[seriesKey]*trackGauges, last map[trackKey]trackCounters, group, client, direction string, conns []stats.Conn) {
+	for _, c := range conns {
+		for i, t := range c.Tracks {
+			sk := seriesKey{group, direction, t.Kind}
+			g := gauges[sk]
+			if g == nil {
+				g = &trackGauges{}
+				gauges[sk] = g
+			}
+			g.tracks++
+			g.bitrate += t.Bitrate
+			g.maxBitrate += t.MaxBitrate
+			g.loss += t.Loss
+			g.jitter += seconds(t.Jitter)
+			if direction == "down" {
+				g.rtts++
+				g.rttTotal += seconds(t.Rtt)
+			}
+
+			tk := trackKey{group, client, c.Id, direction, i}
+			cur := trackCounters{
+				packets:   uint64(t.Packets),
+				lost:      uint64(t.Lost),
+				unordered: uint64(t.Unordered),
+				nacks:     uint64(t.NACKs),
+			}
+			prev := counters.last[tk]
+			total := counters.totals[sk]
+			total.packets += increment(prev.packets, cur.packets)
+			total.lost += increment(prev.lost, cur.lost)
+			total.unordered += increment(prev.unordered, cur.unordered)
+			total.nacks += increment(prev.nacks, cur.nacks)
+			counters.totals[sk] = total
+			last[tk] = cur
+		}
+	}
+}
+
+func getMetrics() []*metricFamily {
+	m := newMetrics()
+
+	counters.mu.Lock()
+	defer counters.mu.Unlock()
+	if counters.totals == nil {
+		counters.totals = make(map[seriesKey]trackCounters)
+	}
+	last := make(map[trackKey]trackCounters)
+
+	gs := stats.GetGroups()
+	m.groups.add(float64(len(gs)))
+	for _, g := range gs {
+		m.clients.add(float64(len(g.Clients)), "group", g.Name)
+		gauges := make(map[seriesKey]*trackGauges)
+		for _, c := range g.Clients {
+			addTracks(gauges, last, g.Name, c.Id, "up", c.Up)
+			addTracks(gauges, last, g.Name, c.Id, "down", c.Down)
+		}
+		keys := slices.SortedFunc(maps.Keys(gauges), compareSeriesKeys)
+		for _, k := range keys {
+			v := gauges[k]
+			labels := k.labels()
+			n := float64(v.tracks)
+			m.tracks.add(n, labels...)
+			m.bitrate.add(float64(v.bitrate), labels...)
+			m.maxBitrate.add(float64(v.maxBitrate), labels...)
+			m.loss.add(v.loss/n, labels...)
+			m.jitter.add(v.jitter/n, labels...)
+			if v.rtts > 0 {
+				m.rtt.add(v.rttTotal/float64(v.rtts), labels...)
+			}
+		}
+	}
+	counters.last = last
+
+	keys := slices.SortedFunc(
+		maps.Keys(counters.totals), compareSeriesKeys,
+	)
+	for _, k := range keys {
+		v := counters.totals[k]
+		labels := k.labels()
+		if k.direction == "up" {
+			m.packets.add(float64(v.packets), labels...)
+			m.lost.add(float64(v.lost), labels...)
+			m.unordered.add(float64(v.unordered), labels...)
+		}
+		m.nacks.add(float64(v.nacks), labels...)
+	}
+
+	if n, ok := turnserver.Allocations(); ok {
+		m.turn.add(float64(n))
+	}
+
+	received, sent := rtpconn.WebsocketStats()
+	m.websocketReceived.add(float64(received))
+	m.websocketSent.add(float64(sent))
+
+	return m.families()
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/metrics" {
+		http.NotFound(w, r)
+		return
+	}
+	if !checkAdmin(w, r, "") {
+		return
+	}
+	if r.Method != "HEAD" && r.Method != "GET" {
+		methodNotAllowed(w, "HEAD, GET")
+		return
+	}
+
+	w.Header().Set("content-type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("cache-control", "no-cache")
+	if r.Method == "HEAD" {
+		return
+	}
+	writeMetrics(w, getMetrics())
+}
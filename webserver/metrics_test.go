@@ -0,0 +1,99 @@
+package webserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jech/galene/stats"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	f1 := &metricFamily{
+		name: "galene_groups", kind: "gauge", help: "Groups.",
+	}
+	f1.add(2)
+	f2 := &metricFamily{
+		name: "galene_clients", kind: "gauge", help: "Clients.",
+	}
+	f2.add(3, "group", "a\"b\\c\nd")
+	f2.add(0.5, "group", "test", "direction", "up")
+	f3 := &metricFamily{
+		name: "galene_empty", kind: "counter", help: "Empty.",
+	}
+
+	var b strings.Builder
+	err := writeMetrics(&b, []*metricFamily{f1, f2, f3})
+	if err != nil {
+		t.Fatalf("writeMetrics: %v", err)
+	}
+	expected := "# HELP galene_groups Groups.\n" +
+		"# TYPE galene_groups gauge\n" +
+		"galene_groups 2\n" +
+		"# HELP galene_clients Clients.\n" +
+		"# TYPE galene_clients gauge\n" +
+		`galene_clients{group="a\"b\\c\nd"} 3` + "\n" +
+		`galene_clients{group="test",direction="up"} 0.5` + "\n"
+	if b.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, b.String())
+	}
+}
+
+func TestTrackMetrics(t *testing.T) {
+	counters.totals = make(map[seriesKey]trackCounters)
+	counters.last = nil
+
+	scrape := func(clients map[string][]stats.Conn) map[seriesKey]*trackGauges {
+		gauges := make(map[seriesKey]*trackGauges)
+		last := make(map[trackKey]trackCounters)
+		for id, conns := range clients {
+			addTracks(gauges, last, "test", id, "up", conns)
+		}
+		counters.last = last
+		return gauges
+	}
+
+	gauges := scrape(map[string][]stats.Conn{
+		"a": {{Id: "a1", Tracks: []stats.Track{
+			{Kind: "audio", Bitrate: 1000, Packets: 10},
+			{Kind: "video", Bitrate: 2000, Packets: 20},
+		}}},
+		"b": {{Id: "b1", Tracks: []stats.Track{
+			{Kind: "audio", Bitrate: 3000, Packets: 30},
+		}}},
+	})
+	audio := seriesKey{"test", "up", "audio"}
+	video := seriesKey{"test", "up", "video"}
+	if len(gauges) != 2 ||
+		gauges[audio].tracks != 2 || gauges[audio].bitrate != 4000 ||
+		gauges[video].tracks != 1 || gauges[video].bitrate != 2000 {
+		t.Errorf("Bad gauges %v %v", gauges[audio], gauges[video])
+	}
+	if p := counters.totals[audio].packets; p != 40 {
+		t.Errorf("Expected 40, got %v", p)
+	}
+
+	// client b goes away, and client a reconnects
+	scrape(map[string][]stats.Conn{
+		"a": {{Id: "a2", Tracks: []stats.Track{
+			{Kind: "audio", Packets: 5},
+		}}},
+	})
+	if p := counters.totals[audio].packets; p != 45 {
+		t.Errorf("Expected 45, got %v", p)
+	}
+	if p := counters.totals[video].packets; p != 20 {
+		t.Errorf("Expected 20, got %v", p)
+	}
+
+	scrape(map[string][]stats.Conn{
+		"a": {{Id: "a2", Tracks: []stats.Track{
+			{Kind: "audio", Packets: 8},
+		}}},
+	})
+	if p := counters.totals[audio].packets; p != 48 {
+		t.Errorf("Expected 48, got %v", p)
+	}
+	if len(counters.last) != 1 {
+		t.Errorf("Stale tracks were not forgotten: %v", counters.last)
+	}
+}
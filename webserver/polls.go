@@ -0,0 +1,79 @@
+package webserver
+
+import (
+	"encoding/csv"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/jech/galene/group"
+)
+
+func pollsHandler(w http.ResponseWriter, r *http.Request, g string) {
+	if apiCORS(w, r, "HEAD, GET, DELETE") {
+		return
+	}
+	if !checkAdminOrOp(w, r, g) {
+		return
+	}
+
+	// polls are not persistent, so there is no point in instantiating
+	// the group
+	gg := group.Get(g)
+	if gg == nil {
+		notFound(w)
+		return
+	}
+
+	if r.Method == "HEAD" || r.Method == "GET" {
+		polls := gg.Polls(true)
+		w.Header().Set("cache-control", "no-cache")
+		switch r.URL.Query().Get("format") {
+		case "", "json":
+			sendJSON(w, r, polls)
+		case "csv":
+			w.Header().Set("content-type", "text/csv; charset=utf-8")
+			if r.Method == "HEAD" {
+				return
+			}
+			writePollsCSV(w, polls)
+		default:
+			http.Error(w, "unknown format", http.StatusBadRequest)
+		}
+		return
+	} else if r.Method == "DELETE" {
+		id := r.URL.Query().Get("id")
+		if id != "" {
+			err := gg.DeletePoll(id)
+			if err != nil {
+				notFound(w)
+				return
+			}
+		} else {
+			for _, p := range gg.Polls(false) {
+				// the poll may have been deleted concurrently
+				gg.DeletePoll(p.Id)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	methodNotAllowed(w, "HEAD, GET, DELETE")
+}
+
+// writePollsCSV writes the results of a set of polls in CSV format, with
+// one line per option.
+func writePollsCSV(w io.Writer, polls []group.Poll) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"poll", "question", "option", "votes"})
+	for _, p := range polls {
+		for i, o := range p.Options {
+			c.Write([]string{
+				p.Id, p.Question, o, strconv.Itoa(p.Counts[i]),
+			})
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
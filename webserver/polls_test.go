@@ -0,0 +1,30 @@
+package webserver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jech/galene/group"
+)
+
+func TestWritePollsCSV(t *testing.T) {
+	polls := []group.Poll{
+		{
+			Id:       "p1",
+			Question: "Tea, or coffee?",
+			Options:  []string{"tea", "coffee"},
+			Counts:   []int{3, 1},
+		},
+	}
+	var b strings.Builder
+	err := writePollsCSV(&b, polls)
+	if err != nil {
+		t.Fatalf("writePollsCSV: %v", err)
+	}
+	expected := "poll,question,option,votes\n" +
+		"p1,\"Tea, or coffee?\",tea,3\n" +
+		"p1,\"Tea, or coffee?\",coffee,1\n"
+	if b.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, b.String())
+	}
+}
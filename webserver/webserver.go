@@ -51,6 +51,7 @@ func Serve(address string, dataDir string) error {
 	http.HandleFunc("/ws", wsHandler)
 	http.HandleFunc("/public-groups.json", publicHandler)
 	http.HandleFunc("/galene-api/", apiHandler)
+	http.HandleFunc("/metrics", metricsHandler)
 
 	s := &http.Server{
 		Addr:              address,
@@ -344,11 +345,13 @@ func groupHandler(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, dir+"/"+".status",
 			http.StatusPermanentRedirect)
 		return
-	} else if kind == ".whip" {
-		if rest == "" {
+	} else if kind == ".whip" || kind == ".whep" {
+		if rest != "" {
+			whipResourceHandler(w, r)
+		} else if kind == ".whip" {
 			whipEndpointHandler(w, r)
 		} else {
-			whipResourceHandler(w, r)
+			whepEndpointHandler(w, r)
 		}
 		return
 	} else if kind != "" {
@@ -774,6 +777,17 @@ func serveGroupRecordings(w http.ResponseWriter, r *http.Request, f *os.File, gr
 		return
 	}
 
+	if r.URL.Query().Get("format") == "json" {
+		manifests, err := diskwriter.GetManifests(group)
+		if err != nil {
+			httpError(w, err)
+			return
+		}
+		w.Header().Set("cache-control", "no-cache")
+		sendJSON(w, r, manifests)
+		return
+	}
+
 	sort.Slice(fis, func(i, j int) bool {
 		return fis[i].Name() < fis[j].Name()
 	})
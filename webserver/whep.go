@@ -0,0 +1,136 @@
+package webserver
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtpconn"
+)
+
+func whepEndpointHandler(w http.ResponseWriter, r *http.Request) {
+	if redirect(w, r) {
+		return
+	}
+
+	pth, kind, pthid := splitPath(r.URL.Path)
+	if kind != ".whep" || pthid != "" {
+		http.Error(w, "Internal server error",
+			http.StatusInternalServerError)
+		return
+	}
+
+	name := parseGroupName("/group/", pth)
+	if name == "" {
+		notFound(w)
+		return
+	}
+
+	g, err := group.Add(name, nil)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	CheckOrigin(w, r, false)
+
+	if r.Method == "OPTIONS" {
+		w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, POST")
+		w.Header().Set("Access-Control-Allow-Headers",
+			"Authorization, Content-Type",
+		)
+		w.Header().Set("Access-Control-Expose-Headers", "Link")
+		whipICEServers(w)
+		return
+	}
+
+	if r.Method != "POST" {
+		methodNotAllowed(w, "POST")
+		return
+	}
+
+	ctype := r.Header.Get("content-type")
+	if !strings.EqualFold(ctype, "application/sdp") {
+		w.Header().Set("Accept", "application/sdp")
+		http.Error(w, "bad content type",
+			http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, sdpLimit))
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	token := parseBearerToken(r.Header.Get("Authorization"))
+
+	whep := "whep"
+	creds := group.ClientCredentials{
+		Username: &whep,
+		Token:    token,
+	}
+
+	id := newId()
+	obfuscated, err := obfuscate(id)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	var addr net.Addr
+	tcpaddr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		log.Printf("ResolveTCPAddr: %v", err)
+	} else {
+		addr = tcpaddr
+	}
+
+	c := rtpconn.NewWhepClient(g, id, token, addr)
+
+	// WHEP clients are subject to the same access rules as other
+	// clients: the token, or the wildcard user if there is no token,
+	// must allow joining the group, and the group must not be locked,
+	// full or require admission by an operator.
+	_, err = group.AddClient(g.Name(), c, creds)
+	if err != nil {
+		log.Printf("WHEP: %v", err)
+		var uerr group.UserError
+		if errors.As(err, &uerr) {
+			http.Error(w, uerr.Error(), http.StatusForbidden)
+			return
+		}
+		httpError(w, err)
+		return
+	}
+
+	c.SetETag("\"" + newId() + "\"")
+
+	answer, err := c.NewConnection(r.Context(), body)
+	if err != nil {
+		c.Close()
+		if errors.Is(err, rtpconn.ErrNoStream) {
+			w.Header().Set("Retry-After", "5")
+			http.Error(w, "no stream available",
+				http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("WHEP offer: %v", err)
+		httpError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", path.Join(r.URL.Path, obfuscated))
+	w.Header().Set("Access-Control-Expose-Headers",
+		"Location, Content-Type, Link, ETag")
+	whipICEServers(w)
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("ETag", c.ETag())
+	w.WriteHeader(http.StatusCreated)
+	w.Write(answer)
+}
@@ -0,0 +1,155 @@
+package webserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jech/galene/group"
+	"github.com/jech/galene/rtpconn"
+)
+
+func TestWhep(t *testing.T) {
+	setup()
+	group.Directory = t.TempDir()
+	group.DataDirectory = t.TempDir()
+	groups := map[string]string{
+		"whep":         `{"wildcard-user": {"password": {"type": "wildcard"}}}`,
+		"whep-private": `{"users": {"vimes": {"password": "sybil"}}}`,
+		"whep-lobby": `{
+    "lobby": true,
+    "wildcard-user": {"password": {"type": "wildcard"}}
+}`,
+	}
+	for name, desc := range groups {
+		err := os.WriteFile(
+			filepath.Join(group.Directory, name+".json"),
+			[]byte(desc), 0600,
+		)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	do := func(handler http.HandlerFunc, method, path, ctype, auth, im, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if ctype != "" {
+			req.Header.Set("Content-Type", ctype)
+		}
+		if auth != "" {
+			req.Header.Set("Authorization", "Bearer "+auth)
+		}
+		if im != "" {
+			req.Header.Set("If-Match", im)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	post := func(g string) *httptest.ResponseRecorder {
+		return do(whepEndpointHandler,
+			"POST", "/group/"+g+"/.whep", "application/sdp",
+			"", "", "v=0\r\n",
+		)
+	}
+
+	w := post("whep-private")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("POST (private): expected 401, got %v", w.Code)
+	}
+
+	w = post("whep-lobby")
+	if w.Code != http.StatusForbidden {
+		t.Errorf("POST (lobby): expected 403, got %v", w.Code)
+	}
+
+	w = do(whepEndpointHandler,
+		"POST", "/group/whep/.whep", "text/plain", "", "", "v=0\r\n",
+	)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("POST (content-type): expected 415, got %v", w.Code)
+	}
+
+	w = post("whep")
+	if w.Code != http.StatusServiceUnavailable ||
+		w.Header().Get("Retry-After") == "" {
+		t.Errorf("POST (no stream): expected 503, got %v", w.Code)
+	}
+
+	g := group.Get("whep")
+	if g == nil {
+		t.Fatalf("Group is not running")
+	}
+	if n := len(g.GetClients(nil)); n != 0 {
+		t.Errorf("Expected no clients, got %v", n)
+	}
+
+	id := newId()
+	obfuscated, err := obfuscate(id)
+	if err != nil {
+		t.Fatalf("obfuscate: %v", err)
+	}
+	c := rtpconn.NewWhepClient(g, id, "secret", nil)
+	username := "whep"
+	_, err = group.AddClient("whep", c, group.ClientCredentials{
+		Username: &username,
+	})
+	if err != nil {
+		t.Fatalf("AddClient: %v", err)
+	}
+	c.SetETag("\"etag\"")
+
+	resource := "/group/whep/.whep/" + obfuscated
+	frag := "a=ice-ufrag:abcd\r\na=ice-pwd:0123456789abcdef01234567\r\n"
+
+	w = do(whipResourceHandler,
+		"PATCH", resource, "application/trickle-ice-sdpfrag",
+		"wrong", "", frag,
+	)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("PATCH (bad token): expected 403, got %v", w.Code)
+	}
+
+	w = do(whipResourceHandler,
+		"PATCH", resource, "application/sdp", "secret", "", frag,
+	)
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("PATCH (content-type): expected 415, got %v", w.Code)
+	}
+
+	w = do(whipResourceHandler,
+		"PATCH", resource, "application/trickle-ice-sdpfrag",
+		"secret", "\"other\"", frag,
+	)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PATCH (etag): expected 412, got %v", w.Code)
+	}
+
+	w = do(whipResourceHandler,
+		"DELETE", resource, "", "wrong", "", "",
+	)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("DELETE (bad token): expected 403, got %v", w.Code)
+	}
+
+	w = do(whipResourceHandler,
+		"DELETE", resource, "", "secret", "\"etag\"", "",
+	)
+	if w.Code != http.StatusOK {
+		t.Errorf("DELETE: expected 200, got %v", w.Code)
+	}
+	if g.GetClient(id) != nil {
+		t.Errorf("Client was not deleted")
+	}
+
+	w = do(whipResourceHandler,
+		"DELETE", resource, "", "secret", "", "",
+	)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("DELETE (twice): expected 404, got %v", w.Code)
+	}
+}
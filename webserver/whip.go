@@ -1,6 +1,7 @@
 package webserver
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	crand "crypto/rand"
@@ -217,7 +218,7 @@ func whipEndpointHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !canPresent(c.Permissions()) {
-		group.DelClient(c)
+		c.Close()
 		http.Error(w, "Forbidden", http.StatusForbidden)
 		return
 	}
@@ -226,7 +227,7 @@ func whipEndpointHandler(w http.ResponseWriter, r *http.Request) {
 
 	answer, err := c.NewConnection(r.Context(), body)
 	if err != nil {
-		group.DelClient(c)
+		c.Close()
 		log.Printf("WHIP offer: %v", err)
 		httpError(w, err)
 		return
@@ -244,13 +245,27 @@ func whipEndpointHandler(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// whipResource is implemented by the clients that are managed over WHIP
+// and WHEP.
+type whipResource interface {
+	Token() string
+	ETag() string
+	SetETag(string)
+	Close() error
+	UFragPwd() (string, string, error)
+	Restart(context.Context, sdpfrag.SDPFrag) (sdpfrag.SDPFrag, error)
+	GotICECandidate(webrtc.ICECandidateInit) error
+}
+
+// whipResourceHandler handles requests for WHIP and WHEP sessions.
 func whipResourceHandler(w http.ResponseWriter, r *http.Request) {
 	pth, kind, rest := splitPath(r.URL.Path)
-	if kind != ".whip" || rest == "" {
+	if (kind != ".whip" && kind != ".whep") || rest == "" {
 		http.Error(w, "Internal server error",
 			http.StatusInternalServerError)
 		return
 	}
+	proto := strings.ToUpper(kind[1:])
 	id, err := deobfuscate(rest[1:])
 	if err != nil {
 		httpError(w, err)
@@ -275,7 +290,13 @@ func whipResourceHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	c, ok := cc.(*rtpconn.WhipClient)
+	var c whipResource
+	var ok bool
+	if kind == ".whip" {
+		c, ok = cc.(*rtpconn.WhipClient)
+	} else {
+		c, ok = cc.(*rtpconn.WhepClient)
+	}
 	if !ok {
 		notFound(w)
 		return
@@ -339,13 +360,13 @@ func whipResourceHandler(w http.ResponseWriter, r *http.Request) {
 	var frag sdpfrag.SDPFrag
 	err = frag.Unmarshal(data)
 	if err != nil {
-		log.Printf("WHIP trickle ICE: %v", err)
+		log.Printf("%v trickle ICE: %v", proto, err)
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 	u, p, err := c.UFragPwd()
 	if err != nil {
-		log.Printf("WHIP UfragPwd: %v", err)
+		log.Printf("%v UfragPwd: %v", proto, err)
 		http.Error(w, "internal server error",
 			http.StatusInternalServerError,
 		)
@@ -355,7 +376,7 @@ func whipResourceHandler(w http.ResponseWriter, r *http.Request) {
 	if uu != u || pp != p {
 		frag2, err := c.Restart(r.Context(), frag)
 		if err != nil {
-			log.Printf("WHIP restart: %v", err)
+			log.Printf("%v restart: %v", proto, err)
 			http.Error(w, "internal server error",
 				http.StatusInternalServerError,
 			)
@@ -364,7 +385,7 @@ func whipResourceHandler(w http.ResponseWriter, r *http.Request) {
 		c.SetETag("\"" + newId() + "\"")
 		f2, err := frag2.Marshal()
 		if err != nil {
-			log.Printf("WHIP marshal frag: %v", err)
+			log.Printf("%v marshal frag: %v", proto, err)
 			http.Error(w, "internal server error",
 				http.StatusInternalServerError,
 			)
@@ -380,7 +401,7 @@ func whipResourceHandler(w http.ResponseWriter, r *http.Request) {
 	for _, init := range frag.AllCandidates() {
 		err := c.GotICECandidate(init)
 		if err != nil {
-			log.Printf("WHIP candidate: %v", err)
+			log.Printf("%v candidate: %v", proto, err)
 		}
 	}
 	w.WriteHeader(http.StatusNoContent)